@@ -1,5 +1,38 @@
 package cyberdaemon
 
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+const (
+	// Version is the version of this library. It is recorded in the
+	// daemon configuration files generated by the control package.
+	Version = "0.1.0"
+
+	// InstanceEnv is the environment variable that stores the name of
+	// the instance of a multi-instance daemon (e.g., 'tenant1' for the
+	// 'myapp@tenant1' instance). It is set by the daemon's configuration
+	// file when the daemon is installed by the control package.
+	InstanceEnv = "CYBERDAEMON_INSTANCE"
+)
+
+// InstanceName returns the name of the daemon instance that the current
+// process is running as. It returns an empty string if the daemon is not
+// an instance of a multi-instance daemon. Daemons that run several
+// instances of the same executable can use the name to select an
+// instance's configuration (e.g., '/etc/myapp/tenant1.conf').
+//
+// The name is inherited by the processes that a Daemonizer starts when
+// detaching the daemon or dropping privileges.
+func InstanceName() string {
+	return os.Getenv(InstanceEnv)
+}
+
 // Daemonizer provides methods for daemonizing your application code.
 //
 // Gotchas
@@ -47,23 +80,301 @@ package cyberdaemon
 // bake the special business logic into their own independent init.d scripts.
 //
 // This library's System V daemon implementation checks the parent PID's
-// "/proc/<pid>/cmdline" to determine:
+// "/proc/<pid>/cmdline" (and those of its ancestors) to determine:
 // 	- Is init.d the parent process?
 // 	- If so, where is the init.d script stored?
 //
+// The directories that init.d scripts are stored in, and the number of
+// ancestor processes to inspect, can be customized using InitdConfig.
+// The DiagnoseInitd function can be used to troubleshoot this logic.
+//
 // If started by init.d, the daemon will attempt to parse the PID file path
 // from the init.d script. A PID file is used to store the PID of the daemon
 // so that the management script can easily determine the status of the daemon.
 // Both the init.d script and the daemon need to know where this file is
 // located (the script so that it can read it, and the daemon so that it can
 // write its PID to it). If the daemon cannot find the PID file path in the
-// init.d script, it uses a sane default PID file path.
+// init.d script, it uses a sane default PID file path. The daemon process
+// (not the process started by init.d) writes its own PID to the PID file,
+// and holds an exclusive lock on it until it exits. This allows stale PID
+// files to be detected reliably. The 'cyberdaemon/pidfile' subpackage
+// implements this logic, and can be used independently of the Daemonizer.
+//
+// The init.d-started process waits for the daemon process to report whether
+// the Application started successfully. If the Application's Start method
+// fails, RunUntilExit returns its error in the init.d-started process. This
+// allows the init.d script to report the failure (assuming the application
+// exits with a non-zero exit status when RunUntilExit returns an error).
+// The init.d-started process exits with a status of zero when the
+// Application starts successfully.
+//
+// If the init.d script exports a restart policy (see RestartPolicyEnv), the
+// daemon process supervises the Application rather than running it. It
+// runs the Application in a new instance of the current executable, and
+// restarts that process according to the policy. The daemon process stops
+// when the policy no longer allows the Application to be restarted.
 type Daemonizer interface {
 	// RunUntilExit runs the provided Application until the daemon is
 	// instructed to quit. This method blocks until the daemon exits.
 	RunUntilExit(Application) error
 }
 
+// DaemonizerConfig configures a Daemonizer.
+type DaemonizerConfig struct {
+	// LogConfig configures the daemon's logging.
+	LogConfig LogConfig
+
+	// DetachConfig configures the daemon to detach from the process
+	// that started it. See DetachConfig for more information.
+	DetachConfig DetachConfig
+
+	// InitdConfig configures how the System V Daemonizer determines
+	// whether the daemon was started by an init.d script. This is
+	// only used on Linux.
+	InitdConfig InitdConfig
+
+	// PrivilegeDropConfig configures the daemon to switch to an
+	// unprivileged user after it starts. See PrivilegeDropConfig
+	// for more information.
+	PrivilegeDropConfig PrivilegeDropConfig
+}
+
+// Validate returns a non-nil error if the configuration is invalid.
+func (o DaemonizerConfig) Validate() error {
+	err := o.DetachConfig.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = o.InitdConfig.Validate()
+	if err != nil {
+		return err
+	}
+
+	return o.PrivilegeDropConfig.Validate()
+}
+
+// DetachConfig configures how a Daemonizer detaches the daemon from the
+// process that started it. This allows the daemon to be started by a
+// plain shell, or by a supervisor that expects daemons to background
+// themselves. Detaching is not supported on Windows.
+//
+// When detaching, the Daemonizer runs a new instance of the current
+// executable (with the same command line arguments) in a new session.
+// The new process' standard input is connected to the null device, and
+// its output is redirected to the log file (if specified). The original
+// process exits only after the new process reports that the Application's
+// Start method succeeded. If Start fails, RunUntilExit returns its error
+// in the original process.
+//
+// Detaching is skipped when the daemon is started by a service manager
+// that expects daemons to run in the foreground (systemd, OpenRC, runit,
+// s6, supervisord, and launchd), or by a System V init.d script (which the Daemonizer
+// already handles).
+type DetachConfig struct {
+	// Detach specifies whether the daemon should detach from the
+	// process that started it.
+	Detach bool
+
+	// PIDFilePath is the path to the daemon's PID file. The file is
+	// managed using the 'cyberdaemon/pidfile' subpackage. If left
+	// unset, no PID file is created.
+	PIDFilePath string
+
+	// WorkDirPath is the daemon's working directory. If left unset,
+	// the daemon's working directory is '/'.
+	WorkDirPath string
+
+	// Umask is the daemon's file mode creation mask formatted as an
+	// octal string (for example, "0027"). If left unset, the umask
+	// is inherited from the process that started the daemon.
+	Umask string
+
+	// Group is the name of the daemon's primary group. If left unset,
+	// the group is inherited from the process that started the daemon.
+	// Changing the group requires super user privileges.
+	//
+	// This is not supported on Windows.
+	Group string
+
+	// SupplementaryGroups are the names of groups that the daemon's
+	// process is added to (in addition to the supplementary groups of
+	// the process that started the daemon). Changing the groups
+	// requires super user privileges.
+	//
+	// This is not supported on Windows.
+	SupplementaryGroups []string
+
+	// LogFilePath is the path to a file that the daemon's stdout and
+	// stderr output is appended to. If left unset, the output is
+	// discarded.
+	LogFilePath string
+
+	// StartTimeout is the amount of time the original process waits
+	// for the daemon to report that the Application started. If left
+	// unset, a timeout of 30 seconds is used. This timeout also applies
+	// to System V daemons started by an init.d script, and to the
+	// unprivileged process started when dropping privileges, regardless
+	// of the value of Detach.
+	StartTimeout time.Duration
+}
+
+// Validate returns a non-nil error if the configuration is invalid.
+func (o DetachConfig) Validate() error {
+	if len(o.Umask) > 0 {
+		_, err := osutil.ParseUmask(o.Umask)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.StartTimeout < 0 {
+		return fmt.Errorf("detach start timeout cannot be negative")
+	}
+
+	return nil
+}
+
+func (o DetachConfig) startTimeout() time.Duration {
+	if o.StartTimeout == 0 {
+		return 30 * time.Second
+	}
+
+	return o.StartTimeout
+}
+
+func (o DetachConfig) workDirPath() string {
+	if len(o.WorkDirPath) == 0 {
+		return "/"
+	}
+
+	return o.WorkDirPath
+}
+
+// PrivilegeDropConfig configures a Daemonizer to switch the daemon to an
+// unprivileged user after it starts. This allows a daemon that is started
+// as root to do things that require super user privileges (such as binding
+// to a port below 1024, or reading a secret that only root can access)
+// before running the Application as a normal user. Dropping privileges is
+// not supported on Windows.
+//
+// Go cannot safely change the user of a running process because the change
+// only applies to the calling thread. Instead, the Daemonizer runs the
+// PreDrop hook, and then runs a new instance of the current executable
+// (with the same command line arguments) as the unprivileged user. The
+// files returned by the hook are passed to the new process, where they
+// can be retrieved using InheritedFiles. The Application is only started
+// in the unprivileged process.
+//
+// The privileged process remains running so that the operating system's
+// daemon manager (or the init.d script's PID file) continues to track the
+// daemon. It stops the unprivileged process when the daemon is stopped,
+// and stops when the unprivileged process exits.
+//
+// The following example passes a listener to the unprivileged process:
+//
+//	config.PrivilegeDropConfig = cyberdaemon.PrivilegeDropConfig{
+//		User: "www-data",
+//		PreDrop: func() ([]*os.File, error) {
+//			listener, err := net.Listen("tcp", ":80")
+//			if err != nil {
+//				return nil, err
+//			}
+//			defer listener.Close()
+//
+//			f, err := listener.(*net.TCPListener).File()
+//			if err != nil {
+//				return nil, err
+//			}
+//
+//			return []*os.File{f}, nil
+//		},
+//	}
+//
+// ... and in the Application's Start method:
+//
+//	listener, err := net.FileListener(cyberdaemon.InheritedFiles()[0])
+type PrivilegeDropConfig struct {
+	// User is the name of the user to switch to. Privileges are not
+	// dropped if left unset.
+	User string
+
+	// Group is the name of the unprivileged process' primary group.
+	// If left unset, the user's primary group is used. The process'
+	// supplementary groups are always set to the user's groups.
+	Group string
+
+	// PreDrop is an optional function that is run in the privileged
+	// process before privileges are dropped. The files it returns are
+	// passed to the unprivileged process in the same order, and are
+	// closed in the privileged process. The daemon fails to start if
+	// the function returns a non-nil error.
+	PreDrop func() ([]*os.File, error)
+}
+
+// Validate returns a non-nil error if the configuration is invalid.
+func (o PrivilegeDropConfig) Validate() error {
+	if len(o.User) == 0 && (len(o.Group) > 0 || o.PreDrop != nil) {
+		return fmt.Errorf("a user must be specified when dropping privileges")
+	}
+
+	return nil
+}
+
+// InitdConfig configures how the System V Daemonizer determines whether
+// the daemon was started by an init.d script. The Daemonizer inspects the
+// command line arguments of its ancestor processes. A process is considered
+// to be an init.d script if its executable, or the script that its
+// interpreter runs (the first argument that is not an option), refers to
+// a file in an init.d script directory. Symbolic links (such as those found in
+// '/etc/rc3.d') are resolved before comparing paths, and relative paths are
+// resolved against the process' working directory.
+//
+// The following script directories are always searched:
+// 	/etc/init.d
+// 	/etc/rc.d/init.d
+type InitdConfig struct {
+	// ScriptDirPaths are additional directories that contain
+	// init.d scripts.
+	ScriptDirPaths []string
+
+	// MaxAncestors is the maximum number of ancestor processes to
+	// inspect, starting with the parent process. If left unset,
+	// a maximum of 5 ancestors are inspected.
+	MaxAncestors int
+}
+
+// Validate returns a non-nil error if the configuration is invalid.
+func (o InitdConfig) Validate() error {
+	if o.MaxAncestors < 0 {
+		return fmt.Errorf("maximum number of init.d ancestor processes cannot be negative")
+	}
+
+	for _, dirPath := range o.ScriptDirPaths {
+		if !strings.HasPrefix(dirPath, "/") {
+			return fmt.Errorf("init.d script directory path '%s' must be absolute", dirPath)
+		}
+	}
+
+	return nil
+}
+
+func (o InitdConfig) maxAncestors() int {
+	if o.MaxAncestors == 0 {
+		return 5
+	}
+
+	return o.MaxAncestors
+}
+
+type errDaemonizer struct {
+	reason string
+}
+
+func (o *errDaemonizer) RunUntilExit(_ Application) error {
+	return fmt.Errorf("no suitable daemonization logic available for this system - %s", o.reason)
+}
+
 // LogConfig configures the logging settings for the daemon.
 type LogConfig struct {
 	// UseNativeLogger specifies whether the operating system's native
@@ -78,13 +389,19 @@ type LogConfig struct {
 	// 	journalctl -u myapp
 	// You can add '-f' to the above command to display log messages
 	// as they are created.
-	// System V (init.d), however, does not provide a similar logging
-	// tool. If the daemon was installed using a Controller, the stderr
-	// output of the daemon will be redirected to a log file. This log
-	// file can be found at:
+	// System V (init.d) and OpenRC, however, do not provide a similar
+	// logging tool. If the daemon was installed using a Controller, the
+	// stderr output of the daemon will be redirected to a log file. This
+	// log file can be found at:
 	// 	/var/log/myapp/myapp.log
 	// If a System V daemon was not installed using a controller, it will
 	// attempt to output logs to stderr.
+	// On runit and s6, the daemon's stderr is sent to a log service
+	// ('svlogd' or 's6-log') that saves the logs in the following
+	// directory (the current log file is named 'current'):
+	// 	/var/log/myapp
+	// On supervisord, the daemon's stderr is saved by supervisord to:
+	// 	/var/log/myapp/myapp.log
 	//
 	// macOS, like System V, does not provide a logging tool. If the daemon
 	// was installed using a Controller, its stderr will be redirected to:
@@ -0,0 +1,115 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+// installSettings are the settings of a Linux Controller that only affect
+// Install and Uninstall.
+type installSettings struct {
+	createUser     bool
+	runAs          string
+	group          string
+	removeDirPaths []string
+}
+
+// beforeInstall prepares the system before the daemon is installed.
+func (o installSettings) beforeInstall() error {
+	if o.createUser {
+		return createSystemAccounts(o.runAs, o.group)
+	}
+
+	return nil
+}
+
+// afterUninstall cleans up after the daemon is uninstalled.
+func (o installSettings) afterUninstall() error {
+	return removeDirectories(o.removeDirPaths)
+}
+
+func newInstallSettings(config ControllerConfig) installSettings {
+	settings := installSettings{
+		createUser: config.CreateUser,
+		runAs:      config.RunAs,
+		group:      config.Group,
+	}
+
+	if config.Directories.RemoveOnUninstall {
+		settings.removeDirPaths = config.Directories.paths()
+	}
+
+	return settings
+}
+
+// createSystemAccounts creates the specified user and group as system
+// accounts if they do not exist. If groupName is empty, the user's
+// primary group is a new group with the same name as the user.
+func createSystemAccounts(userName string, groupName string) error {
+	if len(groupName) > 0 {
+		if _, err := user.LookupGroup(groupName); err != nil {
+			groupaddPath, err := osutil.GroupaddPath()
+			if err != nil {
+				return err
+			}
+
+			_, _, err = osutil.RunDaemonCli(groupaddPath, "--system", groupName)
+			if err != nil {
+				return fmt.Errorf("failed to create group '%s' - %s", groupName, err.Error())
+			}
+		}
+	}
+
+	if _, err := user.Lookup(userName); err == nil {
+		return nil
+	}
+
+	useraddPath, err := osutil.UseraddPath()
+	if err != nil {
+		return err
+	}
+
+	shellPath, err := osutil.NologinPath()
+	if err != nil {
+		shellPath = "/bin/false"
+	}
+
+	args := []string{
+		"--system",
+		"--no-create-home",
+		"--home-dir", "/nonexistent",
+		"--shell", shellPath,
+	}
+
+	if len(groupName) > 0 {
+		args = append(args, "--gid", groupName)
+	} else if _, err := user.LookupGroup(userName); err == nil {
+		// useradd fails if a group with the user's
+		// name already exists.
+		args = append(args, "--gid", userName)
+	} else {
+		args = append(args, "--user-group")
+	}
+
+	_, _, err = osutil.RunDaemonCli(useraddPath, append(args, userName)...)
+	if err != nil {
+		return fmt.Errorf("failed to create user '%s' - %s", userName, err.Error())
+	}
+
+	return nil
+}
+
+// removeDirectories removes the provided directories and their contents.
+func removeDirectories(dirPaths []string) error {
+	for _, dirPath := range dirPaths {
+		err := os.RemoveAll(dirPath)
+		if err != nil {
+			return fmt.Errorf("failed to remove directory - %s", err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package control
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSystemdCapabilities(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := ControllerConfig{
+		DaemonID:     "cyberdaemon-test",
+		ExePath:      "/usr/bin/app",
+		RunAs:        "nobody",
+		Capabilities: []string{"CAP_NET_BIND_SERVICE", "CAP_SYS_TIME"},
+	}
+
+	controller, err := newSystemdController(config, "/bin/systemctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unit := string(controller.unitContents)
+	for _, option := range []string{
+		"AmbientCapabilities=CAP_NET_BIND_SERVICE CAP_SYS_TIME",
+		"CapabilityBoundingSet=CAP_NET_BIND_SERVICE CAP_SYS_TIME",
+	} {
+		if !strings.Contains(unit, "\n"+option+"\n") {
+			t.Fatalf("expected unit to contain '%s' - unit:\n%s", option, unit)
+		}
+	}
+
+	unitFilePath := path.Join(tempDirPath, config.DaemonID+".service")
+	err = ioutil.WriteFile(unitFilePath, controller.unitContents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	command, err := systemdForegroundCommand(unitFilePath, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(command.capabilities, config.Capabilities) {
+		t.Fatalf("expected foreground command capabilities %q - got %q", config.Capabilities, command.capabilities)
+	}
+
+	result, err := ImportSystemdUnit(unitFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Config.Capabilities, config.Capabilities) {
+		t.Fatalf("expected imported capabilities %q - got %q", config.Capabilities, result.Config.Capabilities)
+	}
+
+	// A bounding set that differs from the ambient capabilities
+	// cannot be represented by a ControllerConfig.
+	err = ioutil.WriteFile(unitFilePath, bytes.Replace(controller.unitContents,
+		[]byte("CapabilityBoundingSet=CAP_NET_BIND_SERVICE CAP_SYS_TIME"),
+		[]byte("CapabilityBoundingSet=CAP_NET_BIND_SERVICE"), 1), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = ImportSystemdUnit(unitFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Unmapped) == 0 || !strings.HasPrefix(result.Unmapped[0], "CapabilityBoundingSet=") {
+		t.Fatalf("expected the bounding set to be reported as unmapped - got %q", result.Unmapped)
+	}
+
+	// Inverted ambient capabilities remove capabilities rather than
+	// granting them.
+	err = ioutil.WriteFile(unitFilePath, bytes.Replace(controller.unitContents,
+		[]byte("AmbientCapabilities="), []byte("AmbientCapabilities=~"), 1), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = systemdForegroundCommand(unitFilePath, "", "")
+	if err == nil {
+		t.Fatal("expected an error for inverted ambient capabilities")
+	}
+}
+
+func TestDefaultSystemVTemplateSetPrivilegesCapabilities(t *testing.T) {
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	config := ControllerConfig{
+		DaemonID:     "cyberdaemon-test",
+		ExePath:      "/usr/bin/app",
+		RunAs:        "root",
+		Capabilities: []string{"CAP_NET_BIND_SERVICE", "CAP_SYS_TIME"},
+	}
+
+	script, err := renderSystemvScript(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setPrivileges, err := shellFunction(script, "set_privileges")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	harness := bytes.NewBuffer(nil)
+	for _, line := range strings.Split(script, "\n") {
+		for _, name := range []string{"RUN_AS=", "GROUP=", "SUPPLEMENTARY_GROUPS=", "CAPABILITIES="} {
+			if strings.HasPrefix(line, name) {
+				harness.WriteString(line + "\n")
+			}
+		}
+	}
+	// 'setpriv' only needs to exist - the command is not run.
+	harness.WriteString("setpriv() { :; }\n")
+	harness.WriteString(setPrivileges + "\n")
+	harness.WriteString("set_privileges || exit 1\n")
+	harness.WriteString("printf '%s\\n' \"${privileges[@]}\"\n")
+
+	output, err := exec.Command(bashPath, "-c", harness.String()).Output()
+	if err != nil {
+		t.Fatalf("failed to set privileges - %s", err.Error())
+	}
+
+	privileges := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	for _, expected := range []string{
+		"--inh-caps=+net_bind_service,+sys_time",
+		"--ambient-caps=+net_bind_service,+sys_time",
+		"--bounding-set=-all,+net_bind_service,+sys_time",
+	} {
+		if !containsString(privileges, expected) {
+			t.Fatalf("expected 'setpriv' arguments to contain '%s' - got %q", expected, privileges)
+		}
+	}
+
+	capabilities, _, err := shellArrayValue(script, "CAPABILITIES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(capabilities, config.Capabilities) {
+		t.Fatalf("expected script capabilities %q - got %q", config.Capabilities, capabilities)
+	}
+}
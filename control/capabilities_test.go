@@ -0,0 +1,38 @@
+package control
+
+import (
+	"testing"
+)
+
+func TestValidateCapabilities(t *testing.T) {
+	invalid := []string{"", "cap_net_bind_service", "NET_BIND_SERVICE", "CAP_NET_BIND_SERVICE ", "CAP_UNKNOWN", "CAP_ALL"}
+	for _, capability := range invalid {
+		err := validateCapabilities([]string{"CAP_CHOWN", capability})
+		if err == nil {
+			t.Fatalf("expected an error for capability '%s'", capability)
+		}
+	}
+
+	err := validateCapabilities([]string{"CAP_NET_BIND_SERVICE", "CAP_SYS_TIME", "CAP_CHECKPOINT_RESTORE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapabilityNumbers(t *testing.T) {
+	// The numbers are passed to the kernel, so they must match
+	// linux/capability.h, which numbers the capabilities in order.
+	names := make(map[uintptr]string)
+	for name, number := range capabilityNumbers {
+		if other, ok := names[number]; ok {
+			t.Fatalf("%s and %s have the same number %d", name, other, number)
+		}
+		names[number] = name
+	}
+
+	for number := uintptr(0); number < uintptr(len(capabilityNumbers)); number++ {
+		if _, ok := names[number]; !ok {
+			t.Fatalf("no capability has the number %d", number)
+		}
+	}
+}
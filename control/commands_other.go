@@ -0,0 +1,9 @@
+// +build !linux,!darwin,!windows
+
+package control
+
+// systemSpecificCommands returns the control commands that are only
+// supported on the current operating system.
+func systemSpecificCommands() []Command {
+	return nil
+}
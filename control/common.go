@@ -1,10 +1,16 @@
 package control
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/stephen-fox/cyberdaemon"
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
 )
 
 const (
@@ -24,6 +30,7 @@ const (
 	Stop      Command = "stop"
 	Install   Command = "install"
 	Uninstall Command = "uninstall"
+	Debug     Command = "debug"
 
 	// StartImmediately means that the daemon will start immediately
 	// after it is installed, and will be started whenever the
@@ -34,7 +41,8 @@ const (
 	StartImmediately StartType = "start_immediately"
 
 	// StartOnLoad means that the daemon will not start after its
-	// installation completes (with the exception of macOS). It will,
+	// installation completes (with the exception of macOS and
+	// supervisord, which start the daemon when it is added). It will,
 	// however, start each subsequent time the operating system loads
 	// the daemon. Each operating system "loads" the daemon at slightly
 	// different points depending on who owns the daemon (i.e., the
@@ -42,7 +50,10 @@ const (
 	// detail below.
 	//
 	// On Linux - the answer is a bit complicated. On System V (init.d),
-	// the daemon will start when the operating system boots. This will
+	// the daemon will start when the operating system boots (on OpenRC,
+	// the daemon is added to the 'default' runlevel, and on runit and s6,
+	// the service directory does not contain a 'down' file, and on
+	// supervisord, the program's 'autostart' setting is true). This will
 	// happen regardless of the daemon being run by root, or by a normal
 	// user. On systemd machines, a system-owned daemon will start when
 	// the operating system boots. However, a user-owned daemon will only
@@ -62,6 +73,30 @@ const (
 	// after its installation completes. In addition, the operating
 	// system will not start the daemon when it loads it.
 	ManualStart StartType = "manual"
+
+	// SystemScope means that the daemon is managed by the operating
+	// system's daemon manager. This is the default scope.
+	SystemScope Scope = "system"
+
+	// UserScope means that the daemon is managed by a user's instance
+	// of the daemon manager. The daemon is owned by the RunAs user
+	// (or the current user if RunAs is not set), and normally only
+	// runs while that user is logged in.
+	//
+	// On Linux, this is only supported on systemd, where the daemon is
+	// installed as a user unit (i.e., 'systemctl --user'). The unit
+	// file is saved in the user's systemd configuration directory
+	// ('$XDG_CONFIG_HOME/systemd/user', or '~/.config/systemd/user').
+	// A user daemon can be configured to run when the user is not
+	// logged in using the EnableLingerOption. If the current process
+	// is run by root via sudo and RunAs is not set, the daemon is
+	// installed for the user that invoked sudo.
+	//
+	// On macOS, the daemon is installed as a launchd user agent. The
+	// RunAs user must be the current user.
+	//
+	// This is not supported on Windows.
+	UserScope Scope = "user"
 )
 
 // Status represents the status of a daemon.
@@ -86,6 +121,13 @@ func (o StartType) string() string {
 	return string(o)
 }
 
+// Scope represents who manages a daemon.
+type Scope string
+
+func (o Scope) string() string {
+	return string(o)
+}
+
 // SystemSpecificOption specifies the name of an operating system
 // specific option.
 type SystemSpecificOption string
@@ -114,6 +156,21 @@ type Controller interface {
 	Stop() error
 }
 
+// Debugger is implemented by Controllers that can run an installed daemon
+// in the foreground. This is useful for troubleshooting a daemon that
+// misbehaves when it is started by the operating system.
+//
+// Implementations read the daemon's installed configuration (such as a
+// systemd unit file or an init.d script) and reconstruct the command line,
+// user, environment, and working directory that the operating system would
+// use to start the daemon. The daemon's output is attached to the terminal.
+type Debugger interface {
+	// Debug runs the installed daemon in the foreground. This method
+	// blocks until the daemon exits. Interrupt and termination signals
+	// received by the current process are forwarded to the daemon.
+	Debug() error
+}
+
 // ControllerConfig configures a daemon Controller.
 //
 // TODO: Additional daemon configuration:
@@ -126,6 +183,8 @@ type ControllerConfig struct {
 	// 	- Contain no spaces or special characters
 	// 	- On macOS, must be in reverse DNS format (e.g.,
 	// 	com.github.thedude.myapp)
+	// 	- On Linux, end with '@' to install a multi-instance daemon
+	// 	(e.g., myapp@). See InstanceManager for more information
 	DaemonID string
 
 	// Description is a short blurb describing your application.
@@ -138,6 +197,31 @@ type ControllerConfig struct {
 	// daemon's executable on startup.
 	Arguments []string
 
+	// Environment is a map of environment variable names to values
+	// that are set for the daemon's process. Variable names must
+	// consist of letters, numbers, and underscores, and may not
+	// start with a number.
+	//
+	// This is supported on Linux and macOS.
+	Environment map[string]string
+
+	// EnvironmentFiles is a list of paths to files that contain
+	// environment variables for the daemon's process. Each line of
+	// the file is a 'NAME=value' assignment. Variables in the files
+	// take precedence over the variables in Environment. If a path
+	// is prefixed with '-', the file is ignored if it does not exist.
+	//
+	// On systemd, the files are read by systemd (see the documentation
+	// for the 'EnvironmentFile' setting). On System V and OpenRC, the
+	// files are sourced by the init.d script (meaning they are
+	// interpreted as shell scripts, and each variable they set is
+	// exported). On runit and s6, they are sourced by the service
+	// directory's 'run' script in the same way.
+	//
+	// This is only supported on Linux, and is not supported on
+	// supervisord.
+	EnvironmentFiles []string
+
 	// RunAs is the user to run the daemon as.
 	//
 	// If left unset, the daemon will run as the following:
@@ -145,11 +229,90 @@ type ControllerConfig struct {
 	// 	- Administrator on Windows systems
 	RunAs string
 
+	// CreateUser specifies whether Install creates the RunAs user and
+	// the Group as system accounts if they do not exist. The user's
+	// login shell is 'nologin', and its home directory is not created.
+	// Accounts are not removed when the daemon is uninstalled.
+	//
+	// This requires the 'useradd' and 'groupadd' utilities, and is only
+	// supported by system daemons on Linux.
+	CreateUser bool
+
+	// Group is the name of the daemon's primary group. If left unset,
+	// the RunAs user's primary group is used.
+	//
+	// On System V, changing the group requires the 'setpriv' utility.
+	// This is not supported by user daemons (see UserScope) on Linux,
+	// on s6 or supervisord, or on Windows.
+	Group string
+
+	// SupplementaryGroups are the names of groups that the daemon's
+	// process is added to (in addition to the RunAs user's groups).
+	//
+	// On System V, this requires the 'setpriv' utility. On runit, the
+	// RunAs user's other groups are not kept ('chpst' only adds the
+	// daemon's primary group and these groups). This is not supported
+	// by user daemons (see UserScope) on Linux, on OpenRC, s6, or
+	// supervisord, or on macOS and Windows.
+	SupplementaryGroups []string
+
+	// WorkDirPath is the daemon's working directory. If left unset,
+	// the operating system's default is used (typically '/' for
+	// system daemons, and the user's home directory for user daemons).
+	//
+	// This is only supported on Linux.
+	WorkDirPath string
+
+	// Umask is the daemon's file mode creation mask formatted as an
+	// octal string (for example, "0027"). If left unset, the operating
+	// system's default is used.
+	//
+	// This is not supported on Windows.
+	Umask string
+
+	// Capabilities are the Linux capabilities that the daemon's process
+	// is granted (for example, "CAP_NET_BIND_SERVICE" allows a daemon
+	// that is not run as root to bind to ports below 1024). All other
+	// capabilities are removed from the process' capability bounding
+	// set, even if the daemon runs as root.
+	//
+	// On System V, this requires the 'setpriv' utility. On OpenRC, this
+	// requires OpenRC 0.45 or later. This is only supported by system
+	// daemons on Linux, and is not supported on runit, s6, or supervisord.
+	Capabilities []string
+
+	// Directories configures directories that are created for the
+	// daemon. See Directories for more information.
+	Directories Directories
+
+	// Dependencies configures the daemon's dependencies on other
+	// daemons. See Dependencies for more information.
+	Dependencies Dependencies
+
+	// RestartPolicy configures when the daemon is restarted after it
+	// exits. See cyberdaemon.RestartPolicy for more information.
+	//
+	// This is only supported on Linux.
+	RestartPolicy cyberdaemon.RestartPolicy
+
+	// ResourceLimits configures the resources the daemon may use,
+	// and its scheduling priority. See ResourceLimits for a list of
+	// the limits that each operating system supports.
+	ResourceLimits ResourceLimits
+
 	// StartType specifies the daemon's start up behavior.
 	//
 	// If left unset, the daemon must be started manually.
 	StartType StartType
 
+	// Scope specifies who manages the daemon. See UserScope for
+	// more information about user daemons.
+	//
+	// If left unset, SystemScope is used (unless the legacy
+	// RunOnlyWhenLoggedIn option is specified, which is
+	// equivalent to UserScope).
+	Scope Scope
+
 	// LogConfig, in this context, configures the operating system's
 	// daemon logging configuration. Some operating systems can
 	// store this separately from the daemon executable (macOS,
@@ -166,15 +329,218 @@ func (o ControllerConfig) Validate() error {
 		return fmt.Errorf("executable path must be provided to controller config")
 	}
 
+	err := validateDaemonIDInstance(o.DaemonID)
+	if err != nil {
+		return err
+	}
+
+	switch o.Scope {
+	case "", SystemScope, UserScope:
+	default:
+		return fmt.Errorf("unknown daemon scope '%s'", o.Scope)
+	}
+
+	if len(o.WorkDirPath) > 0 && !path.IsAbs(o.WorkDirPath) {
+		return fmt.Errorf("working directory path '%s' must be absolute", o.WorkDirPath)
+	}
+
+	if len(o.Umask) > 0 {
+		_, err := o.umask()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = o.RestartPolicy.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = o.ResourceLimits.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = validateCapabilities(o.Capabilities)
+	if err != nil {
+		return err
+	}
+
+	err = o.Directories.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = o.Dependencies.Validate()
+	if err != nil {
+		return err
+	}
+	for _, id := range o.Dependencies.daemonIDs() {
+		if id == o.DaemonID {
+			return fmt.Errorf("daemon '%s' cannot depend on itself", id)
+		}
+	}
+
+	if o.CreateUser && len(o.RunAs) == 0 {
+		return fmt.Errorf("a RunAs user must be specified when creating a user")
+	}
+
+	for _, group := range append([]string{o.Group}, o.SupplementaryGroups...) {
+		if strings.ContainsAny(group, " \t\r\n\x00:,") {
+			return fmt.Errorf("group name '%s' is invalid", group)
+		}
+	}
+	for _, group := range o.SupplementaryGroups {
+		if len(group) == 0 {
+			return fmt.Errorf("supplementary group names cannot be empty")
+		}
+	}
+
+	for name, value := range o.Environment {
+		if !isVariableName(name) {
+			return fmt.Errorf("environment variable name '%s' is invalid", name)
+		}
+		if strings.IndexByte(value, 0) > -1 {
+			return fmt.Errorf("environment variable '%s' may not contain null characters", name)
+		}
+	}
+
+	for _, filePath := range o.EnvironmentFiles {
+		if !path.IsAbs(strings.TrimPrefix(filePath, "-")) {
+			return fmt.Errorf("environment file path '%s' must be absolute", filePath)
+		}
+		if strings.ContainsAny(filePath, "\x00\r\n") {
+			return fmt.Errorf("environment file path '%s' may not contain newlines or null characters", filePath)
+		}
+	}
+
 	return nil
 }
 
-func (o ControllerConfig) argumentsAsString() string {
-	if len(o.Arguments) == 0 {
+// umask parses the Umask field.
+func (o ControllerConfig) umask() (int, error) {
+	return osutil.ParseUmask(o.Umask)
+}
+
+// restartPolicyEnvValue returns the value of the daemon's
+// cyberdaemon.RestartPolicyEnv environment variable, or an empty
+// string if the daemon does not have a restart policy.
+func restartPolicyEnvValue(policy cyberdaemon.RestartPolicy) string {
+	if !policy.IsSet() {
 		return ""
 	}
 
-	return strings.Join(o.Arguments, " ")
+	return policy.String()
+}
+
+// hash returns a hex encoded SHA-256 hash of the configuration. Values
+// in SystemSpecificOptions that are not strings, booleans, or numbers
+// (such as functions) are represented by their type.
+func (o ControllerConfig) hash() string {
+	h := sha256.New()
+
+	write := func(values ...string) {
+		for _, value := range values {
+			// Prefix each value with its length so that
+			// adjacent values cannot be confused.
+			fmt.Fprintf(h, "%d:%s", len(value), value)
+		}
+	}
+
+	// An unset StartType is equivalent to ManualStart.
+	startType := o.StartType
+	if len(startType) == 0 {
+		startType = ManualStart
+	}
+
+	write(o.DaemonID, o.Description, o.ExePath)
+	write(strconv.Itoa(len(o.Arguments)))
+	write(o.Arguments...)
+	var environmentNames []string
+	for name := range o.Environment {
+		environmentNames = append(environmentNames, name)
+	}
+	sort.Strings(environmentNames)
+
+	write(strconv.Itoa(len(environmentNames)))
+	for _, name := range environmentNames {
+		write(name, o.Environment[name])
+	}
+	write(strconv.Itoa(len(o.EnvironmentFiles)))
+	write(o.EnvironmentFiles...)
+
+	write(o.Group, strconv.Itoa(len(o.SupplementaryGroups)))
+	write(o.SupplementaryGroups...)
+	write(o.WorkDirPath, o.Umask)
+	write(o.ResourceLimits.hashValues()...)
+	write(strconv.Itoa(len(o.Capabilities)))
+	write(o.Capabilities...)
+
+	// Settings that only affect Install and Uninstall (such as
+	// CreateUser) are not stored in the generated files.
+	for _, kind := range o.Directories.kinds() {
+		write(strconv.Itoa(len(kind.names)))
+		write(kind.names...)
+	}
+	write(o.Directories.mode().String())
+
+	// After is normalized because the daemons implied by Requires
+	// and BindsTo are not stored separately.
+	for _, daemonIDs := range [][]string{o.Dependencies.explicitAfter(), o.Dependencies.Before,
+		o.Dependencies.Requires, o.Dependencies.PartOf, o.Dependencies.BindsTo} {
+		write(strconv.Itoa(len(daemonIDs)))
+		write(daemonIDs...)
+	}
+
+	// An unset Mode is equivalent to RestartOnFailure if any other
+	// restart setting is specified.
+	if o.RestartPolicy.IsSet() {
+		write(string(o.RestartPolicy.EffectiveMode()), o.RestartPolicy.Delay.String(),
+			strconv.Itoa(o.RestartPolicy.BurstLimit), o.RestartPolicy.BurstInterval.String())
+	} else {
+		write("")
+	}
+
+	write(o.RunAs, startType.string(), o.scope().string(),
+		strconv.FormatBool(o.LogConfig.UseNativeLogger), strconv.Itoa(o.LogConfig.NativeLogFlags))
+
+	var optionNames []string
+	for name := range o.SystemSpecificOptions {
+		optionNames = append(optionNames, string(name))
+	}
+	sort.Strings(optionNames)
+
+	for _, name := range optionNames {
+		value := o.SystemSpecificOptions[SystemSpecificOption(name)]
+		switch value.(type) {
+		case string, bool, int, int64, uint, uint64, float64:
+			write(name, fmt.Sprintf("%v", value))
+		default:
+			write(name, fmt.Sprintf("%T", value))
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isVariableName returns true if the string is a valid environment
+// variable name.
+func isVariableName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+
+	return true
 }
 
 // SupportedCommandsString returns a printable string that represents a list of
@@ -183,15 +549,22 @@ func SupportedCommandsString() string {
 	return fmt.Sprintf("'%s'", strings.Join(SupportedCommands(), "', '"))
 }
 
-// SupportedCommands returns a slice of supported daemon control commands.
+// SupportedCommands returns a slice of daemon control commands that are
+// supported on the current operating system.
 func SupportedCommands() []string {
-	return []string{
+	commands := []string{
 		GetStatus.string(),
 		Start.string(),
 		Stop.string(),
 		Install.string(),
 		Uninstall.string(),
 	}
+
+	for _, command := range systemSpecificCommands() {
+		commands = append(commands, command.string())
+	}
+
+	return commands
 }
 
 // Execute executes a control command using the provided daemon controller.
@@ -237,6 +610,19 @@ func Execute(command Command, controller Controller) (output string, err error)
 			return "", fmt.Errorf("failed to uninstall daemon - %s", err.Error())
 		}
 
+		return "", nil
+	case Debug:
+		debugger, ok := controller.(Debugger)
+		if !ok {
+			return "", fmt.Errorf("the '%s' command is not supported by this system's daemon controller",
+				command.string())
+		}
+
+		err := debugger.Debug()
+		if err != nil {
+			return "", fmt.Errorf("failed to debug daemon - %s", err.Error())
+		}
+
 		return "", nil
 	}
 
@@ -0,0 +1,483 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon"
+	"github.com/stephen-fox/cyberdaemon/internal/supervisor"
+	"github.com/stephen-fox/cyberdaemon/pidfile"
+)
+
+const (
+	// builtinConfigDirPath is the directory that stores the files that
+	// describe the daemons managed by the built-in supervisor.
+	builtinConfigDirPath = "/etc/cyberdaemon"
+
+	// builtinRuntimeDirPath is the directory that stores the runtime
+	// directory of each daemon (which contains the supervisor's PID
+	// file and control socket).
+	builtinRuntimeDirPath = "/run/cyberdaemon"
+
+	builtinConfigFileSuffix = ".json"
+	builtinStartTimeout     = 30 * time.Second
+	builtinStopTimeout      = 30 * time.Second
+	builtinCommandTimeout   = 5 * time.Second
+)
+
+// builtinDaemonFile describes a daemon that is managed by the built-in
+// supervisor. It is stored as JSON in the builtinConfigDirPath directory,
+// and serves the same purpose as a systemd unit file or an init.d script.
+type builtinDaemonFile struct {
+	Version             string
+	ConfigHash          string
+	DaemonID            string
+	Description         string
+	ExePath             string
+	Arguments           []string
+	Environment         []string
+	EnvironmentFiles    []string
+	WorkDirPath         string
+	Umask               string
+	RunAs               string
+	Group               string
+	SupplementaryGroups []string
+	Directories         []string
+	DirectoryMode       string
+	RestartPolicy       string
+	StartType           string
+	LogFilePath         string
+}
+
+// builtinController controls a daemon using the built-in supervisor,
+// which is used when the operating system does not provide a daemon
+// manager (for example, in a minimal container). The supervisor is the
+// daemon's own executable. The Daemonizer runs the executable as the
+// supervisor when the supervisor.DirEnv environment variable is set.
+//
+// Start runs the supervisor in a new session with the environment,
+// user, and working directory described by the daemon's file, and
+// waits for the supervisor's control socket to accept commands. Stop
+// sends the stop command to the supervisor, and waits for it to exit.
+// There is no init system to start the daemon when the system boots,
+// so StartOnLoad is equivalent to ManualStart.
+type builtinController struct {
+	daemonID       string
+	filePath       string
+	contents       []byte
+	runtimeDirPath string
+	startType      StartType
+	install        installSettings
+}
+
+func (o *builtinController) Status() (Status, error) {
+	info, statErr := os.Stat(o.filePath)
+	if statErr != nil || info.IsDir() {
+		return NotInstalled, nil
+	}
+
+	_, isRunning, err := pidfile.IsRunning(supervisor.PIDFilePath(o.runtimeDirPath))
+	if err != nil {
+		return Unknown, err
+	}
+
+	if isRunning {
+		return Running, nil
+	}
+
+	return Stopped, nil
+}
+
+func (o *builtinController) Install() error {
+	err := o.install.beforeInstall()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(builtinConfigDirPath, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create daemon configuration directory - %s", err.Error())
+	}
+
+	err = ioutil.WriteFile(o.filePath, o.contents, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write daemon configuration file - %s", err.Error())
+	}
+
+	if o.startType == StartImmediately {
+		return o.Start()
+	}
+
+	return nil
+}
+
+func (o *builtinController) Uninstall() error {
+	// Try to stop the daemon. Ignore any errors because it might be
+	// stopped already.
+	o.Stop()
+
+	err := os.Remove(o.filePath)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(o.runtimeDirPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove daemon runtime directory - %s", err.Error())
+	}
+
+	return o.install.afterUninstall()
+}
+
+func (o *builtinController) Start() error {
+	pidFilePath := supervisor.PIDFilePath(o.runtimeDirPath)
+	if pid, isRunning, _ := pidfile.IsRunning(pidFilePath); isRunning {
+		return fmt.Errorf("daemon is already running with pid %d", pid)
+	}
+
+	file, command, err := o.prepare()
+	if err != nil {
+		return err
+	}
+
+	daemon, err := command.command()
+	if err != nil {
+		return err
+	}
+
+	if len(file.RestartPolicy) > 0 {
+		daemon.Env = append(daemon.Env, cyberdaemon.RestartPolicyEnv+"="+file.RestartPolicy)
+	}
+
+	output, err := openBuiltinLogFile(file.LogFilePath)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	// Leaving stdin unset connects it to the null device.
+	daemon.Stdout = output
+	daemon.Stderr = output
+	daemon.SysProcAttr.Setsid = true
+
+	err = command.start(daemon)
+	if err != nil {
+		return err
+	}
+
+	return awaitBuiltinSupervisor(daemon, o.runtimeDirPath, file.LogFilePath)
+}
+
+func (o *builtinController) Stop() error {
+	pidFilePath := supervisor.PIDFilePath(o.runtimeDirPath)
+	pid, isRunning, _ := pidfile.IsRunning(pidFilePath)
+	if !isRunning {
+		return nil
+	}
+
+	_, err := supervisor.Send(o.runtimeDirPath, supervisor.StopCommand, builtinCommandTimeout)
+	if err != nil {
+		// The supervisor may not be listening yet (or anymore).
+		err = syscall.Kill(pid, syscall.SIGTERM)
+		if err != nil {
+			return fmt.Errorf("failed to signal supervisor process %d - %s", pid, err.Error())
+		}
+	}
+
+	deadline := time.Now().Add(builtinStopTimeout)
+	for time.Now().Before(deadline) {
+		_, isRunning, _ := pidfile.IsRunning(pidFilePath)
+		if !isRunning {
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("daemon did not stop after %s", builtinStopTimeout.String())
+}
+
+// Debug runs the supervisor in the foreground. The daemon is not
+// restarted when it exits, regardless of its restart policy.
+func (o *builtinController) Debug() error {
+	_, command, err := o.prepare()
+	if err != nil {
+		return err
+	}
+
+	return command.run()
+}
+
+// prepare reads the daemon's file, and creates the daemon's directories.
+// It returns the command that runs the supervisor.
+func (o *builtinController) prepare() (builtinDaemonFile, foregroundCommand, error) {
+	file, err := readBuiltinDaemonFile(o.filePath)
+	if err != nil {
+		return builtinDaemonFile{}, foregroundCommand{}, err
+	}
+
+	command, err := builtinForegroundCommand(file, o.runtimeDirPath)
+	if err != nil {
+		return builtinDaemonFile{}, foregroundCommand{}, err
+	}
+
+	credential, err := command.credential()
+	if err != nil {
+		return builtinDaemonFile{}, foregroundCommand{}, err
+	}
+
+	err = createBuiltinDirectories(file, credential, o.runtimeDirPath)
+	if err != nil {
+		return builtinDaemonFile{}, foregroundCommand{}, err
+	}
+
+	return file, command, nil
+}
+
+// awaitBuiltinSupervisor waits for the supervisor's control socket to
+// accept commands, which happens once the Application has started. The
+// supervisor is killed if it does not start before the timeout.
+func awaitBuiltinSupervisor(daemon *exec.Cmd, runtimeDirPath string, logFilePath string) error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- daemon.Wait()
+	}()
+
+	logHint := ""
+	if len(logFilePath) > 0 {
+		logHint = fmt.Sprintf(" (see '%s')", logFilePath)
+	}
+
+	deadline := time.Now().Add(builtinStartTimeout)
+
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-exited:
+			if err != nil {
+				return fmt.Errorf("daemon exited before it started%s - %s", logHint, err.Error())
+			}
+			return fmt.Errorf("daemon exited before it started%s", logHint)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		reply, err := supervisor.Send(runtimeDirPath, supervisor.StatusCommand, builtinCommandTimeout)
+		if err == nil && reply == supervisor.RunningReply {
+			return nil
+		}
+	}
+
+	daemon.Process.Kill()
+
+	return fmt.Errorf("daemon did not report that it started after %s%s", builtinStartTimeout.String(), logHint)
+}
+
+// openBuiltinLogFile opens the file that the supervisor's output is
+// appended to. The null device is returned if the path is empty.
+func openBuiltinLogFile(logFilePath string) (*os.File, error) {
+	if len(logFilePath) == 0 {
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open null device - %s", err.Error())
+		}
+		return devNull, nil
+	}
+
+	err := os.MkdirAll(path.Dir(logFilePath), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log file directory - %s", err.Error())
+	}
+
+	f, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file - %s", err.Error())
+	}
+
+	return f, nil
+}
+
+// createBuiltinDirectories creates the daemon's directories and its
+// runtime directory, and changes their owner to the daemon's user.
+func createBuiltinDirectories(file builtinDaemonFile, credential *syscall.Credential, runtimeDirPath string) error {
+	mode := os.FileMode(0755)
+	if len(file.DirectoryMode) > 0 {
+		parsed, err := strconv.ParseUint(file.DirectoryMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("failed to parse directory mode '%s' - %s", file.DirectoryMode, err.Error())
+		}
+		mode = os.FileMode(parsed)
+	}
+
+	dirModes := map[string]os.FileMode{
+		runtimeDirPath: 0700,
+	}
+	for _, dirPath := range file.Directories {
+		dirModes[dirPath] = mode
+	}
+
+	for dirPath, dirMode := range dirModes {
+		err := os.MkdirAll(dirPath, dirMode)
+		if err != nil {
+			return fmt.Errorf("failed to create directory '%s' - %s", dirPath, err.Error())
+		}
+
+		err = os.Chmod(dirPath, dirMode)
+		if err != nil {
+			return fmt.Errorf("failed to change mode of directory '%s' - %s", dirPath, err.Error())
+		}
+
+		if credential != nil {
+			err = os.Chown(dirPath, int(credential.Uid), int(credential.Gid))
+			if err != nil {
+				return fmt.Errorf("failed to change owner of directory '%s' - %s", dirPath, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// builtinForegroundCommand returns the command that runs the daemon
+// described by the file as the supervisor.
+func builtinForegroundCommand(file builtinDaemonFile, runtimeDirPath string) (foregroundCommand, error) {
+	// Like systemd, the supervisor runs daemons with a mostly empty
+	// environment.
+	env := newEnvironment(systemdDefaultPath)
+	env.set(supervisor.DirEnv, runtimeDirPath)
+	if len(file.RunAs) > 0 {
+		u, err := user.Lookup(file.RunAs)
+		if err != nil {
+			return foregroundCommand{}, fmt.Errorf("failed to lookup user '%s' - %s", file.RunAs, err.Error())
+		}
+		env.setUser(u)
+	}
+
+	instanceName := instanceName(file.DaemonID)
+	if len(instanceName) > 0 {
+		env.set(cyberdaemon.InstanceEnv, instanceName)
+	}
+
+	for _, assignment := range file.Environment {
+		err := env.setAssignment(assignment)
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+	}
+
+	err := loadEnvironmentFiles(file.EnvironmentFiles, env)
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	workDirPath := file.WorkDirPath
+	if len(workDirPath) == 0 {
+		workDirPath = "/"
+	}
+
+	return foregroundCommand{
+		exePath:             file.ExePath,
+		args:                file.Arguments,
+		runAs:               file.RunAs,
+		group:               file.Group,
+		supplementaryGroups: file.SupplementaryGroups,
+		umask:               file.Umask,
+		env:                 env,
+		workDirPath:         workDirPath,
+	}, nil
+}
+
+// readBuiltinDaemonFile reads and parses a daemon's file.
+func readBuiltinDaemonFile(filePath string) (builtinDaemonFile, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return builtinDaemonFile{}, fmt.Errorf("failed to read daemon configuration file - %s", err.Error())
+	}
+
+	var file builtinDaemonFile
+	err = json.Unmarshal(contents, &file)
+	if err != nil {
+		return builtinDaemonFile{}, fmt.Errorf("failed to parse daemon configuration file '%s' - %s",
+			filePath, err.Error())
+	}
+
+	if len(file.ExePath) == 0 {
+		return builtinDaemonFile{}, fmt.Errorf("daemon configuration file '%s' does not specify an executable path",
+			filePath)
+	}
+
+	return file, nil
+}
+
+// builtinDaemonRuntimeDirPath returns the path to the daemon's runtime directory.
+func builtinDaemonRuntimeDirPath(daemonID string) string {
+	return path.Join(builtinRuntimeDirPath, daemonID)
+}
+
+func newBuiltinController(config ControllerConfig) (*builtinController, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case config.Scope == UserScope:
+		return nil, fmt.Errorf("the '%s' scope is not supported by the built-in supervisor", UserScope)
+	case isMultiInstanceID(config.DaemonID):
+		return nil, fmt.Errorf("multi-instance daemons are not supported by the built-in supervisor")
+	case len(config.Capabilities) > 0:
+		return nil, fmt.Errorf("capabilities are not supported by the built-in supervisor")
+	case config.Dependencies.isSet():
+		return nil, fmt.Errorf("dependencies are not supported by the built-in supervisor")
+	case config.ResourceLimits.isSet():
+		return nil, fmt.Errorf("resource limits are not supported by the built-in supervisor")
+	}
+
+	file := builtinDaemonFile{
+		Version:             cyberdaemon.Version,
+		ConfigHash:          config.hash(),
+		DaemonID:            config.DaemonID,
+		Description:         config.Description,
+		ExePath:             config.ExePath,
+		Arguments:           config.Arguments,
+		Environment:         environmentAssignments(config.Environment),
+		EnvironmentFiles:    config.EnvironmentFiles,
+		WorkDirPath:         config.WorkDirPath,
+		Umask:               config.Umask,
+		RunAs:               config.RunAs,
+		Group:               config.Group,
+		SupplementaryGroups: config.SupplementaryGroups,
+		Directories:         config.Directories.paths(),
+		RestartPolicy:       restartPolicyEnvValue(config.RestartPolicy),
+		StartType:           config.StartType.string(),
+	}
+
+	if config.Directories.isSet() {
+		file.DirectoryMode = fmt.Sprintf("%04o", uint32(config.Directories.mode()))
+	}
+
+	if config.LogConfig.UseNativeLogger {
+		// Log file path example: '/var/log/mydaemon/mydaemon.log'.
+		file.LogFilePath = path.Join("/var/log", config.DaemonID, config.DaemonID+".log")
+	}
+
+	contents, err := json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode daemon configuration file - %s", err.Error())
+	}
+
+	return &builtinController{
+		daemonID:       config.DaemonID,
+		filePath:       path.Join(builtinConfigDirPath, config.DaemonID+builtinConfigFileSuffix),
+		contents:       append(contents, '\n'),
+		runtimeDirPath: builtinDaemonRuntimeDirPath(config.DaemonID),
+		startType:      config.StartType,
+		install:        newInstallSettings(config),
+	}, nil
+}
@@ -0,0 +1,61 @@
+package control
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stephen-fox/cyberdaemon/internal/supervisor"
+)
+
+func TestAwaitBuiltinSupervisor(t *testing.T) {
+	runtimeDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(runtimeDirPath)
+
+	// A supervisor that exits before its socket accepts commands
+	// did not start.
+	daemon := exec.Command("true")
+	err = daemon.Start()
+	if err != nil {
+		t.Skipf("failed to run 'true' - %s", err.Error())
+	}
+
+	err = awaitBuiltinSupervisor(daemon, runtimeDirPath, "/var/log/app.log")
+	if err == nil {
+		t.Fatal("expected an error for a supervisor that exited")
+	}
+	if !strings.Contains(err.Error(), "'/var/log/app.log'") {
+		t.Fatalf("expected the error to refer to the log file - got: %s", err.Error())
+	}
+
+	listener, err := net.Listen("unix", supervisor.SocketPath(runtimeDirPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go supervisor.Serve(listener, func(command string) (string, func()) {
+		if command == supervisor.StatusCommand {
+			return supervisor.RunningReply, nil
+		}
+		return supervisor.UnknownCommandReply, nil
+	})
+
+	daemon = exec.Command("sleep", "10")
+	err = daemon.Start()
+	if err != nil {
+		t.Skipf("failed to run 'sleep' - %s", err.Error())
+	}
+	defer daemon.Process.Kill()
+
+	err = awaitBuiltinSupervisor(daemon, runtimeDirPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
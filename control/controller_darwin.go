@@ -1,6 +1,8 @@
 package control
 
 import (
+	"bytes"
+	"encoding/xml"
 	"fmt"
 	"os"
 	"os/user"
@@ -70,6 +72,42 @@ func NewController(controllerConfig ControllerConfig) (Controller, error) {
 		return nil, err
 	}
 
+	if len(controllerConfig.EnvironmentFiles) > 0 {
+		return nil, fmt.Errorf("environment files are not supported on macOS")
+	}
+
+	if len(controllerConfig.WorkDirPath) > 0 {
+		return nil, fmt.Errorf("setting the working directory is not supported on macOS")
+	}
+
+	if len(controllerConfig.SupplementaryGroups) > 0 {
+		return nil, fmt.Errorf("supplementary groups are not supported on macOS")
+	}
+
+	if len(controllerConfig.Capabilities) > 0 {
+		return nil, fmt.Errorf("capabilities are not supported on macOS")
+	}
+
+	if controllerConfig.CreateUser || controllerConfig.Directories.isSet() {
+		return nil, fmt.Errorf("creating users and directories is not supported on macOS")
+	}
+
+	if controllerConfig.ResourceLimits.isSet() {
+		return nil, fmt.Errorf("resource limits are not supported on macOS")
+	}
+
+	if controllerConfig.Dependencies.isSet() {
+		return nil, fmt.Errorf("dependencies are not supported on macOS")
+	}
+
+	if controllerConfig.RestartPolicy.IsSet() {
+		return nil, fmt.Errorf("restart policies are not supported on macOS")
+	}
+
+	if isMultiInstanceID(controllerConfig.DaemonID) {
+		return nil, fmt.Errorf("multi-instance daemons are not supported on macOS")
+	}
+
 	// TODO: Allow user to provide reverse DNS prefix using OS option.
 	if strings.Count(controllerConfig.DaemonID, ".") < 2 {
 		return nil, fmt.Errorf("daemon ID must be in reverse DNS format on macOS (e.g., net.website.MyApp)")
@@ -104,10 +142,33 @@ func NewController(controllerConfig ControllerConfig) (Controller, error) {
 		SetStandardErrorPath(logFilePath).
 		SetUserName(runAs)
 
+	if len(controllerConfig.Group) > 0 {
+		builder.SetGroupName(controllerConfig.Group)
+	}
+
+	if len(controllerConfig.Umask) > 0 {
+		mask, err := controllerConfig.umask()
+		if err != nil {
+			return nil, err
+		}
+		builder.SetUmask(mask)
+	}
+
 	for i := range controllerConfig.Arguments {
 		builder.AddArgument(controllerConfig.Arguments[i])
 	}
 
+	for name, value := range controllerConfig.Environment {
+		// The launchd configuration is XML, and values are
+		// not escaped by the builder.
+		escaped := bytes.NewBuffer(nil)
+		err := xml.EscapeText(escaped, []byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("failed to escape environment variable '%s' - %s", name, err.Error())
+		}
+		builder.AddEnvironmentVariable(name, escaped.String())
+	}
+
 	lconfig, err := builder.Build()
 	if err != nil {
 		return nil, err
@@ -127,7 +188,9 @@ func runSettings(config ControllerConfig) (launchctlutil.Kind, bool, string, err
 	logPathSuffix := fmt.Sprintf("Library/Logs/%s/%s.log",
 		config.DaemonID, config.DaemonID)
 
-	if len(config.RunAs) == 0 {
+	isUserScope := config.scope() == UserScope
+
+	if len(config.RunAs) == 0 && !isUserScope {
 		return launchctlutil.Daemon, false, path.Join("/", logPathSuffix), nil
 	}
 
@@ -137,14 +200,13 @@ func runSettings(config ControllerConfig) (launchctlutil.Kind, bool, string, err
 			fmt.Errorf("failed to get current user - %s", err.Error())
 	}
 
-	_, onlyRunWhenLoggedIn := config.SystemSpecificOptions[RunOnlyWhenLoggedIn]
-	if onlyRunWhenLoggedIn {
-		if config.RunAs == current.Username {
+	if isUserScope {
+		if len(config.RunAs) == 0 || config.RunAs == current.Username {
 			return launchctlutil.UserAgent, false, path.Join(current.HomeDir, logPathSuffix), nil
 		}
 		return launchctlutil.Daemon, false, "",
-			fmt.Errorf("the '%s' option cannot be used when the curret user is not the RunAs user",
-				RunOnlyWhenLoggedIn)
+			fmt.Errorf("the '%s' scope cannot be used when the curret user is not the RunAs user",
+				UserScope)
 	}
 
 	runAs, lookUpErr := user.Lookup(config.RunAs)
@@ -154,3 +216,9 @@ func runSettings(config ControllerConfig) (launchctlutil.Kind, bool, string, err
 
 	return launchctlutil.Daemon, true, path.Join(runAs.HomeDir, logPathSuffix), nil
 }
+
+// systemSpecificCommands returns the control commands that are only
+// supported on macOS.
+func systemSpecificCommands() []Command {
+	return nil
+}
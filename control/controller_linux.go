@@ -9,14 +9,66 @@ import (
 // TODO: Provide a means to override the daemon CLI executable path. Also,
 //  search some common directories for the executable after trying defaults.
 func NewController(controllerConfig ControllerConfig) (Controller, error) {
+	isMultiInstance := isMultiInstanceID(controllerConfig.DaemonID)
+
+	if _, useSupervisord := controllerConfig.SystemSpecificOptions[SupervisordOption]; useSupervisord {
+		supervisorctlPath, _, isSupervisord := osutil.IsSupervisord()
+		if !isSupervisord {
+			return nil, fmt.Errorf("the '%s' option was specified, but 'supervisorctl' failed to connect to supervisord",
+				SupervisordOption)
+		}
+
+		return newSupervisordController(controllerConfig, supervisorctlPath)
+	}
+
 	if systemctlPath, isSystemd := osutil.IsSystemd(); isSystemd {
-		return newSystemdController(controllerConfig, systemctlPath)
+		controller, err := newSystemdController(controllerConfig, systemctlPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if isMultiInstance {
+			return &systemdTemplateController{systemdController: controller}, nil
+		}
+
+		return controller, nil
+	}
+
+	if rcServicePath, isOpenRC := osutil.IsOpenRC(); isOpenRC {
+		return newOpenrcController(controllerConfig, rcServicePath)
+	}
+
+	if svPath, scanDirPath, isRunit := osutil.IsRunit(); isRunit {
+		return newSupervisionController(controllerConfig, runitSuite, svPath, scanDirPath)
+	}
+
+	if s6SvcPath, scanDirPath, isS6 := osutil.IsS6(); isS6 {
+		return newSupervisionController(controllerConfig, s6Suite, s6SvcPath, scanDirPath)
+	}
+
+	if supervisorctlPath, pid, isSupervisord := osutil.IsSupervisord(); isSupervisord && pid == 1 {
+		return newSupervisordController(controllerConfig, supervisorctlPath)
 	}
 
-	servicePath, isRedHat, notVReason, isSystemv := osutil.IsSystemv()
+	servicePath, isRedHat, _, isSystemv := osutil.IsSystemv()
 	if isSystemv {
+		if isMultiInstance {
+			return newSystemvTemplateController(controllerConfig, servicePath, isRedHat)
+		}
+
 		return newSystemvController(controllerConfig, servicePath, isRedHat)
 	}
 
-	return nil, fmt.Errorf(notVReason)
+	// The built-in supervisor is used when the operating system
+	// does not provide a daemon manager.
+	return newBuiltinController(controllerConfig)
+}
+
+// systemSpecificCommands returns the control commands that are only
+// supported on Linux. The Controllers returned by NewController on
+// Linux implement Debugger.
+func systemSpecificCommands() []Command {
+	return []Command{
+		Debug,
+	}
 }
@@ -0,0 +1,491 @@
+package control
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon"
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+const (
+	// openrcScriptTemplate is the template of the OpenRC service scripts
+	// generated by a Controller. It is rendered using an openrcScriptData.
+	//
+	// OpenRC evaluates most of the script's variables (such as 'command'
+	// and 'command_args') when it runs the daemon, and checks whether
+	// others are set (rather than non-empty). Variables are therefore
+	// omitted when they are not set, and values that are evaluated must
+	// not contain characters that are special to the shell.
+	openrcScriptTemplate = `#!/sbin/openrc-run
+# X-Cyberdaemon-Version: {{.LibraryVersion}}
+# X-Cyberdaemon-Config-Hash: {{.ConfigHash}}
+
+name={{shellQuote .Name}}
+description={{shellQuote .Description}}
+{{- if .Supervised}}
+supervisor=supervise-daemon
+{{- else}}
+command_background=yes
+{{- end}}
+command={{shellQuote .ExePath}}
+command_args={{shellQuote (shellQuoteAll .Arguments)}}
+pidfile={{shellQuote .PIDFilePath}}
+{{- if .CommandUser}}
+command_user={{shellQuote .CommandUser}}
+{{- end}}
+{{- if .WorkDirPath}}
+directory={{shellQuote .WorkDirPath}}
+{{- end}}
+{{- if .Umask}}
+umask={{shellQuote .Umask}}
+{{- end}}
+{{- if .Capabilities}}
+capabilities={{shellQuote .Capabilities}}
+{{- end}}
+{{- if .LogFilePath}}
+error_log={{shellQuote .LogFilePath}}
+{{- end}}
+{{- if .RespawnDelay}}
+respawn_delay={{.RespawnDelay}}
+{{- end}}
+{{- if .RespawnMax}}
+respawn_max={{.RespawnMax}}
+{{- end}}
+{{- if .RespawnPeriod}}
+respawn_period={{.RespawnPeriod}}
+{{- end}}
+{{- if .SupervisorArgs}}
+{{if .Supervised}}supervise_daemon_args{{else}}start_stop_daemon_args{{end}}={{shellQuote .SupervisorArgs}}
+{{- end}}
+cyberdaemon_environment={{shellQuote (shellQuoteAll .Environment)}}
+cyberdaemon_environment_files={{shellQuote (shellQuoteAll .EnvironmentFiles)}}
+cyberdaemon_directories={{shellQuote (shellQuoteAll .Directories)}}
+cyberdaemon_directory_mode={{shellQuote .DirectoryMode}}
+
+depend() {
+    use logger
+{{- if .Need}}
+    need {{join .Need " "}}
+{{- end}}
+{{- if .After}}
+    after {{join .After " "}}
+{{- end}}
+{{- if .Before}}
+    before {{join .Before " "}}
+{{- end}}
+}
+
+# start_pre exports the daemon's environment variables, and creates its
+# directories. Files in cyberdaemon_environment_files are sourced, and
+# may override any variable. Paths prefixed with '-' are ignored if the
+# file does not exist.
+start_pre() {
+    if [ -n "${cyberdaemon_environment}" ]
+    then
+        eval "export ${cyberdaemon_environment}"
+    fi
+    local environment_file
+    eval "set -- ${cyberdaemon_environment_files}"
+    for environment_file in "$@"
+    do
+        case "${environment_file}" in
+            -*)
+                environment_file="${environment_file#-}"
+                [ -r "${environment_file}" ] || continue
+                ;;
+        esac
+        set -a
+        . "${environment_file}" || return 1
+        set +a
+    done
+    local owner="${command_user:-root}"
+    case "${owner}" in
+        *:*)
+            ;;
+        *)
+            owner="${owner}:$(id -gn "${owner}")" || return 1
+            ;;
+    esac
+    if [ -n "${error_log}" ]
+    then
+        checkpath --directory --mode 0700 --owner "${owner}" "${error_log%/*}" || return 1
+    fi
+    local directory_path
+    eval "set -- ${cyberdaemon_directories}"
+    for directory_path in "$@"
+    do
+        checkpath --directory --mode "${cyberdaemon_directory_mode}" --owner "${owner}" "${directory_path}" || return 1
+    done
+{{- range .Ulimits}}
+    ulimit {{.}}
+{{- end}}
+}
+`
+)
+
+var (
+	// openrcWordRegex matches values that OpenRC can evaluate as a
+	// single word.
+	openrcWordRegex = regexp.MustCompile(`^[A-Za-z0-9_./@+,:-]+$`)
+)
+
+// openrcScriptData is the data model used to render openrcScriptTemplate.
+type openrcScriptData struct {
+	Name             string
+	Description      string
+	Supervised       bool
+	ExePath          string
+	Arguments        []string
+	PIDFilePath      string
+	CommandUser      string
+	WorkDirPath      string
+	Umask            string
+	Capabilities     string
+	LogFilePath      string
+	RespawnDelay     int64
+	RespawnMax       int
+	RespawnPeriod    int64
+	SupervisorArgs   string
+	Environment      []string
+	EnvironmentFiles []string
+	Directories      []string
+	DirectoryMode    string
+	Need             []string
+	After            []string
+	Before           []string
+	Ulimits          []string
+	LibraryVersion   string
+	ConfigHash       string
+}
+
+type openrcController struct {
+	rcServicePath  string
+	rcUpdatePath   string
+	daemonID       string
+	scriptContents string
+	scriptFilePath string
+	startType      StartType
+	install        installSettings
+}
+
+func (o *openrcController) Status() (Status, error) {
+	info, statErr := os.Stat(o.scriptFilePath)
+	if statErr != nil || info.IsDir() {
+		return NotInstalled, nil
+	}
+
+	_, exitCode, _ := osutil.RunDaemonCli(o.rcServicePath, o.daemonID, "status")
+	switch exitCode {
+	case 0:
+		return Running, nil
+	case 3:
+		return Stopped, nil
+	case 32:
+		// The daemon crashed (i.e., it exited without being stopped).
+		return StoppedDead, nil
+	}
+
+	return Unknown, nil
+}
+
+func (o *openrcController) Install() error {
+	err := o.install.beforeInstall()
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(o.scriptFilePath, []byte(o.scriptContents), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to write OpenRC service script file - %s", err.Error())
+	}
+
+	switch o.startType {
+	case StartImmediately:
+		err := o.Start()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case StartOnLoad:
+		_, _, err := osutil.RunDaemonCli(o.rcUpdatePath, "add", o.daemonID, "default")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *openrcController) Uninstall() error {
+	// Try to stop the daemon. Ignore any errors because it might be
+	// stopped already, or the stop failed (which there is nothing
+	// we can do.
+	o.Stop()
+
+	if o.isEnabled() {
+		_, _, err := osutil.RunDaemonCli(o.rcUpdatePath, "del", o.daemonID, "default")
+		if err != nil {
+			return err
+		}
+	}
+
+	err := os.Remove(o.scriptFilePath)
+	if err != nil {
+		return err
+	}
+
+	return o.install.afterUninstall()
+}
+
+// isEnabled returns true if the daemon is added to the default runlevel.
+func (o *openrcController) isEnabled() bool {
+	_, err := os.Lstat(path.Join("/etc/runlevels/default", o.daemonID))
+	return err == nil
+}
+
+func (o *openrcController) Start() error {
+	_, _, err := osutil.RunDaemonCli(o.rcServicePath, o.daemonID, "start")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *openrcController) Stop() error {
+	_, _, err := osutil.RunDaemonCli(o.rcServicePath, o.daemonID, "stop")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *openrcController) Debug() error {
+	command, err := openrcForegroundCommand(o.scriptFilePath)
+	if err != nil {
+		return err
+	}
+
+	return command.run()
+}
+
+func newOpenrcController(config ControllerConfig, rcServicePath string) (*openrcController, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case config.Scope == UserScope:
+		return nil, fmt.Errorf("the '%s' scope is not supported on OpenRC", UserScope)
+	case isMultiInstanceID(config.DaemonID):
+		return nil, fmt.Errorf("multi-instance daemons are not supported on OpenRC")
+	case len(config.SupplementaryGroups) > 0:
+		return nil, fmt.Errorf("supplementary groups are not supported on OpenRC")
+	case len(config.ResourceLimits.MemoryMax) > 0 || config.ResourceLimits.CPUQuota > 0:
+		return nil, fmt.Errorf("memory and CPU quota limits are not supported on OpenRC")
+	case len(config.Dependencies.PartOf) > 0 || len(config.Dependencies.BindsTo) > 0:
+		return nil, fmt.Errorf("PartOf and BindsTo dependencies are not supported on OpenRC")
+	}
+
+	var logFilePath string
+	if config.LogConfig.UseNativeLogger {
+		// Log file path example: '/var/log/mydaemon/mydaemon.log'.
+		logFilePath = path.Join("/var/log", config.DaemonID, config.DaemonID+".log")
+	}
+
+	script, err := renderOpenrcScript(config, logFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	rcUpdatePath, err := osutil.RcUpdatePath()
+	if err != nil {
+		return nil, err
+	}
+
+	return &openrcController{
+		rcServicePath:  rcServicePath,
+		rcUpdatePath:   rcUpdatePath,
+		daemonID:       config.DaemonID,
+		scriptContents: script,
+		scriptFilePath: path.Join("/etc/init.d", config.DaemonID),
+		startType:      config.StartType,
+		install:        newInstallSettings(config),
+	}, nil
+}
+
+// renderOpenrcScript renders the OpenRC service script for the provided
+// configuration.
+func renderOpenrcScript(config ControllerConfig, logFilePath string) (string, error) {
+	data := openrcScriptData{
+		Name:             config.DaemonID,
+		Description:      config.Description,
+		Supervised:       config.RestartPolicy.Mode != cyberdaemon.RestartNever,
+		ExePath:          config.ExePath,
+		Arguments:        config.Arguments,
+		PIDFilePath:      fmt.Sprintf("/run/%s.pid", config.DaemonID),
+		WorkDirPath:      config.WorkDirPath,
+		Umask:            config.Umask,
+		Capabilities:     openrcCapabilities(config.Capabilities),
+		LogFilePath:      logFilePath,
+		Environment:      environmentAssignments(config.Environment),
+		EnvironmentFiles: config.EnvironmentFiles,
+		Directories:      config.Directories.paths(),
+		DirectoryMode:    fmt.Sprintf("%04o", uint32(config.Directories.mode())),
+		Need:             config.Dependencies.Requires,
+		After:            config.Dependencies.explicitAfter(),
+		Before:           config.Dependencies.Before,
+		Ulimits:          ulimitArguments(config.ResourceLimits, posixShellCoreBlockSize),
+		LibraryVersion:   cyberdaemon.Version,
+		ConfigHash:       config.hash(),
+	}
+
+	switch {
+	case len(config.RunAs) > 0 && len(config.Group) > 0:
+		data.CommandUser = config.RunAs + ":" + config.Group
+	case len(config.RunAs) > 0:
+		data.CommandUser = config.RunAs
+	case len(config.Group) > 0:
+		data.CommandUser = "root:" + config.Group
+	}
+
+	// supervise-daemon restarts the daemon whenever it exits, and only
+	// supports whole numbers of seconds.
+	for name, duration := range map[string]time.Duration{
+		"delay":          config.RestartPolicy.Delay,
+		"burst interval": config.RestartPolicy.BurstInterval,
+	} {
+		if duration%time.Second != 0 {
+			return "", fmt.Errorf("restart %s '%s' must be a whole number of seconds on OpenRC",
+				name, duration.String())
+		}
+	}
+	if data.Supervised {
+		data.RespawnDelay = int64(config.RestartPolicy.Delay / time.Second)
+		data.RespawnMax = config.RestartPolicy.BurstLimit
+		data.RespawnPeriod = int64(config.RestartPolicy.BurstInterval / time.Second)
+	}
+
+	var supervisorArgs []string
+	if config.ResourceLimits.Nice != 0 {
+		supervisorArgs = append(supervisorArgs, "--nicelevel", strconv.Itoa(config.ResourceLimits.Nice))
+	}
+	if class := config.ResourceLimits.IOSchedulingClass.ioniceClass(); class > 0 {
+		supervisorArgs = append(supervisorArgs, "--ionice", strconv.Itoa(class))
+	}
+	data.SupervisorArgs = strings.Join(supervisorArgs, " ")
+
+	// Values that OpenRC evaluates cannot be quoted.
+	words := map[string]string{
+		"executable path":   data.ExePath,
+		"working directory": data.WorkDirPath,
+		"user":              data.CommandUser,
+	}
+	for field, value := range words {
+		if len(value) > 0 && !openrcWordRegex.MatchString(value) {
+			return "", fmt.Errorf("%s '%s' may only contain letters, numbers, and the following characters on OpenRC: _./@+,:-",
+				field, value)
+		}
+	}
+
+	// The remaining values are validated by the System V template's
+	// validation, which has the same restrictions.
+	err := (SystemVTemplateData{
+		Name:             data.Name,
+		Description:      data.Description,
+		ExePath:          data.ExePath,
+		Arguments:        data.Arguments,
+		Environment:      data.Environment,
+		EnvironmentFiles: data.EnvironmentFiles,
+		Umask:            data.Umask,
+		Group:            config.Group,
+		Directories:      data.Directories,
+		DirectoryMode:    data.DirectoryMode,
+		RunAs:            config.RunAs,
+		PIDFilePathVar:   "pidfile",
+		PIDFilePath:      data.PIDFilePath,
+		LogFilePath:      data.LogFilePath,
+		LibraryVersion:   data.LibraryVersion,
+		ConfigHash:       data.ConfigHash,
+	}).Validate()
+	if err != nil {
+		return "", fmt.Errorf("OpenRC service script data is invalid - %s", err.Error())
+	}
+
+	tmpl, err := template.New("openrc").
+		Funcs(template.FuncMap{
+			"join":          strings.Join,
+			"shellQuote":    osutil.ShellQuote,
+			"shellQuoteAll": shellQuoteAll,
+		}).
+		Option("missingkey=error").
+		Parse(openrcScriptTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse OpenRC service script template - %s", err.Error())
+	}
+
+	buff := bytes.NewBuffer(nil)
+	err = tmpl.Execute(buff, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render OpenRC service script template - %s", err.Error())
+	}
+
+	return buff.String(), nil
+}
+
+// openrcCapabilities returns the value of the 'capabilities' variable
+// (a cap_iab(3) string) that grants the capabilities to the daemon's
+// process, and removes all other capabilities from its bounding set.
+// An empty string is returned if no capabilities are specified.
+func openrcCapabilities(capabilities []string) string {
+	if len(capabilities) == 0 {
+		return ""
+	}
+
+	granted := make(map[string]bool)
+	var entries []string
+	for _, capability := range capabilities {
+		granted[capability] = true
+		entries = append(entries, "^"+strings.ToLower(capability))
+	}
+
+	var dropped []string
+	for capability := range capabilityNumbers {
+		if !granted[capability] {
+			dropped = append(dropped, capability)
+		}
+	}
+	sort.Slice(dropped, func(i int, j int) bool {
+		return capabilityNumbers[dropped[i]] < capabilityNumbers[dropped[j]]
+	})
+	for _, capability := range dropped {
+		entries = append(entries, "!"+strings.ToLower(capability))
+	}
+
+	return strings.Join(entries, ",")
+}
+
+// openrcGrantedCapabilities returns the capabilities that are granted
+// by the value of the 'capabilities' variable.
+func openrcGrantedCapabilities(value string) []string {
+	var capabilities []string
+	for _, entry := range strings.Split(value, ",") {
+		if strings.HasPrefix(entry, "^") {
+			capabilities = append(capabilities, strings.ToUpper(strings.TrimPrefix(entry, "^")))
+		}
+	}
+
+	return capabilities
+}
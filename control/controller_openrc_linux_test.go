@@ -0,0 +1,219 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon"
+)
+
+func TestRenderOpenrcScript(t *testing.T) {
+	shPath, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	envFilePath := path.Join(tempDirPath, "env file")
+	err = ioutil.WriteFile(envFilePath, []byte("FROM_FILE='a b'\nOVERRIDDEN=file\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := ControllerConfig{
+		DaemonID:    "cyberdaemon-test",
+		Description: "A test daemon's \"description\"",
+		ExePath:     "/opt/my-app/app",
+		Arguments:   []string{"a b", "'single' \"double\"", "$HOME", "`id`", ""},
+		Environment: map[string]string{
+			"FOO":        "bar $HOME 'baz'",
+			"OVERRIDDEN": "config",
+		},
+		EnvironmentFiles: []string{envFilePath, "-" + path.Join(tempDirPath, "missing")},
+		WorkDirPath:      "/srv/my-app",
+		Umask:            "0027",
+		RunAs:            "nobody",
+		Group:            "nogroup",
+		Directories:      Directories{State: []string{"my-app"}},
+		Dependencies:     Dependencies{After: []string{"network"}, Requires: []string{"database"}, Before: []string{"proxy"}},
+		RestartPolicy: cyberdaemon.RestartPolicy{
+			Delay:         2 * time.Second,
+			BurstLimit:    3,
+			BurstInterval: time.Minute,
+		},
+		ResourceLimits: ResourceLimits{Nice: 5, IOSchedulingClass: IOSchedulingIdle},
+	}
+
+	script, err := renderOpenrcScript(config, "/var/log/cyberdaemon-test/cyberdaemon-test.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The script only assigns variables and declares functions, so it
+	// can be sourced with stubs for the functions that OpenRC provides.
+	scriptFilePath := path.Join(tempDirPath, config.DaemonID)
+	err = ioutil.WriteFile(scriptFilePath, []byte(script), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	harness := `
+use() { echo "use $*"; }
+need() { echo "need $*"; }
+after() { echo "after $*"; }
+before() { echo "before $*"; }
+checkpath() { echo "checkpath $*"; }
+. "$1" || exit 1
+printf '%s\n' "name=${name}" "description=${description}" "supervisor=${supervisor}" \
+    "command=${command}" "pidfile=${pidfile}" "command_user=${command_user}" \
+    "directory=${directory}" "umask=${umask}" "error_log=${error_log}" \
+    "respawn_delay=${respawn_delay}" "respawn_max=${respawn_max}" \
+    "respawn_period=${respawn_period}" "supervise_daemon_args=${supervise_daemon_args}"
+depend
+start_pre || exit 1
+printf '%s\n' "FOO=${FOO}" "FROM_FILE=${FROM_FILE}" "OVERRIDDEN=${OVERRIDDEN}"
+eval "set -- ${command_args}"
+printf 'arg=%s\n' "$@"
+`
+
+	output, err := exec.Command(shPath, "-c", harness, "sh", scriptFilePath).Output()
+	if err != nil {
+		t.Fatalf("failed to source OpenRC script - %s", err.Error())
+	}
+
+	expected := []string{
+		"name=cyberdaemon-test",
+		"description=A test daemon's \"description\"",
+		"supervisor=supervise-daemon",
+		"command=/opt/my-app/app",
+		"pidfile=/run/cyberdaemon-test.pid",
+		"command_user=nobody:nogroup",
+		"directory=/srv/my-app",
+		"umask=0027",
+		"error_log=/var/log/cyberdaemon-test/cyberdaemon-test.log",
+		"respawn_delay=2",
+		"respawn_max=3",
+		"respawn_period=60",
+		"supervise_daemon_args=--nicelevel 5 --ionice 3",
+		"use logger",
+		"need database",
+		"after network",
+		"before proxy",
+		"checkpath --directory --mode 0700 --owner nobody:nogroup /var/log/cyberdaemon-test",
+		"checkpath --directory --mode 0755 --owner nobody:nogroup /var/lib/my-app",
+		"FOO=bar $HOME 'baz'",
+		"FROM_FILE=a b",
+		"OVERRIDDEN=file",
+		"arg=a b",
+		"arg='single' \"double\"",
+		"arg=$HOME",
+		"arg=`id`",
+		"arg=",
+	}
+
+	actual := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestRenderOpenrcScriptUnsupervised(t *testing.T) {
+	config := ControllerConfig{
+		DaemonID:      "cyberdaemon-test",
+		ExePath:       "/usr/bin/app",
+		RestartPolicy: cyberdaemon.RestartPolicy{Mode: cyberdaemon.RestartNever},
+	}
+
+	script, err := renderOpenrcScript(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(script, "\ncommand_background=yes\n") {
+		t.Fatalf("expected an unsupervised daemon to be run in the background - script:\n%s", script)
+	}
+
+	// OpenRC checks whether these variables are set, rather than
+	// whether they are empty.
+	for _, name := range []string{"supervisor=", "respawn_", "command_user=", "directory=", "umask=",
+		"capabilities=", "error_log=", "start_stop_daemon_args="} {
+		if strings.Contains(script, "\n"+name) {
+			t.Fatalf("expected the script to not set '%s' - script:\n%s", name, script)
+		}
+	}
+}
+
+func TestRenderOpenrcScriptErrors(t *testing.T) {
+	invalid := []ControllerConfig{
+		{ExePath: "/opt/my app/app"},
+		{ExePath: "/usr/bin/app", WorkDirPath: "/srv/my app"},
+		{ExePath: "/usr/bin/app", RestartPolicy: cyberdaemon.RestartPolicy{Delay: 1500 * time.Millisecond}},
+		{ExePath: "/usr/bin/app", RestartPolicy: cyberdaemon.RestartPolicy{BurstInterval: time.Second / 2}},
+		{ExePath: "/usr/bin/app", Arguments: []string{"new\nline"}},
+	}
+
+	for _, config := range invalid {
+		config.DaemonID = "cyberdaemon-test"
+
+		_, err := renderOpenrcScript(config, "")
+		if err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+
+	unsupported := []ControllerConfig{
+		{Scope: UserScope},
+		{DaemonID: "cyberdaemon-test@"},
+		{SupplementaryGroups: []string{"adm"}},
+		{ResourceLimits: ResourceLimits{MemoryMax: "1G"}},
+		{Dependencies: Dependencies{BindsTo: []string{"database"}}},
+	}
+
+	for _, config := range unsupported {
+		if len(config.DaemonID) == 0 {
+			config.DaemonID = "cyberdaemon-test"
+		}
+		config.ExePath = "/usr/bin/app"
+
+		_, err := newOpenrcController(config, "/sbin/rc-service")
+		if err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestOpenrcCapabilities(t *testing.T) {
+	if value := openrcCapabilities(nil); len(value) > 0 {
+		t.Fatalf("expected no capabilities - got '%s'", value)
+	}
+
+	capabilities := []string{"CAP_NET_BIND_SERVICE", "CAP_SYS_TIME"}
+	value := openrcCapabilities(capabilities)
+
+	entries := strings.Split(value, ",")
+	if len(entries) != len(capabilityNumbers) {
+		t.Fatalf("expected an entry for each of the %d capabilities - got %d", len(capabilityNumbers), len(entries))
+	}
+
+	// The granted capabilities are inheritable and in the bounding
+	// set. All others are dropped from the bounding set.
+	expectedPrefix := "^cap_net_bind_service,^cap_sys_time,!cap_chown,!cap_dac_override,"
+	if !strings.HasPrefix(value, expectedPrefix) {
+		t.Fatalf("expected capabilities to start with '%s' - got '%s'", expectedPrefix, value)
+	}
+
+	if granted := openrcGrantedCapabilities(value); !reflect.DeepEqual(granted, capabilities) {
+		t.Fatalf("expected granted capabilities %q - got %q", capabilities, granted)
+	}
+}
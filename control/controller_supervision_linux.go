@@ -0,0 +1,655 @@
+package control
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon"
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+const (
+	// supervisionRunScriptTemplate is the template of the 'run' scripts
+	// of the runit and s6 service directories generated by a Controller.
+	// It is rendered using a supervisionRunScriptData.
+	//
+	// The supervisor runs the script with the service directory as its
+	// working directory, and expects the daemon to replace the script's
+	// process. The daemon's settings are stored in variables (rather
+	// than in the commands that use them) so that they can be parsed
+	// by this library.
+	supervisionRunScriptTemplate = `#!/bin/sh
+# X-Cyberdaemon-Version: {{.LibraryVersion}}
+# X-Cyberdaemon-Config-Hash: {{.ConfigHash}}
+
+cyberdaemon_description={{shellQuote .Description}}
+cyberdaemon_command={{shellQuote .ExePath}}
+cyberdaemon_arguments={{shellQuote (shellQuoteAll .Arguments)}}
+cyberdaemon_user={{shellQuote .RunAs}}
+cyberdaemon_group={{shellQuote .Group}}
+cyberdaemon_supplementary_groups={{shellQuote (shellQuoteAll .SupplementaryGroups)}}
+cyberdaemon_work_dir={{shellQuote .WorkDirPath}}
+cyberdaemon_umask={{shellQuote .Umask}}
+cyberdaemon_environment={{shellQuote (shellQuoteAll .Environment)}}
+cyberdaemon_environment_files={{shellQuote (shellQuoteAll .EnvironmentFiles)}}
+cyberdaemon_directories={{shellQuote (shellQuoteAll .Directories)}}
+cyberdaemon_directory_mode={{shellQuote .DirectoryMode}}
+cyberdaemon_scan_dir={{shellQuote .ScanDirPath}}
+{{- if .LogService}}
+
+# The daemon's stdout is connected to its log service.
+exec 2>&1
+{{- end}}
+{{- range .Requires}}
+{{$.RequireCommand}} "${cyberdaemon_scan_dir}"/{{shellQuote .}} || exit 1
+{{- end}}
+
+# Files in cyberdaemon_environment_files are sourced, and may override
+# any variable. Paths prefixed with '-' are ignored if the file does
+# not exist.
+if [ -n "${cyberdaemon_environment}" ]
+then
+    eval "export ${cyberdaemon_environment}"
+fi
+eval "set -- ${cyberdaemon_environment_files}"
+for cyberdaemon_environment_file in "$@"
+do
+    case "${cyberdaemon_environment_file}" in
+        -*)
+            cyberdaemon_environment_file="${cyberdaemon_environment_file#-}"
+            [ -r "${cyberdaemon_environment_file}" ] || continue
+            ;;
+    esac
+    set -a
+    . "${cyberdaemon_environment_file}" || exit 1
+    set +a
+done
+
+cyberdaemon_owner="${cyberdaemon_user:-root}"
+cyberdaemon_owner="${cyberdaemon_owner}:${cyberdaemon_group:-$(id -gn "${cyberdaemon_owner}")}" || exit 1
+eval "set -- ${cyberdaemon_directories}"
+for cyberdaemon_directory in "$@"
+do
+    mkdir -p "${cyberdaemon_directory}" &&
+        chown "${cyberdaemon_owner}" "${cyberdaemon_directory}" &&
+        chmod "${cyberdaemon_directory_mode}" "${cyberdaemon_directory}" || exit 1
+done
+{{- if .Credentials}}
+
+# chpst sets the supplementary groups to the groups that follow
+# the user's name.
+cyberdaemon_credentials="${cyberdaemon_owner}"
+eval "set -- ${cyberdaemon_supplementary_groups}"
+for cyberdaemon_group_name in "$@"
+do
+    cyberdaemon_credentials="${cyberdaemon_credentials}:${cyberdaemon_group_name}"
+done
+{{- end}}
+
+cd "${cyberdaemon_work_dir:-/}" || exit 1
+if [ -n "${cyberdaemon_umask}" ]
+then
+    umask "${cyberdaemon_umask}"
+fi
+{{- range .Ulimits}}
+ulimit {{.}}
+{{- end}}
+eval "set -- ${cyberdaemon_arguments}"
+exec {{.ExecPrefix}}"${cyberdaemon_command}" "$@"
+`
+
+	// supervisionFinishScriptTemplate is the template of the 'finish'
+	// scripts that implement restart policies. It is rendered using a
+	// supervisionFinishScriptData. The supervisor runs the script after
+	// the daemon exits, and passes it the daemon's exit code.
+	supervisionFinishScriptTemplate = `#!/bin/sh
+# X-Cyberdaemon-Version: {{.LibraryVersion}}
+
+cyberdaemon_restart={{shellQuote .Mode}}
+cyberdaemon_restart_delay={{.DelaySeconds}}
+
+case "${cyberdaemon_restart}" in
+    never)
+        {{.StopCommand}}
+        ;;
+    on-failure)
+        [ "$1" = 0 ] && {{.StopCommand}}
+        ;;
+esac
+if [ "${cyberdaemon_restart_delay}" -gt 0 ]
+then
+    exec sleep "${cyberdaemon_restart_delay}"
+fi
+`
+
+	// supervisionLogScriptTemplate is the template of the 'log/run'
+	// scripts of daemons that use the native logger. It is rendered
+	// using a supervisionLogScriptData.
+	supervisionLogScriptTemplate = `#!/bin/sh
+# X-Cyberdaemon-Version: {{.LibraryVersion}}
+
+mkdir -p {{shellQuote .LogDirPath}} || exit 1
+exec {{.Logger}} {{shellQuote .LogDirPath}}
+`
+
+	// supervisionTimeout is the amount of time to wait for the
+	// supervisor to start supervising a service, and for a daemon
+	// to start or stop.
+	supervisionTimeout = 15 * time.Second
+)
+
+// supervisionSuite is a daemon supervision suite that supervises the
+// service directories in a scan directory.
+type supervisionSuite string
+
+const (
+	runitSuite supervisionSuite = "runit"
+	s6Suite    supervisionSuite = "s6"
+)
+
+// serviceDirRootPath returns the path to the directory that contains
+// the suite's service directories. They are linked into the scan
+// directory when they are installed.
+func (o supervisionSuite) serviceDirRootPath() string {
+	if o == s6Suite {
+		return "/etc/s6/sv"
+	}
+
+	return "/etc/sv"
+}
+
+// supervisionRunScriptData is the data model used to render
+// supervisionRunScriptTemplate.
+type supervisionRunScriptData struct {
+	Description         string
+	ExePath             string
+	Arguments           []string
+	RunAs               string
+	Group               string
+	SupplementaryGroups []string
+	WorkDirPath         string
+	Umask               string
+	Environment         []string
+	EnvironmentFiles    []string
+	Directories         []string
+	DirectoryMode       string
+	ScanDirPath         string
+	LogService          bool
+	Requires            []string
+	RequireCommand      string
+	Credentials         bool
+	Ulimits             []string
+	ExecPrefix          string
+	LibraryVersion      string
+	ConfigHash          string
+}
+
+// supervisionFinishScriptData is the data model used to render
+// supervisionFinishScriptTemplate.
+type supervisionFinishScriptData struct {
+	Mode           string
+	DelaySeconds   int64
+	StopCommand    string
+	LibraryVersion string
+}
+
+// supervisionLogScriptData is the data model used to render
+// supervisionLogScriptTemplate.
+type supervisionLogScriptData struct {
+	Logger         string
+	LogDirPath     string
+	LibraryVersion string
+}
+
+// supervisionFile is a file in a service directory.
+type supervisionFile struct {
+	name     string
+	contents string
+	mode     os.FileMode
+}
+
+type supervisionController struct {
+	suite          supervisionSuite
+	controlPath    string
+	statusPath     string
+	scanctlPath    string
+	daemonID       string
+	serviceDirPath string
+	scanDirPath    string
+	files          []supervisionFile
+	startType      StartType
+	install        installSettings
+}
+
+// linkPath returns the path to the service directory's link in the
+// scan directory.
+func (o *supervisionController) linkPath() string {
+	return path.Join(o.scanDirPath, o.daemonID)
+}
+
+// downFilePath returns the path to the service directory's 'down'
+// file. The supervisor does not start the daemon when it starts
+// supervising the service directory if the file exists.
+func (o *supervisionController) downFilePath() string {
+	return path.Join(o.serviceDirPath, "down")
+}
+
+func (o *supervisionController) Status() (Status, error) {
+	info, statErr := os.Stat(path.Join(o.serviceDirPath, "run"))
+	if statErr != nil || info.IsDir() {
+		return NotInstalled, nil
+	}
+
+	var output string
+	var err error
+	if o.suite == s6Suite {
+		output, _, err = osutil.RunDaemonCli(o.statusPath, o.linkPath())
+	} else {
+		output, _, err = osutil.RunDaemonCli(o.statusPath, "status", o.linkPath())
+	}
+	if err != nil {
+		// The service directory is not supervised.
+		return Unknown, nil
+	}
+
+	// Example outputs:
+	// 	runit: 'run: /etc/service/mydaemon: (pid 123) 5s'
+	// 	runit: 'down: /etc/service/mydaemon: 3s, normally up'
+	// 	s6:    'up (pid 123) 5 seconds'
+	// 	s6:    'down (exitcode 0) 3 seconds, normally up, ready 3 seconds'
+	switch strings.Fields(output + " ")[0] {
+	case "run:", "up":
+		return Running, nil
+	case "down:", "finish:", "down":
+		return Stopped, nil
+	}
+
+	return Unknown, nil
+}
+
+func (o *supervisionController) Install() error {
+	err := o.install.beforeInstall()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range o.files {
+		filePath := path.Join(o.serviceDirPath, file.name)
+
+		err := os.MkdirAll(path.Dir(filePath), 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create service directory - %s", err.Error())
+		}
+
+		err = ioutil.WriteFile(filePath, []byte(file.contents), file.mode)
+		if err != nil {
+			return fmt.Errorf("failed to write service directory file '%s' - %s", file.name, err.Error())
+		}
+	}
+
+	// The 'down' file prevents the supervisor from starting the daemon
+	// when the service directory is linked into the scan directory.
+	if o.startType == StartImmediately {
+		err := os.Remove(o.downFilePath())
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove service directory's down file - %s", err.Error())
+		}
+	} else {
+		err := ioutil.WriteFile(o.downFilePath(), nil, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write service directory's down file - %s", err.Error())
+		}
+	}
+
+	if _, err := os.Lstat(o.linkPath()); os.IsNotExist(err) {
+		err := os.Symlink(o.serviceDirPath, o.linkPath())
+		if err != nil {
+			return fmt.Errorf("failed to link service directory into scan directory - %s", err.Error())
+		}
+	}
+
+	err = o.waitUntilSupervised()
+	if err != nil {
+		return err
+	}
+
+	// The supervisor only checks for the 'down' file when it starts
+	// supervising the service directory (i.e., when the system boots).
+	if o.startType == StartOnLoad {
+		err := os.Remove(o.downFilePath())
+		if err != nil {
+			return fmt.Errorf("failed to remove service directory's down file - %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// waitUntilSupervised waits for the supervisor to start supervising the
+// service directory. runsvdir checks the scan directory for new service
+// directories every five seconds. s6-svscan is told to check it.
+func (o *supervisionController) waitUntilSupervised() error {
+	if o.suite == s6Suite {
+		_, _, err := osutil.RunDaemonCli(o.scanctlPath, "-a", o.scanDirPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(supervisionTimeout)
+	for {
+		status, err := o.Status()
+		if err != nil {
+			return err
+		}
+
+		if status != Unknown {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s to supervise the service directory", o.suite)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func (o *supervisionController) Uninstall() error {
+	// Try to stop the daemon. Ignore any errors because it might be
+	// stopped already, or the stop failed (which there is nothing
+	// we can do.
+	o.Stop()
+
+	err := os.Remove(o.linkPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove service directory link from scan directory - %s", err.Error())
+	}
+
+	// Tell the service directory's supervisor to exit rather than
+	// waiting for the supervisor to notice that it was removed.
+	// Errors are ignored because the supervisor might not be running.
+	if o.suite == s6Suite {
+		osutil.RunDaemonCli(o.scanctlPath, "-an", o.scanDirPath)
+	} else {
+		osutil.RunDaemonCli(o.controlPath, "exit", o.serviceDirPath)
+	}
+
+	err = os.RemoveAll(o.serviceDirPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove service directory - %s", err.Error())
+	}
+
+	return o.install.afterUninstall()
+}
+
+func (o *supervisionController) Start() error {
+	var err error
+	if o.suite == s6Suite {
+		_, _, err = osutil.RunDaemonCli(o.controlPath, "-wu", "-T", o.timeoutMillis(), "-u", o.linkPath())
+	} else {
+		_, _, err = osutil.RunDaemonCli(o.controlPath, "-w", o.timeoutSeconds(), "start", o.linkPath())
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *supervisionController) Stop() error {
+	var err error
+	if o.suite == s6Suite {
+		_, _, err = osutil.RunDaemonCli(o.controlPath, "-wd", "-T", o.timeoutMillis(), "-d", o.linkPath())
+	} else {
+		_, _, err = osutil.RunDaemonCli(o.controlPath, "-w", o.timeoutSeconds(), "stop", o.linkPath())
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *supervisionController) timeoutSeconds() string {
+	return strconv.FormatInt(int64(supervisionTimeout/time.Second), 10)
+}
+
+func (o *supervisionController) timeoutMillis() string {
+	return strconv.FormatInt(int64(supervisionTimeout/time.Millisecond), 10)
+}
+
+func (o *supervisionController) Debug() error {
+	command, err := supervisionForegroundCommand(path.Join(o.serviceDirPath, "run"))
+	if err != nil {
+		return err
+	}
+
+	return command.run()
+}
+
+func newSupervisionController(config ControllerConfig, suite supervisionSuite, controlPath string, scanDirPath string) (*supervisionController, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case config.Scope == UserScope:
+		return nil, fmt.Errorf("the '%s' scope is not supported on %s", UserScope, suite)
+	case isMultiInstanceID(config.DaemonID):
+		return nil, fmt.Errorf("multi-instance daemons are not supported on %s", suite)
+	case len(config.Capabilities) > 0:
+		return nil, fmt.Errorf("capabilities are not supported on %s", suite)
+	case suite == s6Suite && (len(config.Group) > 0 || len(config.SupplementaryGroups) > 0):
+		return nil, fmt.Errorf("groups and supplementary groups are not supported on %s", suite)
+	case len(config.ResourceLimits.MemoryMax) > 0 || config.ResourceLimits.CPUQuota > 0:
+		return nil, fmt.Errorf("memory and CPU quota limits are not supported on %s", suite)
+	case len(config.Dependencies.PartOf) > 0 || len(config.Dependencies.BindsTo) > 0:
+		return nil, fmt.Errorf("PartOf and BindsTo dependencies are not supported on %s", suite)
+	}
+
+	files, err := renderSupervisionFiles(config, suite, scanDirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	controller := &supervisionController{
+		suite:          suite,
+		controlPath:    controlPath,
+		statusPath:     controlPath,
+		daemonID:       config.DaemonID,
+		serviceDirPath: path.Join(suite.serviceDirRootPath(), config.DaemonID),
+		scanDirPath:    scanDirPath,
+		files:          files,
+		startType:      config.StartType,
+		install:        newInstallSettings(config),
+	}
+
+	if suite == s6Suite {
+		controller.statusPath, err = osutil.S6SvstatPath()
+		if err != nil {
+			return nil, err
+		}
+
+		controller.scanctlPath, err = osutil.S6SvscanctlPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return controller, nil
+}
+
+// renderSupervisionFiles renders the files of the service directory
+// for the provided configuration (not including the 'down' file).
+func renderSupervisionFiles(config ControllerConfig, suite supervisionSuite, scanDirPath string) ([]supervisionFile, error) {
+	data := supervisionRunScriptData{
+		Description:         config.Description,
+		ExePath:             config.ExePath,
+		Arguments:           config.Arguments,
+		RunAs:               config.RunAs,
+		Group:               config.Group,
+		SupplementaryGroups: config.SupplementaryGroups,
+		WorkDirPath:         config.WorkDirPath,
+		Umask:               config.Umask,
+		Environment:         environmentAssignments(config.Environment),
+		EnvironmentFiles:    config.EnvironmentFiles,
+		Directories:         config.Directories.paths(),
+		DirectoryMode:       fmt.Sprintf("%04o", uint32(config.Directories.mode())),
+		ScanDirPath:         scanDirPath,
+		LogService:          config.LogConfig.UseNativeLogger,
+		Requires:            config.Dependencies.Requires,
+		Ulimits:             ulimitArguments(config.ResourceLimits, posixShellCoreBlockSize),
+		LibraryVersion:      cyberdaemon.Version,
+		ConfigHash:          config.hash(),
+	}
+
+	var execPrefix []string
+	if config.ResourceLimits.Nice != 0 {
+		execPrefix = append(execPrefix, "nice", "-n", strconv.Itoa(config.ResourceLimits.Nice))
+	}
+	if class := config.ResourceLimits.IOSchedulingClass.ioniceClass(); class > 0 {
+		execPrefix = append(execPrefix, "ionice", "-c", strconv.Itoa(class))
+	}
+
+	finishData := supervisionFinishScriptData{
+		Mode:           string(config.RestartPolicy.EffectiveMode()),
+		DelaySeconds:   int64(config.RestartPolicy.Delay / time.Second),
+		LibraryVersion: data.LibraryVersion,
+	}
+
+	logData := supervisionLogScriptData{
+		// Log directory path example: '/var/log/mydaemon'.
+		LogDirPath:     path.Join("/var/log", config.DaemonID),
+		LibraryVersion: data.LibraryVersion,
+	}
+
+	switch suite {
+	case runitSuite:
+		data.RequireCommand = "sv -w 15 start"
+		if len(config.RunAs) > 0 || len(config.Group) > 0 || len(config.SupplementaryGroups) > 0 {
+			data.Credentials = true
+			execPrefix = append(execPrefix, "chpst", "-u", `"${cyberdaemon_credentials}"`)
+		}
+		// Telling the supervisor to stop the daemon prevents it
+		// from being restarted.
+		finishData.StopCommand = "exec sv down ."
+		logData.Logger = "svlogd -tt"
+	case s6Suite:
+		data.RequireCommand = "s6-svc -wu -T 15000 -u"
+		if len(config.RunAs) > 0 {
+			execPrefix = append(execPrefix, "s6-setuidgid", `"${cyberdaemon_user}"`)
+		}
+		// s6-supervise does not restart a daemon if its finish
+		// script exits with 125.
+		finishData.StopCommand = "exit 125"
+		logData.Logger = "s6-log T"
+	}
+
+	for _, word := range execPrefix {
+		data.ExecPrefix = data.ExecPrefix + word + " "
+	}
+
+	// The supervisor restarts the daemon one second after it exits.
+	// Restart limits are not supported, and the finish script waits
+	// for the additional delay.
+	policy := config.RestartPolicy
+	if policy.BurstLimit > 0 || policy.BurstInterval > 0 {
+		return nil, fmt.Errorf("restart burst limits are not supported on %s", suite)
+	}
+	if policy.Delay%time.Second != 0 {
+		return nil, fmt.Errorf("restart delay '%s' must be a whole number of seconds on %s",
+			policy.Delay.String(), suite)
+	}
+
+	// The run script's values are validated by the System V template's
+	// validation, which has the same restrictions. Service directories
+	// do not use a PID file, but the validation requires its variable.
+	err := (SystemVTemplateData{
+		Name:                config.DaemonID,
+		Description:         data.Description,
+		ExePath:             data.ExePath,
+		Arguments:           data.Arguments,
+		Environment:         data.Environment,
+		EnvironmentFiles:    data.EnvironmentFiles,
+		Umask:               data.Umask,
+		Group:               data.Group,
+		SupplementaryGroups: data.SupplementaryGroups,
+		Directories:         data.Directories,
+		DirectoryMode:       data.DirectoryMode,
+		RunAs:               data.RunAs,
+		WorkDirPath:         data.WorkDirPath,
+		PIDFilePathVar:      "pidfile",
+		LibraryVersion:      data.LibraryVersion,
+		ConfigHash:          data.ConfigHash,
+	}).Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s service directory data is invalid - %s", suite, err.Error())
+	}
+
+	run, err := renderSupervisionTemplate(supervisionRunScriptTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []supervisionFile{
+		{name: "run", contents: run, mode: 0755},
+	}
+
+	if policy.IsSet() {
+		finish, err := renderSupervisionTemplate(supervisionFinishScriptTemplate, finishData)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, supervisionFile{name: "finish", contents: finish, mode: 0755})
+
+		// s6-supervise kills finish scripts that run for longer than
+		// five seconds unless told otherwise.
+		if suite == s6Suite && finishData.DelaySeconds > 0 {
+			files = append(files, supervisionFile{
+				name:     "timeout-finish",
+				contents: fmt.Sprintf("%d\n", (finishData.DelaySeconds+5)*1000),
+				mode:     0644,
+			})
+		}
+	}
+
+	if data.LogService {
+		logRun, err := renderSupervisionTemplate(supervisionLogScriptTemplate, logData)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, supervisionFile{name: "log/run", contents: logRun, mode: 0755})
+	}
+
+	return files, nil
+}
+
+func renderSupervisionTemplate(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("supervision").
+		Funcs(template.FuncMap{
+			"shellQuote":    osutil.ShellQuote,
+			"shellQuoteAll": shellQuoteAll,
+		}).
+		Option("missingkey=error").
+		Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse service directory template - %s", err.Error())
+	}
+
+	buff := bytes.NewBuffer(nil)
+	err = tmpl.Execute(buff, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render service directory template - %s", err.Error())
+	}
+
+	return buff.String(), nil
+}
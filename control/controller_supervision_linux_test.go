@@ -0,0 +1,61 @@
+package control
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon"
+)
+
+func TestRenderSupervisionFilesFinishTimeout(t *testing.T) {
+	for _, delay := range []time.Duration{0, 3 * time.Second, 5 * time.Second, 2 * time.Minute} {
+		for _, suite := range []supervisionSuite{runitSuite, s6Suite} {
+			config := ControllerConfig{
+				DaemonID: "cyberdaemon-test",
+				ExePath:  "/usr/bin/app",
+				RestartPolicy: cyberdaemon.RestartPolicy{
+					Mode:  cyberdaemon.RestartAlways,
+					Delay: delay,
+				},
+			}
+
+			files, err := renderSupervisionFiles(config, suite, "/etc/service")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			contents := make(map[string]string)
+			for _, file := range files {
+				contents[file.name] = file.contents
+			}
+
+			delayValue, _, err := shellVariableValue(contents["finish"], "cyberdaemon_restart_delay")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if delayValue != strconv.FormatInt(int64(delay/time.Second), 10) {
+				t.Fatalf("%s: expected finish script delay of %s - got '%s'", suite, delay, delayValue)
+			}
+
+			// s6-supervise kills the finish script after five
+			// seconds by default. runsv does not time it out.
+			timeout, hasTimeout := contents["timeout-finish"]
+			if suite != s6Suite || delay == 0 {
+				if hasTimeout {
+					t.Fatalf("%s: unexpected timeout-finish file for delay %s", suite, delay)
+				}
+				continue
+			}
+
+			milliseconds, err := strconv.ParseInt(strings.TrimSpace(timeout), 10, 64)
+			if err != nil {
+				t.Fatalf("%s: failed to parse timeout-finish - %s", suite, err.Error())
+			}
+			if time.Duration(milliseconds)*time.Millisecond <= delay {
+				t.Fatalf("%s: finish timeout of %dms does not cover delay %s", suite, milliseconds, delay)
+			}
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/stephen-fox/cyberdaemon"
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+const (
+	// supervisordProgramTemplate is the template of the supervisord
+	// program configuration files generated by a Controller. It is
+	// rendered using a supervisordProgramData. Values must be escaped
+	// using supervisordEscape (or supervisordQuote) because supervisord
+	// expands Python format strings (e.g., '%(program_name)s') in them.
+	supervisordProgramTemplate = `# X-Cyberdaemon-Version: {{.LibraryVersion}}
+# X-Cyberdaemon-Config-Hash: {{.ConfigHash}}
+# Description: {{.Description}}
+
+[program:{{.Name}}]
+command={{.Command}}
+{{- if .User}}
+user={{.User}}
+{{- end}}
+{{- if .WorkDirPath}}
+directory={{.WorkDirPath}}
+{{- end}}
+{{- if .Umask}}
+umask={{.Umask}}
+{{- end}}
+{{- if .Environment}}
+environment={{.Environment}}
+{{- end}}
+autostart={{.AutoStart}}
+{{- if .AutoRestart}}
+autorestart={{.AutoRestart}}
+{{- end}}
+stopsignal=TERM
+{{- if .LogFilePath}}
+stderr_logfile={{.LogFilePath}}
+{{- end}}
+`
+
+	// supervisordConfDirPath is the path to the directory that contains
+	// the program configuration files that supervisord includes (the
+	// default on Debian based systems).
+	supervisordConfDirPath = "/etc/supervisor/conf.d"
+
+	// supervisordEnabledEnv is the environment variable that supervisord
+	// sets for the processes it starts.
+	supervisordEnabledEnv = "SUPERVISOR_ENABLED"
+)
+
+var (
+	// supervisordCommentRegex matches the ';' and '#' characters that
+	// supervisord's configuration parser treats as the start of an
+	// inline comment (even when they are quoted).
+	supervisordCommentRegex = regexp.MustCompile(`(\s)([;#])`)
+)
+
+// supervisordProgramData is the data model used to render
+// supervisordProgramTemplate.
+type supervisordProgramData struct {
+	Name           string
+	Description    string
+	Command        string
+	User           string
+	WorkDirPath    string
+	Umask          string
+	Environment    string
+	AutoStart      bool
+	AutoRestart    string
+	LogFilePath    string
+	LibraryVersion string
+	ConfigHash     string
+}
+
+type supervisordController struct {
+	supervisorctlPath string
+	daemonID          string
+	programContents   string
+	programFilePath   string
+	logFilePath       string
+	install           installSettings
+}
+
+func (o *supervisordController) Status() (Status, error) {
+	info, statErr := os.Stat(o.programFilePath)
+	if statErr != nil || info.IsDir() {
+		return NotInstalled, nil
+	}
+
+	// Example output: 'mydaemon    RUNNING   pid 123, uptime 0:00:05'.
+	// supervisorctl exits with a non-zero status if the program is
+	// not running, so its error is ignored.
+	output, _, _ := osutil.RunDaemonCli(o.supervisorctlPath, "status", o.daemonID)
+	fields := strings.Fields(output)
+	if len(fields) < 2 || fields[0] != o.daemonID {
+		return Unknown, nil
+	}
+
+	switch fields[1] {
+	case "RUNNING", "STARTING":
+		return Running, nil
+	case "STOPPED", "STOPPING", "EXITED":
+		return Stopped, nil
+	case "BACKOFF", "FATAL":
+		// The program exited while it was starting.
+		return StoppedDead, nil
+	}
+
+	return Unknown, nil
+}
+
+func (o *supervisordController) Install() error {
+	err := o.install.beforeInstall()
+	if err != nil {
+		return err
+	}
+
+	// supervisord does not create the log file's directory.
+	if len(o.logFilePath) > 0 {
+		err := os.MkdirAll(path.Dir(o.logFilePath), 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create log directory - %s", err.Error())
+		}
+	}
+
+	err = ioutil.WriteFile(o.programFilePath, []byte(o.programContents), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write supervisord program configuration file - %s", err.Error())
+	}
+
+	// supervisord starts the program when it is added if its
+	// 'autostart' setting is enabled.
+	return o.update()
+}
+
+func (o *supervisordController) Uninstall() error {
+	// Try to stop the daemon. Ignore any errors because it might be
+	// stopped already, or the stop failed (which there is nothing
+	// we can do.
+	o.Stop()
+
+	err := os.Remove(o.programFilePath)
+	if err != nil {
+		return err
+	}
+
+	err = o.update()
+	if err != nil {
+		return err
+	}
+
+	return o.install.afterUninstall()
+}
+
+// update makes supervisord reread its configuration, and add or remove
+// the daemon's program.
+func (o *supervisordController) update() error {
+	_, _, err := osutil.RunDaemonCli(o.supervisorctlPath, "reread")
+	if err != nil {
+		return err
+	}
+
+	_, _, err = osutil.RunDaemonCli(o.supervisorctlPath, "update", o.daemonID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *supervisordController) Start() error {
+	return o.control("start")
+}
+
+func (o *supervisordController) Stop() error {
+	return o.control("stop")
+}
+
+// control runs a supervisorctl command for the daemon's program. Older
+// versions of supervisorctl exit with a status of zero when a command
+// fails, so errors are also detected in its output.
+func (o *supervisordController) control(command string) error {
+	output, _, err := osutil.RunDaemonCli(o.supervisorctlPath, command, o.daemonID)
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(output, "ERROR") {
+		return fmt.Errorf("failed to %s supervisord program - %s", command, output)
+	}
+
+	return nil
+}
+
+func (o *supervisordController) Debug() error {
+	command, err := supervisordForegroundCommand(o.programFilePath)
+	if err != nil {
+		return err
+	}
+
+	return command.run()
+}
+
+func newSupervisordController(config ControllerConfig, supervisorctlPath string) (*supervisordController, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	limits := config.ResourceLimits
+	limits.Nice = 0
+	limits.IOSchedulingClass = ""
+
+	switch {
+	case config.Scope == UserScope:
+		return nil, fmt.Errorf("the '%s' scope is not supported on supervisord", UserScope)
+	case isMultiInstanceID(config.DaemonID):
+		return nil, fmt.Errorf("multi-instance daemons are not supported on supervisord")
+	case len(config.Group) > 0 || len(config.SupplementaryGroups) > 0:
+		return nil, fmt.Errorf("groups and supplementary groups are not supported on supervisord")
+	case len(config.Capabilities) > 0:
+		return nil, fmt.Errorf("capabilities are not supported on supervisord")
+	case len(config.EnvironmentFiles) > 0:
+		return nil, fmt.Errorf("environment files are not supported on supervisord")
+	case config.Directories.isSet():
+		return nil, fmt.Errorf("directories are not supported on supervisord")
+	case limits != ResourceLimits{}:
+		return nil, fmt.Errorf("only the Nice and IOSchedulingClass resource limits are supported on supervisord")
+	case len(config.Dependencies.Requires) > 0 || len(config.Dependencies.PartOf) > 0 || len(config.Dependencies.BindsTo) > 0:
+		return nil, fmt.Errorf("only After and Before dependencies are supported on supervisord")
+	}
+
+	var logFilePath string
+	if config.LogConfig.UseNativeLogger {
+		// Log file path example: '/var/log/mydaemon/mydaemon.log'.
+		logFilePath = path.Join("/var/log", config.DaemonID, config.DaemonID+".log")
+	}
+
+	program, err := renderSupervisordProgram(config, logFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &supervisordController{
+		supervisorctlPath: supervisorctlPath,
+		daemonID:          config.DaemonID,
+		programContents:   program,
+		programFilePath:   supervisordProgramFilePath(config.DaemonID),
+		logFilePath:       logFilePath,
+		install:           newInstallSettings(config),
+	}, nil
+}
+
+func supervisordProgramFilePath(daemonID string) string {
+	return path.Join(supervisordConfDirPath, daemonID+".conf")
+}
+
+// renderSupervisordProgram renders the supervisord program configuration
+// file for the provided configuration.
+func renderSupervisordProgram(config ControllerConfig, logFilePath string) (string, error) {
+	// The values are validated by the System V template's validation,
+	// which prevents new lines (which would start a new setting).
+	err := (SystemVTemplateData{
+		Name:           config.DaemonID,
+		Description:    config.Description,
+		ExePath:        config.ExePath,
+		Arguments:      config.Arguments,
+		Environment:    environmentAssignments(config.Environment),
+		Umask:          config.Umask,
+		RunAs:          config.RunAs,
+		WorkDirPath:    config.WorkDirPath,
+		PIDFilePathVar: "pidfile",
+		LogFilePath:    logFilePath,
+		LibraryVersion: cyberdaemon.Version,
+	}).Validate()
+	if err != nil {
+		return "", fmt.Errorf("supervisord program data is invalid - %s", err.Error())
+	}
+
+	if supervisordCommentRegex.MatchString(config.WorkDirPath) {
+		return "", fmt.Errorf("working directory '%s' may not contain a ';' or '#' that follows whitespace on supervisord",
+			config.WorkDirPath)
+	}
+
+	policy := config.RestartPolicy
+	if policy.Delay > 0 || policy.BurstLimit > 0 || policy.BurstInterval > 0 {
+		return "", fmt.Errorf("restart delays and burst limits are not supported on supervisord")
+	}
+
+	data := supervisordProgramData{
+		Name:           config.DaemonID,
+		Description:    config.Description,
+		User:           config.RunAs,
+		WorkDirPath:    supervisordEscape(config.WorkDirPath),
+		Umask:          config.Umask,
+		AutoStart:      config.StartType == StartImmediately || config.StartType == StartOnLoad,
+		LogFilePath:    supervisordEscape(logFilePath),
+		LibraryVersion: cyberdaemon.Version,
+		ConfigHash:     config.hash(),
+	}
+
+	// supervisord's default (restarting the program if it exits with
+	// an unexpected exit code) is used if the policy is not set.
+	if policy.IsSet() {
+		switch policy.EffectiveMode() {
+		case cyberdaemon.RestartNever:
+			data.AutoRestart = "false"
+		case cyberdaemon.RestartOnFailure:
+			data.AutoRestart = "unexpected"
+		case cyberdaemon.RestartAlways:
+			data.AutoRestart = "true"
+		}
+	}
+
+	var command []string
+	if config.ResourceLimits.Nice != 0 {
+		command = append(command, "nice", "-n", strconv.Itoa(config.ResourceLimits.Nice))
+	}
+	if class := config.ResourceLimits.IOSchedulingClass.ioniceClass(); class > 0 {
+		command = append(command, "ionice", "-c", strconv.Itoa(class))
+	}
+	command = append(command, supervisordQuote(config.ExePath))
+	for _, argument := range config.Arguments {
+		command = append(command, supervisordQuote(argument))
+	}
+	data.Command = strings.Join(command, " ")
+
+	var environment []string
+	for _, assignment := range environmentAssignments(config.Environment) {
+		i := strings.Index(assignment, "=")
+		value, err := supervisordEnvironmentValue(assignment[i+1:])
+		if err != nil {
+			return "", fmt.Errorf("environment variable '%s' is invalid - %s", assignment[:i], err.Error())
+		}
+		environment = append(environment, assignment[:i]+"="+value)
+	}
+	data.Environment = strings.Join(environment, ",")
+
+	tmpl, err := template.New("supervisord").
+		Option("missingkey=error").
+		Parse(supervisordProgramTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse supervisord program template - %s", err.Error())
+	}
+
+	buff := bytes.NewBuffer(nil)
+	err = tmpl.Execute(buff, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render supervisord program template - %s", err.Error())
+	}
+
+	return buff.String(), nil
+}
+
+// supervisordEscape escapes the '%' characters in a value so that
+// supervisord does not expand them.
+func supervisordEscape(value string) string {
+	return strings.Replace(value, "%", "%%", -1)
+}
+
+// supervisordQuote quotes a word of a program's command. supervisord
+// splits commands using the POSIX shell's quoting rules. Comment
+// characters that follow whitespace are escaped outside of the quotes
+// (see supervisordCommentRegex).
+func supervisordQuote(word string) string {
+	return supervisordEscape(supervisordCommentRegex.ReplaceAllString(osutil.ShellQuote(word), `$1'\$2'`))
+}
+
+// supervisordEnvironmentValue quotes the value of a variable in a
+// program's 'environment' setting. supervisord does not support escape
+// characters in the setting, and removes all quotes from the start and
+// end of values, so values that cannot be quoted are rejected.
+func supervisordEnvironmentValue(value string) (string, error) {
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "'") ||
+		strings.HasSuffix(value, `"`) || strings.HasSuffix(value, "'") {
+		return "", fmt.Errorf("the value may not start or end with a quote on supervisord")
+	}
+
+	if supervisordCommentRegex.MatchString(value) {
+		return "", fmt.Errorf("the value may not contain a ';' or '#' that follows whitespace on supervisord")
+	}
+
+	quote := `"`
+	if strings.Contains(value, quote) {
+		quote = "'"
+		if strings.Contains(value, quote) {
+			return "", fmt.Errorf("the value may not contain both single and double quotes on supervisord")
+		}
+	}
+
+	return quote + supervisordEscape(value) + quote, nil
+}
+
+// supervisordProgram is a parsed supervisord program configuration file
+// that was generated by a Controller.
+type supervisordProgram struct {
+	name        string
+	description string
+	settings    map[string]string
+}
+
+// command returns the words of the program's command.
+func (o supervisordProgram) command() ([]string, error) {
+	words, err := osutil.ShellWords(o.settings["command"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse supervisord program's command - %s", err.Error())
+	}
+
+	if len(words) == 0 {
+		return nil, fmt.Errorf("supervisord program does not specify a command")
+	}
+
+	return words, nil
+}
+
+// environment returns the program's environment variable assignments.
+func (o supervisordProgram) environment() ([]string, error) {
+	value := o.settings["environment"]
+	var assignments []string
+
+	for len(value) > 0 {
+		i := strings.Index(value, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("supervisord program's environment setting is malformed")
+		}
+		name := value[:i]
+		value = value[i+1:]
+
+		end := strings.Index(value, ",")
+		if len(value) > 0 && (value[0] == '"' || value[0] == '\'') {
+			closing := strings.IndexByte(value[1:], value[0])
+			if closing < 0 {
+				return nil, fmt.Errorf("supervisord program's environment setting has an unterminated quote")
+			}
+			end = closing + 2
+		}
+		if end < 0 {
+			end = len(value)
+		}
+
+		assignments = append(assignments, name+"="+strings.Trim(value[:end], `'"`))
+		value = strings.TrimPrefix(value[end:], ",")
+	}
+
+	return assignments, nil
+}
+
+// readSupervisordProgram parses a supervisord program configuration file.
+// Values are unescaped (see supervisordEscape).
+func readSupervisordProgram(filePath string) (supervisordProgram, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return supervisordProgram{}, fmt.Errorf("failed to open supervisord program configuration file - %s", err.Error())
+	}
+	defer f.Close()
+
+	program := supervisordProgram{
+		settings: make(map[string]string),
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "# Description:"):
+			program.description = strings.TrimSpace(strings.TrimPrefix(line, "# Description:"))
+		case strings.HasPrefix(line, "[program:"):
+			if len(program.name) > 0 {
+				return supervisordProgram{}, fmt.Errorf("supervisord program configuration file contains more than one program")
+			}
+			program.name = strings.TrimSuffix(strings.TrimPrefix(line, "[program:"), "]")
+		case len(program.name) > 0 && strings.Contains(line, "="):
+			i := strings.Index(line, "=")
+			program.settings[strings.TrimSpace(line[:i])] = strings.Replace(strings.TrimSpace(line[i+1:]), "%%", "%", -1)
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return supervisordProgram{}, fmt.Errorf("failed to read supervisord program configuration file - %s", err.Error())
+	}
+
+	if len(program.name) == 0 {
+		return supervisordProgram{}, fmt.Errorf("supervisord program configuration file does not contain a program")
+	}
+
+	return program, nil
+}
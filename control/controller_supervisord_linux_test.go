@@ -0,0 +1,190 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon"
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+func TestSupervisordQuote(t *testing.T) {
+	tests := []struct {
+		word     string
+		expected string
+	}{
+		{"plain", `'plain'`},
+		{"a b", `'a b'`},
+		{"it's", `'it'\''s'`},
+		{"100%", `'100%%'`},
+		{"%(program_name)s", `'%%(program_name)s'`},
+		{"a ;b", `'a '\;'b'`},
+		{"a #b", `'a '\#'b'`},
+		{"a;b#c", `'a;b#c'`},
+		{"", `''`},
+	}
+
+	for _, test := range tests {
+		actual := supervisordQuote(test.word)
+		if actual != test.expected {
+			t.Fatalf("%q: expected %q - got %q", test.word, test.expected, actual)
+		}
+
+		// supervisord removes inline comments, expands format
+		// strings, and then splits the command into words.
+		if supervisordCommentRegex.MatchString(actual) {
+			t.Fatalf("%q: quoted word %q contains an inline comment", test.word, actual)
+		}
+		words, err := osutil.ShellWords(strings.Replace(actual, "%%", "%", -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(words) != 1 || words[0] != test.word {
+			t.Fatalf("%q: expected quoted word to be parsed as itself - got %q", test.word, words)
+		}
+	}
+}
+
+func TestSupervisordEnvironmentValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+		isValid  bool
+	}{
+		{"plain", `"plain"`, true},
+		{"a,b c", `"a,b c"`, true},
+		{"a=b", `"a=b"`, true},
+		{"100%", `"100%%"`, true},
+		{`say "hi" now`, `'say "hi" now'`, true},
+		{"it's", `"it's"`, true},
+		{"a;b#c", `"a;b#c"`, true},
+		{"", `""`, true},
+		{`"quoted"`, "", false},
+		{"'quoted'", "", false},
+		{`ends with "`, "", false},
+		{`it's "both"!`, "", false},
+		{"a ;b", "", false},
+		{"a\t#b", "", false},
+	}
+
+	for _, test := range tests {
+		actual, err := supervisordEnvironmentValue(test.value)
+		if test.isValid != (err == nil) {
+			t.Fatalf("%q: expected valid: %t - got error: %v", test.value, test.isValid, err)
+		}
+		if actual != test.expected {
+			t.Fatalf("%q: expected %q - got %q", test.value, test.expected, actual)
+		}
+	}
+}
+
+func TestSupervisordProgramRoundTrip(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := ControllerConfig{
+		DaemonID:  "cyberdaemon-test",
+		ExePath:   "/opt/my app/100%/app",
+		Arguments: []string{"a b", "it's", "%(ENV_HOME)s", "a ;b", "x #y", "", `back\slash`},
+		Environment: map[string]string{
+			"COMMAS":  "a,b,c",
+			"EQUALS":  "a=b",
+			"FORMAT":  "%(program_name)s",
+			"QUOTES":  `say "hi" now`,
+			"SINGLE":  "it's",
+			"SPACES":  "a  b",
+			"COMMENT": "a;b#c",
+		},
+		WorkDirPath: "/srv/my app/50%",
+		RestartPolicy: cyberdaemon.RestartPolicy{
+			Mode: cyberdaemon.RestartAlways,
+		},
+		ResourceLimits: ResourceLimits{Nice: 5, IOSchedulingClass: IOSchedulingIdle},
+	}
+
+	program, err := renderSupervisordProgram(config, "/var/log/100%/app.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(program, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if supervisordCommentRegex.MatchString(line) {
+			t.Fatalf("setting contains an inline comment: %s", line)
+		}
+		if strings.Contains(strings.Replace(line, "%%", "", -1), "%") {
+			t.Fatalf("setting contains an unescaped '%%': %s", line)
+		}
+	}
+
+	programFilePath := path.Join(tempDirPath, config.DaemonID+".conf")
+	err = ioutil.WriteFile(programFilePath, []byte(program), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := readSupervisordProgram(programFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedSettings := map[string]string{
+		"directory":      config.WorkDirPath,
+		"autorestart":    "true",
+		"stderr_logfile": "/var/log/100%/app.log",
+	}
+	for name, value := range expectedSettings {
+		if parsed.settings[name] != value {
+			t.Fatalf("expected %s to be %q - got %q", name, value, parsed.settings[name])
+		}
+	}
+
+	command, err := parsed.command()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCommand := append([]string{"nice", "-n", "5", "ionice", "-c", "3", config.ExePath}, config.Arguments...)
+	if !reflect.DeepEqual(command, expectedCommand) {
+		t.Fatalf("expected command %q - got %q", expectedCommand, command)
+	}
+
+	environment, err := parsed.environment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := environmentAssignments(config.Environment); !reflect.DeepEqual(environment, expected) {
+		t.Fatalf("expected environment %q - got %q", expected, environment)
+	}
+}
+
+func TestRenderSupervisordProgramErrors(t *testing.T) {
+	invalid := []ControllerConfig{
+		{WorkDirPath: "/srv/a ;b"},
+		{Environment: map[string]string{"FOO": `"quoted"`}},
+		{Environment: map[string]string{"FOO": `it's "both"!`}},
+		{Environment: map[string]string{"FOO": "a #b"}},
+		{Arguments: []string{"new\nline"}},
+		{RestartPolicy: cyberdaemon.RestartPolicy{Delay: time.Second}},
+		{RestartPolicy: cyberdaemon.RestartPolicy{BurstLimit: 3, BurstInterval: time.Minute}},
+	}
+
+	for _, config := range invalid {
+		config.DaemonID = "cyberdaemon-test"
+		config.ExePath = "/usr/bin/app"
+
+		_, err := renderSupervisordProgram(config, "")
+		if err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+}
@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/user"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/coreos/go-systemd/unit"
+	"github.com/stephen-fox/cyberdaemon"
 	"github.com/stephen-fox/cyberdaemon/internal/osutil"
 )
 
@@ -14,6 +19,10 @@ const (
 	systemctlExeName    = "systemctl"
 	userArgument        = "--user"
 	daemonReloadCommand = "daemon-reload"
+
+	// instanceEnvironmentAssignment is the 'Environment' setting that
+	// passes an instance's name to the daemon.
+	instanceEnvironmentAssignment = cyberdaemon.InstanceEnv + "=%i"
 )
 
 var (
@@ -25,8 +34,9 @@ type systemdController struct {
 	daemonID      string
 	unitFilePath  string
 	unitContents  []byte
-	addUserArg    bool
 	startType     StartType
+	userScope     *systemdUserScope
+	install       installSettings
 }
 
 func (o *systemdController) Status() (Status, error) {
@@ -35,53 +45,90 @@ func (o *systemdController) Status() (Status, error) {
 		return NotInstalled, nil
 	}
 
-	var args []string
-	if o.addUserArg {
-		args = append(args, userArgument)
-	}
-	args = append(args,"status", o.daemonID)
+	return o.unitStatus(o.daemonID), nil
+}
 
-	_, exitCode, statusErr := osutil.RunDaemonCli(o.systemctlPath, args...)
+// unitStatus returns the status of the specified unit using
+// 'systemctl status'.
+func (o *systemdController) unitStatus(unitName string) Status {
+	_, exitCode, statusErr := o.systemctl("status", unitName)
 	if statusErr != nil {
 		switch exitCode {
 		case 3:
-			return Stopped, nil
+			return Stopped
 		case 1:
-			return StoppedDead, nil
+			return StoppedDead
 		}
 	}
 
 	if exitCode == 0 {
-		return Running, nil
+		return Running
 	}
 
-	return Unknown, nil
+	return Unknown
 }
 
 func (o *systemdController) Install() error {
-	err := ioutil.WriteFile(o.unitFilePath, o.unitContents, 0644)
+	err := o.writeUnitFile()
+	if err != nil {
+		return err
+	}
+
+	return o.applyStartType(o.daemonID)
+}
+
+// writeUnitFile prepares the system, and writes the unit file.
+func (o *systemdController) writeUnitFile() error {
+	err := o.install.beforeInstall()
+	if err != nil {
+		return err
+	}
+
+	if o.userScope != nil {
+		err := o.userScope.prepareUnitDir(path.Dir(o.unitFilePath))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ioutil.WriteFile(o.unitFilePath, o.unitContents, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write systemd unit file - %s", err.Error())
 	}
 
-	var args []string
-	if o.addUserArg {
-		args = append(args, userArgument)
+	if o.userScope != nil {
+		err := o.userScope.chown(o.unitFilePath)
+		if err != nil {
+			return err
+		}
+
+		if o.userScope.enableLinger {
+			err := o.userScope.lingerAndWait()
+			if err != nil {
+				return err
+			}
+		}
 	}
 
+	return nil
+}
+
+// applyStartType starts and enables the specified unit according
+// to the StartType.
+func (o *systemdController) applyStartType(unitName string) error {
 	switch o.startType {
 	case StartImmediately:
-		_, _, err = osutil.RunDaemonCli(o.systemctlPath, append(args, daemonReloadCommand)...)
+		_, _, err := o.systemctl(daemonReloadCommand)
 		if err != nil {
 			return err
 		}
-		err := o.Start()
+		_, _, err = o.systemctl("start", unitName)
 		if err != nil {
 			return err
 		}
 		fallthrough
 	case StartOnLoad:
-		_, _, err := osutil.RunDaemonCli(o.systemctlPath, append(args, "enable", o.daemonID)...)
+		_, _, err := o.systemctl("enable", unitName)
 		if err != nil {
 			return err
 		}
@@ -102,28 +149,25 @@ func (o *systemdController) Uninstall() error {
 		return err
 	}
 
-	var args []string
-	if o.addUserArg {
-		args = append(args, userArgument)
-	}
-	args = append(args, daemonReloadCommand)
-
-	_, _, err = osutil.RunDaemonCli(o.systemctlPath, args...)
+	_, _, err = o.systemctl(daemonReloadCommand)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return o.install.afterUninstall()
 }
 
 func (o *systemdController) Start() error {
-	var args []string
-	if o.addUserArg {
-		args = append(args, userArgument)
+	_, _, err := o.systemctl("start", o.daemonID)
+	if err != nil {
+		return err
 	}
-	args = append(args, "start", o.daemonID)
 
-	_, _, err := osutil.RunDaemonCli(o.systemctlPath, args...)
+	return nil
+}
+
+func (o *systemdController) Stop() error {
+	_, _, err := o.systemctl("stop", o.daemonID)
 	if err != nil {
 		return err
 	}
@@ -131,19 +175,40 @@ func (o *systemdController) Start() error {
 	return nil
 }
 
-func (o *systemdController) Stop() error {
-	var args []string
-	if o.addUserArg {
-		args = append(args, userArgument)
+func (o *systemdController) Debug() error {
+	return o.debugUnit("")
+}
+
+// debugUnit runs the unit in the foreground. If the unit file is a
+// template, the instance name must be specified.
+func (o *systemdController) debugUnit(instanceName string) error {
+	var userUnitOwner string
+	if o.userScope != nil {
+		userUnitOwner = o.userScope.owner.Username
 	}
-	args = append(args, "stop", o.daemonID)
 
-	_, _, err := osutil.RunDaemonCli(o.systemctlPath, args...)
+	command, err := systemdForegroundCommand(o.unitFilePath, instanceName, userUnitOwner)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return command.run()
+}
+
+// systemctl runs systemctl with the specified arguments. For user
+// daemons, '--user' is added to the arguments, and systemctl is run
+// as the daemon's owner.
+func (o *systemdController) systemctl(args ...string) (string, int, error) {
+	if o.userScope == nil {
+		return osutil.RunDaemonCli(o.systemctlPath, args...)
+	}
+
+	cmd, err := o.userScope.command(o.systemctlPath, append([]string{userArgument}, args...)...)
+	if err != nil {
+		return "", -1, err
+	}
+
+	return osutil.RunDaemonCliCmd(cmd)
 }
 
 func newSystemdController(config ControllerConfig, systemctlPath string) (*systemdController, error) {
@@ -152,17 +217,23 @@ func newSystemdController(config ControllerConfig, systemctlPath string) (*syste
 		return nil, err
 	}
 
-	command := config.ExePath
-	if len(config.Arguments) > 0 {
-		command = fmt.Sprintf("%s %s", config.ExePath, config.argumentsAsString())
+	command, err := systemdCommandLine(append([]string{config.ExePath}, config.Arguments...))
+	if err != nil {
+		return nil, err
 	}
 
-	unitOptions := []*unit.UnitOption{
-		{
-			Section: "Unit",
-			Name:    "Description",
-			Value:   config.Description,
-		},
+	// User instances of systemd do not have a 'multi-user.target'.
+	wantedBy := "multi-user.target"
+	if config.scope() == UserScope {
+		wantedBy = "default.target"
+	}
+
+	userScope, unitFilePath, err := runSettings(config)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceOptions := []*unit.UnitOption{
 		{
 			Section: "Service",
 			Name:    "Type",
@@ -173,26 +244,84 @@ func newSystemdController(config ControllerConfig, systemctlPath string) (*syste
 			Name:    "ExecStart",
 			Value:   command,
 		},
+	}
+
+	restartUnitOptions, restartServiceOptions := systemdRestartOptions(config.RestartPolicy)
+	serviceOptions = append(serviceOptions, restartServiceOptions...)
+
+	if userScope == nil && len(config.RunAs) > 0 {
+		serviceOptions = append(serviceOptions, unit.NewUnitOption("Service", "User", config.RunAs))
+	}
+
+	if len(config.Group) > 0 || len(config.SupplementaryGroups) > 0 {
+		if userScope != nil {
+			return nil, fmt.Errorf("groups cannot be changed for user daemons")
+		}
+		if len(config.Group) > 0 {
+			serviceOptions = append(serviceOptions, unit.NewUnitOption("Service", "Group",
+				escapeSystemdSpecifiers(config.Group)))
+		}
+		if len(config.SupplementaryGroups) > 0 {
+			serviceOptions = append(serviceOptions, unit.NewUnitOption("Service", "SupplementaryGroups",
+				escapeSystemdSpecifiers(strings.Join(config.SupplementaryGroups, " "))))
+		}
+	}
+
+	if len(config.Capabilities) > 0 {
+		if userScope != nil {
+			return nil, fmt.Errorf("capabilities cannot be granted to user daemons")
+		}
+		capabilities := strings.Join(config.Capabilities, " ")
+		serviceOptions = append(serviceOptions,
+			unit.NewUnitOption("Service", "AmbientCapabilities", capabilities),
+			unit.NewUnitOption("Service", "CapabilityBoundingSet", capabilities))
+	}
+
+	if userScope != nil && (config.CreateUser || config.Directories.isSet()) {
+		return nil, fmt.Errorf("users and directories cannot be created for user daemons")
+	}
+
+	if len(config.WorkDirPath) > 0 {
+		serviceOptions = append(serviceOptions, unit.NewUnitOption("Service", "WorkingDirectory",
+			escapeSystemdSpecifiers(config.WorkDirPath)))
+	}
+
+	if len(config.Umask) > 0 {
+		mask, _ := config.umask()
+		serviceOptions = append(serviceOptions, unit.NewUnitOption("Service", "UMask", fmt.Sprintf("%04o", mask)))
+	}
+
+	serviceOptions = append(serviceOptions, systemdEnvironmentOptions(config)...)
+	serviceOptions = append(serviceOptions, systemdResourceLimitOptions(config.ResourceLimits)...)
+	serviceOptions = append(serviceOptions, systemdDirectoryOptions(config.Directories)...)
+
+	unitOptions := []*unit.UnitOption{
 		{
-			Section: "Service",
-			Name:    "Restart",
-			Value:   "on-failure",
+			Section: "Unit",
+			Name:    "Description",
+			Value:   escapeSystemdSpecifiers(config.Description),
 		},
+	}
+	unitOptions = append(unitOptions, systemdDependencyOptions(config.Dependencies)...)
+	unitOptions = append(unitOptions, restartUnitOptions...)
+	unitOptions = append(unitOptions, serviceOptions...)
+	unitOptions = append(unitOptions, []*unit.UnitOption{
 		{
 			Section: "Install",
 			Name:    "WantedBy",
-			Value:   "multi-user.target",
+			Value:   wantedBy,
 		},
-	}
-
-	addUserToUnit, unitFilePath, specifyUserArg, err := runSettings(config)
-	if err != nil {
-		return nil, err
-	}
-
-	if addUserToUnit {
-		unitOptions = append(unitOptions, unit.NewUnitOption("Service", "User", config.RunAs))
-	}
+		{
+			Section: markerSection,
+			Name:    markerVersionName,
+			Value:   cyberdaemon.Version,
+		},
+		{
+			Section: markerSection,
+			Name:    markerConfigHashName,
+			Value:   config.hash(),
+		},
+	}...)
 
 	unitContents, err := ioutil.ReadAll(unit.Serialize(unitOptions))
 	if err != nil {
@@ -204,35 +333,198 @@ func newSystemdController(config ControllerConfig, systemctlPath string) (*syste
 		daemonID:      config.DaemonID,
 		unitFilePath:  unitFilePath,
 		unitContents:  unitContents,
-		addUserArg:    specifyUserArg,
 		startType:     config.StartType,
+		userScope:     userScope,
+		install:       newInstallSettings(config),
 	}, nil
 }
 
-// runSettings returns whether the user should be specified in the unit config
-// file, the unit file path, and whether '--user' needs to be specified when
-// running the 'systemctl' command.
-func runSettings(config ControllerConfig) (bool, string, bool, error) {
-	defaultUnitPath := fmt.Sprintf("/etc/systemd/system/%s.service", config.DaemonID)
-	if len(config.RunAs) == 0 {
-		return false, defaultUnitPath, false, nil
+// systemdEnvironmentOptions returns the 'Environment' and 'EnvironmentFile'
+// settings for the configuration. Variables are sorted by name so that the
+// unit file's contents are stable.
+func systemdEnvironmentOptions(config ControllerConfig) []*unit.UnitOption {
+	var names []string
+	for name := range config.Environment {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	current, err := user.Current()
-	if err != nil {
-		return false, "", false, fmt.Errorf("failed to get current user - %s", err.Error())
+	var options []*unit.UnitOption
+
+	if strings.Contains(config.DaemonID, instanceSeparator) {
+		// systemd expands '%i' to the unit's instance name.
+		options = append(options, unit.NewUnitOption("Service", "Environment", instanceEnvironmentAssignment))
+	}
+
+	for _, name := range names {
+		// Variables are not substituted in the 'Environment'
+		// setting, so only specifiers need to be escaped.
+		options = append(options, unit.NewUnitOption("Service", "Environment",
+			escapeSystemdSpecifiers(quoteSystemdWord(name+"="+config.Environment[name]))))
 	}
 
-	_, onlyRunWhenLoggedIn := config.SystemSpecificOptions[RunOnlyWhenLoggedIn]
-	if onlyRunWhenLoggedIn {
-		if config.RunAs == current.Username {
-			return false, fmt.Sprintf("%s/.config/systemd/user/%s.service", current.HomeDir, config.DaemonID),
-				true, nil
+	for _, filePath := range config.EnvironmentFiles {
+		options = append(options, unit.NewUnitOption("Service", "EnvironmentFile",
+			escapeSystemdSpecifiers(filePath)))
+	}
+
+	return options
+}
+
+// systemdResourceLimitOptions returns the settings that apply the
+// resource limits. Sizes are converted to bytes.
+func systemdResourceLimitOptions(limits ResourceLimits) []*unit.UnitOption {
+	var options []*unit.UnitOption
+
+	if limits.OpenFiles > 0 {
+		options = append(options, unit.NewUnitOption("Service", "LimitNOFILE",
+			strconv.FormatUint(limits.OpenFiles, 10)))
+	}
+
+	if limits.Processes > 0 {
+		options = append(options, unit.NewUnitOption("Service", "LimitNPROC",
+			strconv.FormatUint(limits.Processes, 10)))
+	}
+
+	if len(limits.CoreSize) > 0 {
+		coreSize, _ := parseSizeLimit(limits.CoreSize, true)
+		if coreSize == unlimitedSize {
+			coreSize = "infinity"
 		}
-		return false, "", false,
-			fmt.Errorf("the '%s' option cannot be used when the curret user is not the RunAs user",
-				RunOnlyWhenLoggedIn)
+		options = append(options, unit.NewUnitOption("Service", "LimitCORE", coreSize))
+	}
+
+	if limits.Nice != 0 {
+		options = append(options, unit.NewUnitOption("Service", "Nice", strconv.Itoa(limits.Nice)))
+	}
+
+	if len(limits.IOSchedulingClass) > 0 {
+		options = append(options, unit.NewUnitOption("Service", "IOSchedulingClass",
+			limits.IOSchedulingClass.string()))
+	}
+
+	if len(limits.MemoryMax) > 0 {
+		memoryMax, _ := parseSizeLimit(limits.MemoryMax, false)
+		options = append(options, unit.NewUnitOption("Service", "MemoryMax", memoryMax))
 	}
 
-	return true, defaultUnitPath, false, nil
+	if limits.CPUQuota > 0 {
+		options = append(options, unit.NewUnitOption("Service", "CPUQuota",
+			fmt.Sprintf("%d%%", limits.CPUQuota)))
+	}
+
+	return options
+}
+
+// systemdDependencyOptions returns the '[Unit]' settings that declare the
+// dependencies. The daemons implied by Requires and BindsTo are added to
+// the 'After' setting.
+func systemdDependencyOptions(dependencies Dependencies) []*unit.UnitOption {
+	settings := []struct {
+		name      string
+		daemonIDs []string
+	}{
+		{name: "After", daemonIDs: dependencies.startAfter()},
+		{name: "Before", daemonIDs: dependencies.Before},
+		{name: "Requires", daemonIDs: dependencies.Requires},
+		{name: "BindsTo", daemonIDs: dependencies.BindsTo},
+		{name: "PartOf", daemonIDs: dependencies.PartOf},
+	}
+
+	var options []*unit.UnitOption
+
+	for _, setting := range settings {
+		if len(setting.daemonIDs) == 0 {
+			continue
+		}
+
+		unitNames := make([]string, len(setting.daemonIDs))
+		for i, id := range setting.daemonIDs {
+			unitNames[i] = id + ".service"
+		}
+
+		options = append(options, unit.NewUnitOption("Unit", setting.name, strings.Join(unitNames, " ")))
+	}
+
+	return options
+}
+
+// systemdRestartOptions returns the '[Unit]' and '[Service]' settings
+// that apply the restart policy. Only the settings that are specified by
+// the policy are returned, so systemd's defaults apply to the others.
+func systemdRestartOptions(policy cyberdaemon.RestartPolicy) ([]*unit.UnitOption, []*unit.UnitOption) {
+	restart := "on-failure"
+	switch policy.EffectiveMode() {
+	case cyberdaemon.RestartNever:
+		restart = "no"
+	case cyberdaemon.RestartAlways:
+		restart = "always"
+	}
+
+	var unitOptions []*unit.UnitOption
+	serviceOptions := []*unit.UnitOption{
+		unit.NewUnitOption("Service", "Restart", restart),
+	}
+
+	if policy.Delay > 0 {
+		serviceOptions = append(serviceOptions, unit.NewUnitOption("Service", "RestartSec",
+			systemdTimeSpan(policy.Delay)))
+	}
+
+	if policy.BurstLimit > 0 {
+		unitOptions = append(unitOptions, unit.NewUnitOption("Unit", "StartLimitBurst",
+			strconv.Itoa(policy.BurstLimit)))
+	}
+
+	if policy.BurstInterval > 0 {
+		unitOptions = append(unitOptions, unit.NewUnitOption("Unit", "StartLimitIntervalSec",
+			systemdTimeSpan(policy.BurstInterval)))
+	}
+
+	return unitOptions, serviceOptions
+}
+
+// systemdTimeSpan formats the duration as a systemd time span in whole
+// seconds or milliseconds.
+func systemdTimeSpan(duration time.Duration) string {
+	if duration%time.Second == 0 {
+		return strconv.FormatInt(int64(duration/time.Second), 10) + "s"
+	}
+
+	return strconv.FormatInt(int64(duration/time.Millisecond), 10) + "ms"
+}
+
+// systemdDirectoryOptions returns the settings that create the directories.
+func systemdDirectoryOptions(directories Directories) []*unit.UnitOption {
+	var options []*unit.UnitOption
+
+	for _, kind := range directories.kinds() {
+		if len(kind.names) == 0 {
+			continue
+		}
+
+		options = append(options,
+			unit.NewUnitOption("Service", kind.settingPrefix+"Directory", strings.Join(kind.names, " ")),
+			unit.NewUnitOption("Service", kind.settingPrefix+"DirectoryMode",
+				fmt.Sprintf("%04o", uint32(directories.mode()))))
+	}
+
+	return options
+}
+
+// runSettings returns the user scope settings (nil for system daemons),
+// and the unit file path.
+func runSettings(config ControllerConfig) (*systemdUserScope, string, error) {
+	if config.scope() != UserScope {
+		return nil, fmt.Sprintf("/etc/systemd/system/%s.service", config.DaemonID), nil
+	}
+
+	userScope, err := newSystemdUserScope(config.RunAs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, userScope.enableLinger = config.SystemSpecificOptions[EnableLingerOption]
+
+	return userScope, path.Join(userScope.unitDirPath(), config.DaemonID+".service"), nil
 }
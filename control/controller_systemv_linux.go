@@ -5,40 +5,55 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
-	"strings"
 
 	"github.com/stephen-fox/cyberdaemon"
 	"github.com/stephen-fox/cyberdaemon/internal/osutil"
 )
 
 const (
-	// systemvTemplate is a System V init.d script template that
-	// contains placeholders for customizable options. This template
-	// is based on '/etc/init.d/sshd' from CentOS 6.10.
+	// DefaultSystemVTemplate is the built-in System V init.d script
+	// template. It is a text/template template that is rendered using
+	// a SystemVTemplateData. This template is based on '/etc/init.d/sshd'
+	// from CentOS 6.10.
+	//
+	// The template defines the following blocks, which can be redefined
+	// by a custom template (see SystemVTemplateOption) to extend the
+	// built-in template:
+	// 	- "variables": Additional shell variables defined at the top
+	// 	  level of the script (empty by default)
+	// 	- "pre-start": Shell commands that run (as root) before the
+	// 	  daemon starts (renders the PreStart commands by default)
+	//
+	// Whitespace preceding the blocks is trimmed, so redefined blocks
+	// should start with a newline.
 	//
 	// Credit to the OpenSSH team et al:
 	//  Taken from: https://github.com/openssh/openssh-portable/blob/79226e5413c5b0fda3511351a8511ff457e306d8/contrib/redhat/sshd.init
 	//  Commit: 79226e5413c5b0fda3511351a8511ff457e306d8
-	//
-	// TODO: Support additional 'Required' and 'Should' statements,
-	//  such as '$network'.
-	// TODO: Support run level customization.
-	systemvTemplate = `#!/bin/bash
+	DefaultSystemVTemplate = `#!/bin/bash
 #
 # This file is based on '/etc/init.d/sshd' from the OpenSSH project.
 # See https://github.com/openssh/openssh-portable/blob/master/LICENCE
 # for details.
 
 ### BEGIN INIT INFO
-# Provides: ` + serviceNamePlaceholder + `
-# Required-Start: $local_fs $syslog
-# Required-Stop: $local_fs $syslog
-# Should-Start: $syslog
-# Should-Stop: $syslog
-# Default-Start: 2 3 4 5
-# Default-Stop: 0 1 6
-# Short-Description: ` + shortDescriptionPlaceholder + `
-# Description:       ` + descriptionPlaceholder + `
+# Provides: {{.Name}}
+# Required-Start: {{join .RequiredStart " "}}
+# Required-Stop: {{join .RequiredStop " "}}
+# Should-Start: {{join .ShouldStart " "}}
+# Should-Stop: {{join .ShouldStop " "}}
+# Default-Start: {{join .DefaultStart " "}}
+# Default-Stop: {{join .DefaultStop " "}}
+{{- if .StartBefore}}
+# X-Start-Before: {{join .StartBefore " "}}
+{{- end}}
+{{- if .StopAfter}}
+# X-Stop-After: {{join .StopAfter " "}}
+{{- end}}
+# Short-Description: {{.ShortDescription}}
+# Description:       {{.Description}}
+# X-Cyberdaemon-Version: {{.LibraryVersion}}
+# X-Cyberdaemon-Config-Hash: {{.ConfigHash}}
 ### END INIT INFO
 
 IS_REDHAT=""
@@ -55,18 +70,180 @@ else
     fi
 fi
 
-PROGRAM_NAME='` + serviceNamePlaceholder + `'
-PROGRAM_PATH='` + exePathPlaceholder + `'
-ARGUMENTS='` + argumentsPlaceholder + `'
-RUN_AS='` + runAsPlaceholder + `'
+PROGRAM_NAME={{shellQuote .Name}}
+SHORT_DESCRIPTION={{shellQuote .ShortDescription}}
+PROGRAM_PATH={{shellQuote .ExePath}}
+ARGUMENTS=({{shellQuoteAll .Arguments}})
+RUN_AS={{shellQuote .RunAs}}
 if [ -z "${RUN_AS}" ]
 then
 	RUN_AS='root'
 fi
-` + cyberdaemon.PIDFilePathVar + `='` + pidFilePathPlaceholder + `'
+{{.PIDFilePathVar}}={{shellQuote .PIDFilePath}}
+ENVIRONMENT=({{shellQuoteAll .Environment}})
+ENVIRONMENT_FILES=({{shellQuoteAll .EnvironmentFiles}})
+WORK_DIR_PATH={{shellQuote .WorkDirPath}}
+UMASK={{shellQuote .Umask}}
+GROUP={{shellQuote .Group}}
+SUPPLEMENTARY_GROUPS=({{shellQuoteAll .SupplementaryGroups}})
+CAPABILITIES=({{shellQuoteAll .Capabilities}})
+DIRECTORIES=({{shellQuoteAll .Directories}})
+DIRECTORY_MODE={{shellQuote .DirectoryMode}}
+INSTANCE_NAME={{shellQuote .InstanceName}}
+RESTART_POLICY={{shellQuote .RestartPolicy}}
+{{- block "variables" .}}{{end}}
 
 runlevel=$(set -- $(runlevel); eval "echo \$$#" )
 
+# load_environment exports the daemon's environment variables. Files in
+# ENVIRONMENT_FILES are sourced, and may override any variable. Paths
+# prefixed with '-' are ignored if the file does not exist.
+load_environment() {
+    if [ -n "${INSTANCE_NAME}" ]
+    then
+        export CYBERDAEMON_INSTANCE="${INSTANCE_NAME}"
+    fi
+    if [ -n "${RESTART_POLICY}" ]
+    then
+        export CYBERDAEMON_RESTART_POLICY="${RESTART_POLICY}"
+    fi
+    if [ ${#ENVIRONMENT[@]} -gt 0 ]
+    then
+        export "${ENVIRONMENT[@]}"
+    fi
+    local environmentFile
+    for environmentFile in "${ENVIRONMENT_FILES[@]}"
+    do
+        if [ "${environmentFile:0:1}" == "-" ]
+        then
+            environmentFile="${environmentFile:1}"
+            [ -r "${environmentFile}" ] || continue
+        fi
+        set -a
+        . "${environmentFile}" || exit 6
+        set +a
+    done
+}
+
+# prepare_process applies the daemon's process settings to the current
+# shell. It is run in the subshell that starts the daemon.
+prepare_process() {
+    if [ -n "${WORK_DIR_PATH}" ]
+    then
+        cd "${WORK_DIR_PATH}" || exit 1
+    fi
+    if [ -n "${UMASK}" ]
+    then
+        umask "${UMASK}" || exit 1
+    fi
+    load_environment
+}
+
+# create_directories creates the daemon's directories and the specified
+# log file directory (if any), and sets their owner and mode. The owner
+# of the files in the directories is not changed.
+create_directories() {
+    local logDirPath="$1"
+    [ ${#DIRECTORIES[@]} -gt 0 ] || [ -n "${logDirPath}" ] || return 0
+    local group="${GROUP}"
+    if [ -z "${group}" ]
+    then
+        group="$(id -gn "${RUN_AS}")" || return 1
+    fi
+    if [ -n "${logDirPath}" ]
+    then
+        install -d -m 0700 -o "${RUN_AS}" -g "${group}" "${logDirPath}" || return 1
+    fi
+    local directory
+    for directory in "${DIRECTORIES[@]}"
+    do
+        install -d -m "${DIRECTORY_MODE}" -o "${RUN_AS}" -g "${group}" "${directory}" || return 1
+    done
+}
+
+# needs_setpriv returns zero if the daemon's groups or capabilities
+# must be changed using 'setpriv'.
+needs_setpriv() {
+    [ -n "${GROUP}" ] || [ ${#SUPPLEMENTARY_GROUPS[@]} -gt 0 ] || [ ${#CAPABILITIES[@]} -gt 0 ]
+}
+
+# set_privileges sets the caller's 'privileges' array to a 'setpriv'
+# command that runs the daemon as RUN_AS with the configured groups and
+# capabilities. 'su' cannot change the groups or capabilities of the
+# process that it starts.
+set_privileges() {
+    if ! command -v setpriv > /dev/null
+    then
+        echo "'setpriv' is required to change the daemon's groups or capabilities" >&2
+        return 1
+    fi
+    local uid
+    uid="$(id -u "${RUN_AS}")" || return 1
+    local gid
+    if [ -n "${GROUP}" ]
+    then
+        gid="$(getent group "${GROUP}" | cut -d: -f3)"
+    else
+        gid="$(id -g "${RUN_AS}")"
+    fi
+    if [ -z "${gid}" ]
+    then
+        echo "failed to find group '${GROUP}'" >&2
+        return 1
+    fi
+    local groups
+    groups="$(id -G "${RUN_AS}")" || return 1
+    local group
+    local groupID
+    for group in "${SUPPLEMENTARY_GROUPS[@]}"
+    do
+        groupID="$(getent group "${group}" | cut -d: -f3)"
+        if [ -z "${groupID}" ]
+        then
+            echo "failed to find group '${group}'" >&2
+            return 1
+        fi
+        groups="${groups} ${groupID}"
+    done
+    # Capabilities must be inheritable to be added to the ambient
+    # set, which preserves them when the user is changed.
+    local capabilities=()
+    if [ ${#CAPABILITIES[@]} -gt 0 ]
+    then
+        local names=""
+        local capability
+        for capability in "${CAPABILITIES[@]}"
+        do
+            capability="${capability,,}"
+            names="${names},+${capability#cap_}"
+        done
+        names="${names#,}"
+        capabilities=(--inh-caps="${names}" --ambient-caps="${names}" --bounding-set=-all,"${names}")
+    fi
+    local homeDirPath
+    homeDirPath="$(getent passwd "${RUN_AS}" | cut -d: -f6)"
+    privileges=(setpriv --reuid="${uid}" --regid="${gid}" --groups="${groups// /,}" "${capabilities[@]}" --
+        env HOME="${homeDirPath}" USER="${RUN_AS}" LOGNAME="${RUN_AS}")
+}
+
+# su_command sets the caller's 'command' variable to the command that
+# 'su' runs to start the daemon, which redirects stderr to the specified
+# log file. The command is re-parsed by the shell that 'su' runs, so each
+# word must be quoted for that shell (which is forced to bash). 'su' may
+# reset the umask, so it is set again by that shell.
+su_command() {
+    command=""
+    if [ -n "${UMASK}" ]
+    then
+        printf -v command 'umask %q && ' "${UMASK}"
+    fi
+    local quoted
+    printf -v quoted '%q ' "${PROGRAM_PATH}" "${ARGUMENTS[@]}"
+    command+="exec ${quoted}"
+    printf -v quoted '%q' "$1"
+    command+="2> ${quoted}"
+}
+
 start() {
     [ -x "${PROGRAM_PATH}" ] || exit 5
     if [ -n "${IS_REDHAT}" ]
@@ -76,22 +253,52 @@ start() {
         check_dev_null
         log_daemon_msg "Starting ${SHORT_DESCRIPTION}" "${PROGRAM_NAME}" || true
     fi
-    local logFilePath="` + logFilePathPlaceholder + `"
+    local logFilePath={{shellQuote .LogFilePath}}
+    local logDirPath=""
     if [ -z "${logFilePath}" ]
     then
         logFilePath=/dev/null
     else
-        mkdir -p -m 0700 "${logFilePath%/*}"
-        chown -R "${RUN_AS}:${RUN_AS}" "${logFilePath%/*}"
+        logDirPath="${logFilePath%/*}"
     fi
+    create_directories "${logDirPath}" || exit 1
+{{- range .Ulimits}}
+    ulimit {{.}}
+{{- end}}
+{{- block "pre-start" .}}
+{{- range .PreStart}}
+    {{.}}
+{{- end}}
+{{- end}}
     local r=0
-    if [ "${RUN_AS}" == "root" ]
+    if [ "${RUN_AS}" != "root" ]
+    then
+        touch "${PID_FILE_PATH}"
+        chown "${RUN_AS}:${RUN_AS}" "${PID_FILE_PATH}"
+    fi
+    # The process settings are applied in a subshell so that they
+    # cannot change the script's variables. The command is stored in
+    # the positional parameters before the environment is loaded.
+    if [ "${RUN_AS}" == "root" ] || needs_setpriv
     then
-        $PROGRAM_PATH $ARGUMENTS 2> "$logFilePath"
+        local privileges=()
+        if needs_setpriv
+        then
+            set_privileges || exit 1
+        fi
+        (
+            set -- "${privileges[@]}" "${PROGRAM_PATH}" "${ARGUMENTS[@]}"
+            prepare_process
+            exec {{if .Nice}}nice -n {{.Nice}} {{end}}{{if .IOSchedulingClass}}ionice -c {{.IOSchedulingClass}} {{end}}"$@"
+        ) 2> "${logFilePath}"
     else
-        touch $PID_FILE_PATH
-        chown ${RUN_AS}:${RUN_AS} $PID_FILE_PATH
-        su $RUN_AS -c "$PROGRAM_PATH $ARGUMENTS 2> '$logFilePath'"
+        local command
+        su_command "${logFilePath}"
+        (
+            set -- "${RUN_AS}" "${command}"
+            prepare_process
+            exec {{if .Nice}}nice -n {{.Nice}} {{end}}{{if .IOSchedulingClass}}ionice -c {{.IOSchedulingClass}} {{end}}su -s /bin/bash "$1" -c "$2"
+        )
     fi
     r=$?
     if [ -n "${IS_REDHAT}" ]
@@ -118,11 +325,11 @@ stop() {
     if [ -n "${IS_REDHAT}" ]
     then
         echo -n $"Stopping $PROGRAM_NAME: "
-        killproc -p $PID_FILE_PATH $PROGRAM_PATH
+        killproc -p "${PID_FILE_PATH}" "${PROGRAM_PATH}"
         echo
     else
         log_daemon_msg "Stopping ${SHORT_DESCRIPTION}" "${PROGRAM_NAME}" || true
-        if start-stop-daemon --stop --pidfile ${PID_FILE_PATH}
+        if start-stop-daemon --stop --pidfile "${PID_FILE_PATH}"
         then
             log_end_msg 0 || true
         else
@@ -133,14 +340,14 @@ stop() {
     # so the TCP connections are closed cleanly
     if [ "x$runlevel" = x0 -o "x$runlevel" = x6 ]; then
         trap '' TERM
-        pkill $PROGRAM_NAME 2>/dev/null
+        pkill "${PROGRAM_NAME}" 2>/dev/null
         trap TERM
     fi
     return $?
 }
 
 rh_status() {
-    status -p $PID_FILE_PATH $PROGRAM_NAME
+    status -p "${PID_FILE_PATH}" "${PROGRAM_NAME}"
 }
 
 rh_status_q() {
@@ -172,7 +379,7 @@ case "$1" in
         then
             rh_status_q && exit 0
         else
-            start-stop-daemon --status --pidfile ${PID_FILE_PATH} && exit 0
+            start-stop-daemon --status --pidfile "${PID_FILE_PATH}" && exit 0
         fi
         start
         ;;
@@ -183,7 +390,7 @@ case "$1" in
                 exit 0
             fi
         else
-            start-stop-daemon --status --pidfile ${PID_FILE_PATH} || exit 0
+            start-stop-daemon --status --pidfile "${PID_FILE_PATH}" || exit 0
         fi
         stop
         ;;
@@ -196,13 +403,13 @@ case "$1" in
         if [ -n "${IS_REDHAT}" ]
         then
             echo -n $"Reloading $PROGRAM_NAME: "
-            killproc -p $PID_FILE_PATH $PROGRAM_PATH -HUP
+            killproc -p "${PID_FILE_PATH}" "${PROGRAM_PATH}" -HUP
             r=$?
             echo
             exit $r
         else
             log_daemon_msg "Reloading ${SHORT_DESCRIPTION}" "${PROGRAM_NAME}" || true
-            if start-stop-daemon --signal HUP --pidfile ${PID_FILE_PATH} --stop; then
+            if start-stop-daemon --signal HUP --pidfile "${PID_FILE_PATH}" --stop; then
                 log_end_msg 0 || true
             else
                 log_end_msg 1 || true
@@ -217,10 +424,10 @@ case "$1" in
             start
             exit $?
         else
-            start-stop-daemon --status --pidfile ${PID_FILE_PATH} && exit 0
+            start-stop-daemon --status --pidfile "${PID_FILE_PATH}" && exit 0
             log_daemon_msg "Restarting ${SHORT_DESCRIPTION}" "${PROGRAM_NAME}" || true
             r=0
-            start-stop-daemon --stop --quiet --retry 30 --pidfile ${PID_FILE_PATH} || r="$?"
+            start-stop-daemon --stop --quiet --retry 30 --pidfile "${PID_FILE_PATH}" || r="$?"
             case $r in
                 0)
                 # old daemon stopped
@@ -251,7 +458,7 @@ case "$1" in
             rh_status
             exit $?
         else
-            status_of_proc -p ${PID_FILE_PATH} "${PROGRAM_PATH}" "${PROGRAM_NAME}" && exit 0 || exit $?
+            status_of_proc -p "${PID_FILE_PATH}" "${PROGRAM_PATH}" "${PROGRAM_NAME}" && exit 0 || exit $?
         fi
         ;;
     *)
@@ -265,16 +472,6 @@ case "$1" in
 esac
 exit $?
 `
-
-	serviceNamePlaceholder      = placeholderDelim + "NAME" + placeholderDelim
-	shortDescriptionPlaceholder = placeholderDelim + "SHORT_DESCRIPTION" + placeholderDelim
-	descriptionPlaceholder      = placeholderDelim + "DESCRIPTION" + placeholderDelim
-	exePathPlaceholder          = placeholderDelim + "EXE_PATH" + placeholderDelim
-	argumentsPlaceholder        = placeholderDelim + "ARGUMENTS" + placeholderDelim
-	logFilePathPlaceholder      = placeholderDelim + "LOG_FILE_PATH" + placeholderDelim
-	pidFilePathPlaceholder      = placeholderDelim + "PID_FILE_PATH" + placeholderDelim
-	runAsPlaceholder            = placeholderDelim + "RUN_AS" + placeholderDelim
-	placeholderDelim            = "^"
 )
 
 type systemvController struct {
@@ -287,6 +484,7 @@ type systemvController struct {
 	chkconfig    string
 	updatercd    string
 	logConfig    cyberdaemon.LogConfig
+	install      installSettings
 }
 
 func (o *systemvController) Status() (Status, error) {
@@ -313,7 +511,12 @@ func (o *systemvController) Status() (Status, error) {
 }
 
 func (o *systemvController) Install() error {
-	err := ioutil.WriteFile(o.initFilePath, []byte(o.initContents), 0755)
+	err := o.install.beforeInstall()
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(o.initFilePath, []byte(o.initContents), 0755)
 	if err != nil {
 		return fmt.Errorf("failed to write init.d script file - %s", err.Error())
 	}
@@ -359,7 +562,12 @@ func (o *systemvController) Uninstall() error {
 	// we can do.
 	o.Stop()
 
-	return os.Remove(o.initFilePath)
+	err := os.Remove(o.initFilePath)
+	if err != nil {
+		return err
+	}
+
+	return o.install.afterUninstall()
 }
 
 func (o *systemvController) Start() error {
@@ -380,12 +588,33 @@ func (o *systemvController) Stop() error {
 	return nil
 }
 
+func (o *systemvController) Debug() error {
+	command, err := systemvForegroundCommand(o.initFilePath)
+	if err != nil {
+		return err
+	}
+
+	return command.run()
+}
+
 func newSystemvController(config ControllerConfig, serviceExePath string, isRedHat bool) (*systemvController, error) {
 	err := config.Validate()
 	if err != nil {
 		return nil, err
 	}
 
+	if config.Scope == UserScope {
+		return nil, fmt.Errorf("the '%s' scope is not supported on System V", UserScope)
+	}
+
+	if len(config.ResourceLimits.MemoryMax) > 0 || config.ResourceLimits.CPUQuota > 0 {
+		return nil, fmt.Errorf("memory and CPU quota limits are not supported on System V")
+	}
+
+	if len(config.Dependencies.PartOf) > 0 || len(config.Dependencies.BindsTo) > 0 {
+		return nil, fmt.Errorf("PartOf and BindsTo dependencies are not supported on System V")
+	}
+
 	var logFilePath string
 
 	if config.LogConfig.UseNativeLogger {
@@ -395,18 +624,9 @@ func newSystemvController(config ControllerConfig, serviceExePath string, isRedH
 		logFilePath = path.Join("/var/log", config.DaemonID, config.DaemonID+ ".log")
 	}
 
-	replacer := strings.NewReplacer(serviceNamePlaceholder, config.DaemonID,
-		shortDescriptionPlaceholder, fmt.Sprintf("%s daemon.", config.DaemonID),
-		descriptionPlaceholder, config.Description,
-		exePathPlaceholder, config.ExePath,
-		argumentsPlaceholder, config.argumentsAsString(),
-		runAsPlaceholder, config.RunAs,
-		logFilePathPlaceholder, logFilePath,
-		pidFilePathPlaceholder, defaultPidFilePath(config.DaemonID))
-
-	script := replacer.Replace(systemvTemplate)
-	if strings.Contains(script, placeholderDelim) {
-		return nil, fmt.Errorf("failed to replace all placeholders in daemon init.d script")
+	script, err := renderSystemvScript(config, logFilePath)
+	if err != nil {
+		return nil, err
 	}
 
 	var enableCliToolPath string
@@ -429,6 +649,7 @@ func newSystemvController(config ControllerConfig, serviceExePath string, isRedH
 		isRedHat:     isRedHat,
 		chkconfig:    enableCliToolPath,
 		updatercd:    enableCliToolPath,
+		install:      newInstallSettings(config),
 	}, nil
 }
 
@@ -0,0 +1,311 @@
+package control
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestDefaultSystemVTemplateSuCommand(t *testing.T) {
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	exeDirPath := path.Join(tempDirPath, "my app $(touch injected)")
+	err = os.Mkdir(exeDirPath, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The executable writes its arguments to the file in the 'OUTPUT'
+	// environment variable, separated by null characters.
+	exePath := path.Join(exeDirPath, "app;x")
+	err = ioutil.WriteFile(exePath, []byte("#!/bin/sh\nprintf '%s\\0' \"$@\" > \"${OUTPUT}\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arguments := []string{
+		"a b",
+		"$(id)",
+		"c;d",
+		"`touch injected`",
+		"'single' \"double\"",
+		"back\\slash",
+		"glob*",
+		"tab\tseparated",
+		"> redirect",
+		"",
+		"-",
+		"%s %q",
+	}
+
+	config := ControllerConfig{
+		DaemonID:  "cyberdaemon-test",
+		ExePath:   exePath,
+		Arguments: arguments,
+		RunAs:     "nobody",
+		Umask:     "0027",
+	}
+
+	logFilePath := path.Join(tempDirPath, "log dir", "$(touch injected).log")
+	err = os.Mkdir(path.Dir(logFilePath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := renderSystemvScript(config, logFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suCommand, err := shellFunction(script, "su_command")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Emulate the shell that 'su' starts by running the command with
+	// a new bash process.
+	harness := bytes.NewBuffer(nil)
+	for _, line := range strings.Split(script, "\n") {
+		for _, name := range []string{"PROGRAM_PATH=", "ARGUMENTS=", "UMASK="} {
+			if strings.HasPrefix(line, name) {
+				harness.WriteString(line + "\n")
+			}
+		}
+	}
+	harness.WriteString(suCommand + "\n")
+	harness.WriteString("su_command \"$1\"\n")
+	harness.WriteString("exec bash -c \"${command}\"\n")
+
+	outputPath := path.Join(tempDirPath, "output")
+	cmd := exec.Command(bashPath, "-c", harness.String(), "harness", logFilePath)
+	cmd.Dir = tempDirPath
+	cmd.Env = append(os.Environ(), "OUTPUT="+outputPath)
+	combined, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to run su command - %s - output: %s", err.Error(), combined)
+	}
+
+	raw, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := strings.Split(strings.TrimSuffix(string(raw), "\x00"), "\x00")
+	if !reflect.DeepEqual(actual, arguments) {
+		t.Fatalf("expected arguments %q - got %q", arguments, actual)
+	}
+
+	_, err = os.Stat(logFilePath)
+	if err != nil {
+		t.Fatalf("log file was not created - %s", err.Error())
+	}
+
+	_, err = os.Stat(path.Join(tempDirPath, "injected"))
+	if err == nil {
+		t.Fatal("a command in the arguments was executed")
+	}
+}
+
+func TestDefaultSystemVTemplateCreateDirectories(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of directories requires root")
+	}
+
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	runAs, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("the 'nobody' user does not exist")
+	}
+
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	// A file that already exists in the log directory must keep its
+	// owner.
+	logDirPath := path.Join(tempDirPath, "log")
+	err = os.Mkdir(logDirPath, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existingFilePath := path.Join(logDirPath, "existing")
+	err = ioutil.WriteFile(existingFilePath, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := ControllerConfig{
+		DaemonID: "cyberdaemon-test",
+		ExePath:  "/usr/bin/app",
+		RunAs:    runAs.Username,
+	}
+
+	script, err := renderSystemvScript(config, path.Join(logDirPath, "app.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createDirectories, err := shellFunction(script, "create_directories")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	harness := bytes.NewBuffer(nil)
+	for _, line := range strings.Split(script, "\n") {
+		for _, name := range []string{"RUN_AS=", "GROUP=", "DIRECTORIES=", "DIRECTORY_MODE="} {
+			if strings.HasPrefix(line, name) {
+				harness.WriteString(line + "\n")
+			}
+		}
+	}
+	harness.WriteString(createDirectories + "\n")
+	harness.WriteString("create_directories \"$1\"\n")
+
+	output, err := exec.Command(bashPath, "-c", harness.String(), "harness", logDirPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to create directories - %s - output: %s", err.Error(), output)
+	}
+
+	info, err := os.Stat(logDirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Fatalf("expected log directory mode 0700 - got %04o", info.Mode().Perm())
+	}
+	if uid := strconv.Itoa(int(info.Sys().(*syscall.Stat_t).Uid)); uid != runAs.Uid {
+		t.Fatalf("expected log directory to be owned by uid %s - got %s", runAs.Uid, uid)
+	}
+
+	info, err = os.Stat(existingFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := info.Sys().(*syscall.Stat_t).Uid; uid != 0 {
+		t.Fatalf("expected the owner of the existing file to be unchanged - got uid %d", uid)
+	}
+}
+
+func TestRenderSystemvScriptCustomTemplate(t *testing.T) {
+	config := ControllerConfig{
+		DaemonID: "cyberdaemon-test",
+		ExePath:  "/usr/bin/app",
+		SystemSpecificOptions: map[SystemSpecificOption]interface{}{
+			SystemVTemplateOption: `{{define "pre-start"}}
+    mkdir -p /var/lib/{{.Name}}{{end}}`,
+			SystemVTemplateDataOption: EditSystemVTemplateData(func(data *SystemVTemplateData) error {
+				data.RequiredStart = append(data.RequiredStart, "$network")
+				data.DefaultStart = []string{"3", "5"}
+				return nil
+			}),
+		},
+	}
+
+	script, err := renderSystemvScript(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"\n# Required-Start: $local_fs $syslog $network\n",
+		"\n# Default-Start: 3 5\n",
+		"\n    mkdir -p /var/lib/cyberdaemon-test\n",
+		"\nPROGRAM_PATH=",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Fatalf("expected script to contain %q - script:\n%s", expected, script)
+		}
+	}
+
+	if bashPath, err := exec.LookPath("bash"); err == nil {
+		output, err := exec.Command(bashPath, "-n", "-c", script).CombinedOutput()
+		if err != nil {
+			t.Fatalf("script is not valid bash - %s - output: %s", err.Error(), output)
+		}
+	}
+
+	// A template that does not redefine a block replaces the built-in
+	// template entirely.
+	config.SystemSpecificOptions = map[SystemSpecificOption]interface{}{
+		SystemVTemplateOption: "#!/bin/sh\nPROGRAM_PATH={{shellQuote .ExePath}}\n",
+	}
+
+	script, err = renderSystemvScript(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "#!/bin/sh\nPROGRAM_PATH='/usr/bin/app'\n"; script != expected {
+		t.Fatalf("expected script %q - got %q", expected, script)
+	}
+}
+
+func TestRenderSystemvScriptCustomTemplateErrors(t *testing.T) {
+	tests := []map[SystemSpecificOption]interface{}{
+		{SystemVTemplateOption: 1},
+		{SystemVTemplateOption: "{{.Name"},
+		{SystemVTemplateOption: "{{.Unknown}}"},
+		{SystemVTemplateDataOption: "not a function"},
+		{SystemVTemplateDataOption: EditSystemVTemplateData(func(data *SystemVTemplateData) error {
+			return fmt.Errorf("failed")
+		})},
+		{SystemVTemplateDataOption: EditSystemVTemplateData(func(data *SystemVTemplateData) error {
+			data.DefaultStart = []string{"3\n# Injected: header"}
+			return nil
+		})},
+	}
+
+	for i, options := range tests {
+		config := ControllerConfig{
+			DaemonID:              "cyberdaemon-test",
+			ExePath:               "/usr/bin/app",
+			SystemSpecificOptions: options,
+		}
+
+		_, err := renderSystemvScript(config, "")
+		if err == nil {
+			t.Fatalf("expected an error for options %d", i)
+		}
+	}
+}
+
+// shellFunction returns the definition of the specified shell function
+// in a script. The function's closing brace must be at the start of
+// a line.
+func shellFunction(script string, name string) (string, error) {
+	start := strings.Index(script, "\n"+name+"() {\n")
+	if start < 0 {
+		return "", fmt.Errorf("function '%s' was not found", name)
+	}
+
+	end := strings.Index(script[start+1:], "\n}\n")
+	if end < 0 {
+		return "", fmt.Errorf("function '%s' is not terminated", name)
+	}
+
+	return script[start+1 : start+1+end+2], nil
+}
@@ -198,6 +198,46 @@ func NewController(controllerConfig ControllerConfig) (Controller, error) {
 		return nil, err
 	}
 
+	if controllerConfig.scope() == UserScope {
+		return nil, fmt.Errorf("the '%s' scope is not supported on Windows", UserScope)
+	}
+
+	if len(controllerConfig.Environment) > 0 || len(controllerConfig.EnvironmentFiles) > 0 {
+		return nil, fmt.Errorf("environment variables are not supported on Windows")
+	}
+
+	if len(controllerConfig.Group) > 0 || len(controllerConfig.SupplementaryGroups) > 0 {
+		return nil, fmt.Errorf("groups are not supported on Windows")
+	}
+
+	if len(controllerConfig.WorkDirPath) > 0 || len(controllerConfig.Umask) > 0 {
+		return nil, fmt.Errorf("setting the working directory or umask is not supported on Windows")
+	}
+
+	if len(controllerConfig.Capabilities) > 0 {
+		return nil, fmt.Errorf("capabilities are not supported on Windows")
+	}
+
+	if controllerConfig.CreateUser || controllerConfig.Directories.isSet() {
+		return nil, fmt.Errorf("creating users and directories is not supported on Windows")
+	}
+
+	if controllerConfig.ResourceLimits.isSet() {
+		return nil, fmt.Errorf("resource limits are not supported on Windows")
+	}
+
+	if controllerConfig.Dependencies.isSet() {
+		return nil, fmt.Errorf("dependencies are not supported on Windows")
+	}
+
+	if controllerConfig.RestartPolicy.IsSet() {
+		return nil, fmt.Errorf("restart policies are not supported on Windows")
+	}
+
+	if isMultiInstanceID(controllerConfig.DaemonID) {
+		return nil, fmt.Errorf("multi-instance daemons are not supported on Windows")
+	}
+
 	var winStartType uint32
 	switch controllerConfig.StartType {
 	case StartImmediately, StartOnLoad:
@@ -272,3 +312,9 @@ func defaultServiceStopTimeout() time.Duration {
 
 	return time.Millisecond * time.Duration(v)
 }
+
+// systemSpecificCommands returns the control commands that are only
+// supported on Windows.
+func systemSpecificCommands() []Command {
+	return nil
+}
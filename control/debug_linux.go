@@ -0,0 +1,861 @@
+package control
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/stephen-fox/cyberdaemon"
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+const (
+	systemdDefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	systemvDefaultPath = "/sbin:/usr/sbin:/bin:/usr/bin"
+)
+
+// foregroundCommand describes how the operating system starts a daemon's
+// process. It is used to run an installed daemon in the foreground.
+type foregroundCommand struct {
+	exePath             string
+	args                []string
+	runAs               string
+	group               string
+	supplementaryGroups []string
+	capabilities        []string
+	umask               string
+	env                 *environment
+	workDirPath         string
+}
+
+// run runs the command in the foreground and blocks until it exits.
+func (o foregroundCommand) run() error {
+	daemon, err := o.command()
+	if err != nil {
+		return err
+	}
+	// Daemons are not attached to a terminal's input. Leaving stdin
+	// unset connects it to the null device.
+	daemon.Stdout = os.Stdout
+	daemon.Stderr = os.Stderr
+	// Place the daemon in its own process group so that signals
+	// generated by the terminal are only delivered once (by us).
+	daemon.SysProcAttr.Setpgid = true
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	err = o.start(daemon)
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- daemon.Wait()
+	}()
+
+	for {
+		select {
+		case s := <-signals:
+			daemon.Process.Signal(s)
+		case err := <-exited:
+			if err != nil {
+				return fmt.Errorf("daemon process exited abnormally - %s", err.Error())
+			}
+			return nil
+		}
+	}
+}
+
+// command returns an unstarted command for the daemon's process. Its
+// input and output are not set. The command must be started using start.
+func (o foregroundCommand) command() (*exec.Cmd, error) {
+	daemon := exec.Command(o.exePath, o.args...)
+	daemon.Env = o.env.list()
+	daemon.Dir = o.workDirPath
+	daemon.SysProcAttr = &syscall.SysProcAttr{}
+
+	var err error
+	daemon.SysProcAttr.Credential, err = o.credential()
+	if err != nil {
+		return nil, err
+	}
+
+	// The capabilities are added to the daemon's ambient set. Unlike
+	// the operating system, the capability bounding set is not changed.
+	for _, capability := range o.capabilities {
+		number, ok := capabilityNumbers[capability]
+		if !ok {
+			return nil, fmt.Errorf("unknown capability '%s'", capability)
+		}
+		daemon.SysProcAttr.AmbientCaps = append(daemon.SysProcAttr.AmbientCaps, number)
+	}
+
+	return daemon, nil
+}
+
+// start starts the daemon's command with the daemon's umask.
+func (o foregroundCommand) start(daemon *exec.Cmd) error {
+	// The umask is inherited by the daemon's process, so it is
+	// changed only while the process is started.
+	if len(o.umask) > 0 {
+		mask, err := strconv.ParseUint(o.umask, 8, 32)
+		if err != nil {
+			return fmt.Errorf("failed to parse umask '%s' - %s", o.umask, err.Error())
+		}
+		defer syscall.Umask(syscall.Umask(int(mask)))
+	}
+
+	err := daemon.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start daemon process - %s", err.Error())
+	}
+
+	return nil
+}
+
+// credential returns the credential for the daemon's process, or nil
+// if the process should run with the current process' credential.
+func (o foregroundCommand) credential() (*syscall.Credential, error) {
+	var credential *syscall.Credential
+
+	if len(o.runAs) > 0 {
+		runAs, err := user.Lookup(o.runAs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup user '%s' - %s", o.runAs, err.Error())
+		}
+
+		if runAs.Uid != fmt.Sprintf("%d", os.Getuid()) {
+			credential, err = osutil.UserCredential(runAs)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(o.group) == 0 && len(o.supplementaryGroups) == 0 {
+		return credential, nil
+	}
+
+	if credential == nil {
+		groups, err := os.Getgroups()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get supplementary groups - %s", err.Error())
+		}
+
+		credential = &syscall.Credential{
+			Uid: uint32(os.Getuid()),
+			Gid: uint32(os.Getgid()),
+		}
+		for _, group := range groups {
+			credential.Groups = append(credential.Groups, uint32(group))
+		}
+	}
+
+	if len(o.group) > 0 {
+		gid, err := osutil.LookupGroupID(o.group)
+		if err != nil {
+			return nil, err
+		}
+		credential.Gid = gid
+	}
+
+	for _, groupName := range o.supplementaryGroups {
+		gid, err := osutil.LookupGroupID(groupName)
+		if err != nil {
+			return nil, err
+		}
+		credential.Groups = append(credential.Groups, gid)
+	}
+
+	return credential, nil
+}
+
+// environment is an ordered set of environment variables.
+type environment struct {
+	names  []string
+	values map[string]string
+}
+
+func (o *environment) set(name string, value string) {
+	if _, exists := o.values[name]; !exists {
+		o.names = append(o.names, name)
+	}
+
+	o.values[name] = value
+}
+
+// setAssignment sets a variable using a 'NAME=value' string.
+func (o *environment) setAssignment(assignment string) error {
+	i := strings.Index(assignment, "=")
+	if i < 1 {
+		return fmt.Errorf("invalid environment variable assignment '%s'", assignment)
+	}
+
+	o.set(assignment[:i], assignment[i+1:])
+
+	return nil
+}
+
+// setUser sets the variables that describe the user a daemon runs as.
+func (o *environment) setUser(u *user.User) {
+	o.set("HOME", u.HomeDir)
+	o.set("USER", u.Username)
+	o.set("LOGNAME", u.Username)
+}
+
+func (o *environment) list() []string {
+	var assignments []string
+
+	for _, name := range o.names {
+		assignments = append(assignments, name+"="+o.values[name])
+	}
+
+	return assignments
+}
+
+func newEnvironment(systemPath string) *environment {
+	env := &environment{
+		values: make(map[string]string),
+	}
+
+	env.set("PATH", systemPath)
+
+	if lang, ok := os.LookupEnv("LANG"); ok {
+		env.set("LANG", lang)
+	}
+
+	return env
+}
+
+// systemdForegroundCommand reconstructs the command that systemd runs for
+// an installed unit file. instanceName is the name of the instance to run
+// if the unit file is a template, or an empty string otherwise.
+// userUnitOwner is the name of the user that owns the unit if it is a user
+// unit, or an empty string for system units.
+func systemdForegroundCommand(unitFilePath string, instanceName string, userUnitOwner string) (foregroundCommand, error) {
+	f, err := os.Open(unitFilePath)
+	if err != nil {
+		return foregroundCommand{}, fmt.Errorf("failed to open unit file - %s", err.Error())
+	}
+	defer f.Close()
+
+	options, err := unit.Deserialize(f)
+	if err != nil {
+		return foregroundCommand{}, fmt.Errorf("failed to parse unit file - %s", err.Error())
+	}
+
+	// Instances of a template unit are named after the template
+	// (e.g., 'myapp@tenant1.service' for 'myapp@.service').
+	unitName := path.Base(unitFilePath)
+	if len(instanceName) > 0 {
+		unitName = strings.Replace(unitName, instanceSeparator+".", instanceSeparator+instanceName+".", 1)
+	}
+
+	// Settings in drop-ins override the unit file's settings. The
+	// drop-ins of a template unit also apply to its instances.
+	dropIns, err := readDropIns(unitFilePath)
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	if len(instanceName) > 0 {
+		instanceDropIns, err := readDropIns(path.Join(path.Dir(unitFilePath), unitName))
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+		dropIns = append(dropIns, instanceDropIns...)
+		sort.SliceStable(dropIns, func(i, j int) bool {
+			return dropIns[i].Name < dropIns[j].Name
+		})
+	}
+	for _, dropIn := range dropIns {
+		options = append(options, dropIn.Options...)
+	}
+
+	var execStart string
+	var runAs string
+	var group string
+	var supplementaryGroups []string
+	var capabilities []string
+	var umask string
+	var workDirPath string
+	var assignments []string
+	var envFilePaths []string
+
+	for _, option := range options {
+		if option.Section != "Service" {
+			continue
+		}
+
+		// An empty value resets list settings.
+		switch option.Name {
+		case "ExecStart":
+			execStart = option.Value
+		case "User":
+			runAs = option.Value
+		case "Group":
+			group = option.Value
+		case "SupplementaryGroups":
+			if len(option.Value) == 0 {
+				supplementaryGroups = nil
+				continue
+			}
+			supplementaryGroups = append(supplementaryGroups, strings.Fields(option.Value)...)
+		case "AmbientCapabilities":
+			if len(option.Value) == 0 {
+				capabilities = nil
+				continue
+			}
+			if strings.HasPrefix(option.Value, "~") {
+				return foregroundCommand{}, fmt.Errorf("inverted 'AmbientCapabilities' settings are not supported")
+			}
+			capabilities = append(capabilities, strings.Fields(option.Value)...)
+		case "UMask":
+			umask = option.Value
+		case "WorkingDirectory":
+			workDirPath = option.Value
+		case "Environment":
+			if len(option.Value) == 0 {
+				assignments = nil
+				continue
+			}
+			words, err := systemdWords(option.Value)
+			if err != nil {
+				return foregroundCommand{}, fmt.Errorf("failed to parse 'Environment' setting - %s",
+					err.Error())
+			}
+			assignments = append(assignments, words...)
+		case "EnvironmentFile":
+			if len(option.Value) == 0 {
+				envFilePaths = nil
+				continue
+			}
+			envFilePaths = append(envFilePaths, option.Value)
+		}
+	}
+
+	if len(execStart) == 0 {
+		return foregroundCommand{}, fmt.Errorf("unit file does not contain an 'ExecStart' setting")
+	}
+
+	// User units always run as their owner.
+	isUserUnit := len(userUnitOwner) > 0
+	if isUserUnit {
+		runAs = userUnitOwner
+	}
+
+	context, err := specifierContextForUser(unitName, runAs)
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	env := newEnvironment(systemdDefaultPath)
+	if len(runAs) > 0 || isUserUnit {
+		env.set("HOME", context.homePath)
+		env.set("USER", context.userName)
+		env.set("LOGNAME", context.userName)
+	}
+
+	// Variables set in environment files override variables set
+	// with the 'Environment' setting.
+	for _, assignment := range assignments {
+		expanded, err := expandSystemdSpecifiers(assignment, context)
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+		err = env.setAssignment(expanded)
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+	}
+
+	for i := range envFilePaths {
+		envFilePaths[i], err = expandSystemdSpecifiers(envFilePaths[i], context)
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+	}
+
+	err = loadEnvironmentFiles(envFilePaths, env)
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	switch {
+	case len(workDirPath) == 0 && isUserUnit:
+		workDirPath = context.homePath
+	case len(workDirPath) == 0:
+		workDirPath = "/"
+	default:
+		workDirPath, err = expandSystemdSpecifiers(workDirPath, context)
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+		workDirPath = strings.TrimPrefix(workDirPath, "-")
+		if workDirPath == "~" {
+			workDirPath = context.homePath
+		}
+	}
+
+	// Strip special executable prefixes (such as '-', which tells
+	// systemd to ignore the exit code).
+	execStart = strings.TrimLeft(execStart, "-:+!")
+	if strings.HasPrefix(execStart, "@") {
+		return foregroundCommand{}, fmt.Errorf("the '@' executable prefix is not supported")
+	}
+
+	execStart, err = expandSystemdSpecifiers(execStart, context)
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	words, err := systemdWords(execStart)
+	if err != nil {
+		return foregroundCommand{}, fmt.Errorf("failed to parse 'ExecStart' setting - %s", err.Error())
+	}
+
+	words = substituteSystemdVariables(words, env.values)
+	if len(words) == 0 {
+		return foregroundCommand{}, fmt.Errorf("the 'ExecStart' setting is empty")
+	}
+
+	group, err = expandSystemdSpecifiers(group, context)
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	for i := range supplementaryGroups {
+		supplementaryGroups[i], err = expandSystemdSpecifiers(supplementaryGroups[i], context)
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+	}
+
+	return foregroundCommand{
+		exePath:             words[0],
+		args:                words[1:],
+		runAs:               runAs,
+		group:               group,
+		supplementaryGroups: supplementaryGroups,
+		capabilities:        capabilities,
+		umask:               umask,
+		env:                 env,
+		workDirPath:         workDirPath,
+	}, nil
+}
+
+// loadEnvironmentFiles reads the specified environment files into the
+// provided environment in order (see readEnvironmentFile). A path that
+// is prefixed with '-' is ignored if the file does not exist.
+func loadEnvironmentFiles(filePaths []string, env *environment) error {
+	for _, filePath := range filePaths {
+		optional := strings.HasPrefix(filePath, "-")
+		filePath = strings.TrimPrefix(filePath, "-")
+
+		err := readEnvironmentFile(filePath, env)
+		if err != nil {
+			if optional && os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read environment file '%s' - %s", filePath, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// readEnvironmentFile reads 'NAME=value' assignments from a file into
+// the provided environment. Empty lines and comments are ignored.
+func readEnvironmentFile(filePath string, env *environment) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 1 {
+			return fmt.Errorf("invalid line '%s'", line)
+		}
+
+		words, err := osutil.ShellWords(line[i+1:])
+		if err != nil {
+			return err
+		}
+
+		// Shell scripts may export variables.
+		name := strings.TrimPrefix(strings.TrimSpace(line[:i]), "export ")
+		env.set(strings.TrimSpace(name), strings.Join(words, " "))
+	}
+
+	return scanner.Err()
+}
+
+// systemvForegroundCommand reconstructs the command that an init.d script
+// generated by a Controller runs.
+func systemvForegroundCommand(initFilePath string) (foregroundCommand, error) {
+	contents, err := ioutil.ReadFile(initFilePath)
+	if err != nil {
+		return foregroundCommand{}, fmt.Errorf("failed to read init.d script - %s", err.Error())
+	}
+	script := string(contents)
+
+	exePath, ok, err := shellVariableValue(script, "PROGRAM_PATH")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	if !ok || len(exePath) == 0 {
+		return foregroundCommand{}, fmt.Errorf("init.d script does not specify the program path")
+	}
+
+	arguments, _, err := shellArrayValue(script, "ARGUMENTS")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	runAs, _, err := shellVariableValue(script, "RUN_AS")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	if len(runAs) == 0 {
+		runAs = "root"
+	}
+
+	// The 'service' command runs init.d scripts with a mostly empty
+	// environment. 'su' additionally sets the user's variables.
+	env := newEnvironment(systemvDefaultPath)
+	if runAs != "root" {
+		u, err := user.Lookup(runAs)
+		if err != nil {
+			return foregroundCommand{}, fmt.Errorf("failed to lookup user '%s' - %s", runAs, err.Error())
+		}
+		env.setUser(u)
+	}
+
+	instanceName, _, err := shellVariableValue(script, "INSTANCE_NAME")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	if len(instanceName) > 0 {
+		env.set(cyberdaemon.InstanceEnv, instanceName)
+	}
+
+	assignments, _, err := shellArrayValue(script, "ENVIRONMENT")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	for _, assignment := range assignments {
+		err := env.setAssignment(assignment)
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+	}
+
+	// The init.d script sources environment files, which are
+	// approximated by parsing them as 'NAME=value' files.
+	envFilePaths, _, err := shellArrayValue(script, "ENVIRONMENT_FILES")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	err = loadEnvironmentFiles(envFilePaths, env)
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	workDirPath, _, err := shellVariableValue(script, "WORK_DIR_PATH")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	if len(workDirPath) == 0 {
+		workDirPath = "/"
+	}
+
+	umask, _, err := shellVariableValue(script, "UMASK")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	group, _, err := shellVariableValue(script, "GROUP")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	supplementaryGroups, _, err := shellArrayValue(script, "SUPPLEMENTARY_GROUPS")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	capabilities, _, err := shellArrayValue(script, "CAPABILITIES")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	return foregroundCommand{
+		exePath:             exePath,
+		args:                arguments,
+		runAs:               runAs,
+		group:               group,
+		supplementaryGroups: supplementaryGroups,
+		capabilities:        capabilities,
+		umask:               umask,
+		env:                 env,
+		workDirPath:         workDirPath,
+	}, nil
+}
+
+// openrcForegroundCommand reconstructs the command that an OpenRC service
+// script generated by a Controller runs.
+func openrcForegroundCommand(scriptFilePath string) (foregroundCommand, error) {
+	contents, err := ioutil.ReadFile(scriptFilePath)
+	if err != nil {
+		return foregroundCommand{}, fmt.Errorf("failed to read OpenRC service script - %s", err.Error())
+	}
+	script := string(contents)
+
+	exePath, ok, err := shellVariableValue(script, "command")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	if !ok || len(exePath) == 0 {
+		return foregroundCommand{}, fmt.Errorf("OpenRC service script does not specify the command")
+	}
+
+	arguments, err := shellWordsVariableValue(script, "command_args")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	commandUser, _, err := shellVariableValue(script, "command_user")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	runAs := "root"
+	var group string
+	if len(commandUser) > 0 {
+		parts := strings.SplitN(commandUser, ":", 2)
+		runAs = parts[0]
+		if len(parts) > 1 {
+			group = parts[1]
+		}
+	}
+
+	// OpenRC runs service scripts with a mostly empty environment. Its
+	// supervisors preserve the variables that identify the service, and
+	// set the user's variables.
+	env := newEnvironment(systemvDefaultPath)
+	env.set("RC_SVCNAME", path.Base(scriptFilePath))
+	env.set("RC_SERVICE", scriptFilePath)
+	if runAs != "root" {
+		u, err := user.Lookup(runAs)
+		if err != nil {
+			return foregroundCommand{}, fmt.Errorf("failed to lookup user '%s' - %s", runAs, err.Error())
+		}
+		env.setUser(u)
+	}
+
+	assignments, err := shellWordsVariableValue(script, "cyberdaemon_environment")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	for _, assignment := range assignments {
+		err := env.setAssignment(assignment)
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+	}
+
+	// The service script sources environment files, which are
+	// approximated by parsing them as 'NAME=value' files.
+	envFilePaths, err := shellWordsVariableValue(script, "cyberdaemon_environment_files")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	err = loadEnvironmentFiles(envFilePaths, env)
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	workDirPath, _, err := shellVariableValue(script, "directory")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	if len(workDirPath) == 0 {
+		workDirPath = "/"
+	}
+
+	umask, _, err := shellVariableValue(script, "umask")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	capabilities, _, err := shellVariableValue(script, "capabilities")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	return foregroundCommand{
+		exePath:      exePath,
+		args:         arguments,
+		runAs:        runAs,
+		group:        group,
+		capabilities: openrcGrantedCapabilities(capabilities),
+		umask:        umask,
+		env:          env,
+		workDirPath:  workDirPath,
+	}, nil
+}
+
+// supervisionForegroundCommand reconstructs the command that the 'run'
+// script of a runit or s6 service directory generated by a Controller
+// runs.
+func supervisionForegroundCommand(runScriptPath string) (foregroundCommand, error) {
+	contents, err := ioutil.ReadFile(runScriptPath)
+	if err != nil {
+		return foregroundCommand{}, fmt.Errorf("failed to read service directory's run script - %s", err.Error())
+	}
+	script := string(contents)
+
+	exePath, ok, err := shellVariableValue(script, "cyberdaemon_command")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	if !ok || len(exePath) == 0 {
+		return foregroundCommand{}, fmt.Errorf("service directory's run script does not specify the command")
+	}
+
+	arguments, err := shellWordsVariableValue(script, "cyberdaemon_arguments")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	runAs, _, err := shellVariableValue(script, "cyberdaemon_user")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	group, _, err := shellVariableValue(script, "cyberdaemon_group")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	supplementaryGroups, err := shellWordsVariableValue(script, "cyberdaemon_supplementary_groups")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	// The supervisor's environment is inherited by the daemon. chpst
+	// and s6-setuidgid do not set the user's variables.
+	env := newEnvironment(systemvDefaultPath)
+
+	assignments, err := shellWordsVariableValue(script, "cyberdaemon_environment")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	for _, assignment := range assignments {
+		err := env.setAssignment(assignment)
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+	}
+
+	// The run script sources environment files, which are
+	// approximated by parsing them as 'NAME=value' files.
+	envFilePaths, err := shellWordsVariableValue(script, "cyberdaemon_environment_files")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	err = loadEnvironmentFiles(envFilePaths, env)
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	workDirPath, _, err := shellVariableValue(script, "cyberdaemon_work_dir")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	if len(workDirPath) == 0 {
+		workDirPath = "/"
+	}
+
+	umask, _, err := shellVariableValue(script, "cyberdaemon_umask")
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	return foregroundCommand{
+		exePath:             exePath,
+		args:                arguments,
+		runAs:               runAs,
+		group:               group,
+		supplementaryGroups: supplementaryGroups,
+		umask:               umask,
+		env:                 env,
+		workDirPath:         workDirPath,
+	}, nil
+}
+
+// supervisordForegroundCommand reconstructs the command that supervisord
+// runs for a program configuration file generated by a Controller.
+func supervisordForegroundCommand(programFilePath string) (foregroundCommand, error) {
+	program, err := readSupervisordProgram(programFilePath)
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	words, err := program.command()
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+
+	// supervisord's environment is inherited by the program. It sets
+	// the variables that identify the program, but does not set the
+	// user's variables.
+	env := newEnvironment(systemvDefaultPath)
+	env.set(supervisordEnabledEnv, "1")
+	env.set("SUPERVISOR_PROCESS_NAME", program.name)
+	env.set("SUPERVISOR_GROUP_NAME", program.name)
+
+	assignments, err := program.environment()
+	if err != nil {
+		return foregroundCommand{}, err
+	}
+	for _, assignment := range assignments {
+		err := env.setAssignment(assignment)
+		if err != nil {
+			return foregroundCommand{}, err
+		}
+	}
+
+	workDirPath := program.settings["directory"]
+	if len(workDirPath) == 0 {
+		workDirPath = "/"
+	}
+
+	return foregroundCommand{
+		exePath:     words[0],
+		args:        words[1:],
+		runAs:       program.settings["user"],
+		umask:       program.settings["umask"],
+		env:         env,
+		workDirPath: workDirPath,
+	}, nil
+}
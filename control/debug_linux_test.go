@@ -0,0 +1,263 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnvironmentFile(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	filePath := path.Join(tempDirPath, "env")
+	err = ioutil.WriteFile(filePath, []byte(`# A comment.
+; Another comment.
+
+FOO=bar
+export QUOTED="a b"
+  SINGLE='it''s'
+EMPTY=
+FOO=replaced
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &environment{values: make(map[string]string)}
+	err = readEnvironmentFile(filePath, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"FOO=replaced", "QUOTED=a b", "SINGLE=its", "EMPTY="}
+	if !reflect.DeepEqual(env.list(), expected) {
+		t.Fatalf("expected %q - got %q", expected, env.list())
+	}
+
+	err = ioutil.WriteFile(filePath, []byte("NOT AN ASSIGNMENT\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = readEnvironmentFile(filePath, env)
+	if err == nil {
+		t.Fatal("expected an error for a line that is not an assignment")
+	}
+}
+
+func TestLoadEnvironmentFiles(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	firstPath := path.Join(tempDirPath, "first")
+	err = ioutil.WriteFile(firstPath, []byte("A=1\nB=1\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondPath := path.Join(tempDirPath, "second")
+	err = ioutil.WriteFile(secondPath, []byte("B=2\nC=2\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missingPath := path.Join(tempDirPath, "missing")
+
+	env := &environment{values: make(map[string]string)}
+	err = loadEnvironmentFiles([]string{firstPath, "-" + missingPath, "-" + secondPath}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"A=1", "B=2", "C=2"}
+	if !reflect.DeepEqual(env.list(), expected) {
+		t.Fatalf("expected %q - got %q", expected, env.list())
+	}
+
+	err = loadEnvironmentFiles([]string{firstPath, missingPath}, env)
+	if err == nil {
+		t.Fatal("expected an error for a required file that does not exist")
+	}
+}
+
+func TestSystemvForegroundCommand(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	envFilePath := path.Join(tempDirPath, "env")
+	err = ioutil.WriteFile(envFilePath, []byte("FROM_FILE=file\nOVERRIDDEN=file\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := ControllerConfig{
+		DaemonID:  "cyberdaemon-test",
+		ExePath:   "/opt/my app/app",
+		Arguments: []string{"a b", "$(id)", ""},
+		Environment: map[string]string{
+			"FROM_CONFIG": "config value",
+			"OVERRIDDEN":  "config",
+		},
+		EnvironmentFiles: []string{envFilePath, "-" + path.Join(tempDirPath, "missing")},
+		WorkDirPath:      "/srv/my app",
+		Umask:            "0027",
+	}
+
+	script, err := renderSystemvScript(config, "/var/log/cyberdaemon-test.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initFilePath := path.Join(tempDirPath, config.DaemonID)
+	err = ioutil.WriteFile(initFilePath, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	command, err := systemvForegroundCommand(initFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertForegroundCommand(t, command, config, "root", map[string]string{
+		"FROM_CONFIG": "config value",
+		"FROM_FILE":   "file",
+		"OVERRIDDEN":  "file",
+	})
+}
+
+func TestSupervisionForegroundCommand(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	envFilePath := path.Join(tempDirPath, "env")
+	err = ioutil.WriteFile(envFilePath, []byte("FROM_FILE=file\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := ControllerConfig{
+		DaemonID:         "cyberdaemon-test",
+		ExePath:          "/opt/my app/app",
+		Arguments:        []string{"a b", "'single' \"double\"", ""},
+		Environment:      map[string]string{"FROM_CONFIG": "config value"},
+		EnvironmentFiles: []string{"-" + envFilePath},
+		WorkDirPath:      "/srv/my app",
+		Umask:            "0027",
+	}
+
+	for _, suite := range []supervisionSuite{runitSuite, s6Suite} {
+		files, err := renderSupervisionFiles(config, suite, "/etc/service")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runScriptPath := path.Join(tempDirPath, "run")
+		for _, file := range files {
+			if file.name == "run" {
+				err = ioutil.WriteFile(runScriptPath, []byte(file.contents), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		command, err := supervisionForegroundCommand(runScriptPath)
+		if err != nil {
+			t.Fatalf("%s: %s", suite, err.Error())
+		}
+
+		assertForegroundCommand(t, command, config, "", map[string]string{
+			"FROM_CONFIG": "config value",
+			"FROM_FILE":   "file",
+		})
+	}
+}
+
+func TestSupervisordForegroundCommand(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := ControllerConfig{
+		DaemonID:    "cyberdaemon-test",
+		ExePath:     "/opt/my app/app",
+		Arguments:   []string{"a b", "100%", "'single' \"double\""},
+		Environment: map[string]string{"FROM_CONFIG": "a,b \"c\" d"},
+		WorkDirPath: "/srv/my app",
+		Umask:       "0027",
+	}
+
+	program, err := renderSupervisordProgram(config, "/var/log/cyberdaemon-test.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	programFilePath := path.Join(tempDirPath, config.DaemonID+".conf")
+	err = ioutil.WriteFile(programFilePath, []byte(program), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	command, err := supervisordForegroundCommand(programFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertForegroundCommand(t, command, config, "", map[string]string{
+		"FROM_CONFIG":             "a,b \"c\" d",
+		"SUPERVISOR_PROCESS_NAME": config.DaemonID,
+	})
+}
+
+// assertForegroundCommand fails the test if the foreground command does
+// not run the configuration's executable, or if it is missing any of the
+// expected environment variables.
+func assertForegroundCommand(t *testing.T, command foregroundCommand, config ControllerConfig,
+	runAs string, env map[string]string) {
+	t.Helper()
+
+	if command.exePath != config.ExePath {
+		t.Fatalf("expected executable path %q - got %q", config.ExePath, command.exePath)
+	}
+
+	if !reflect.DeepEqual(command.args, config.Arguments) {
+		t.Fatalf("expected arguments %q - got %q", config.Arguments, command.args)
+	}
+
+	if command.runAs != runAs {
+		t.Fatalf("expected user %q - got %q", runAs, command.runAs)
+	}
+
+	if command.workDirPath != config.WorkDirPath {
+		t.Fatalf("expected working directory %q - got %q", config.WorkDirPath, command.workDirPath)
+	}
+
+	if command.umask != config.Umask {
+		t.Fatalf("expected umask %q - got %q", config.Umask, command.umask)
+	}
+
+	for name, expected := range env {
+		actual, ok := command.env.values[name]
+		if !ok || actual != expected {
+			t.Fatalf("expected %s to be %q - got %q", name, expected, actual)
+		}
+	}
+}
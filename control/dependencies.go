@@ -0,0 +1,126 @@
+package control
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dependencies configures a daemon's dependencies on other daemons that
+// are managed by a Controller. Each dependency is specified as the other
+// daemon's DaemonID. Use a GroupController to install, start, and stop
+// a group of daemons that depend on each other in the correct order.
+//
+// On systemd, the dependencies are mapped to the unit settings of the
+// same names (the daemon IDs are converted to '<daemon-id>.service' unit
+// names). On System V, they are mapped to LSB headers (Requires maps to
+// 'Required-Start' and 'Required-Stop', After maps to 'Should-Start' and
+// 'Should-Stop', and Before maps to 'X-Start-Before' and 'X-Stop-After').
+// On OpenRC, they are mapped to the 'need' (Requires), 'after', and
+// 'before' dependencies of the service script's 'depend' function. On
+// runit and s6, the service directory's 'run' script starts the daemons
+// in Requires (and exits if they fail to start). runit and s6 start
+// every daemon at the same time when the system boots, so After and
+// Before only affect a GroupController. On supervisord, After and Before
+// only affect a GroupController, and Requires is not supported.
+//
+// Dependencies are only supported on Linux.
+type Dependencies struct {
+	// After are daemons that must be started before this daemon
+	// (if they are being started at the same time). This only
+	// affects the order in which the daemons are started.
+	After []string
+
+	// Before are daemons that must be started after this daemon
+	// (if they are being started at the same time).
+	Before []string
+
+	// Requires are daemons that are started with this daemon. This
+	// daemon is not started if they fail to start. Unlike systemd's
+	// setting of the same name, this implies After.
+	Requires []string
+
+	// PartOf are daemons that stop (and restart) this daemon when
+	// they are stopped (or restarted). This is only supported on
+	// systemd.
+	PartOf []string
+
+	// BindsTo are daemons that are required by this daemon (see
+	// Requires). In addition, this daemon is stopped when they stop.
+	// This implies After, and is only supported on systemd.
+	BindsTo []string
+}
+
+// Validate returns a non-nil error if the dependencies are invalid.
+func (o Dependencies) Validate() error {
+	for _, id := range o.daemonIDs() {
+		if len(id) == 0 || strings.ContainsAny(id, " \t\r\n\x00%$") {
+			return fmt.Errorf("dependency daemon ID '%s' must be non-empty, and may not contain whitespace, '%%', or '$' characters",
+				id)
+		}
+
+		if isMultiInstanceID(id) {
+			return fmt.Errorf("dependency '%s' is a multi-instance daemon - depend on one of its instances instead", id)
+		}
+	}
+
+	return nil
+}
+
+// isSet returns true if any dependencies are specified.
+func (o Dependencies) isSet() bool {
+	return len(o.daemonIDs()) > 0
+}
+
+// daemonIDs returns the IDs of all of the dependencies.
+func (o Dependencies) daemonIDs() []string {
+	var ids []string
+	ids = append(ids, o.After...)
+	ids = append(ids, o.Before...)
+	ids = append(ids, o.Requires...)
+	ids = append(ids, o.PartOf...)
+	ids = append(ids, o.BindsTo...)
+	return ids
+}
+
+// startAfter returns the daemons that must be started before this daemon
+// (including the daemons that are implied by Requires and BindsTo).
+func (o Dependencies) startAfter() []string {
+	return uniqueStrings(o.After, o.Requires, o.BindsTo)
+}
+
+// explicitAfter returns the daemons in After that are not implied by
+// Requires or BindsTo.
+func (o Dependencies) explicitAfter() []string {
+	implied := make(map[string]bool)
+	for _, id := range uniqueStrings(o.Requires, o.BindsTo) {
+		implied[id] = true
+	}
+
+	var after []string
+	for _, id := range uniqueStrings(o.After) {
+		if !implied[id] {
+			after = append(after, id)
+		}
+	}
+
+	return after
+}
+
+// uniqueStrings returns the strings in the lists without duplicates,
+// preserving their order.
+func uniqueStrings(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+
+	for _, list := range lists {
+		for _, s := range list {
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+
+	return unique
+}
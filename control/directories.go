@@ -0,0 +1,150 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Directories configures directories that are created for a daemon when
+// it starts. The directories are owned by the RunAs user and the Group
+// (or the RunAs user's primary group). Each directory is specified as a
+// relative path (e.g., "myapp") that is created in the corresponding base
+// directory.
+//
+// On systemd, the directories are mapped to the 'RuntimeDirectory',
+// 'StateDirectory', 'CacheDirectory', and 'LogsDirectory' settings.
+// systemd removes runtime directories when the daemon stops. On System V
+// and OpenRC, the init.d script creates the directories each time the
+// daemon starts. On runit and s6, the service directory's 'run' script
+// creates them.
+//
+// Directories are only supported by system daemons on Linux, and are
+// not supported on supervisord.
+type Directories struct {
+	// Runtime directories are created in '/run'.
+	Runtime []string
+
+	// State directories are created in '/var/lib'.
+	State []string
+
+	// Cache directories are created in '/var/cache'.
+	Cache []string
+
+	// Logs directories are created in '/var/log'.
+	Logs []string
+
+	// Mode is the file mode of the directories. If left unset,
+	// a mode of 0755 is used.
+	Mode os.FileMode
+
+	// RemoveOnUninstall specifies whether the directories (and their
+	// contents) are removed when the daemon is uninstalled.
+	RemoveOnUninstall bool
+}
+
+// Validate returns a non-nil error if the directories are invalid.
+func (o Directories) Validate() error {
+	for _, name := range o.names() {
+		// Names must be clean, so a name that refers to the base
+		// directory or its parent is '.', '..', or starts with '../'.
+		if len(name) == 0 || path.IsAbs(name) || path.Clean(name) != name ||
+			name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("directory '%s' must be a relative path inside its base directory", name)
+		}
+
+		if strings.ContainsAny(name, " \t\r\n\x00%") {
+			return fmt.Errorf("directory '%s' may not contain whitespace or '%%' characters", name)
+		}
+	}
+
+	if o.Mode&^os.ModePerm != 0 {
+		return fmt.Errorf("directory mode %o may only contain permission bits", o.Mode)
+	}
+
+	return nil
+}
+
+// isSet returns true if any directories are specified.
+func (o Directories) isSet() bool {
+	return len(o.names()) > 0
+}
+
+func (o Directories) names() []string {
+	var names []string
+	names = append(names, o.Runtime...)
+	names = append(names, o.State...)
+	names = append(names, o.Cache...)
+	names = append(names, o.Logs...)
+	return names
+}
+
+// paths returns the absolute paths of the directories.
+func (o Directories) paths() []string {
+	var paths []string
+
+	for _, kind := range o.kinds() {
+		for _, name := range kind.names {
+			paths = append(paths, path.Join(kind.baseDirPath, name))
+		}
+	}
+
+	return paths
+}
+
+// mode returns the directories' file mode.
+func (o Directories) mode() os.FileMode {
+	if o.Mode == 0 {
+		return 0755
+	}
+
+	return o.Mode
+}
+
+// directoryKind describes a kind of directory.
+type directoryKind struct {
+	// settingPrefix is the prefix of the kind's systemd settings
+	// (e.g., 'Runtime' for 'RuntimeDirectory').
+	settingPrefix string
+	baseDirPath   string
+	names         []string
+}
+
+func (o Directories) kinds() []directoryKind {
+	return []directoryKind{
+		{settingPrefix: "Runtime", baseDirPath: "/run", names: o.Runtime},
+		{settingPrefix: "State", baseDirPath: "/var/lib", names: o.State},
+		{settingPrefix: "Cache", baseDirPath: "/var/cache", names: o.Cache},
+		{settingPrefix: "Logs", baseDirPath: "/var/log", names: o.Logs},
+	}
+}
+
+// namesOfKind returns a pointer to the directory names of the kind with
+// the specified systemd setting prefix (e.g., 'Runtime').
+func (o *Directories) namesOfKind(settingPrefix string) *[]string {
+	switch settingPrefix {
+	case "Runtime":
+		return &o.Runtime
+	case "State":
+		return &o.State
+	case "Cache":
+		return &o.Cache
+	default:
+		return &o.Logs
+	}
+}
+
+// addPath adds an absolute directory path to the corresponding kind of
+// directory. It returns false if the path is not in a base directory.
+func (o *Directories) addPath(dirPath string) bool {
+	for _, kind := range o.kinds() {
+		if strings.HasPrefix(dirPath, kind.baseDirPath+"/") {
+			names := o.namesOfKind(kind.settingPrefix)
+			*names = append(*names, strings.TrimPrefix(dirPath, kind.baseDirPath+"/"))
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,23 @@
+package control
+
+import (
+	"testing"
+)
+
+func TestDirectoriesValidate(t *testing.T) {
+	invalid := []string{"", ".", "..", "../myapp", "myapp/../..", "/myapp", "./myapp", "myapp/", "my app", "100%"}
+	for _, name := range invalid {
+		err := Directories{State: []string{name}}.Validate()
+		if err == nil {
+			t.Fatalf("expected an error for directory '%s'", name)
+		}
+	}
+
+	valid := []string{"myapp", "myapp/cache", "..cache", "myapp..", "myapp/..cache"}
+	for _, name := range valid {
+		err := Directories{State: []string{name}}.Validate()
+		if err != nil {
+			t.Fatalf("expected directory '%s' to be valid - %s", name, err.Error())
+		}
+	}
+}
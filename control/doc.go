@@ -9,4 +9,27 @@
 // A Controller is configured using the ControllerConfig struct. This struct
 // provides the necessary information about a daemon (such as its ID).
 // It also provides customization options, such as the start up type.
+//
+// On runit and s6 machines (such as containers that run 'runsvdir' or
+// 's6-svscan' as PID 1), a daemon is installed as a service directory
+// in '/etc/sv' or '/etc/s6/sv' (respectively), which is linked into the
+// supervisor's scan directory. The service directory contains a 'run'
+// script, a 'log/run' script if the native logger is used, a 'finish'
+// script if a restart policy is set, and a 'down' file if the daemon
+// must be started manually.
+//
+// On machines that run supervisord as PID 1 (or when the SupervisordOption
+// is specified), a daemon is installed as a program section in
+// '/etc/supervisor/conf.d/<daemon-id>.conf'. The program is added to
+// supervisord and controlled using 'supervisorctl'.
+//
+// On Linux systems that provide no daemon manager (i.e., systemd, OpenRC,
+// runit, s6, or System V), daemons are run by a built-in supervisor. The
+// daemon's configuration is stored as a JSON file in '/etc/cyberdaemon',
+// and the supervisor's PID file and control socket are stored in
+// '/run/cyberdaemon/<daemon-id>'. Daemons that are run this way are not
+// started at boot.
+//
+// A GroupController controls several daemons that depend on each other
+// (see Dependencies), and installs, starts, and stops them in order.
 package control
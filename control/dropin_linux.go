@@ -0,0 +1,183 @@
+package control
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+const (
+	dropInFileSuffix = ".conf"
+)
+
+var (
+	dropInNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+)
+
+// DropIn is a systemd drop-in file. Drop-ins are unit file fragments that
+// are stored in the '<unit-file>.d' directory. Their settings are applied
+// on top of the unit file in lexical order of their file names.
+type DropIn struct {
+	// Name is the drop-in's file name without the '.conf' suffix
+	// (e.g., '50-memory').
+	Name string
+
+	// FilePath is the path to the drop-in file.
+	FilePath string
+
+	// Options are the unit file settings stored in the drop-in.
+	Options []*unit.UnitOption
+}
+
+// DropInManager is implemented by Controllers that support systemd drop-in
+// files. Drop-ins make it possible to change individual settings of an
+// installed daemon (for example, a memory limit or an environment variable)
+// without regenerating its unit file. systemd is reloaded after a drop-in
+// is written or removed. The daemon must be restarted for the changes to
+// take effect. Drop-ins are not removed when the daemon is uninstalled,
+// which means they are preserved if the daemon is reinstalled.
+//
+// The following example sets a memory limit for an installed daemon:
+//
+//	dropIns, ok := controller.(control.DropInManager)
+//	if !ok {
+//		return fmt.Errorf("drop-ins are not supported on this system")
+//	}
+//
+//	err := dropIns.WriteDropIn("50-memory", []*unit.UnitOption{
+//		unit.NewUnitOption("Service", "MemoryMax", "512M"),
+//	})
+type DropInManager interface {
+	// WriteDropIn creates or replaces the specified drop-in.
+	WriteDropIn(name string, options []*unit.UnitOption) error
+
+	// DropIns returns the daemon's drop-ins in the order that
+	// systemd applies them.
+	DropIns() ([]DropIn, error)
+
+	// RemoveDropIn removes the specified drop-in. It is not an error
+	// to remove a drop-in that does not exist.
+	RemoveDropIn(name string) error
+}
+
+func (o *systemdController) WriteDropIn(name string, options []*unit.UnitOption) error {
+	filePath, err := o.dropInFilePath(name)
+	if err != nil {
+		return err
+	}
+
+	contents, err := ioutil.ReadAll(unit.Serialize(options))
+	if err != nil {
+		return fmt.Errorf("failed to read from unit reader - %s", err.Error())
+	}
+
+	dirPath := path.Dir(filePath)
+	if o.userScope != nil {
+		err = o.userScope.prepareUnitDir(dirPath)
+	} else {
+		err = os.MkdirAll(dirPath, 0755)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create drop-in directory - %s", err.Error())
+	}
+
+	err = ioutil.WriteFile(filePath, contents, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write drop-in file - %s", err.Error())
+	}
+
+	if o.userScope != nil {
+		err := o.userScope.chown(filePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, _, err = o.systemctl(daemonReloadCommand)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *systemdController) DropIns() ([]DropIn, error) {
+	return readDropIns(o.unitFilePath)
+}
+
+func (o *systemdController) RemoveDropIn(name string) error {
+	filePath, err := o.dropInFilePath(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to remove drop-in file - %s", err.Error())
+	}
+
+	// Remove the directory if it is empty. The error is
+	// ignored because the directory may contain other
+	// drop-ins.
+	os.Remove(path.Dir(filePath))
+
+	_, _, err = o.systemctl(daemonReloadCommand)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *systemdController) dropInFilePath(name string) (string, error) {
+	name = strings.TrimSuffix(name, dropInFileSuffix)
+	if !dropInNameRegex.MatchString(name) {
+		return "", fmt.Errorf("drop-in name '%s' may only contain letters, numbers, '_', '.', '@', and '-'", name)
+	}
+
+	return path.Join(o.unitFilePath+".d", name+dropInFileSuffix), nil
+}
+
+// readDropIns reads the drop-ins for a unit file. The drop-ins are sorted
+// in the order that systemd applies them.
+func readDropIns(unitFilePath string) ([]DropIn, error) {
+	filePaths, err := filepath.Glob(path.Join(unitFilePath+".d", "*"+dropInFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for drop-in files - %s", err.Error())
+	}
+
+	sort.Strings(filePaths)
+
+	var dropIns []DropIn
+
+	for _, filePath := range filePaths {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open drop-in file - %s", err.Error())
+		}
+
+		options, err := unit.Deserialize(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse drop-in file '%s' - %s", filePath, err.Error())
+		}
+
+		dropIns = append(dropIns, DropIn{
+			Name:     strings.TrimSuffix(path.Base(filePath), dropInFileSuffix),
+			FilePath: filePath,
+			Options:  options,
+		})
+	}
+
+	return dropIns, nil
+}
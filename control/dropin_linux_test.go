@@ -0,0 +1,166 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+func TestSystemdControllerDropIns(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	// daemon-reload is a no-op.
+	controller := &systemdController{
+		systemctlPath: "/bin/true",
+		daemonID:      "cyberdaemon-test",
+		unitFilePath:  path.Join(tempDirPath, "cyberdaemon-test.service"),
+	}
+
+	dropIns, err := controller.DropIns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropIns) > 0 {
+		t.Fatalf("expected no drop-ins - got %d", len(dropIns))
+	}
+
+	err = controller.WriteDropIn("50-memory", []*unit.UnitOption{
+		unit.NewUnitOption("Service", "MemoryMax", "512M"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = controller.WriteDropIn("10-env.conf", []*unit.UnitOption{
+		unit.NewUnitOption("Service", "Environment", "FOO=bar"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", "../escape", "a/b", "a b"} {
+		err = controller.WriteDropIn(name, nil)
+		if err == nil {
+			t.Fatalf("expected an error for drop-in name '%s'", name)
+		}
+	}
+
+	dropIns, err = controller.DropIns()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, dropIn := range dropIns {
+		names = append(names, dropIn.Name)
+	}
+	if expected := []string{"10-env", "50-memory"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected drop-ins %q - got %q", expected, names)
+	}
+
+	if dropIns[1].FilePath != path.Join(controller.unitFilePath+".d", "50-memory.conf") {
+		t.Fatalf("unexpected drop-in file path '%s'", dropIns[1].FilePath)
+	}
+	if len(dropIns[1].Options) != 1 || dropIns[1].Options[0].Value != "512M" {
+		t.Fatalf("unexpected drop-in options %+v", dropIns[1].Options)
+	}
+
+	for _, name := range []string{"10-env", "50-memory", "50-memory"} {
+		err = controller.RemoveDropIn(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = os.Stat(controller.unitFilePath + ".d")
+	if !os.IsNotExist(err) {
+		t.Fatal("expected the empty drop-in directory to be removed")
+	}
+}
+
+func TestSystemdForegroundCommandDropIns(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := ControllerConfig{
+		DaemonID:    "cyberdaemon-test",
+		ExePath:     "/usr/bin/app",
+		Arguments:   []string{"-v"},
+		Environment: map[string]string{"FOO": "unit", "BAR": "unit"},
+		WorkDirPath: "/srv/app",
+	}
+
+	controller, err := newSystemdController(config, "/bin/true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller.unitFilePath = path.Join(tempDirPath, config.DaemonID+".service")
+
+	err = ioutil.WriteFile(controller.unitFilePath, controller.unitContents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop-ins are applied in lexical order. An empty 'ExecStart'
+	// resets the command.
+	err = controller.WriteDropIn("20-command", []*unit.UnitOption{
+		unit.NewUnitOption("Service", "ExecStart", ""),
+		unit.NewUnitOption("Service", "ExecStart", `/usr/bin/other "a b"`),
+		unit.NewUnitOption("Service", "Environment", "FOO=second"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = controller.WriteDropIn("10-env", []*unit.UnitOption{
+		unit.NewUnitOption("Service", "Environment", "FOO=first BAZ=first"),
+		unit.NewUnitOption("Service", "WorkingDirectory", "/srv/other"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	command, err := systemdForegroundCommand(controller.unitFilePath, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if command.exePath != "/usr/bin/other" || !reflect.DeepEqual(command.args, []string{"a b"}) {
+		t.Fatalf("expected the drop-in's command - got %q %q", command.exePath, command.args)
+	}
+
+	if command.workDirPath != "/srv/other" {
+		t.Fatalf("expected the drop-in's working directory - got '%s'", command.workDirPath)
+	}
+
+	for name, expected := range map[string]string{"FOO": "second", "BAR": "unit", "BAZ": "first"} {
+		if actual := command.env.values[name]; actual != expected {
+			t.Fatalf("expected %s to be '%s' - got '%s'", name, expected, actual)
+		}
+	}
+
+	result, err := ImportSystemdUnit(controller.unitFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The drop-ins are reported, but not merged into the imported
+	// configuration.
+	if !reflect.DeepEqual(result.Config.Environment, config.Environment) {
+		t.Fatalf("expected the unit's environment %v - got %v", config.Environment, result.Config.Environment)
+	}
+	if len(result.Unmapped) != 3 {
+		t.Fatalf("expected both drop-ins and the log settings to be unmapped - got %q", result.Unmapped)
+	}
+}
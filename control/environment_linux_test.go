@@ -0,0 +1,190 @@
+package control
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+// environmentTestValues are values that must be quoted in every
+// configuration file format.
+var environmentTestValues = map[string]string{
+	"SPACES":    "a b  c",
+	"QUOTES":    `it's "quoted"`,
+	"BACKSLASH": `back\slash \n`,
+	"SPECIFIER": "100% %n %%",
+	"VARIABLE":  "$HOME ${HOME} $$ `id` $(id)",
+	"CONTROL":   "tab\tnew\nline",
+	"EMPTY":     "",
+	"EQUALS":    "a=b",
+}
+
+func TestControllerConfigValidateEnvironment(t *testing.T) {
+	invalid := []ControllerConfig{
+		{Environment: map[string]string{"": "value"}},
+		{Environment: map[string]string{"1NAME": "value"}},
+		{Environment: map[string]string{"MY-NAME": "value"}},
+		{Environment: map[string]string{"MY NAME": "value"}},
+		{Environment: map[string]string{"NAME": "null\x00"}},
+		{EnvironmentFiles: []string{"relative/env"}},
+		{EnvironmentFiles: []string{"-relative/env"}},
+		{EnvironmentFiles: []string{"/etc/env\n"}},
+	}
+
+	for _, config := range invalid {
+		config.DaemonID = "cyberdaemon-test"
+		config.ExePath = "/usr/bin/app"
+
+		err := config.Validate()
+		if err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+
+	config := ControllerConfig{
+		DaemonID:         "cyberdaemon-test",
+		ExePath:          "/usr/bin/app",
+		Environment:      environmentTestValues,
+		EnvironmentFiles: []string{"/etc/default/app", "-/etc/app.env"},
+	}
+	err := config.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSystemdEnvironmentOptions(t *testing.T) {
+	options := systemdEnvironmentOptions(ControllerConfig{
+		DaemonID:         "cyberdaemon-test",
+		Environment:      environmentTestValues,
+		EnvironmentFiles: []string{"/etc/100%/env", "-/etc/app.env"},
+	})
+
+	expected := []string{
+		`Environment="BACKSLASH=back\\slash \\n"`,
+		`Environment="CONTROL=tab\tnew\nline"`,
+		`Environment="EMPTY="`,
+		`Environment="EQUALS=a=b"`,
+		`Environment="QUOTES=it's \"quoted\""`,
+		`Environment="SPACES=a b  c"`,
+		`Environment="SPECIFIER=100%% %%n %%%%"`,
+		"Environment=\"VARIABLE=$HOME ${HOME} $$ `id` $(id)\"",
+		`EnvironmentFile=/etc/100%%/env`,
+		`EnvironmentFile=-/etc/app.env`,
+	}
+
+	if len(options) != len(expected) {
+		t.Fatalf("expected %d options - got %d", len(expected), len(options))
+	}
+
+	for i, option := range options {
+		actual := option.Name + "=" + option.Value
+		if actual != expected[i] {
+			t.Fatalf("expected option '%s' - got '%s'", expected[i], actual)
+		}
+
+		if option.Name != "Environment" {
+			continue
+		}
+
+		// systemd resolves specifiers before splitting the
+		// value into assignments, and does not substitute
+		// variables.
+		unescaped, _ := unescapeSystemdSpecifiers(option.Value)
+		words, err := systemdWords(unescaped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(words) != 1 {
+			t.Fatalf("expected '%s' to be a single assignment - got %q", option.Value, words)
+		}
+		i := strings.Index(words[0], "=")
+		if value := words[0][i+1:]; value != environmentTestValues[words[0][:i]] {
+			t.Fatalf("expected %s to be %q - got %q", words[0][:i], environmentTestValues[words[0][:i]], value)
+		}
+	}
+}
+
+func TestDefaultSystemVTemplateLoadEnvironment(t *testing.T) {
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	envFilePath := path.Join(tempDirPath, "env file")
+	err = ioutil.WriteFile(envFilePath, []byte("EQUALS=overridden\nFROM_FILE='a b'\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// init.d scripts cannot store values that contain newlines.
+	env := make(map[string]string)
+	for name, value := range environmentTestValues {
+		env[name] = strings.Replace(value, "\n", " ", -1)
+	}
+
+	config := ControllerConfig{
+		DaemonID:         "cyberdaemon-test",
+		ExePath:          "/usr/bin/app",
+		Environment:      env,
+		EnvironmentFiles: []string{envFilePath, "-" + path.Join(tempDirPath, "missing")},
+	}
+
+	script, err := renderSystemvScript(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadEnvironment, err := shellFunction(script, "load_environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	harness := bytes.NewBuffer(nil)
+	for _, line := range strings.Split(script, "\n") {
+		for _, name := range []string{"ENVIRONMENT=", "ENVIRONMENT_FILES=", "INSTANCE_NAME=", "RESTART_POLICY="} {
+			if strings.HasPrefix(line, name) {
+				harness.WriteString(line + "\n")
+			}
+		}
+	}
+	harness.WriteString(loadEnvironment + "\n")
+	harness.WriteString("load_environment\n")
+	harness.WriteString("env -0\n")
+
+	cmd := exec.Command(bashPath, "-c", harness.String())
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("failed to load environment - %s", err.Error())
+	}
+
+	actual := make(map[string]string)
+	for _, assignment := range strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00") {
+		i := strings.Index(assignment, "=")
+		actual[assignment[:i]] = assignment[i+1:]
+	}
+
+	expected := make(map[string]string)
+	for name, value := range env {
+		expected[name] = value
+	}
+	expected["EQUALS"] = "overridden"
+	expected["FROM_FILE"] = "a b"
+
+	for name, value := range expected {
+		if actual[name] != value {
+			t.Fatalf("expected %s to be %q - got %q", name, value, actual[name])
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// +build linux darwin windows
+
+package control
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GroupController controls a group of daemons that depend on each other
+// (for example, the daemons that make up a product). It implements the
+// Controller interface. The daemons are installed and started in the order
+// of their Dependencies (i.e., a daemon is started after the daemons that
+// it must start after), and are stopped and uninstalled in the reverse
+// order. Daemons that do not depend on each other are ordered as they
+// were provided to NewGroupController.
+//
+// The order is determined by the After, Requires, BindsTo, and Before
+// dependencies between the daemons in the group. Dependencies on daemons
+// that are not in the group are ignored when ordering the group.
+//
+// Each method stops at the first daemon that fails. Daemons that were
+// already installed, started, or stopped are left as-is.
+type GroupController struct {
+	daemonIDs   []string
+	controllers map[string]Controller
+}
+
+// DaemonIDs returns the IDs of the daemons in the group in the order
+// that they are started.
+func (o *GroupController) DaemonIDs() []string {
+	return append([]string(nil), o.daemonIDs...)
+}
+
+// Controller returns the Controller of the specified daemon. It returns
+// false if the daemon is not in the group.
+func (o *GroupController) Controller(daemonID string) (Controller, bool) {
+	controller, ok := o.controllers[daemonID]
+	return controller, ok
+}
+
+// Statuses returns the status of each daemon in the group, keyed by
+// daemon ID.
+func (o *GroupController) Statuses() (map[string]Status, error) {
+	statuses := make(map[string]Status)
+
+	for _, id := range o.daemonIDs {
+		status, err := o.controllers[id].Status()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get status of daemon '%s' - %s", id, err.Error())
+		}
+
+		statuses[id] = status
+	}
+
+	return statuses, nil
+}
+
+// Status returns the status that is shared by all of the daemons in the
+// group. Unknown is returned if the daemons' statuses differ. Use Statuses
+// to get the status of each daemon.
+func (o *GroupController) Status() (Status, error) {
+	statuses, err := o.Statuses()
+	if err != nil {
+		return Unknown, err
+	}
+
+	shared := statuses[o.daemonIDs[0]]
+	for _, status := range statuses {
+		if status != shared {
+			return Unknown, nil
+		}
+	}
+
+	return shared, nil
+}
+
+func (o *GroupController) Install() error {
+	return o.each(false, Controller.Install)
+}
+
+func (o *GroupController) Uninstall() error {
+	return o.each(true, Controller.Uninstall)
+}
+
+func (o *GroupController) Start() error {
+	return o.each(false, Controller.Start)
+}
+
+func (o *GroupController) Stop() error {
+	return o.each(true, Controller.Stop)
+}
+
+// each calls the function for each daemon in start order, or in reverse
+// start order.
+func (o *GroupController) each(reverse bool, fn func(Controller) error) error {
+	for i := range o.daemonIDs {
+		id := o.daemonIDs[i]
+		if reverse {
+			id = o.daemonIDs[len(o.daemonIDs)-1-i]
+		}
+
+		err := fn(o.controllers[id])
+		if err != nil {
+			return fmt.Errorf("daemon '%s' - %s", id, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// NewGroupController creates a GroupController for the provided daemon
+// configurations. A Controller is created for each configuration using
+// NewController. A non-nil error is returned if the daemons' dependencies
+// contain a cycle.
+func NewGroupController(configs []ControllerConfig) (*GroupController, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("a daemon group must contain at least one daemon")
+	}
+
+	configsByID := make(map[string]ControllerConfig)
+	for _, config := range configs {
+		if _, exists := configsByID[config.DaemonID]; exists {
+			return nil, fmt.Errorf("daemon '%s' was specified more than once", config.DaemonID)
+		}
+
+		configsByID[config.DaemonID] = config
+	}
+
+	daemonIDs, err := dependencyOrder(configs)
+	if err != nil {
+		return nil, err
+	}
+
+	group := &GroupController{
+		daemonIDs:   daemonIDs,
+		controllers: make(map[string]Controller),
+	}
+
+	for _, id := range daemonIDs {
+		controller, err := NewController(configsByID[id])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create controller for daemon '%s' - %s", id, err.Error())
+		}
+
+		group.controllers[id] = controller
+	}
+
+	return group, nil
+}
+
+// dependencyOrder returns the daemon IDs of the configurations in the
+// order that the daemons must be started. A non-nil error describing
+// the cycle is returned if the dependencies contain a cycle.
+func dependencyOrder(configs []ControllerConfig) ([]string, error) {
+	// startAfter maps each daemon to the daemons in the group that
+	// must be started before it.
+	startAfter := make(map[string][]string)
+	for _, config := range configs {
+		startAfter[config.DaemonID] = nil
+	}
+
+	for _, config := range configs {
+		for _, id := range config.Dependencies.startAfter() {
+			if _, inGroup := startAfter[id]; inGroup {
+				startAfter[config.DaemonID] = append(startAfter[config.DaemonID], id)
+			}
+		}
+
+		for _, id := range config.Dependencies.Before {
+			if _, inGroup := startAfter[id]; inGroup {
+				startAfter[id] = append(startAfter[id], config.DaemonID)
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	states := make(map[string]int)
+	var order []string
+	var path []string
+
+	// visit adds the daemon to the order after the daemons that
+	// must be started before it. A daemon that is visited while
+	// it is being visited is part of a cycle.
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch states[id] {
+		case visited:
+			return nil
+		case visiting:
+			var cycle []string
+			for i := range path {
+				if path[i] == id {
+					cycle = append(cycle, path[i:]...)
+					break
+				}
+			}
+			cycle = append(cycle, id)
+
+			return fmt.Errorf("daemon dependencies contain a cycle (each daemon must start after the next): %s",
+				strings.Join(cycle, " -> "))
+		}
+
+		states[id] = visiting
+		path = append(path, id)
+
+		for _, dependency := range startAfter[id] {
+			err := visit(dependency)
+			if err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		states[id] = visited
+		order = append(order, id)
+
+		return nil
+	}
+
+	for _, config := range configs {
+		err := visit(config.DaemonID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
@@ -0,0 +1,79 @@
+// +build linux darwin windows
+
+package control
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDependencyOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		configs  []ControllerConfig
+		expected []string
+	}{
+		{
+			name: "no dependencies",
+			configs: []ControllerConfig{
+				{DaemonID: "a"},
+				{DaemonID: "b"},
+			},
+			expected: []string{"a", "b"},
+		},
+		{
+			name: "chain",
+			configs: []ControllerConfig{
+				{DaemonID: "c", Dependencies: Dependencies{After: []string{"b"}}},
+				{DaemonID: "b", Dependencies: Dependencies{Requires: []string{"a"}}},
+				{DaemonID: "a"},
+			},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name: "before",
+			configs: []ControllerConfig{
+				{DaemonID: "b"},
+				{DaemonID: "a", Dependencies: Dependencies{Before: []string{"b"}}},
+			},
+			expected: []string{"a", "b"},
+		},
+		{
+			name: "dependencies outside of the group are ignored",
+			configs: []ControllerConfig{
+				{DaemonID: "b", Dependencies: Dependencies{After: []string{"network"}, BindsTo: []string{"a"}}},
+				{DaemonID: "a", Dependencies: Dependencies{Before: []string{"other"}}},
+			},
+			expected: []string{"a", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		actual, err := dependencyOrder(test.configs)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Fatalf("%s: expected order %q - got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestDependencyOrderCycle(t *testing.T) {
+	configs := []ControllerConfig{
+		{DaemonID: "a", Dependencies: Dependencies{After: []string{"b"}}},
+		{DaemonID: "b", Dependencies: Dependencies{After: []string{"c"}}},
+		{DaemonID: "c", Dependencies: Dependencies{Before: []string{"a"}, After: []string{"a"}}},
+	}
+
+	_, err := dependencyOrder(configs)
+	if err == nil {
+		t.Fatal("expected an error for a dependency cycle")
+	}
+
+	expected := "daemon dependencies contain a cycle (each daemon must start after the next): a -> b -> c -> a"
+	if err.Error() != expected {
+		t.Fatalf("expected error '%s' - got '%s'", expected, err.Error())
+	}
+}
@@ -0,0 +1,1335 @@
+package control
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/stephen-fox/cyberdaemon"
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+var (
+	systemdTimeSpanRegex     = regexp.MustCompile(`^([0-9]+[a-z]*)+$`)
+	systemdTimeSpanPartRegex = regexp.MustCompile(`([0-9]+)([a-z]*)`)
+)
+
+// ImportResult is the result of reconstructing a ControllerConfig from an
+// installed daemon's configuration file.
+type ImportResult struct {
+	// Config is the reconstructed ControllerConfig.
+	Config ControllerConfig
+
+	// FilePath is the path to the configuration file that was parsed
+	// (for example, a systemd unit file or an init.d script).
+	FilePath string
+
+	// Unmapped are human readable descriptions of settings that could
+	// not be represented by the ControllerConfig. These settings will
+	// be lost if the daemon is reinstalled using Config.
+	Unmapped []string
+}
+
+func (o *ImportResult) unmappedf(format string, a ...interface{}) {
+	o.Unmapped = append(o.Unmapped, fmt.Sprintf(format, a...))
+}
+
+// setEnvironment adds a 'NAME=value' assignment to the Config's
+// Environment.
+func (o *ImportResult) setEnvironment(assignment string) {
+	i := strings.Index(assignment, "=")
+	if i < 1 {
+		o.unmappedf("invalid environment variable assignment '%s'", assignment)
+		return
+	}
+
+	if o.Config.Environment == nil {
+		o.Config.Environment = make(map[string]string)
+	}
+	o.Config.Environment[assignment[:i]] = assignment[i+1:]
+}
+
+// Import reconstructs the ControllerConfig of an installed daemon by
+// parsing its configuration file. On systemd machines, system units are
+// searched for first, followed by the current user's units. On OpenRC and
+// System V machines, the daemon's init.d script must have been generated
+// by a Controller. On runit and s6 machines, the daemon's service directory
+// must have been generated by a Controller. Otherwise, the daemon's built-in
+// supervisor configuration file is imported. If a supervisord program
+// configuration file exists for the daemon (see SupervisordOption), it is
+// imported instead.
+func Import(daemonID string) (ImportResult, error) {
+	if _, err := os.Stat(supervisordProgramFilePath(daemonID)); err == nil {
+		return ImportSupervisordProgram(supervisordProgramFilePath(daemonID))
+	}
+
+	if _, isSystemd := osutil.IsSystemd(); isSystemd {
+		unitFilePath := fmt.Sprintf("/etc/systemd/system/%s.service", daemonID)
+
+		_, statErr := os.Stat(unitFilePath)
+		if os.IsNotExist(statErr) {
+			userScope, err := newSystemdUserScope("")
+			if err != nil {
+				return ImportResult{}, err
+			}
+
+			userUnitFilePath := path.Join(userScope.unitDirPath(), daemonID+".service")
+			if _, err := os.Stat(userUnitFilePath); err == nil {
+				unitFilePath = userUnitFilePath
+			}
+		}
+
+		return ImportSystemdUnit(unitFilePath)
+	}
+
+	if _, isOpenRC := osutil.IsOpenRC(); isOpenRC {
+		return ImportOpenRCScript(fmt.Sprintf("/etc/init.d/%s", daemonID))
+	}
+
+	if _, _, isRunit := osutil.IsRunit(); isRunit {
+		return ImportRunitServiceDir(path.Join(runitSuite.serviceDirRootPath(), daemonID))
+	}
+
+	if _, _, isS6 := osutil.IsS6(); isS6 {
+		return ImportS6ServiceDir(path.Join(s6Suite.serviceDirRootPath(), daemonID))
+	}
+
+	_, _, _, isSystemv := osutil.IsSystemv()
+	if isSystemv {
+		return ImportSystemVScript(fmt.Sprintf("/etc/init.d/%s", daemonID))
+	}
+
+	return ImportBuiltinDaemonFile(path.Join(builtinConfigDirPath, daemonID+builtinConfigFileSuffix))
+}
+
+// ImportSystemdUnit reconstructs a ControllerConfig from a systemd unit
+// file. Units found in a user's unit directory (e.g., '~/.config/systemd/user')
+// are imported with the UserScope, and are owned by the unit file's owner.
+func ImportSystemdUnit(unitFilePath string) (ImportResult, error) {
+	f, err := os.Open(unitFilePath)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("failed to open unit file - %s", err.Error())
+	}
+	defer f.Close()
+
+	options, err := unit.Deserialize(f)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("failed to parse unit file - %s", err.Error())
+	}
+
+	result := ImportResult{
+		Config: ControllerConfig{
+			DaemonID:              strings.TrimSuffix(path.Base(unitFilePath), ".service"),
+			SystemSpecificOptions: make(map[SystemSpecificOption]interface{}),
+		},
+		FilePath: unitFilePath,
+	}
+
+	var execStarts []string
+	var wantedBy []string
+	var boundingSet []string
+
+	for _, option := range options {
+		if option.Section == markerSection {
+			continue
+		}
+
+		switch option.Section + "." + option.Name {
+		case "Unit.After", "Unit.Before", "Unit.Requires", "Unit.BindsTo", "Unit.PartOf":
+			importSystemdDependency(option.Name, option.Value, &result)
+		case "Unit.Description":
+			description, hasSpecifiers := unescapeSystemdSpecifiers(option.Value)
+			if hasSpecifiers {
+				result.unmappedf("Description contains specifiers, which were not expanded: %s", option.Value)
+			}
+			result.Config.Description = description
+		case "Service.ExecStart":
+			if len(option.Value) == 0 {
+				execStarts = nil
+				continue
+			}
+			execStarts = append(execStarts, option.Value)
+		case "Service.User":
+			result.Config.RunAs = option.Value
+		case "Service.Group":
+			group, hasSpecifiers := unescapeSystemdSpecifiers(option.Value)
+			if hasSpecifiers {
+				result.unmappedf("Group contains specifiers, which were not expanded: %s", option.Value)
+			}
+			result.Config.Group = group
+		case "Service.SupplementaryGroups":
+			if len(option.Value) == 0 {
+				result.Config.SupplementaryGroups = nil
+				continue
+			}
+			groups, hasSpecifiers := unescapeSystemdSpecifiers(option.Value)
+			if hasSpecifiers {
+				result.unmappedf("SupplementaryGroups contains specifiers, which were not expanded: %s", option.Value)
+			}
+			result.Config.SupplementaryGroups = append(result.Config.SupplementaryGroups, strings.Fields(groups)...)
+		case "Service.AmbientCapabilities":
+			if len(option.Value) == 0 || strings.HasPrefix(option.Value, "~") {
+				result.unmappedf("AmbientCapabilities=%s (only lists of capabilities are supported)", option.Value)
+				continue
+			}
+			result.Config.Capabilities = append(result.Config.Capabilities, strings.Fields(option.Value)...)
+		case "Service.CapabilityBoundingSet":
+			boundingSet = append(boundingSet, strings.Fields(option.Value)...)
+		case "Service.RuntimeDirectory", "Service.StateDirectory", "Service.CacheDirectory", "Service.LogsDirectory":
+			names := result.Config.Directories.namesOfKind(strings.TrimSuffix(option.Name, "Directory"))
+			*names = append(*names, strings.Fields(option.Value)...)
+		case "Service.RuntimeDirectoryMode", "Service.StateDirectoryMode", "Service.CacheDirectoryMode",
+			"Service.LogsDirectoryMode":
+			mode, err := strconv.ParseUint(option.Value, 8, 32)
+			directories := &result.Config.Directories
+			if err != nil || (directories.Mode != 0 && os.FileMode(mode) != directories.Mode) {
+				result.unmappedf("%s=%s (only a single mode for all directories is supported)",
+					option.Name, option.Value)
+				continue
+			}
+			directories.Mode = os.FileMode(mode)
+		case "Service.WorkingDirectory":
+			workDirPath, hasSpecifiers := unescapeSystemdSpecifiers(option.Value)
+			if hasSpecifiers || !path.IsAbs(workDirPath) {
+				result.unmappedf("WorkingDirectory=%s (only absolute paths without specifiers are supported)",
+					option.Value)
+				continue
+			}
+			result.Config.WorkDirPath = workDirPath
+		case "Service.UMask":
+			result.Config.Umask = option.Value
+		case "Service.Environment":
+			err := importSystemdEnvironment(option.Value, &result)
+			if err != nil {
+				return ImportResult{}, err
+			}
+		case "Service.EnvironmentFile":
+			filePath, hasSpecifiers := unescapeSystemdSpecifiers(option.Value)
+			if hasSpecifiers {
+				result.unmappedf("EnvironmentFile contains specifiers, which were not expanded: %s", option.Value)
+			}
+			result.Config.EnvironmentFiles = append(result.Config.EnvironmentFiles, filePath)
+		case "Service.LimitNOFILE", "Service.LimitNPROC", "Service.LimitCORE", "Service.Nice",
+			"Service.IOSchedulingClass", "Service.MemoryMax", "Service.CPUQuota":
+			err := importSystemdResourceLimit(option.Name, option.Value, &result.Config.ResourceLimits)
+			if err != nil {
+				result.unmappedf("%s=%s (%s)", option.Name, option.Value, err.Error())
+			}
+		case "Service.Type":
+			if option.Value != "simple" {
+				result.unmappedf("Type=%s (only 'simple' is supported)", option.Value)
+			}
+		case "Service.Restart", "Service.RestartSec", "Unit.StartLimitBurst", "Unit.StartLimitIntervalSec":
+			err := importSystemdRestart(option.Name, option.Value, &result.Config.RestartPolicy)
+			if err != nil {
+				result.unmappedf("%s=%s (%s)", option.Name, option.Value, err.Error())
+			}
+		case "Install.WantedBy":
+			wantedBy = append(wantedBy, strings.Fields(option.Value)...)
+		default:
+			result.unmappedf("[%s] %s=%s", option.Section, option.Name, option.Value)
+		}
+	}
+
+	// A Controller sets the bounding set to the ambient capabilities.
+	if strings.Join(boundingSet, " ") != strings.Join(result.Config.Capabilities, " ") {
+		result.unmappedf("CapabilityBoundingSet=%s (only a bounding set equal to AmbientCapabilities is supported)",
+			strings.Join(boundingSet, " "))
+	}
+
+	switch len(execStarts) {
+	case 0:
+		return ImportResult{}, fmt.Errorf("unit file does not contain an 'ExecStart' setting")
+	case 1:
+	default:
+		result.unmappedf("only the last of %d ExecStart settings was imported", len(execStarts))
+	}
+
+	words, err := importSystemdCommandLine(execStarts[len(execStarts)-1], &result)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	result.Config.ExePath = words[0]
+	result.Config.Arguments = words[1:]
+
+	unitDirPath := filepath.Dir(unitFilePath)
+	isUserUnit := filepath.Base(unitDirPath) == "user" && filepath.Base(filepath.Dir(unitDirPath)) == "systemd" &&
+		!strings.HasPrefix(unitDirPath, "/etc/")
+	if isUserUnit {
+		result.Config.Scope = UserScope
+		if len(result.Config.RunAs) == 0 {
+			owner, err := fileOwner(unitFilePath)
+			if err != nil {
+				return ImportResult{}, err
+			}
+			result.Config.RunAs = owner
+		}
+	}
+
+	result.Config.StartType = ManualStart
+	for _, target := range wantedBy {
+		_, err := os.Lstat(path.Join(unitDirPath, target+".wants", path.Base(unitFilePath)))
+		if err == nil {
+			result.Config.StartType = StartOnLoad
+			break
+		}
+	}
+
+	// The daemons that are implied by Requires and BindsTo are
+	// added to the 'After' setting when the unit file is generated.
+	result.Config.Dependencies.After = result.Config.Dependencies.explicitAfter()
+
+	expectedWantedBy := "multi-user.target"
+	if isUserUnit {
+		expectedWantedBy = "default.target"
+	}
+	if len(wantedBy) > 0 && !(len(wantedBy) == 1 && wantedBy[0] == expectedWantedBy) {
+		result.unmappedf("WantedBy=%s (only '%s' is supported)", strings.Join(wantedBy, " "), expectedWantedBy)
+	}
+
+	dropIns, err := readDropIns(unitFilePath)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, dropIn := range dropIns {
+		result.unmappedf("drop-in '%s' was not imported", dropIn.FilePath)
+	}
+
+	result.unmappedf("log settings are not stored in systemd unit files - the daemon's output is saved by the journal")
+
+	return result, nil
+}
+
+// importSystemdCommandLine parses an 'ExecStart' setting's value into
+// words. Specifiers and environment variables that cannot be represented
+// literally are left unexpanded and reported as unmapped.
+func importSystemdCommandLine(execStart string, result *ImportResult) ([]string, error) {
+	if trimmed := strings.TrimLeft(execStart, systemdExecPrefixes); trimmed != execStart {
+		result.unmappedf("ExecStart prefixes '%s' were removed", execStart[:len(execStart)-len(trimmed)])
+		execStart = trimmed
+	}
+
+	unescaped, hasSpecifiers := unescapeSystemdSpecifiers(execStart)
+	if hasSpecifiers {
+		result.unmappedf("ExecStart contains specifiers, which were not expanded: %s", execStart)
+	}
+
+	words, err := systemdWords(unescaped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse 'ExecStart' setting - %s", err.Error())
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("the 'ExecStart' setting is empty")
+	}
+
+	for i, word := range words {
+		if strings.Contains(strings.Replace(word, "$$", "", -1), "$") {
+			result.unmappedf("ExecStart argument '%s' references environment variables, which were not expanded",
+				word)
+		}
+		words[i] = strings.Replace(word, "$$", "$", -1)
+	}
+
+	return words, nil
+}
+
+// importSystemdDependency adds the services in a dependency setting to the
+// result's Dependencies. Dependencies on other kinds of units (such as
+// targets) are reported as unmapped.
+func importSystemdDependency(name string, value string, result *ImportResult) {
+	var daemonIDs *[]string
+	switch name {
+	case "After":
+		daemonIDs = &result.Config.Dependencies.After
+	case "Before":
+		daemonIDs = &result.Config.Dependencies.Before
+	case "Requires":
+		daemonIDs = &result.Config.Dependencies.Requires
+	case "BindsTo":
+		daemonIDs = &result.Config.Dependencies.BindsTo
+	default:
+		daemonIDs = &result.Config.Dependencies.PartOf
+	}
+
+	for _, unitName := range strings.Fields(value) {
+		if !strings.HasSuffix(unitName, ".service") || strings.Contains(unitName, "%") {
+			result.unmappedf("%s=%s (only dependencies on services are supported)", name, unitName)
+			continue
+		}
+
+		*daemonIDs = append(*daemonIDs, strings.TrimSuffix(unitName, ".service"))
+	}
+}
+
+// importSystemdEnvironment parses an 'Environment' setting's value into
+// the result's Environment.
+func importSystemdEnvironment(value string, result *ImportResult) error {
+	// The instance name is derived from the DaemonID.
+	if value == instanceEnvironmentAssignment {
+		return nil
+	}
+
+	unescaped, hasSpecifiers := unescapeSystemdSpecifiers(value)
+	if hasSpecifiers {
+		result.unmappedf("Environment contains specifiers, which were not expanded: %s", value)
+	}
+
+	assignments, err := systemdWords(unescaped)
+	if err != nil {
+		return fmt.Errorf("failed to parse 'Environment' setting - %s", err.Error())
+	}
+
+	for _, assignment := range assignments {
+		result.setEnvironment(assignment)
+	}
+
+	return nil
+}
+
+// importSystemdRestart parses a restart setting into the provided
+// RestartPolicy. A non-nil error is returned if the setting's value
+// cannot be represented by RestartPolicy. 'Restart=on-failure' leaves
+// the Mode unset, which is how an unset policy is rendered.
+func importSystemdRestart(name string, value string, policy *cyberdaemon.RestartPolicy) error {
+	var err error
+
+	switch name {
+	case "Restart":
+		switch value {
+		case "no":
+			policy.Mode = cyberdaemon.RestartNever
+		case "on-failure":
+		case "always":
+			policy.Mode = cyberdaemon.RestartAlways
+		default:
+			return fmt.Errorf("only 'no', 'on-failure', and 'always' are supported")
+		}
+	case "RestartSec":
+		policy.Delay, err = parseSystemdTimeSpan(value)
+	case "StartLimitBurst":
+		policy.BurstLimit, err = strconv.Atoi(value)
+		if err == nil && policy.BurstLimit < 0 {
+			err = fmt.Errorf("the burst limit cannot be negative")
+		}
+	case "StartLimitIntervalSec":
+		policy.BurstInterval, err = parseSystemdTimeSpan(value)
+	}
+
+	return err
+}
+
+// parseSystemdTimeSpan parses a systemd time span (e.g., '1min 30s'). A
+// number without a unit is a number of seconds. A non-nil error is
+// returned if the time span is not a whole number of milliseconds.
+func parseSystemdTimeSpan(value string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"ms": time.Millisecond, "msec": time.Millisecond,
+		"": time.Second, "s": time.Second, "sec": time.Second, "second": time.Second, "seconds": time.Second,
+		"m": time.Minute, "min": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+		"h": time.Hour, "hr": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	}
+
+	value = strings.Join(strings.Fields(value), "")
+	if !systemdTimeSpanRegex.MatchString(value) {
+		return 0, fmt.Errorf("time span '%s' is invalid (only whole numbers of ms, s, min, or h are supported)", value)
+	}
+
+	var total time.Duration
+	for _, part := range systemdTimeSpanPartRegex.FindAllStringSubmatch(value, -1) {
+		unit, ok := units[part[2]]
+		number, err := strconv.ParseUint(part[1], 10, 32)
+		if !ok || err != nil {
+			return 0, fmt.Errorf("time span '%s' is invalid (only whole numbers of ms, s, min, or h are supported)", value)
+		}
+		total += time.Duration(number) * unit
+	}
+
+	return total, nil
+}
+
+// importSystemdResourceLimit parses a resource limit setting into the
+// provided ResourceLimits. A non-nil error is returned if the setting's
+// value cannot be represented by ResourceLimits.
+func importSystemdResourceLimit(name string, value string, limits *ResourceLimits) error {
+	imported := *limits
+	var err error
+
+	switch name {
+	case "LimitNOFILE":
+		imported.OpenFiles, err = strconv.ParseUint(value, 10, 64)
+	case "LimitNPROC":
+		imported.Processes, err = strconv.ParseUint(value, 10, 64)
+	case "LimitCORE":
+		imported.CoreSize = value
+	case "Nice":
+		imported.Nice, err = strconv.Atoi(value)
+	case "IOSchedulingClass":
+		// The class may also be specified by its number.
+		imported.IOSchedulingClass = IOSchedulingClass(value)
+		if number, numErr := strconv.Atoi(value); numErr == nil {
+			imported.IOSchedulingClass = ioSchedulingClassFromNumber(number)
+			if len(imported.IOSchedulingClass) == 0 {
+				err = fmt.Errorf("unknown I/O scheduling class")
+			}
+		}
+	case "MemoryMax":
+		imported.MemoryMax = value
+	case "CPUQuota":
+		var quota uint64
+		quota, err = strconv.ParseUint(strings.TrimSuffix(value, "%"), 10, 32)
+		imported.CPUQuota = uint(quota)
+	}
+	if err != nil {
+		return fmt.Errorf("unsupported value")
+	}
+
+	err = imported.Validate()
+	if err != nil {
+		return err
+	}
+
+	*limits = imported
+
+	return nil
+}
+
+// unescapeSystemdSpecifiers replaces '%%' with '%'. It returns true
+// if the value contains other specifiers (which are left as-is).
+func unescapeSystemdSpecifiers(value string) (string, bool) {
+	var result strings.Builder
+	hasSpecifiers := false
+
+	for i := 0; i < len(value); i++ {
+		if value[i] == '%' && i+1 < len(value) && value[i+1] == '%' {
+			result.WriteByte('%')
+			i++
+			continue
+		}
+		if value[i] == '%' {
+			hasSpecifiers = true
+		}
+		result.WriteByte(value[i])
+	}
+
+	return result.String(), hasSpecifiers
+}
+
+// ImportSystemVScript reconstructs a ControllerConfig from an init.d
+// script that was generated by a Controller. Customizations made to the
+// script (for example, using SystemVTemplateOption) are not imported, but
+// are reported as unmapped.
+func ImportSystemVScript(initFilePath string) (ImportResult, error) {
+	contents, err := ioutil.ReadFile(initFilePath)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("failed to read init.d script - %s", err.Error())
+	}
+	script := string(contents)
+
+	result := ImportResult{
+		Config: ControllerConfig{
+			DaemonID: path.Base(initFilePath),
+		},
+		FilePath: initFilePath,
+	}
+
+	exePath, ok, err := shellVariableValue(script, "PROGRAM_PATH")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if !ok {
+		return ImportResult{}, fmt.Errorf("init.d script was not generated by a Controller (it does not define PROGRAM_PATH)")
+	}
+	result.Config.ExePath = exePath
+
+	result.Config.Arguments, _, err = shellArrayValue(script, "ARGUMENTS")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	assignments, _, err := shellArrayValue(script, "ENVIRONMENT")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, assignment := range assignments {
+		result.setEnvironment(assignment)
+	}
+
+	result.Config.EnvironmentFiles, _, err = shellArrayValue(script, "ENVIRONMENT_FILES")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result.Config.WorkDirPath, _, err = shellVariableValue(script, "WORK_DIR_PATH")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result.Config.Umask, _, err = shellVariableValue(script, "UMASK")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result.Config.Group, _, err = shellVariableValue(script, "GROUP")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result.Config.SupplementaryGroups, _, err = shellArrayValue(script, "SUPPLEMENTARY_GROUPS")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result.Config.Capabilities, _, err = shellArrayValue(script, "CAPABILITIES")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	dirPaths, _, err := shellArrayValue(script, "DIRECTORIES")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, dirPath := range dirPaths {
+		if !result.Config.Directories.addPath(dirPath) {
+			result.unmappedf("directory '%s' is not in a supported base directory", dirPath)
+		}
+	}
+
+	if directoryMode, ok, _ := shellVariableValue(script, "DIRECTORY_MODE"); ok && len(dirPaths) > 0 {
+		mode, err := strconv.ParseUint(directoryMode, 8, 32)
+		if err != nil {
+			result.unmappedf("directory mode '%s' is invalid", directoryMode)
+		} else {
+			result.Config.Directories.Mode = os.FileMode(mode)
+		}
+	}
+
+	if restartPolicy, ok, _ := shellVariableValue(script, "RESTART_POLICY"); ok && len(restartPolicy) > 0 {
+		policy, err := cyberdaemon.ParseRestartPolicy(restartPolicy)
+		if err != nil {
+			result.unmappedf("restart policy '%s' is invalid - %s", restartPolicy, err.Error())
+		}
+		result.Config.RestartPolicy = policy
+	}
+
+	runAs, _, err := shellVariableValue(script, "RUN_AS")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if runAs != "root" {
+		result.Config.RunAs = runAs
+	}
+
+	if name, ok, _ := shellVariableValue(script, "PROGRAM_NAME"); ok && name != result.Config.DaemonID {
+		result.unmappedf("program name '%s' differs from the init.d script's name", name)
+	}
+
+	var logFilePath string
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "# Required-Start:"):
+			result.Config.Dependencies.Requires = lsbDaemonIDs(strings.TrimPrefix(line, "# Required-Start:"))
+		case strings.HasPrefix(line, "# Should-Start:"):
+			result.Config.Dependencies.After = lsbDaemonIDs(strings.TrimPrefix(line, "# Should-Start:"))
+		case strings.HasPrefix(line, "# X-Start-Before:"):
+			result.Config.Dependencies.Before = lsbDaemonIDs(strings.TrimPrefix(line, "# X-Start-Before:"))
+		case strings.HasPrefix(line, "# Description:"):
+			result.Config.Description = strings.TrimSpace(strings.TrimPrefix(line, "# Description:"))
+		case strings.HasPrefix(line, "local logFilePath="):
+			words, err := osutil.ShellWords(strings.TrimPrefix(line, "local logFilePath="))
+			if err != nil {
+				return ImportResult{}, fmt.Errorf("failed to parse log file path - %s", err.Error())
+			}
+			if len(words) > 0 {
+				logFilePath = words[0]
+			}
+		case strings.HasPrefix(line, "ulimit "):
+			err := importUlimit(strings.Fields(line)[1:], systemvCoreBlockSize, &result.Config.ResourceLimits)
+			if err != nil {
+				result.unmappedf("'%s' (%s)", line, err.Error())
+			}
+		case strings.HasPrefix(line, "exec "):
+			err := importSystemvPriority(strings.Fields(line)[1:], &result.Config.ResourceLimits)
+			if err != nil {
+				result.unmappedf("'%s' (%s)", line, err.Error())
+			}
+		}
+	}
+
+	result.Config.LogConfig.UseNativeLogger = len(logFilePath) > 0
+
+	result.Config.StartType = ManualStart
+	startLinks, _ := filepath.Glob(fmt.Sprintf("/etc/rc[0-6S].d/S[0-9][0-9]%s", result.Config.DaemonID))
+	redHatStartLinks, _ := filepath.Glob(fmt.Sprintf("/etc/rc.d/rc[0-6].d/S[0-9][0-9]%s", result.Config.DaemonID))
+	if len(startLinks) > 0 || len(redHatStartLinks) > 0 {
+		result.Config.StartType = StartOnLoad
+	}
+
+	// Settings that are not part of the ControllerConfig (such as
+	// custom templates and additional LSB facilities) are detected by
+	// rendering the script for the imported configuration and
+	// comparing it.
+	rendered, err := renderSystemvScript(result.Config, logFilePath)
+	if err != nil || rendered != script {
+		result.unmappedf("the init.d script differs from the script generated for the imported configuration - it was customized or generated by a different version")
+	}
+
+	return result, nil
+}
+
+// lsbDaemonIDs returns the daemon IDs in the value of an LSB dependency
+// header. System facilities (such as '$syslog') are not included.
+func lsbDaemonIDs(value string) []string {
+	var daemonIDs []string
+	for _, facility := range strings.Fields(value) {
+		if !strings.HasPrefix(facility, "$") {
+			daemonIDs = append(daemonIDs, facility)
+		}
+	}
+
+	return daemonIDs
+}
+
+// importUlimit parses the arguments of a 'ulimit' command into the
+// provided ResourceLimits. Core sizes are specified in blocks of the
+// specified size.
+func importUlimit(args []string, coreBlockSize uint64, limits *ResourceLimits) error {
+	if len(args) != 2 {
+		return fmt.Errorf("only an option followed by a value is supported")
+	}
+
+	if args[0] == "-c" {
+		if args[1] == unlimitedSize {
+			limits.CoreSize = unlimitedSize
+			return nil
+		}
+		blocks, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil || blocks > ^uint64(0)/coreBlockSize {
+			return fmt.Errorf("unsupported value")
+		}
+		limits.CoreSize = strconv.FormatUint(blocks*coreBlockSize, 10)
+		return nil
+	}
+
+	value, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("unsupported value")
+	}
+
+	switch args[0] {
+	case "-n":
+		limits.OpenFiles = value
+	case "-u":
+		limits.Processes = value
+	default:
+		return fmt.Errorf("unsupported option")
+	}
+
+	return nil
+}
+
+// importSystemvPriority parses the 'nice' and 'ionice' commands that
+// precede the daemon's command in an 'exec' command into the provided
+// ResourceLimits.
+func importSystemvPriority(words []string, limits *ResourceLimits) error {
+	for len(words) >= 3 {
+		var err error
+		switch {
+		case words[0] == "nice" && words[1] == "-n":
+			err = importPriority("nice", words[2], limits)
+		case words[0] == "ionice" && words[1] == "-c":
+			err = importPriority("ionice", words[2], limits)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		words = words[3:]
+	}
+
+	return nil
+}
+
+// importPriority parses a niceness or an I/O scheduling class number
+// into the provided ResourceLimits.
+func importPriority(kind string, value string, limits *ResourceLimits) error {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("unsupported %s value '%s'", kind, value)
+	}
+
+	if kind == "nice" {
+		limits.Nice = number
+		return nil
+	}
+
+	limits.IOSchedulingClass = ioSchedulingClassFromNumber(number)
+	if len(limits.IOSchedulingClass) == 0 {
+		return fmt.Errorf("unsupported %s value '%s'", kind, value)
+	}
+
+	return nil
+}
+
+// ImportOpenRCScript reconstructs a ControllerConfig from an OpenRC service
+// script that was generated by a Controller. Customizations made to the
+// script are not imported, but are reported as unmapped. OpenRC restarts
+// the daemon whenever it exits, so the RestartPolicy's Mode is left unset
+// (unless the daemon is not supervised, in which case it is RestartNever).
+func ImportOpenRCScript(scriptFilePath string) (ImportResult, error) {
+	contents, err := ioutil.ReadFile(scriptFilePath)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("failed to read OpenRC service script - %s", err.Error())
+	}
+	script := string(contents)
+
+	result := ImportResult{
+		Config: ControllerConfig{
+			DaemonID: path.Base(scriptFilePath),
+		},
+		FilePath: scriptFilePath,
+	}
+
+	exePath, ok, err := shellVariableValue(script, "command")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	_, isGenerated, _ := shellVariableValue(script, "cyberdaemon_environment")
+	if !ok || !isGenerated {
+		return ImportResult{}, fmt.Errorf("OpenRC service script was not generated by a Controller")
+	}
+	result.Config.ExePath = exePath
+
+	result.Config.Description, _, err = shellVariableValue(script, "description")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result.Config.Arguments, err = shellWordsVariableValue(script, "command_args")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	assignments, err := shellWordsVariableValue(script, "cyberdaemon_environment")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, assignment := range assignments {
+		result.setEnvironment(assignment)
+	}
+
+	result.Config.EnvironmentFiles, err = shellWordsVariableValue(script, "cyberdaemon_environment_files")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result.Config.WorkDirPath, _, err = shellVariableValue(script, "directory")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result.Config.Umask, _, err = shellVariableValue(script, "umask")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	commandUser, _, err := shellVariableValue(script, "command_user")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if len(commandUser) > 0 {
+		parts := strings.SplitN(commandUser, ":", 2)
+		if parts[0] != "root" {
+			result.Config.RunAs = parts[0]
+		}
+		if len(parts) > 1 {
+			result.Config.Group = parts[1]
+		}
+	}
+
+	capabilities, _, err := shellVariableValue(script, "capabilities")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	result.Config.Capabilities = openrcGrantedCapabilities(capabilities)
+
+	dirPaths, err := shellWordsVariableValue(script, "cyberdaemon_directories")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, dirPath := range dirPaths {
+		if !result.Config.Directories.addPath(dirPath) {
+			result.unmappedf("directory '%s' is not in a supported base directory", dirPath)
+		}
+	}
+
+	if directoryMode, ok, _ := shellVariableValue(script, "cyberdaemon_directory_mode"); ok && len(dirPaths) > 0 {
+		mode, err := strconv.ParseUint(directoryMode, 8, 32)
+		if err != nil {
+			result.unmappedf("directory mode '%s' is invalid", directoryMode)
+		} else {
+			result.Config.Directories.Mode = os.FileMode(mode)
+		}
+	}
+
+	if supervisor, _, _ := shellVariableValue(script, "supervisor"); supervisor == "supervise-daemon" {
+		for name, setting := range map[string]*time.Duration{
+			"respawn_delay":  &result.Config.RestartPolicy.Delay,
+			"respawn_period": &result.Config.RestartPolicy.BurstInterval,
+		} {
+			if value, ok, _ := shellVariableValue(script, name); ok {
+				seconds, err := strconv.ParseUint(value, 10, 32)
+				if err != nil {
+					result.unmappedf("%s '%s' is invalid", name, value)
+					continue
+				}
+				*setting = time.Duration(seconds) * time.Second
+			}
+		}
+
+		if value, ok, _ := shellVariableValue(script, "respawn_max"); ok {
+			result.Config.RestartPolicy.BurstLimit, err = strconv.Atoi(value)
+			if err != nil {
+				result.unmappedf("respawn_max '%s' is invalid", value)
+			}
+		}
+	} else {
+		result.Config.RestartPolicy.Mode = cyberdaemon.RestartNever
+	}
+
+	for _, name := range []string{"supervise_daemon_args", "start_stop_daemon_args"} {
+		if args, ok, _ := shellVariableValue(script, name); ok {
+			err := importOpenrcPriority(strings.Fields(args), &result.Config.ResourceLimits)
+			if err != nil {
+				result.unmappedf("%s='%s' (%s)", name, args, err.Error())
+			}
+		}
+	}
+
+	if name, ok, _ := shellVariableValue(script, "name"); ok && name != result.Config.DaemonID {
+		result.unmappedf("name '%s' differs from the OpenRC service script's name", name)
+	}
+
+	logFilePath, _, err := shellVariableValue(script, "error_log")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	result.Config.LogConfig.UseNativeLogger = len(logFilePath) > 0
+
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "need "):
+			result.Config.Dependencies.Requires = strings.Fields(line)[1:]
+		case strings.HasPrefix(line, "after "):
+			result.Config.Dependencies.After = strings.Fields(line)[1:]
+		case strings.HasPrefix(line, "before "):
+			result.Config.Dependencies.Before = strings.Fields(line)[1:]
+		case strings.HasPrefix(line, "ulimit "):
+			err := importUlimit(strings.Fields(line)[1:], posixShellCoreBlockSize, &result.Config.ResourceLimits)
+			if err != nil {
+				result.unmappedf("'%s' (%s)", line, err.Error())
+			}
+		}
+	}
+
+	result.Config.StartType = ManualStart
+	runlevelLinks, _ := filepath.Glob(path.Join("/etc/runlevels/*", result.Config.DaemonID))
+	if len(runlevelLinks) > 0 {
+		result.Config.StartType = StartOnLoad
+	}
+
+	// Settings that are not part of the ControllerConfig are detected by
+	// rendering the script for the imported configuration and comparing
+	// it. The config hash is not compared because it also depends on
+	// settings that cannot be determined from the script (such as
+	// StartImmediately, and the restart policy's Mode).
+	rendered, err := renderOpenrcScript(result.Config, logFilePath)
+	if err != nil || withoutConfigHashHeader(rendered) != withoutConfigHashHeader(script) {
+		result.unmappedf("the OpenRC service script differs from the script generated for the imported configuration - it was customized or generated by a different version")
+	}
+
+	return result, nil
+}
+
+// ImportRunitServiceDir reconstructs a ControllerConfig from a runit
+// service directory that was generated by a Controller. Customizations
+// made to its files are not imported, but are reported as unmapped. The
+// daemon is started when the system boots unless the service directory
+// contains a 'down' file, so the StartType is StartOnLoad or ManualStart.
+func ImportRunitServiceDir(serviceDirPath string) (ImportResult, error) {
+	return importSupervisionServiceDir(runitSuite, serviceDirPath)
+}
+
+// ImportS6ServiceDir is the same as ImportRunitServiceDir, but imports
+// an s6 service directory.
+func ImportS6ServiceDir(serviceDirPath string) (ImportResult, error) {
+	return importSupervisionServiceDir(s6Suite, serviceDirPath)
+}
+
+func importSupervisionServiceDir(suite supervisionSuite, serviceDirPath string) (ImportResult, error) {
+	contents, err := ioutil.ReadFile(path.Join(serviceDirPath, "run"))
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("failed to read service directory's run script - %s", err.Error())
+	}
+	script := string(contents)
+
+	result := ImportResult{
+		Config: ControllerConfig{
+			DaemonID: path.Base(serviceDirPath),
+		},
+		FilePath: serviceDirPath,
+	}
+
+	exePath, ok, err := shellVariableValue(script, "cyberdaemon_command")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	scanDirPath, isGenerated, _ := shellVariableValue(script, "cyberdaemon_scan_dir")
+	if !ok || !isGenerated {
+		return ImportResult{}, fmt.Errorf("%s service directory was not generated by a Controller", suite)
+	}
+	result.Config.ExePath = exePath
+
+	for name, setting := range map[string]*string{
+		"cyberdaemon_description": &result.Config.Description,
+		"cyberdaemon_user":        &result.Config.RunAs,
+		"cyberdaemon_group":       &result.Config.Group,
+		"cyberdaemon_work_dir":    &result.Config.WorkDirPath,
+		"cyberdaemon_umask":       &result.Config.Umask,
+	} {
+		*setting, _, err = shellVariableValue(script, name)
+		if err != nil {
+			return ImportResult{}, err
+		}
+	}
+
+	for name, setting := range map[string]*[]string{
+		"cyberdaemon_arguments":            &result.Config.Arguments,
+		"cyberdaemon_supplementary_groups": &result.Config.SupplementaryGroups,
+		"cyberdaemon_environment_files":    &result.Config.EnvironmentFiles,
+	} {
+		*setting, err = shellWordsVariableValue(script, name)
+		if err != nil {
+			return ImportResult{}, err
+		}
+	}
+
+	assignments, err := shellWordsVariableValue(script, "cyberdaemon_environment")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, assignment := range assignments {
+		result.setEnvironment(assignment)
+	}
+
+	dirPaths, err := shellWordsVariableValue(script, "cyberdaemon_directories")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, dirPath := range dirPaths {
+		if !result.Config.Directories.addPath(dirPath) {
+			result.unmappedf("directory '%s' is not in a supported base directory", dirPath)
+		}
+	}
+
+	if directoryMode, ok, _ := shellVariableValue(script, "cyberdaemon_directory_mode"); ok && len(dirPaths) > 0 {
+		mode, err := strconv.ParseUint(directoryMode, 8, 32)
+		if err != nil {
+			result.unmappedf("directory mode '%s' is invalid", directoryMode)
+		} else {
+			result.Config.Directories.Mode = os.FileMode(mode)
+		}
+	}
+
+	requirePrefix := `"${cyberdaemon_scan_dir}"/`
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.Contains(line, requirePrefix):
+			requirement := strings.TrimSuffix(line[strings.Index(line, requirePrefix)+len(requirePrefix):], " || exit 1")
+			words, err := osutil.ShellWords(requirement)
+			if err != nil || len(words) != 1 {
+				result.unmappedf("'%s' (failed to parse required daemon)", line)
+				continue
+			}
+			result.Config.Dependencies.Requires = append(result.Config.Dependencies.Requires, words[0])
+		case strings.HasPrefix(line, "ulimit "):
+			err := importUlimit(strings.Fields(line)[1:], posixShellCoreBlockSize, &result.Config.ResourceLimits)
+			if err != nil {
+				result.unmappedf("'%s' (%s)", line, err.Error())
+			}
+		case strings.HasPrefix(line, "exec ") && line != "exec 2>&1":
+			err := importSystemvPriority(strings.Fields(line)[1:], &result.Config.ResourceLimits)
+			if err != nil {
+				result.unmappedf("'%s' (%s)", line, err.Error())
+			}
+		}
+	}
+
+	finishContents, err := ioutil.ReadFile(path.Join(serviceDirPath, "finish"))
+	if err == nil {
+		finish := string(finishContents)
+
+		mode, _, _ := shellVariableValue(finish, "cyberdaemon_restart")
+		result.Config.RestartPolicy.Mode = cyberdaemon.RestartMode(mode)
+
+		if delay, ok, _ := shellVariableValue(finish, "cyberdaemon_restart_delay"); ok {
+			seconds, err := strconv.ParseUint(delay, 10, 32)
+			if err != nil {
+				result.unmappedf("restart delay '%s' is invalid", delay)
+			} else {
+				result.Config.RestartPolicy.Delay = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	_, err = os.Stat(path.Join(serviceDirPath, "log", "run"))
+	result.Config.LogConfig.UseNativeLogger = err == nil
+
+	result.Config.StartType = StartOnLoad
+	if _, err := os.Stat(path.Join(serviceDirPath, "down")); err == nil {
+		result.Config.StartType = ManualStart
+	}
+
+	// Settings that are not part of the ControllerConfig are detected by
+	// rendering the service directory's files for the imported
+	// configuration and comparing them (see ImportOpenRCScript).
+	files, err := renderSupervisionFiles(result.Config, suite, scanDirPath)
+	isCustomized := err != nil
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(path.Join(serviceDirPath, file.name))
+		if err != nil || withoutConfigHashHeader(string(contents)) != withoutConfigHashHeader(file.contents) {
+			isCustomized = true
+		}
+	}
+	if isCustomized {
+		result.unmappedf("the %s service directory differs from the one generated for the imported configuration - it was customized or generated by a different version",
+			suite)
+	}
+
+	return result, nil
+}
+
+// ImportSupervisordProgram reconstructs a ControllerConfig from a supervisord
+// program configuration file that was generated by a Controller. The config
+// includes the SupervisordOption. Customizations made to the file are not
+// imported, but are reported as unmapped.
+func ImportSupervisordProgram(programFilePath string) (ImportResult, error) {
+	program, err := readSupervisordProgram(programFilePath)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	if _, isManaged := scriptMarker(programFilePath); !isManaged {
+		return ImportResult{}, fmt.Errorf("supervisord program configuration file was not generated by a Controller")
+	}
+
+	result := ImportResult{
+		Config: ControllerConfig{
+			DaemonID:    strings.TrimSuffix(path.Base(programFilePath), ".conf"),
+			Description: program.description,
+			RunAs:       program.settings["user"],
+			WorkDirPath: program.settings["directory"],
+			Umask:       program.settings["umask"],
+			SystemSpecificOptions: map[SystemSpecificOption]interface{}{
+				SupervisordOption: "",
+			},
+		},
+		FilePath: programFilePath,
+	}
+
+	if program.name != result.Config.DaemonID {
+		result.unmappedf("program name '%s' differs from the configuration file's name", program.name)
+	}
+
+	words, err := program.command()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	err = importSystemvPriority(words, &result.Config.ResourceLimits)
+	if err != nil {
+		result.unmappedf("program command '%s' (%s)", program.settings["command"], err.Error())
+	}
+	for len(words) > 3 && (words[0] == "nice" && words[1] == "-n" || words[0] == "ionice" && words[1] == "-c") {
+		words = words[3:]
+	}
+	result.Config.ExePath = words[0]
+	result.Config.Arguments = words[1:]
+
+	assignments, err := program.environment()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, assignment := range assignments {
+		result.setEnvironment(assignment)
+	}
+
+	result.Config.StartType = ManualStart
+	if program.settings["autostart"] == "true" {
+		result.Config.StartType = StartOnLoad
+	}
+
+	switch program.settings["autorestart"] {
+	case "":
+	case "false":
+		result.Config.RestartPolicy.Mode = cyberdaemon.RestartNever
+	case "unexpected":
+		result.Config.RestartPolicy.Mode = cyberdaemon.RestartOnFailure
+	case "true":
+		result.Config.RestartPolicy.Mode = cyberdaemon.RestartAlways
+	default:
+		result.unmappedf("autorestart '%s' is invalid", program.settings["autorestart"])
+	}
+
+	logFilePath := program.settings["stderr_logfile"]
+	result.Config.LogConfig.UseNativeLogger = len(logFilePath) > 0
+
+	contents, err := ioutil.ReadFile(programFilePath)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("failed to read supervisord program configuration file - %s", err.Error())
+	}
+
+	// Settings that are not part of the ControllerConfig are detected by
+	// rendering the file for the imported configuration and comparing
+	// it (see ImportOpenRCScript).
+	rendered, err := renderSupervisordProgram(result.Config, logFilePath)
+	if err != nil || withoutConfigHashHeader(rendered) != withoutConfigHashHeader(string(contents)) {
+		result.unmappedf("the supervisord program configuration file differs from the file generated for the imported configuration - it was customized or generated by a different version")
+	}
+
+	return result, nil
+}
+
+// withoutConfigHashHeader returns the script without its config hash
+// header line.
+func withoutConfigHashHeader(script string) string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(line, markerConfigHashHeader) {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// importOpenrcPriority parses the '--nicelevel' and '--ionice' options
+// of an OpenRC supervisor's arguments into the provided ResourceLimits.
+func importOpenrcPriority(args []string, limits *ResourceLimits) error {
+	for i := 0; i+1 < len(args); i += 2 {
+		var err error
+		switch args[i] {
+		case "--nicelevel":
+			err = importPriority("nice", args[i+1], limits)
+		case "--ionice":
+			err = importPriority("ionice", args[i+1], limits)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fileOwner returns the name of the user that owns a file.
+func fileOwner(filePath string) (string, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat '%s' - %s", filePath, err.Error())
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("failed to get owner of '%s' (type assertion failure)", filePath)
+	}
+
+	u, err := user.LookupId(fmt.Sprintf("%d", stat.Uid))
+	if err != nil {
+		return "", fmt.Errorf("failed to lookup owner of '%s' - %s", filePath, err.Error())
+	}
+
+	return u.Username, nil
+}
+
+// ImportBuiltinDaemonFile reconstructs a ControllerConfig from a file
+// that describes a daemon managed by the built-in supervisor (e.g.,
+// '/etc/cyberdaemon/myapp.json').
+func ImportBuiltinDaemonFile(filePath string) (ImportResult, error) {
+	file, err := readBuiltinDaemonFile(filePath)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result := ImportResult{
+		FilePath: filePath,
+		Config: ControllerConfig{
+			DaemonID:            strings.TrimSuffix(path.Base(filePath), builtinConfigFileSuffix),
+			Description:         file.Description,
+			ExePath:             file.ExePath,
+			Arguments:           file.Arguments,
+			EnvironmentFiles:    file.EnvironmentFiles,
+			WorkDirPath:         file.WorkDirPath,
+			Umask:               file.Umask,
+			RunAs:               file.RunAs,
+			Group:               file.Group,
+			SupplementaryGroups: file.SupplementaryGroups,
+			StartType:           StartType(file.StartType),
+		},
+	}
+
+	if file.DaemonID != result.Config.DaemonID {
+		result.unmappedf("daemon ID '%s' differs from the file's name", file.DaemonID)
+	}
+
+	for _, assignment := range file.Environment {
+		result.setEnvironment(assignment)
+	}
+
+	for _, dirPath := range file.Directories {
+		if !result.Config.Directories.addPath(dirPath) {
+			result.unmappedf("directory '%s' is not in a supported base directory", dirPath)
+		}
+	}
+
+	if len(file.DirectoryMode) > 0 && len(file.Directories) > 0 {
+		mode, err := strconv.ParseUint(file.DirectoryMode, 8, 32)
+		if err != nil {
+			result.unmappedf("directory mode '%s' is invalid", file.DirectoryMode)
+		} else {
+			result.Config.Directories.Mode = os.FileMode(mode)
+		}
+	}
+
+	if len(file.RestartPolicy) > 0 {
+		policy, err := cyberdaemon.ParseRestartPolicy(file.RestartPolicy)
+		if err != nil {
+			result.unmappedf("restart policy '%s' is invalid - %s", file.RestartPolicy, err.Error())
+		}
+		result.Config.RestartPolicy = policy
+	}
+
+	result.Config.LogConfig.UseNativeLogger = len(file.LogFilePath) > 0
+
+	return result, nil
+}
@@ -0,0 +1,311 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon"
+)
+
+// importTestConfig returns a configuration that sets the settings that
+// every backend supports.
+func importTestConfig() ControllerConfig {
+	return ControllerConfig{
+		DaemonID:    "cyberdaemon-test",
+		Description: "A test daemon's \"description\"",
+		ExePath:     "/opt/my app/app",
+		Arguments:   []string{"a b", "'single' \"double\"", "100%", "$HOME"},
+		Environment: map[string]string{
+			"FOO":   "bar baz",
+			"QUOTE": "it's",
+		},
+		WorkDirPath: "/srv/my app",
+		Umask:       "0027",
+		StartType:   ManualStart,
+		RestartPolicy: cyberdaemon.RestartPolicy{
+			Mode:  cyberdaemon.RestartAlways,
+			Delay: 3 * time.Second,
+		},
+	}
+}
+
+func TestImportSystemdUnitRoundTrip(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := importTestConfig()
+	config.RunAs = "nobody"
+	config.EnvironmentFiles = []string{"/etc/default/app", "-/etc/app.env"}
+	config.Dependencies = Dependencies{After: []string{"network"}, Requires: []string{"database"}}
+	config.RestartPolicy.BurstLimit = 3
+	config.RestartPolicy.BurstInterval = time.Minute
+	config.ResourceLimits = ResourceLimits{OpenFiles: 4096, Nice: 5, IOSchedulingClass: IOSchedulingIdle}
+
+	controller, err := newSystemdController(config, "/bin/systemctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unitFilePath := path.Join(tempDirPath, config.DaemonID+".service")
+	err = ioutil.WriteFile(unitFilePath, controller.unitContents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ImportSystemdUnit(unitFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Log settings are never stored in unit files, which is always
+	// reported.
+	if len(result.Unmapped) == 0 || result.Unmapped[len(result.Unmapped)-1] !=
+		"log settings are not stored in systemd unit files - the daemon's output is saved by the journal" {
+		t.Fatalf("expected the log settings to be reported as unmapped - got %q", result.Unmapped)
+	}
+	result.Unmapped = result.Unmapped[:len(result.Unmapped)-1]
+
+	config.SystemSpecificOptions = make(map[SystemSpecificOption]interface{})
+	assertImportResult(t, result, config)
+}
+
+func TestImportSystemVScriptRoundTrip(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := importTestConfig()
+	config.RunAs = "nobody"
+	config.EnvironmentFiles = []string{"/etc/default/app"}
+	config.ResourceLimits = ResourceLimits{OpenFiles: 4096, Nice: 5, IOSchedulingClass: IOSchedulingIdle}
+	config.LogConfig.UseNativeLogger = true
+
+	script, err := renderSystemvScript(config, "/var/log/cyberdaemon-test/cyberdaemon-test.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initFilePath := path.Join(tempDirPath, config.DaemonID)
+	err = ioutil.WriteFile(initFilePath, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ImportSystemVScript(initFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertImportResult(t, result, config)
+}
+
+func TestImportOpenRCScriptRoundTrip(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := importTestConfig()
+	config.ExePath = "/opt/my-app/app"
+	config.WorkDirPath = "/srv/my-app"
+	config.RunAs = "nobody"
+	config.EnvironmentFiles = []string{"/etc/conf.d/app"}
+	config.RestartPolicy = cyberdaemon.RestartPolicy{
+		Delay:         2 * time.Second,
+		BurstLimit:    3,
+		BurstInterval: time.Minute,
+	}
+	config.ResourceLimits = ResourceLimits{Nice: -5, IOSchedulingClass: IOSchedulingBestEffort}
+
+	script, err := renderOpenrcScript(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scriptFilePath := path.Join(tempDirPath, config.DaemonID)
+	err = ioutil.WriteFile(scriptFilePath, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ImportOpenRCScript(scriptFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertImportResult(t, result, config)
+}
+
+func TestImportSupervisionServiceDirRoundTrip(t *testing.T) {
+	for _, suite := range []supervisionSuite{runitSuite, s6Suite} {
+		tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tempDirPath)
+
+		config := importTestConfig()
+		config.RunAs = "nobody"
+		config.StartType = StartOnLoad
+		config.EnvironmentFiles = []string{"/etc/default/app"}
+		config.ResourceLimits = ResourceLimits{OpenFiles: 4096, Nice: 5}
+		config.LogConfig.UseNativeLogger = true
+
+		files, err := renderSupervisionFiles(config, suite, tempDirPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		serviceDirPath := path.Join(tempDirPath, config.DaemonID)
+		for _, file := range files {
+			filePath := path.Join(serviceDirPath, file.name)
+			err = os.MkdirAll(path.Dir(filePath), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(filePath, []byte(file.contents), file.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var result ImportResult
+		if suite == runitSuite {
+			result, err = ImportRunitServiceDir(serviceDirPath)
+		} else {
+			result, err = ImportS6ServiceDir(serviceDirPath)
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", suite, err.Error())
+		}
+
+		assertImportResult(t, result, config)
+	}
+}
+
+func TestImportSupervisordProgramRoundTrip(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := importTestConfig()
+	config.RunAs = "nobody"
+	config.RestartPolicy = cyberdaemon.RestartPolicy{Mode: cyberdaemon.RestartOnFailure}
+	config.ResourceLimits = ResourceLimits{Nice: 5}
+	config.SystemSpecificOptions = map[SystemSpecificOption]interface{}{
+		SupervisordOption: "",
+	}
+
+	program, err := renderSupervisordProgram(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	programFilePath := path.Join(tempDirPath, config.DaemonID+".conf")
+	err = ioutil.WriteFile(programFilePath, []byte(program), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ImportSupervisordProgram(programFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertImportResult(t, result, config)
+}
+
+func TestImportOpenrcPriority(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected ResourceLimits
+		isValid  bool
+	}{
+		{[]string{"--nicelevel", "-5", "--ionice", "3"}, ResourceLimits{Nice: -5, IOSchedulingClass: IOSchedulingIdle}, true},
+		{[]string{"--ionice", "1", "--other", "x"}, ResourceLimits{IOSchedulingClass: IOSchedulingRealtime}, true},
+		{[]string{"--nicelevel", "high"}, ResourceLimits{}, false},
+		{[]string{"--ionice", "best-effort"}, ResourceLimits{}, false},
+		{[]string{"--ionice", "9"}, ResourceLimits{}, false},
+	}
+
+	for _, test := range tests {
+		var limits ResourceLimits
+		err := importOpenrcPriority(test.args, &limits)
+		if test.isValid != (err == nil) {
+			t.Fatalf("%q: expected valid: %t - got error: %v", test.args, test.isValid, err)
+		}
+		if test.isValid && limits != test.expected {
+			t.Fatalf("%q: expected %+v - got %+v", test.args, test.expected, limits)
+		}
+	}
+}
+
+func TestImportInvalidDirectoryMode(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := importTestConfig()
+	config.ExePath = "/opt/my-app/app"
+	config.WorkDirPath = "/srv/my-app"
+	config.Directories = Directories{State: []string{"myapp"}, Mode: 0750}
+
+	script, err := renderOpenrcScript(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ParseUint returns its maximum value along with the error for
+	// a value that is out of range.
+	script = regexp.MustCompile(`(?m)^cyberdaemon_directory_mode=.*$`).
+		ReplaceAllString(script, "cyberdaemon_directory_mode=777777777777")
+
+	scriptFilePath := path.Join(tempDirPath, config.DaemonID)
+	err = ioutil.WriteFile(scriptFilePath, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ImportOpenRCScript(scriptFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Config.Directories.Mode != 0 {
+		t.Fatalf("expected an invalid directory mode to not be imported - got %04o", result.Config.Directories.Mode)
+	}
+	if len(result.Unmapped) == 0 {
+		t.Fatal("expected an invalid directory mode to be reported as unmapped")
+	}
+}
+
+// assertImportResult fails the test if the imported configuration is
+// not equal to the expected configuration, or if any settings were not
+// mapped.
+func assertImportResult(t *testing.T, result ImportResult, expected ControllerConfig) {
+	t.Helper()
+
+	if len(result.Unmapped) > 0 {
+		t.Fatalf("expected no unmapped settings - got %q", result.Unmapped)
+	}
+
+	if !reflect.DeepEqual(result.Config, expected) {
+		t.Fatalf("imported config is not equal to the original config\nexpected: %+v\ngot:      %+v",
+			expected, result.Config)
+	}
+}
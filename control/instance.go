@@ -0,0 +1,114 @@
+package control
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// instanceSeparator separates a multi-instance daemon's ID from
+	// the name of one of its instances (e.g., 'myapp@tenant1').
+	instanceSeparator = "@"
+)
+
+var (
+	instanceNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
+// InstanceManager is implemented by Controllers of multi-instance daemons.
+// A multi-instance daemon runs several instances of the same executable
+// with the same configuration (for example, one instance per tenant). Its
+// DaemonID ends with '@' (e.g., 'myapp@'), and each instance is identified
+// by the DaemonID followed by the instance's name (e.g., 'myapp@tenant1').
+// Instances can retrieve their name using cyberdaemon.InstanceName.
+//
+// The multi-instance daemon's Controller manages all of its instances.
+// Its Status method returns Running if any instance is running, and its
+// Start and Stop methods start and stop all of the installed instances.
+// Uninstalling the daemon also uninstalls its instances. The StartType
+// applies to each instance when it is installed.
+//
+// On systemd, the daemon is installed as a template unit (e.g.,
+// 'myapp@.service'), and the instances are units created from the template
+// (e.g., 'myapp@tenant1.service'). On System V, installing the daemon only
+// creates its user (see CreateUser). Each instance is installed as a
+// separate init.d script with its own PID file and log file.
+//
+// Multi-instance daemons are only supported on Linux, and are not
+// supported on OpenRC, runit, s6, or supervisord.
+//
+// The following example installs an instance for a tenant:
+//
+//	instances, ok := controller.(control.InstanceManager)
+//	if !ok {
+//		return fmt.Errorf("the controller does not manage instances")
+//	}
+//
+//	err := instances.InstallInstance("tenant1")
+type InstanceManager interface {
+	// InstallInstance installs the specified instance. The
+	// multi-instance daemon must be installed first.
+	InstallInstance(name string) error
+
+	// Instances returns the names of the installed instances
+	// in lexical order.
+	Instances() ([]string, error)
+
+	// UninstallInstance stops and uninstalls the specified instance.
+	UninstallInstance(name string) error
+
+	// Instance returns a Controller for the specified instance.
+	// Calling its Install and Uninstall methods is equivalent to
+	// calling InstallInstance and UninstallInstance.
+	Instance(name string) (Controller, error)
+}
+
+// validateInstanceName returns a non-nil error if the string is not
+// a valid instance name.
+func validateInstanceName(name string) error {
+	if !instanceNameRegex.MatchString(name) {
+		return fmt.Errorf("instance name '%s' must start with a letter or number and may only contain letters, numbers, '_', '.', and '-'",
+			name)
+	}
+
+	return nil
+}
+
+// validateDaemonIDInstance returns a non-nil error if the daemon ID refers
+// to a malformed multi-instance daemon or instance.
+func validateDaemonIDInstance(daemonID string) error {
+	i := strings.Index(daemonID, instanceSeparator)
+	if i < 0 {
+		return nil
+	}
+
+	if i == 0 || strings.Count(daemonID, instanceSeparator) > 1 {
+		return fmt.Errorf("daemon ID '%s' must contain a name before '%s', and may only contain one '%s'",
+			daemonID, instanceSeparator, instanceSeparator)
+	}
+
+	if name := instanceName(daemonID); len(name) > 0 {
+		return validateInstanceName(name)
+	}
+
+	return nil
+}
+
+// isMultiInstanceID returns true if the daemon ID identifies
+// a multi-instance daemon (e.g., 'myapp@').
+func isMultiInstanceID(daemonID string) bool {
+	return strings.HasSuffix(daemonID, instanceSeparator)
+}
+
+// instanceName returns the instance name of the daemon ID (e.g., 'tenant1'
+// for 'myapp@tenant1'). An empty string is returned if the ID does not
+// refer to an instance.
+func instanceName(daemonID string) string {
+	i := strings.Index(daemonID, instanceSeparator)
+	if i < 0 {
+		return ""
+	}
+
+	return daemonID[i+1:]
+}
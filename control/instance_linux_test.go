@@ -0,0 +1,180 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/stephen-fox/cyberdaemon"
+)
+
+func TestSystemdTemplateInstances(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := ControllerConfig{
+		DaemonID:    "cyberdaemon-test@",
+		ExePath:     "/usr/bin/app",
+		Environment: map[string]string{"FOO": "unit"},
+		StartType:   ManualStart,
+	}
+
+	controller, err := newSystemdController(config, "/bin/true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller.unitFilePath = path.Join(tempDirPath, config.DaemonID+".service")
+	template := &systemdTemplateController{systemdController: controller}
+
+	unitContents := string(controller.unitContents)
+	if !strings.Contains(unitContents, "\nEnvironment="+cyberdaemon.InstanceEnv+"=%i\n") {
+		t.Fatalf("expected the template unit to pass the instance name to the daemon - unit:\n%s", unitContents)
+	}
+
+	err = template.InstallInstance("tenant1")
+	if err == nil {
+		t.Fatal("expected an error when installing an instance before the template unit")
+	}
+
+	err = ioutil.WriteFile(controller.unitFilePath, controller.unitContents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range []string{"", "-tenant", "tenant 1", "../tenant1", "tenant@1"} {
+		_, err := template.Instance(invalid)
+		if err == nil {
+			t.Fatalf("expected an error for instance name '%s'", invalid)
+		}
+	}
+
+	for _, name := range []string{"tenant2", "tenant1", "tenant3"} {
+		err = template.InstallInstance(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = template.UninstallInstance("tenant3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The template's drop-in directory must not be mistaken for an
+	// instance.
+	err = template.WriteDropIn("10-env", []*unit.UnitOption{
+		unit.NewUnitOption("Service", "Environment", "FOO=template BAR=template"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := template.Instances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"tenant1", "tenant2"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected instances %q - got %q", expected, names)
+	}
+
+	instance, err := template.instance("tenant1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := path.Join(tempDirPath, "cyberdaemon-test@tenant1.service"); instance.unitFilePath != expected {
+		t.Fatalf("expected instance unit file path '%s' - got '%s'", expected, instance.unitFilePath)
+	}
+
+	// An instance's drop-ins are merged with the template's drop-ins
+	// in lexical order, and only apply to the instance.
+	err = instance.WriteDropIn("20-env", []*unit.UnitOption{
+		unit.NewUnitOption("Service", "Environment", "FOO=%i"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		instanceName string
+		expected     map[string]string
+	}{
+		{"tenant1", map[string]string{"FOO": "tenant1", "BAR": "template", cyberdaemon.InstanceEnv: "tenant1"}},
+		{"tenant2", map[string]string{"FOO": "template", "BAR": "template", cyberdaemon.InstanceEnv: "tenant2"}},
+	}
+
+	for _, test := range tests {
+		command, err := systemdForegroundCommand(controller.unitFilePath, test.instanceName, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, value := range test.expected {
+			if actual := command.env.values[name]; actual != value {
+				t.Fatalf("%s: expected %s to be '%s' - got '%s'", test.instanceName, name, value, actual)
+			}
+		}
+	}
+
+	result, err := ImportSystemdUnit(controller.unitFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Config.DaemonID != config.DaemonID {
+		t.Fatalf("expected imported daemon ID '%s' - got '%s'", config.DaemonID, result.Config.DaemonID)
+	}
+	if _, ok := result.Config.Environment[cyberdaemon.InstanceEnv]; ok {
+		t.Fatalf("expected the instance name to not be imported as an environment variable - got %q",
+			result.Config.Environment)
+	}
+}
+
+func TestSystemvInstanceScript(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := ControllerConfig{
+		DaemonID: "cyberdaemon-test@tenant1",
+		ExePath:  "/usr/bin/app",
+	}
+
+	script, err := renderSystemvScript(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initFilePath := path.Join(tempDirPath, config.DaemonID)
+	err = ioutil.WriteFile(initFilePath, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	command, err := systemvForegroundCommand(initFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := command.env.values[cyberdaemon.InstanceEnv]; actual != "tenant1" {
+		t.Fatalf("expected %s to be 'tenant1' - got '%s'", cyberdaemon.InstanceEnv, actual)
+	}
+
+	config.SystemSpecificOptions = map[SystemSpecificOption]interface{}{
+		SystemVTemplateDataOption: EditSystemVTemplateData(func(data *SystemVTemplateData) error {
+			data.InstanceName = "$(id)"
+			return nil
+		}),
+	}
+
+	_, err = renderSystemvScript(config, "")
+	if err == nil {
+		t.Fatal("expected an error for an invalid instance name in the template data")
+	}
+}
@@ -0,0 +1,45 @@
+package control
+
+import (
+	"testing"
+)
+
+func TestValidateDaemonIDInstance(t *testing.T) {
+	invalid := []string{"@", "@tenant1", "myapp@@", "myapp@a@b", "myapp@-tenant", "myapp@.tenant",
+		"myapp@tenant 1", "myapp@tenant/1", "myapp@tenant%i"}
+	for _, daemonID := range invalid {
+		err := validateDaemonIDInstance(daemonID)
+		if err == nil {
+			t.Fatalf("expected an error for daemon ID '%s'", daemonID)
+		}
+	}
+
+	valid := []string{"myapp", "myapp@", "myapp@tenant1", "myapp@1", "myapp@Tenant_1.eu-west"}
+	for _, daemonID := range valid {
+		err := validateDaemonIDInstance(daemonID)
+		if err != nil {
+			t.Fatalf("expected daemon ID '%s' to be valid - %s", daemonID, err.Error())
+		}
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	tests := []struct {
+		daemonID        string
+		isMultiInstance bool
+		instanceName    string
+	}{
+		{"myapp", false, ""},
+		{"myapp@", true, ""},
+		{"myapp@tenant1", false, "tenant1"},
+	}
+
+	for _, test := range tests {
+		if isMultiInstanceID(test.daemonID) != test.isMultiInstance {
+			t.Fatalf("'%s': expected multi-instance: %t", test.daemonID, test.isMultiInstance)
+		}
+		if name := instanceName(test.daemonID); name != test.instanceName {
+			t.Fatalf("'%s': expected instance name '%s' - got '%s'", test.daemonID, test.instanceName, name)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package control
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// IOSchedulingRealtime gives the daemon first access to the disk,
+	// regardless of what else is happening on the system.
+	IOSchedulingRealtime IOSchedulingClass = "realtime"
+
+	// IOSchedulingBestEffort is the default I/O scheduling class.
+	IOSchedulingBestEffort IOSchedulingClass = "best-effort"
+
+	// IOSchedulingIdle means the daemon only gets disk time when no
+	// other process has asked for disk I/O for a while.
+	IOSchedulingIdle IOSchedulingClass = "idle"
+
+	// unlimitedSize is the canonical representation of a size limit
+	// that removes the limit.
+	unlimitedSize = "unlimited"
+)
+
+// IOSchedulingClass represents a Linux I/O scheduling class.
+type IOSchedulingClass string
+
+func (o IOSchedulingClass) string() string {
+	return string(o)
+}
+
+// ioniceClass returns the class' number as understood by 'ionice -c'.
+func (o IOSchedulingClass) ioniceClass() int {
+	switch o {
+	case IOSchedulingRealtime:
+		return 1
+	case IOSchedulingBestEffort:
+		return 2
+	case IOSchedulingIdle:
+		return 3
+	}
+
+	return 0
+}
+
+// ioSchedulingClassFromNumber returns the class with the specified
+// 'ionice -c' number, or an empty string if there is no such class.
+func ioSchedulingClassFromNumber(number int) IOSchedulingClass {
+	for _, class := range []IOSchedulingClass{IOSchedulingRealtime, IOSchedulingBestEffort, IOSchedulingIdle} {
+		if class.ioniceClass() == number {
+			return class
+		}
+	}
+
+	return ""
+}
+
+// ResourceLimits configures the resources a daemon may use, and its
+// scheduling priority. Fields that are left unset are not changed from
+// the operating system's defaults.
+//
+// Not every daemon manager can express every limit. A Controller returns
+// an error when it is created if a limit is set that it cannot apply:
+// 	- systemd supports all of the limits
+// 	- System V supports OpenFiles, Processes, CoreSize, Nice, and
+// 	  IOSchedulingClass (which requires the 'ionice' utility)
+// 	- OpenRC supports OpenFiles, Processes, CoreSize, Nice, and
+// 	  IOSchedulingClass
+// 	- runit and s6 support OpenFiles, Processes, CoreSize, Nice, and
+// 	  IOSchedulingClass (which requires the 'ionice' utility)
+// 	- supervisord supports Nice and IOSchedulingClass (which requires
+// 	  the 'ionice' utility)
+// 	- Resource limits are not supported on macOS or Windows
+//
+// Sizes are specified in bytes, and may end with a 'K', 'M', 'G', or 'T'
+// suffix (base 1024). For example, "512M".
+type ResourceLimits struct {
+	// OpenFiles is the maximum number of file descriptors the daemon
+	// may have open (i.e., RLIMIT_NOFILE).
+	OpenFiles uint64
+
+	// Processes is the maximum number of processes the daemon's user
+	// may have (i.e., RLIMIT_NPROC).
+	Processes uint64
+
+	// CoreSize is the maximum size of a core dump (i.e., RLIMIT_CORE).
+	// Use "0" to disable core dumps, and "unlimited" to remove the
+	// limit.
+	CoreSize string
+
+	// Nice is the daemon's niceness, from -20 (highest priority) to
+	// 19 (lowest priority).
+	Nice int
+
+	// IOSchedulingClass is the daemon's I/O scheduling class.
+	IOSchedulingClass IOSchedulingClass
+
+	// MemoryMax is the maximum amount of memory that the daemon's
+	// processes may use in total. This is enforced by cgroups.
+	MemoryMax string
+
+	// CPUQuota is the maximum percentage of a single CPU's time that
+	// the daemon's processes may use in total. Values greater than
+	// 100 allow the daemon to use more than one CPU. This is enforced
+	// by cgroups.
+	CPUQuota uint
+}
+
+// Validate returns a non-nil error if the limits are invalid.
+func (o ResourceLimits) Validate() error {
+	if len(o.CoreSize) > 0 {
+		_, err := parseSizeLimit(o.CoreSize, true)
+		if err != nil {
+			return fmt.Errorf("core size limit is invalid - %s", err.Error())
+		}
+	}
+
+	if o.Nice < -20 || o.Nice > 19 {
+		return fmt.Errorf("nice value %d must be between -20 and 19", o.Nice)
+	}
+
+	if len(o.IOSchedulingClass) > 0 && o.IOSchedulingClass.ioniceClass() == 0 {
+		return fmt.Errorf("unknown I/O scheduling class '%s'", o.IOSchedulingClass)
+	}
+
+	if len(o.MemoryMax) > 0 {
+		_, err := parseSizeLimit(o.MemoryMax, false)
+		if err != nil {
+			return fmt.Errorf("memory limit is invalid - %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// isSet returns true if any limit is set.
+func (o ResourceLimits) isSet() bool {
+	return o != ResourceLimits{}
+}
+
+// hashValues returns the limits as strings for ControllerConfig.hash.
+// Sizes are normalized so that equivalent sizes (e.g., "1K" and "1024")
+// have the same hash.
+func (o ResourceLimits) hashValues() []string {
+	coreSize, _ := parseSizeLimit(o.CoreSize, true)
+	memoryMax, _ := parseSizeLimit(o.MemoryMax, false)
+
+	return []string{
+		strconv.FormatUint(o.OpenFiles, 10),
+		strconv.FormatUint(o.Processes, 10),
+		coreSize,
+		strconv.Itoa(o.Nice),
+		o.IOSchedulingClass.string(),
+		memoryMax,
+		strconv.FormatUint(uint64(o.CPUQuota), 10),
+	}
+}
+
+// parseSizeLimit parses a size with an optional 'K', 'M', 'G', or 'T'
+// suffix and returns the number of bytes as a string. If allowUnlimited
+// is true, "unlimited" and "infinity" are accepted and returned as
+// "unlimited". An empty string is returned as is.
+func parseSizeLimit(size string, allowUnlimited bool) (string, error) {
+	if len(size) == 0 {
+		return "", nil
+	}
+
+	if allowUnlimited && (size == unlimitedSize || size == "infinity") {
+		return unlimitedSize, nil
+	}
+
+	multiplier := uint64(1)
+	number := size
+	switch size[len(size)-1] {
+	case 'K', 'k':
+		multiplier = 1 << 10
+	case 'M', 'm':
+		multiplier = 1 << 20
+	case 'G', 'g':
+		multiplier = 1 << 30
+	case 'T', 't':
+		multiplier = 1 << 40
+	}
+	if multiplier > 1 {
+		number = strings.TrimSpace(size[:len(size)-1])
+	}
+
+	value, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("'%s' is not a size in bytes with an optional K, M, G, or T suffix", size)
+	}
+
+	if value > ^uint64(0)/multiplier {
+		return "", fmt.Errorf("size '%s' is too large", size)
+	}
+
+	return strconv.FormatUint(value*multiplier, 10), nil
+}
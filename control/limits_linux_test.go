@@ -0,0 +1,155 @@
+package control
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSystemdResourceLimitOptions(t *testing.T) {
+	options := systemdResourceLimitOptions(ResourceLimits{
+		OpenFiles:         4096,
+		Processes:         64,
+		CoreSize:          "unlimited",
+		Nice:              -5,
+		IOSchedulingClass: IOSchedulingIdle,
+		MemoryMax:         "512M",
+		CPUQuota:          150,
+	})
+
+	expected := []string{
+		"LimitNOFILE=4096",
+		"LimitNPROC=64",
+		"LimitCORE=infinity",
+		"Nice=-5",
+		"IOSchedulingClass=idle",
+		"MemoryMax=536870912",
+		"CPUQuota=150%",
+	}
+
+	var actual []string
+	for _, option := range options {
+		if option.Section != "Service" {
+			t.Fatalf("expected %s to be in the Service section - got %s", option.Name, option.Section)
+		}
+		actual = append(actual, option.Name+"="+option.Value)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %q - got %q", expected, actual)
+	}
+
+	if options := systemdResourceLimitOptions(ResourceLimits{}); len(options) > 0 {
+		t.Fatalf("expected no options for unset limits - got %d", len(options))
+	}
+}
+
+func TestImportSystemdResourceLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected ResourceLimits
+		isValid  bool
+	}{
+		{"LimitNOFILE", "4096", ResourceLimits{OpenFiles: 4096}, true},
+		{"LimitNPROC", "64", ResourceLimits{Processes: 64}, true},
+		{"LimitCORE", "infinity", ResourceLimits{CoreSize: "infinity"}, true},
+		{"Nice", "-5", ResourceLimits{Nice: -5}, true},
+		{"IOSchedulingClass", "idle", ResourceLimits{IOSchedulingClass: IOSchedulingIdle}, true},
+		{"IOSchedulingClass", "1", ResourceLimits{IOSchedulingClass: IOSchedulingRealtime}, true},
+		{"MemoryMax", "1G", ResourceLimits{MemoryMax: "1G"}, true},
+		{"CPUQuota", "150%", ResourceLimits{CPUQuota: 150}, true},
+		{"LimitNOFILE", "infinity", ResourceLimits{}, false},
+		{"LimitNOFILE", "1024:4096", ResourceLimits{}, false},
+		{"Nice", "20", ResourceLimits{}, false},
+		{"IOSchedulingClass", "9", ResourceLimits{}, false},
+		{"IOSchedulingClass", "fast", ResourceLimits{}, false},
+		{"MemoryMax", "50%", ResourceLimits{}, false},
+		{"CPUQuota", "1.5%", ResourceLimits{}, false},
+	}
+
+	for _, test := range tests {
+		var limits ResourceLimits
+		err := importSystemdResourceLimit(test.name, test.value, &limits)
+		if test.isValid != (err == nil) {
+			t.Fatalf("%s=%s: expected valid: %t - got error: %v", test.name, test.value, test.isValid, err)
+		}
+		if limits != test.expected {
+			t.Fatalf("%s=%s: expected %+v - got %+v", test.name, test.value, test.expected, limits)
+		}
+	}
+}
+
+func TestUlimitArguments(t *testing.T) {
+	tests := []struct {
+		limits        ResourceLimits
+		coreBlockSize uint64
+		expected      []string
+	}{
+		{ResourceLimits{}, systemvCoreBlockSize, nil},
+		{ResourceLimits{Nice: 5, MemoryMax: "1G"}, systemvCoreBlockSize, nil},
+		{ResourceLimits{OpenFiles: 4096, Processes: 64}, systemvCoreBlockSize, []string{"-n 4096", "-u 64"}},
+		{ResourceLimits{CoreSize: "0"}, systemvCoreBlockSize, []string{"-c 0"}},
+		{ResourceLimits{CoreSize: "infinity"}, systemvCoreBlockSize, []string{"-c unlimited"}},
+		{ResourceLimits{CoreSize: "1M"}, systemvCoreBlockSize, []string{"-c 1024"}},
+		{ResourceLimits{CoreSize: "1M"}, posixShellCoreBlockSize, []string{"-c 2048"}},
+		// Sizes that are not a multiple of the block size are
+		// rounded up so that the limit is never lower than the
+		// configured size.
+		{ResourceLimits{CoreSize: "1025"}, systemvCoreBlockSize, []string{"-c 2"}},
+		{ResourceLimits{CoreSize: "1"}, posixShellCoreBlockSize, []string{"-c 1"}},
+	}
+
+	for _, test := range tests {
+		actual := ulimitArguments(test.limits, test.coreBlockSize)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Fatalf("%+v: expected %q - got %q", test.limits, test.expected, actual)
+		}
+
+		for _, ulimit := range actual {
+			if !ulimitArgumentsRegex.MatchString(ulimit) {
+				t.Fatalf("'%s' is not accepted by the init.d script template", ulimit)
+			}
+		}
+	}
+}
+
+func TestImportUlimit(t *testing.T) {
+	limits := ResourceLimits{OpenFiles: 4096, Processes: 64, CoreSize: "1048576"}
+
+	for _, coreBlockSize := range []uint64{systemvCoreBlockSize, posixShellCoreBlockSize} {
+		var imported ResourceLimits
+		for _, ulimit := range ulimitArguments(limits, coreBlockSize) {
+			err := importUlimit(strings.Fields(ulimit), coreBlockSize, &imported)
+			if err != nil {
+				t.Fatalf("'%s': %s", ulimit, err.Error())
+			}
+		}
+		if imported != limits {
+			t.Fatalf("expected %+v - got %+v", limits, imported)
+		}
+	}
+
+	var imported ResourceLimits
+	err := importUlimit([]string{"-c", "unlimited"}, systemvCoreBlockSize, &imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.CoreSize != unlimitedSize {
+		t.Fatalf("expected core size '%s' - got '%s'", unlimitedSize, imported.CoreSize)
+	}
+
+	invalid := [][]string{
+		{"-n"},
+		{"-n", "unlimited"},
+		{"-v", "1024"},
+		{"-S", "-n", "1024"},
+		{"-c", "-1"},
+		{"-c", "18446744073709551615"},
+	}
+	for _, args := range invalid {
+		err := importUlimit(args, systemvCoreBlockSize, &imported)
+		if err == nil {
+			t.Fatalf("expected an error for %q", args)
+		}
+	}
+}
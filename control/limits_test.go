@@ -0,0 +1,101 @@
+package control
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResourceLimitsValidate(t *testing.T) {
+	invalid := []ResourceLimits{
+		{CoreSize: "1X"},
+		{CoreSize: "-1"},
+		{CoreSize: "K"},
+		{CoreSize: "99999999T"},
+		{Nice: -21},
+		{Nice: 20},
+		{IOSchedulingClass: "fast"},
+		{MemoryMax: "unlimited"},
+		{MemoryMax: "infinity"},
+		{MemoryMax: "1.5G"},
+	}
+	for _, limits := range invalid {
+		err := limits.Validate()
+		if err == nil {
+			t.Fatalf("expected an error for %+v", limits)
+		}
+	}
+
+	valid := []ResourceLimits{
+		{},
+		{CoreSize: "0"},
+		{CoreSize: "unlimited"},
+		{CoreSize: "infinity"},
+		{Nice: -20, IOSchedulingClass: IOSchedulingRealtime},
+		{Nice: 19, IOSchedulingClass: IOSchedulingIdle},
+		{OpenFiles: 4096, Processes: 64, MemoryMax: "512M", CPUQuota: 150},
+	}
+	for _, limits := range valid {
+		err := limits.Validate()
+		if err != nil {
+			t.Fatalf("expected %+v to be valid - %s", limits, err.Error())
+		}
+	}
+}
+
+func TestParseSizeLimit(t *testing.T) {
+	tests := []struct {
+		size           string
+		allowUnlimited bool
+		expected       string
+		isValid        bool
+	}{
+		{"", false, "", true},
+		{"0", false, "0", true},
+		{"1024", false, "1024", true},
+		{"1K", false, "1024", true},
+		{"1k", false, "1024", true},
+		{"512M", false, "536870912", true},
+		{"2G", false, "2147483648", true},
+		{"1T", false, "1099511627776", true},
+		{"16777215T", false, "18446742974197923840", true},
+		{"16777216T", false, "", false},
+		{"unlimited", true, "unlimited", true},
+		{"infinity", true, "unlimited", true},
+		{"unlimited", false, "", false},
+		{"1.5G", false, "", false},
+		{"-1", false, "", false},
+		{"1P", false, "", false},
+		{"M", false, "", false},
+	}
+
+	for _, test := range tests {
+		actual, err := parseSizeLimit(test.size, test.allowUnlimited)
+		if test.isValid != (err == nil) {
+			t.Fatalf("'%s': expected valid: %t - got error: %v", test.size, test.isValid, err)
+		}
+		if actual != test.expected {
+			t.Fatalf("'%s': expected '%s' - got '%s'", test.size, test.expected, actual)
+		}
+	}
+}
+
+func TestResourceLimitsHashValues(t *testing.T) {
+	a := ResourceLimits{CoreSize: "1K", MemoryMax: "1G"}
+	b := ResourceLimits{CoreSize: "1024", MemoryMax: "1073741824"}
+	if !reflect.DeepEqual(a.hashValues(), b.hashValues()) {
+		t.Fatalf("expected equivalent sizes to have the same hash values - got %q and %q",
+			a.hashValues(), b.hashValues())
+	}
+
+	c := ResourceLimits{CoreSize: "unlimited"}
+	d := ResourceLimits{CoreSize: "infinity"}
+	if !reflect.DeepEqual(c.hashValues(), d.hashValues()) {
+		t.Fatalf("expected unlimited sizes to have the same hash values - got %q and %q",
+			c.hashValues(), d.hashValues())
+	}
+
+	e := ResourceLimits{Nice: 1}
+	if reflect.DeepEqual(e.hashValues(), ResourceLimits{}.hashValues()) {
+		t.Fatal("expected different limits to have different hash values")
+	}
+}
@@ -0,0 +1,490 @@
+package control
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+const (
+	// markerSection is the name of the systemd unit file section
+	// that identifies units generated by a Controller. systemd
+	// ignores sections prefixed with 'X-'.
+	markerSection        = "X-Cyberdaemon"
+	markerVersionName    = "Version"
+	markerConfigHashName = "ConfigHash"
+
+	// markerVersionHeader and markerConfigHashHeader are the LSB
+	// headers (or comments in OpenRC service scripts) that identify
+	// init.d scripts generated by a Controller.
+	markerVersionHeader    = "# X-Cyberdaemon-Version:"
+	markerConfigHashHeader = "# X-Cyberdaemon-Config-Hash:"
+)
+
+// ManagedDaemon describes a daemon that was installed by a Controller.
+type ManagedDaemon struct {
+	// DaemonID is the daemon's ID.
+	DaemonID string
+
+	// FilePath is the path to the daemon's configuration file
+	// (for example, a systemd unit file or an init.d script), or
+	// to its runit or s6 service directory.
+	FilePath string
+
+	// Status is the daemon's current status.
+	Status Status
+
+	// LibraryVersion is the version of this library that generated
+	// the daemon's configuration file.
+	LibraryVersion string
+
+	// ConfigHash is the hash of the ControllerConfig that the
+	// daemon's configuration file was generated from.
+	ConfigHash string
+}
+
+// List returns the daemons that were installed by a Controller. On systemd
+// machines, system units and the current user's units are searched (see
+// UserScope for details about how the current user is determined). On
+// OpenRC and System V machines, init.d scripts are searched. On runit and
+// s6 machines, the service directories in '/etc/sv' and '/etc/s6/sv' are
+// searched (respectively). Otherwise, the daemons managed by the built-in
+// supervisor are listed. If supervisord is running, the programs in
+// '/etc/supervisor/conf.d' are also listed (see SupervisordOption).
+// Daemons installed by versions of this library that did not mark their
+// configuration files are not included.
+func List() ([]ManagedDaemon, error) {
+	daemons, err := listSystemDaemons()
+	if err != nil {
+		return nil, err
+	}
+
+	if supervisorctlPath, _, isSupervisord := osutil.IsSupervisord(); isSupervisord {
+		programs, err := listSupervisordPrograms(supervisorctlPath, supervisordConfDirPath)
+		if err != nil {
+			return nil, err
+		}
+
+		daemons = append(daemons, programs...)
+	}
+
+	return daemons, nil
+}
+
+// listSystemDaemons lists the daemons managed by the operating system's
+// daemon manager (or the built-in supervisor).
+func listSystemDaemons() ([]ManagedDaemon, error) {
+	if systemctlPath, isSystemd := osutil.IsSystemd(); isSystemd {
+		daemons, err := listSystemdUnits(systemctlPath, "/etc/systemd/system", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		userScope, err := newSystemdUserScope("")
+		if err != nil {
+			return nil, err
+		}
+
+		userDaemons, err := listSystemdUnits(systemctlPath, userScope.unitDirPath(), userScope)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(daemons, userDaemons...), nil
+	}
+
+	if rcServicePath, isOpenRC := osutil.IsOpenRC(); isOpenRC {
+		return listOpenrcScripts(rcServicePath, "/etc/init.d")
+	}
+
+	if svPath, scanDirPath, isRunit := osutil.IsRunit(); isRunit {
+		return listSupervisionServices(&supervisionController{
+			suite:       runitSuite,
+			controlPath: svPath,
+			statusPath:  svPath,
+			scanDirPath: scanDirPath,
+		})
+	}
+
+	if s6SvcPath, scanDirPath, isS6 := osutil.IsS6(); isS6 {
+		s6SvstatPath, err := osutil.S6SvstatPath()
+		if err != nil {
+			return nil, err
+		}
+
+		return listSupervisionServices(&supervisionController{
+			suite:       s6Suite,
+			controlPath: s6SvcPath,
+			statusPath:  s6SvstatPath,
+			scanDirPath: scanDirPath,
+		})
+	}
+
+	servicePath, _, _, isSystemv := osutil.IsSystemv()
+	if isSystemv {
+		return listSystemvScripts(servicePath, "/etc/init.d")
+	}
+
+	return listBuiltinDaemons(builtinConfigDirPath)
+}
+
+func listSystemdUnits(systemctlPath string, unitDirPath string, userScope *systemdUserScope) ([]ManagedDaemon, error) {
+	infos, err := ioutil.ReadDir(unitDirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read systemd unit directory - %s", err.Error())
+	}
+
+	var daemons []ManagedDaemon
+
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !strings.HasSuffix(info.Name(), ".service") {
+			continue
+		}
+
+		unitFilePath := path.Join(unitDirPath, info.Name())
+		daemon, isManaged := systemdMarker(unitFilePath)
+		if !isManaged {
+			continue
+		}
+
+		unitController := &systemdController{
+			systemctlPath: systemctlPath,
+			daemonID:      daemon.DaemonID,
+			unitFilePath:  unitFilePath,
+			userScope:     userScope,
+		}
+
+		var controller Controller = unitController
+		if isMultiInstanceID(daemon.DaemonID) {
+			controller = &systemdTemplateController{systemdController: unitController}
+		}
+
+		daemon.Status, err = controller.Status()
+		if err != nil {
+			return nil, err
+		}
+
+		daemons = append(daemons, daemon)
+	}
+
+	return daemons, nil
+}
+
+// systemdMarker returns a ManagedDaemon if the unit file contains the
+// marker section. Unit files that cannot be parsed are ignored.
+func systemdMarker(unitFilePath string) (ManagedDaemon, bool) {
+	f, err := os.Open(unitFilePath)
+	if err != nil {
+		return ManagedDaemon{}, false
+	}
+	defer f.Close()
+
+	options, err := unit.Deserialize(f)
+	if err != nil {
+		return ManagedDaemon{}, false
+	}
+
+	daemon := ManagedDaemon{
+		DaemonID: strings.TrimSuffix(path.Base(unitFilePath), ".service"),
+		FilePath: unitFilePath,
+	}
+	isManaged := false
+
+	for _, option := range options {
+		if option.Section != markerSection {
+			continue
+		}
+
+		isManaged = true
+
+		switch option.Name {
+		case markerVersionName:
+			daemon.LibraryVersion = option.Value
+		case markerConfigHashName:
+			daemon.ConfigHash = option.Value
+		}
+	}
+
+	return daemon, isManaged
+}
+
+func listSystemvScripts(servicePath string, initDirPath string) ([]ManagedDaemon, error) {
+	infos, err := ioutil.ReadDir(initDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read init.d directory - %s", err.Error())
+	}
+
+	var daemons []ManagedDaemon
+
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		initFilePath := path.Join(initDirPath, info.Name())
+		daemon, isManaged := systemvMarker(initFilePath)
+		if !isManaged {
+			continue
+		}
+
+		controller := &systemvController{
+			servicePath:  servicePath,
+			daemonID:     daemon.DaemonID,
+			initFilePath: initFilePath,
+		}
+
+		daemon.Status, err = controller.Status()
+		if err != nil {
+			return nil, err
+		}
+
+		daemons = append(daemons, daemon)
+	}
+
+	return daemons, nil
+}
+
+func listOpenrcScripts(rcServicePath string, initDirPath string) ([]ManagedDaemon, error) {
+	infos, err := ioutil.ReadDir(initDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read init.d directory - %s", err.Error())
+	}
+
+	var daemons []ManagedDaemon
+
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		scriptFilePath := path.Join(initDirPath, info.Name())
+		daemon, isManaged := scriptMarker(scriptFilePath)
+		if !isManaged {
+			continue
+		}
+
+		controller := &openrcController{
+			rcServicePath:  rcServicePath,
+			daemonID:       daemon.DaemonID,
+			scriptFilePath: scriptFilePath,
+		}
+
+		daemon.Status, err = controller.Status()
+		if err != nil {
+			return nil, err
+		}
+
+		daemons = append(daemons, daemon)
+	}
+
+	return daemons, nil
+}
+
+// listSupervisionServices lists the service directories of the suite
+// of the provided supervisionController, which is used as a template
+// for the controllers that query each daemon's status.
+func listSupervisionServices(suiteController *supervisionController) ([]ManagedDaemon, error) {
+	rootPath := suiteController.suite.serviceDirRootPath()
+
+	infos, err := ioutil.ReadDir(rootPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read service directories - %s", err.Error())
+	}
+
+	var daemons []ManagedDaemon
+
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+
+		serviceDirPath := path.Join(rootPath, info.Name())
+		daemon, isManaged := scriptMarker(path.Join(serviceDirPath, "run"))
+		if !isManaged {
+			continue
+		}
+		daemon.DaemonID = info.Name()
+		daemon.FilePath = serviceDirPath
+
+		controller := *suiteController
+		controller.daemonID = daemon.DaemonID
+		controller.serviceDirPath = serviceDirPath
+
+		daemon.Status, err = controller.Status()
+		if err != nil {
+			return nil, err
+		}
+
+		daemons = append(daemons, daemon)
+	}
+
+	return daemons, nil
+}
+
+func listSupervisordPrograms(supervisorctlPath string, confDirPath string) ([]ManagedDaemon, error) {
+	infos, err := ioutil.ReadDir(confDirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read supervisord configuration directory - %s", err.Error())
+	}
+
+	var daemons []ManagedDaemon
+
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !strings.HasSuffix(info.Name(), ".conf") {
+			continue
+		}
+
+		programFilePath := path.Join(confDirPath, info.Name())
+		daemon, isManaged := scriptMarker(programFilePath)
+		if !isManaged {
+			continue
+		}
+		daemon.DaemonID = strings.TrimSuffix(info.Name(), ".conf")
+
+		controller := &supervisordController{
+			supervisorctlPath: supervisorctlPath,
+			daemonID:          daemon.DaemonID,
+			programFilePath:   programFilePath,
+		}
+
+		daemon.Status, err = controller.Status()
+		if err != nil {
+			return nil, err
+		}
+
+		daemons = append(daemons, daemon)
+	}
+
+	return daemons, nil
+}
+
+func listBuiltinDaemons(configDirPath string) ([]ManagedDaemon, error) {
+	infos, err := ioutil.ReadDir(configDirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read daemon configuration directory - %s", err.Error())
+	}
+
+	var daemons []ManagedDaemon
+
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !strings.HasSuffix(info.Name(), builtinConfigFileSuffix) {
+			continue
+		}
+
+		filePath := path.Join(configDirPath, info.Name())
+		file, err := readBuiltinDaemonFile(filePath)
+		if err != nil || len(file.Version) == 0 {
+			continue
+		}
+
+		daemon := ManagedDaemon{
+			DaemonID:       strings.TrimSuffix(info.Name(), builtinConfigFileSuffix),
+			FilePath:       filePath,
+			LibraryVersion: file.Version,
+			ConfigHash:     file.ConfigHash,
+		}
+
+		controller := &builtinController{
+			daemonID:       daemon.DaemonID,
+			filePath:       filePath,
+			runtimeDirPath: builtinDaemonRuntimeDirPath(daemon.DaemonID),
+		}
+
+		daemon.Status, err = controller.Status()
+		if err != nil {
+			return nil, err
+		}
+
+		daemons = append(daemons, daemon)
+	}
+
+	return daemons, nil
+}
+
+// systemvMarker returns a ManagedDaemon if the init.d script's LSB
+// header contains the marker headers. Only the header is read.
+func systemvMarker(initFilePath string) (ManagedDaemon, bool) {
+	f, err := os.Open(initFilePath)
+	if err != nil {
+		return ManagedDaemon{}, false
+	}
+	defer f.Close()
+
+	daemon := ManagedDaemon{
+		DaemonID: path.Base(initFilePath),
+		FilePath: initFilePath,
+	}
+	isManaged := false
+
+	scanner := bufio.NewScanner(io.LimitReader(f, 10000))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, markerVersionHeader):
+			isManaged = true
+			daemon.LibraryVersion = strings.TrimSpace(strings.TrimPrefix(line, markerVersionHeader))
+		case strings.HasPrefix(line, markerConfigHashHeader):
+			daemon.ConfigHash = strings.TrimSpace(strings.TrimPrefix(line, markerConfigHashHeader))
+		case strings.HasPrefix(line, "### END INIT INFO"):
+			return daemon, isManaged
+		}
+	}
+
+	return daemon, isManaged
+}
+
+// scriptMarker returns a ManagedDaemon if the script's leading comments
+// contain the marker headers (i.e., an OpenRC service script, the run
+// script of a runit or s6 service directory, or a supervisord program
+// configuration file). Only the comments that precede the first line
+// of code are read.
+func scriptMarker(scriptFilePath string) (ManagedDaemon, bool) {
+	f, err := os.Open(scriptFilePath)
+	if err != nil {
+		return ManagedDaemon{}, false
+	}
+	defer f.Close()
+
+	daemon := ManagedDaemon{
+		DaemonID: path.Base(scriptFilePath),
+		FilePath: scriptFilePath,
+	}
+	isManaged := false
+
+	scanner := bufio.NewScanner(io.LimitReader(f, 10000))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, markerVersionHeader):
+			isManaged = true
+			daemon.LibraryVersion = strings.TrimSpace(strings.TrimPrefix(line, markerVersionHeader))
+		case strings.HasPrefix(line, markerConfigHashHeader):
+			daemon.ConfigHash = strings.TrimSpace(strings.TrimPrefix(line, markerConfigHashHeader))
+		case !strings.HasPrefix(line, "#"):
+			return daemon, isManaged
+		}
+	}
+
+	return daemon, isManaged
+}
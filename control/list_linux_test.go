@@ -0,0 +1,159 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stephen-fox/cyberdaemon"
+)
+
+func TestSystemdMarker(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := ControllerConfig{
+		DaemonID: "cyberdaemon-test",
+		ExePath:  "/usr/bin/app",
+	}
+
+	controller, err := newSystemdController(config, "/bin/systemctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unitFilePath := path.Join(tempDirPath, config.DaemonID+".service")
+	err = ioutil.WriteFile(unitFilePath, controller.unitContents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemon, isManaged := systemdMarker(unitFilePath)
+	if !isManaged {
+		t.Fatal("expected the generated unit to be managed")
+	}
+	assertManagedDaemon(t, daemon, config, unitFilePath)
+
+	unmanagedFilePath := path.Join(tempDirPath, "unmanaged.service")
+	err = ioutil.WriteFile(unmanagedFilePath, []byte("[Service]\nExecStart=/usr/bin/app\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, isManaged = systemdMarker(unmanagedFilePath)
+	if isManaged {
+		t.Fatal("expected a unit without the marker section to not be managed")
+	}
+}
+
+func TestSystemvMarker(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := ControllerConfig{
+		DaemonID: "cyberdaemon-test",
+		ExePath:  "/usr/bin/app",
+	}
+
+	script, err := renderSystemvScript(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initFilePath := path.Join(tempDirPath, config.DaemonID)
+	err = ioutil.WriteFile(initFilePath, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemon, isManaged := systemvMarker(initFilePath)
+	if !isManaged {
+		t.Fatal("expected the generated init.d script to be managed")
+	}
+	assertManagedDaemon(t, daemon, config, initFilePath)
+
+	// Headers after the LSB header block are not markers.
+	unmanagedFilePath := path.Join(tempDirPath, "unmanaged")
+	err = ioutil.WriteFile(unmanagedFilePath, []byte("#!/bin/sh\n### BEGIN INIT INFO\n### END INIT INFO\n"+
+		markerVersionHeader+" "+cyberdaemon.Version+"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, isManaged = systemvMarker(unmanagedFilePath)
+	if isManaged {
+		t.Fatal("expected a marker after the LSB header to be ignored")
+	}
+}
+
+func TestScriptMarker(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	config := ControllerConfig{
+		DaemonID: "cyberdaemon-test",
+		ExePath:  "/usr/bin/app",
+	}
+
+	script, err := renderOpenrcScript(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scriptFilePath := path.Join(tempDirPath, config.DaemonID)
+	err = ioutil.WriteFile(scriptFilePath, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemon, isManaged := scriptMarker(scriptFilePath)
+	if !isManaged {
+		t.Fatal("expected the generated OpenRC script to be managed")
+	}
+	assertManagedDaemon(t, daemon, config, scriptFilePath)
+
+	// Only the comments that precede the first line of code are read.
+	unmanagedFilePath := path.Join(tempDirPath, "unmanaged")
+	err = ioutil.WriteFile(unmanagedFilePath, []byte("#!/sbin/openrc-run\ncommand=/usr/bin/app\n"+
+		markerVersionHeader+" "+cyberdaemon.Version+"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, isManaged = scriptMarker(unmanagedFilePath)
+	if isManaged {
+		t.Fatal("expected a marker after the first line of code to be ignored")
+	}
+}
+
+// assertManagedDaemon fails the test if the daemon's marker does not
+// identify the configuration that it was generated from.
+func assertManagedDaemon(t *testing.T, daemon ManagedDaemon, config ControllerConfig, filePath string) {
+	t.Helper()
+
+	if daemon.DaemonID != config.DaemonID {
+		t.Fatalf("expected daemon ID '%s' - got '%s'", config.DaemonID, daemon.DaemonID)
+	}
+
+	if daemon.FilePath != filePath {
+		t.Fatalf("expected file path '%s' - got '%s'", filePath, daemon.FilePath)
+	}
+
+	if daemon.LibraryVersion != cyberdaemon.Version {
+		t.Fatalf("expected library version '%s' - got '%s'", cyberdaemon.Version, daemon.LibraryVersion)
+	}
+
+	if daemon.ConfigHash != config.hash() {
+		t.Fatalf("expected config hash '%s' - got '%s'", config.hash(), daemon.ConfigHash)
+	}
+}
@@ -0,0 +1,102 @@
+package control
+
+const (
+	// SystemVTemplateOption specifies a custom System V init.d script
+	// template. The value must be a string containing a text/template
+	// template. The template is rendered using a SystemVTemplateData.
+	// This option is only used by the System V Controller.
+	//
+	// The custom template is parsed after DefaultSystemVTemplate. This
+	// means a custom template can either replace the built-in template
+	// entirely, or extend it by only redefining its blocks. The following
+	// example extends the built-in template by redefining its "pre-start"
+	// block:
+	//
+	//	config := control.ControllerConfig{
+	//		DaemonID:              "test",
+	//		Description:           "I need my guys. They're the best.",
+	//		SystemSpecificOptions: map[control.SystemSpecificOption]interface{}{
+	//			control.SystemVTemplateOption: `{{define "pre-start"}}
+	//    mkdir -p /var/lib/test{{end}}`,
+	//		},
+	//	}
+	//
+	// Templates that replace the built-in template entirely should
+	// define the following shell variables at the top level of the
+	// script so that the daemon (and Debugger) can locate them:
+	// 	PROGRAM_PATH, ARGUMENTS, RUN_AS, and PID_FILE_PATH
+	SystemVTemplateOption SystemSpecificOption = "systemv_template"
+
+	// SystemVTemplateDataOption specifies a function that modifies the
+	// SystemVTemplateData before the System V init.d script template is
+	// rendered. This can be used to customize the built-in template
+	// without replacing it (for example, to add LSB dependencies or
+	// change the default run levels). The value must be an
+	// EditSystemVTemplateData function. The following example demonstrates
+	// this option by making the daemon depend on the network:
+	//
+	//	config := control.ControllerConfig{
+	//		DaemonID:              "test",
+	//		Description:           "I need my guys. They're the best.",
+	//		SystemSpecificOptions: map[control.SystemSpecificOption]interface{}{
+	//			control.SystemVTemplateDataOption: control.EditSystemVTemplateData(func(data *control.SystemVTemplateData) error {
+	//				data.RequiredStart = append(data.RequiredStart, "$network", "$remote_fs")
+	//				data.RequiredStop = append(data.RequiredStop, "$network", "$remote_fs")
+	//				return nil
+	//			}),
+	//		},
+	//	}
+	SystemVTemplateDataOption SystemSpecificOption = "systemv_template_data"
+
+	// EnableLingerOption specifies that lingering should be enabled
+	// for the owner of a user daemon (see UserScope) when it is
+	// installed. This allows the daemon to start when the operating
+	// system boots, and to keep running after the user logs out. It is
+	// implemented using 'loginctl enable-linger', which requires super
+	// user privileges unless the current user is the daemon's owner
+	// (depending on the system's polkit configuration). Lingering is
+	// not disabled when the daemon is uninstalled, as other daemons
+	// may rely on it. The option's value is ignored. This option is
+	// only supported on systemd.
+	//
+	// The following ControllerConfig example demonstrates how to
+	// specify this option:
+	//
+	//	config := control.ControllerConfig{
+	//		DaemonID:              "test",
+	//		Description:           "I need my guys. They're the best.",
+	//		Scope:                 control.UserScope,
+	//		StartType:             control.StartOnLoad,
+	//		SystemSpecificOptions: map[control.SystemSpecificOption]interface{}{
+	//			control.EnableLingerOption: "",
+	//		},
+	//	}
+	EnableLingerOption SystemSpecificOption = "enable_linger"
+
+	// SupervisordOption specifies that the daemon is managed by
+	// supervisord instead of the operating system's daemon manager.
+	// The daemon is installed as a program configuration file in
+	// '/etc/supervisor/conf.d' (which supervisord must be configured
+	// to include), and is controlled using 'supervisorctl'. The
+	// option's value is ignored. Without this option, supervisord is
+	// only used if it is the init process (i.e., PID 1, as is common
+	// in containers).
+	//
+	// The following ControllerConfig example demonstrates how to
+	// specify this option:
+	//
+	//	config := control.ControllerConfig{
+	//		DaemonID:              "test",
+	//		Description:           "I need my guys. They're the best.",
+	//		StartType:             control.StartImmediately,
+	//		SystemSpecificOptions: map[control.SystemSpecificOption]interface{}{
+	//			control.SupervisordOption: "",
+	//		},
+	//	}
+	SupervisordOption SystemSpecificOption = "supervisord"
+)
+
+// EditSystemVTemplateData represents a function that modifies the data
+// used to render a System V init.d script template. See the documentation
+// for SystemVTemplateDataOption for more information.
+type EditSystemVTemplateData func(*SystemVTemplateData) error
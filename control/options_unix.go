@@ -9,6 +9,9 @@ const (
 	// daemon. This options does not take effect if the 'RunAs' field
 	// is not set. This option is not supported on System V.
 	//
+	// This option predates the ControllerConfig's Scope field, and is
+	// equivalent to setting the Scope to UserScope.
+	//
 	// The following ControllerConfig example demonstrates how to
 	// specify this option:
 	//
@@ -27,3 +30,16 @@ const (
 	//	}
 	RunOnlyWhenLoggedIn SystemSpecificOption = "run_only_when_logged_in"
 )
+
+// scope returns the daemon's effective Scope.
+func (o ControllerConfig) scope() Scope {
+	if len(o.Scope) > 0 {
+		return o.Scope
+	}
+
+	if _, onlyRunWhenLoggedIn := o.SystemSpecificOptions[RunOnlyWhenLoggedIn]; onlyRunWhenLoggedIn && len(o.RunAs) > 0 {
+		return UserScope
+	}
+
+	return SystemScope
+}
@@ -27,3 +27,12 @@ const (
 // installing a daemon that will run as that user. See the documentation for
 // PasswordOption for more information.
 type GetPassword func() (string, error)
+
+// scope returns the daemon's effective Scope.
+func (o ControllerConfig) scope() Scope {
+	if len(o.Scope) > 0 {
+		return o.Scope
+	}
+
+	return SystemScope
+}
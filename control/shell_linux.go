@@ -0,0 +1,89 @@
+package control
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+// shellVariableValue returns the value of the specified shell variable by
+// parsing its first top-level assignment in a shell script. Only literal
+// (quoted or unquoted) values are supported.
+func shellVariableValue(script string, name string) (string, bool, error) {
+	prefix := name + "="
+
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		words, err := osutil.ShellWords(strings.TrimPrefix(line, prefix))
+		if err != nil {
+			return "", false, fmt.Errorf("failed to parse value of shell variable '%s' - %s",
+				name, err.Error())
+		}
+
+		if len(words) == 0 {
+			return "", true, nil
+		}
+
+		return words[0], true, nil
+	}
+
+	return "", false, nil
+}
+
+// shellArrayValue returns the elements of the specified bash array variable
+// by parsing its first top-level assignment in a shell script (e.g.,
+// "NAME=('a' 'b c')"). If the variable is assigned a plain string, the
+// string is split on whitespace (matching how the shell would expand it
+// when unquoted).
+func shellArrayValue(script string, name string) ([]string, bool, error) {
+	prefix := name + "=("
+
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(strings.TrimPrefix(line, prefix))
+		if !strings.HasSuffix(trimmed, ")") {
+			return nil, false, fmt.Errorf("shell array variable '%s' is not terminated on the same line", name)
+		}
+
+		words, err := osutil.ShellWords(strings.TrimSuffix(trimmed, ")"))
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to parse value of shell array variable '%s' - %s",
+				name, err.Error())
+		}
+
+		return words, true, nil
+	}
+
+	value, ok, err := shellVariableValue(script, name)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	return strings.Fields(value), true, nil
+}
+
+// shellWordsVariableValue returns the words in the value of the specified
+// shell variable (see shellVariableValue). This is used for variables that
+// store a list of quoted words, which are evaluated by the script (e.g.,
+// "NAME=\"'a' 'b c'\"").
+func shellWordsVariableValue(script string, name string) ([]string, error) {
+	value, _, err := shellVariableValue(script, name)
+	if err != nil {
+		return nil, err
+	}
+
+	words, err := osutil.ShellWords(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse words in value of shell variable '%s' - %s",
+			name, err.Error())
+	}
+
+	return words, nil
+}
@@ -0,0 +1,340 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// systemdExecPrefixes are the characters that systemd interprets as
+// prefixes of the executable in 'Exec' settings (e.g., '-' ignores the
+// command's exit status).
+const systemdExecPrefixes = "-@:+!"
+
+// systemdSpecifierContext provides the values used to resolve systemd
+// specifiers (e.g., '%n') found in a unit file.
+type systemdSpecifierContext struct {
+	unitName string
+	userName string
+	homePath string
+}
+
+// expandSystemdSpecifiers resolves the systemd specifiers in a unit file
+// setting's value. Only the subset of specifiers that is meaningful when
+// reconstructing a daemon's command line is supported.
+func expandSystemdSpecifiers(value string, context systemdSpecifierContext) (string, error) {
+	if !strings.Contains(value, "%") {
+		return value, nil
+	}
+
+	prefix := strings.TrimSuffix(context.unitName, ".service")
+	var instance string
+	if i := strings.Index(prefix, "@"); i > -1 {
+		instance = prefix[i+1:]
+		prefix = prefix[:i]
+	}
+
+	var result strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		if i+1 >= len(value) {
+			return "", fmt.Errorf("incomplete specifier at end of '%s'", value)
+		}
+
+		i++
+		switch value[i] {
+		case '%':
+			result.WriteByte('%')
+		case 'n':
+			result.WriteString(context.unitName)
+		case 'N':
+			result.WriteString(strings.TrimSuffix(context.unitName, ".service"))
+		case 'p', 'P':
+			result.WriteString(prefix)
+		case 'i', 'I':
+			result.WriteString(instance)
+		case 'H':
+			hostname, err := os.Hostname()
+			if err != nil {
+				return "", fmt.Errorf("failed to get hostname for specifier - %s", err.Error())
+			}
+			result.WriteString(hostname)
+		case 't':
+			result.WriteString("/run")
+		case 'u':
+			result.WriteString(context.userName)
+		case 'h':
+			result.WriteString(context.homePath)
+		default:
+			return "", fmt.Errorf("unsupported specifier '%%%c' in '%s'", value[i], value)
+		}
+	}
+
+	return result.String(), nil
+}
+
+// systemdWords splits a systemd unit file setting's value into words
+// according to systemd's quoting rules. Words are separated by whitespace,
+// may be quoted using single or double quotes, and may contain C-style
+// backslash escapes. Specifiers and environment variables are not
+// expanded.
+func systemdWords(value string) ([]string, error) {
+	var words []string
+	var current strings.Builder
+	inWord := false
+	var quote byte
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		switch {
+		case c == '\\':
+			inWord = true
+			if i+1 >= len(value) {
+				return nil, fmt.Errorf("trailing backslash in '%s'", value)
+			}
+			consumed, err := writeSystemdEscape(&current, value[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse escape sequence in '%s' - %s",
+					value, err.Error())
+			}
+			i += consumed
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteByte(c)
+			}
+		case c == '"' || c == '\'':
+			inWord = true
+			quote = c
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			inWord = true
+			current.WriteByte(c)
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in '%s'", value)
+	}
+
+	if inWord {
+		words = append(words, current.String())
+	}
+
+	return words, nil
+}
+
+// writeSystemdEscape decodes the C-style escape sequence at the start of
+// the provided string (which excludes the leading backslash) and writes
+// the result to the builder. It returns the number of bytes consumed.
+func writeSystemdEscape(builder *strings.Builder, s string) (int, error) {
+	switch s[0] {
+	case 'a':
+		builder.WriteByte('\a')
+	case 'b':
+		builder.WriteByte('\b')
+	case 'f':
+		builder.WriteByte('\f')
+	case 'n':
+		builder.WriteByte('\n')
+	case 'r':
+		builder.WriteByte('\r')
+	case 't':
+		builder.WriteByte('\t')
+	case 'v':
+		builder.WriteByte('\v')
+	case 's':
+		builder.WriteByte(' ')
+	case '\\', '"', '\'', ' ', ';':
+		builder.WriteByte(s[0])
+	case 'x':
+		if len(s) < 3 {
+			return 0, fmt.Errorf("incomplete hexadecimal escape")
+		}
+		b, err := strconv.ParseUint(s[1:3], 16, 8)
+		if err != nil {
+			return 0, fmt.Errorf("invalid hexadecimal escape - %s", err.Error())
+		}
+		builder.WriteByte(byte(b))
+		return 3, nil
+	case 'u', 'U':
+		length := 4
+		if s[0] == 'U' {
+			length = 8
+		}
+		if len(s) < length+1 {
+			return 0, fmt.Errorf("incomplete unicode escape")
+		}
+		r, err := strconv.ParseUint(s[1:length+1], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return 0, fmt.Errorf("invalid unicode escape")
+		}
+		builder.WriteRune(rune(r))
+		return length + 1, nil
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		if len(s) < 3 {
+			return 0, fmt.Errorf("incomplete octal escape")
+		}
+		b, err := strconv.ParseUint(s[0:3], 8, 8)
+		if err != nil {
+			return 0, fmt.Errorf("invalid octal escape - %s", err.Error())
+		}
+		builder.WriteByte(byte(b))
+		return 3, nil
+	default:
+		return 0, fmt.Errorf("unknown escape sequence '\\%c'", s[0])
+	}
+
+	return 1, nil
+}
+
+// systemdCommandLine returns a value for a unit file's 'Exec' settings
+// (such as 'ExecStart') that systemd parses into the provided words.
+// Each word is quoted so that whitespace and quotes are interpreted
+// literally. Specifiers (e.g., '%n') and environment variables (e.g.,
+// '$HOME') are escaped so that they are not expanded. systemd removes
+// executable prefixes (such as '-') from the first word before it is
+// unquoted, so an error is returned if the first word starts with one.
+func systemdCommandLine(words []string) (string, error) {
+	if len(words) > 0 && len(words[0]) > 0 && strings.IndexByte(systemdExecPrefixes, words[0][0]) > -1 {
+		return "", fmt.Errorf("executable path '%s' may not start with '%c', which systemd interprets as a prefix",
+			words[0], words[0][0])
+	}
+
+	quoted := make([]string, len(words))
+
+	for i, word := range words {
+		if strings.IndexByte(word, 0) > -1 {
+			return "", fmt.Errorf("command line argument '%s' may not contain null characters", word)
+		}
+
+		if word == ";" {
+			// A lone semicolon separates commands unless
+			// it is escaped.
+			quoted[i] = `\;`
+			continue
+		}
+
+		quoted[i] = quoteSystemdWord(strings.Replace(word, "$", "$$", -1))
+	}
+
+	return escapeSystemdSpecifiers(strings.Join(quoted, " ")), nil
+}
+
+// quoteSystemdWord quotes a word using double quotes and C-style
+// backslash escapes.
+func quoteSystemdWord(word string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch c {
+		case '"', '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&quoted, `\x%02x`, c)
+			} else {
+				quoted.WriteByte(c)
+			}
+		}
+	}
+
+	quoted.WriteByte('"')
+
+	return quoted.String()
+}
+
+// escapeSystemdSpecifiers escapes the '%' characters in a unit file
+// setting's value so that systemd does not treat them as specifiers.
+func escapeSystemdSpecifiers(value string) string {
+	return strings.Replace(value, "%", "%%", -1)
+}
+
+// substituteSystemdVariables performs systemd's environment variable
+// substitution on the words of a command line. A word consisting solely
+// of '$NAME' is replaced by the variable's value split on whitespace,
+// '${NAME}' is replaced in place, and '$$' is replaced by '$'.
+func substituteSystemdVariables(words []string, env map[string]string) []string {
+	var result []string
+
+	for _, word := range words {
+		if len(word) > 1 && word[0] == '$' && isVariableName(word[1:]) {
+			result = append(result, strings.Fields(env[word[1:]])...)
+			continue
+		}
+
+		var substituted strings.Builder
+		for i := 0; i < len(word); i++ {
+			if word[i] != '$' || i+1 >= len(word) {
+				substituted.WriteByte(word[i])
+				continue
+			}
+
+			switch word[i+1] {
+			case '$':
+				substituted.WriteByte('$')
+				i++
+			case '{':
+				end := strings.IndexByte(word[i+2:], '}')
+				if end < 0 {
+					substituted.WriteByte(word[i])
+					continue
+				}
+				substituted.WriteString(env[word[i+2:i+2+end]])
+				i = i + 2 + end
+			default:
+				substituted.WriteByte(word[i])
+			}
+		}
+
+		result = append(result, substituted.String())
+	}
+
+	return result
+}
+
+// specifierContextForUser returns a systemdSpecifierContext for the
+// specified unit and user. The current user is used if the user name
+// is empty.
+func specifierContextForUser(unitName string, userName string) (systemdSpecifierContext, error) {
+	var u *user.User
+	var err error
+	if len(userName) == 0 {
+		u, err = user.Current()
+	} else {
+		u, err = user.Lookup(userName)
+	}
+	if err != nil {
+		return systemdSpecifierContext{}, fmt.Errorf("failed to lookup user - %s", err.Error())
+	}
+
+	return systemdSpecifierContext{
+		unitName: unitName,
+		userName: u.Username,
+		homePath: u.HomeDir,
+	}, nil
+}
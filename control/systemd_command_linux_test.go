@@ -0,0 +1,107 @@
+package control
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The expected values follow the 'Command lines' section of systemd.service(5)
+// and the 'Specifiers' section of systemd.unit(5): '%%' is a literal '%',
+// '$$' is a literal '$', a lone '\;' is a literal ';', and double quoted
+// words may contain C-style escapes.
+func TestSystemdCommandLine(t *testing.T) {
+	tests := []struct {
+		words    []string
+		expected string
+	}{
+		{[]string{"/usr/bin/app"}, `"/usr/bin/app"`},
+		{[]string{"/opt/my app/app", "a b", ""}, `"/opt/my app/app" "a b" ""`},
+		{[]string{"/usr/bin/app", "100%", "%n"}, `"/usr/bin/app" "100%%" "%%n"`},
+		{[]string{"/usr/bin/app", "$HOME", "${HOME}", "$$"}, `"/usr/bin/app" "$$HOME" "$${HOME}" "$$$$"`},
+		{[]string{"/usr/bin/app", `it's "quoted"`, `back\slash`}, `"/usr/bin/app" "it's \"quoted\"" "back\\slash"`},
+		{[]string{"/usr/bin/app", ";", "a;b", "-v"}, `"/usr/bin/app" \; "a;b" "-v"`},
+		{[]string{"/usr/bin/app", "tab\tnew\nline\rreturn"}, `"/usr/bin/app" "tab\tnew\nline\rreturn"`},
+		{[]string{"/usr/bin/app", "\x01\x7f", "caf\xc3\xa9"}, `"/usr/bin/app" "\x01\x7f" "café"`},
+	}
+
+	for _, test := range tests {
+		actual, err := systemdCommandLine(test.words)
+		if err != nil {
+			t.Fatalf("failed to convert %q - %s", test.words, err.Error())
+		}
+
+		if actual != test.expected {
+			t.Fatalf("expected %q to be converted to '%s' - got '%s'", test.words, test.expected, actual)
+		}
+	}
+}
+
+func TestSystemdCommandLineRoundTrip(t *testing.T) {
+	tests := [][]string{
+		{"/usr/bin/app"},
+		{"/opt/my app/app", "a b", ""},
+		{"/usr/bin/app", "100%", "%n", "%%", "%"},
+		{"/usr/bin/app", "$HOME", "${HOME}", "$$", "$", "a$"},
+		{"/usr/bin/app", `"double"`, "'single'", `it's "quoted"`},
+		{"/usr/bin/app", `back\slash`, `\n`, `\`, `\\"`},
+		{"/usr/bin/app", ";"},
+		{"/usr/bin/app", ";", "a;b", "; "},
+		{"/usr/bin/app", "-", "-v", "@arg", "+arg", "!arg", "!!arg", ":arg"},
+		{"/usr/bin/app", "tab\tnew\nline\rreturn", "\x01\x7f", "caf\xc3\xa9"},
+	}
+
+	for _, words := range tests {
+		actual, err := systemdCommandLineRoundTrip(words)
+		if err != nil {
+			t.Fatalf("failed to round trip %q - %s", words, err.Error())
+		}
+
+		if !reflect.DeepEqual(actual, words) {
+			t.Fatalf("expected %q - got %q", words, actual)
+		}
+	}
+}
+
+func TestSystemdCommandLineExecPrefixes(t *testing.T) {
+	for _, exePath := range []string{"-/usr/bin/app", "@/usr/bin/app", "+/usr/bin/app", "!/usr/bin/app", ":/usr/bin/app"} {
+		_, err := systemdCommandLine([]string{exePath, "a"})
+		if err == nil {
+			t.Fatalf("expected an error for executable path '%s'", exePath)
+		}
+	}
+}
+
+func TestSystemdCommandLineErrors(t *testing.T) {
+	tests := [][]string{
+		{"-/usr/bin/app", "@", "+"},
+		{"/usr/bin/app", "null\x00", ""},
+		{"/usr/bin/app\x00"},
+	}
+
+	for _, words := range tests {
+		_, err := systemdCommandLineRoundTrip(words)
+		if err == nil {
+			t.Fatalf("expected an error for words %q", words)
+		}
+	}
+}
+
+// systemdCommandLineRoundTrip converts words into a command line using
+// systemdCommandLine, and parses it in the same order as systemd does:
+// specifiers are resolved first, the command line is then split into
+// words, and environment variables are substituted last.
+func systemdCommandLineRoundTrip(words []string) ([]string, error) {
+	commandLine, err := systemdCommandLine(words)
+	if err != nil {
+		return nil, err
+	}
+
+	unescaped, _ := unescapeSystemdSpecifiers(commandLine)
+
+	parsed, err := systemdWords(unescaped)
+	if err != nil {
+		return nil, err
+	}
+
+	return substituteSystemdVariables(parsed, nil), nil
+}
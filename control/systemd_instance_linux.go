@@ -0,0 +1,252 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/stephen-fox/cyberdaemon"
+)
+
+const (
+	// instanceMarkerDropInName is the name of the drop-in that marks
+	// an installed instance of a multi-instance daemon. The drop-in
+	// only contains the marker section, which systemd ignores.
+	instanceMarkerDropInName = "cyberdaemon-instance"
+)
+
+// systemdTemplateController controls a multi-instance daemon, which is
+// installed as a template unit (e.g., 'myapp@.service'). Drop-ins that
+// are written using its DropInManager methods apply to all instances.
+type systemdTemplateController struct {
+	*systemdController
+}
+
+func (o *systemdTemplateController) Status() (Status, error) {
+	status, err := o.systemdController.Status()
+	if err != nil || status == NotInstalled {
+		return status, err
+	}
+
+	names, err := o.Instances()
+	if err != nil {
+		return Unknown, err
+	}
+
+	for _, name := range names {
+		if o.unitStatus(o.daemonID+name) == Running {
+			return Running, nil
+		}
+	}
+
+	return Stopped, nil
+}
+
+func (o *systemdTemplateController) Install() error {
+	err := o.writeUnitFile()
+	if err != nil {
+		return err
+	}
+
+	// Template units cannot be started or enabled. The StartType
+	// is applied when an instance is installed.
+	_, _, err = o.systemctl(daemonReloadCommand)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *systemdTemplateController) Uninstall() error {
+	names, err := o.Instances()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err := o.UninstallInstance(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Remove(o.unitFilePath)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = o.systemctl(daemonReloadCommand)
+	if err != nil {
+		return err
+	}
+
+	return o.install.afterUninstall()
+}
+
+func (o *systemdTemplateController) Start() error {
+	return o.eachInstance(Controller.Start)
+}
+
+func (o *systemdTemplateController) Stop() error {
+	return o.eachInstance(Controller.Stop)
+}
+
+func (o *systemdTemplateController) Debug() error {
+	return fmt.Errorf("a multi-instance daemon cannot be debugged - debug one of its instances instead")
+}
+
+func (o *systemdTemplateController) InstallInstance(name string) error {
+	instance, err := o.instance(name)
+	if err != nil {
+		return err
+	}
+
+	return instance.Install()
+}
+
+func (o *systemdTemplateController) Instances() ([]string, error) {
+	filePaths, err := filepath.Glob(path.Join(path.Dir(o.unitFilePath), o.daemonID+"*.service.d",
+		instanceMarkerDropInName+dropInFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for instances - %s", err.Error())
+	}
+
+	var names []string
+
+	for _, filePath := range filePaths {
+		unitName := strings.TrimSuffix(path.Base(path.Dir(filePath)), ".service.d")
+		name := strings.TrimPrefix(unitName, o.daemonID)
+		if validateInstanceName(name) != nil {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (o *systemdTemplateController) UninstallInstance(name string) error {
+	instance, err := o.instance(name)
+	if err != nil {
+		return err
+	}
+
+	return instance.Uninstall()
+}
+
+func (o *systemdTemplateController) Instance(name string) (Controller, error) {
+	return o.instance(name)
+}
+
+func (o *systemdTemplateController) instance(name string) (*systemdInstanceController, error) {
+	err := validateInstanceName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	unitName := o.daemonID + name
+
+	return &systemdInstanceController{
+		systemdController: &systemdController{
+			systemctlPath: o.systemctlPath,
+			daemonID:      unitName,
+			unitFilePath:  path.Join(path.Dir(o.unitFilePath), unitName+".service"),
+			startType:     o.startType,
+			userScope:     o.userScope,
+		},
+		template: o.systemdController,
+		name:     name,
+	}, nil
+}
+
+// eachInstance calls the function for each of the installed instances.
+func (o *systemdTemplateController) eachInstance(fn func(Controller) error) error {
+	names, err := o.Instances()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		instance, err := o.instance(name)
+		if err != nil {
+			return err
+		}
+
+		err = fn(instance)
+		if err != nil {
+			return fmt.Errorf("instance '%s' - %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// systemdInstanceController controls an instance of a multi-instance
+// daemon. The embedded systemdController refers to the instance's unit
+// (e.g., 'myapp@tenant1.service'), which does not have a unit file. An
+// installed instance is marked by a drop-in in the instance's drop-in
+// directory. Drop-ins that are written using its DropInManager methods
+// only apply to the instance.
+type systemdInstanceController struct {
+	*systemdController
+	template *systemdController
+	name     string
+}
+
+func (o *systemdInstanceController) Status() (Status, error) {
+	markerFilePath, err := o.dropInFilePath(instanceMarkerDropInName)
+	if err != nil {
+		return Unknown, err
+	}
+
+	_, statErr := os.Stat(markerFilePath)
+	if statErr != nil {
+		return NotInstalled, nil
+	}
+
+	return o.unitStatus(o.daemonID), nil
+}
+
+func (o *systemdInstanceController) Install() error {
+	_, statErr := os.Stat(o.template.unitFilePath)
+	if statErr != nil {
+		return fmt.Errorf("the multi-instance daemon must be installed before its instances - %s",
+			statErr.Error())
+	}
+
+	err := o.WriteDropIn(instanceMarkerDropInName, []*unit.UnitOption{
+		unit.NewUnitOption(markerSection, markerVersionName, cyberdaemon.Version),
+	})
+	if err != nil {
+		return err
+	}
+
+	return o.applyStartType(o.daemonID)
+}
+
+func (o *systemdInstanceController) Uninstall() error {
+	// Try to stop the instance. Ignore any errors because it might
+	// be stopped already.
+	o.Stop()
+
+	// Unlike other units, the template's unit file remains after the
+	// instance is uninstalled, so the instance must be disabled.
+	_, _, err := o.systemctl("disable", o.daemonID)
+	if err != nil {
+		return err
+	}
+
+	return o.RemoveDropIn(instanceMarkerDropInName)
+}
+
+func (o *systemdInstanceController) Debug() error {
+	return o.template.debugUnit(o.name)
+}
@@ -0,0 +1,214 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+const (
+	// lingerTimeout is how long to wait for a user's instance of
+	// systemd to start after lingering is enabled.
+	lingerTimeout = 10 * time.Second
+)
+
+// systemdUserScope describes a daemon that is managed by a user's instance
+// of systemd (i.e., 'systemctl --user').
+type systemdUserScope struct {
+	// owner is the user that owns the daemon.
+	owner *user.User
+
+	// isOtherUser is true if the current process is not run by the
+	// owner (for example, when it is run by root via sudo).
+	isOtherUser bool
+
+	// configHomePath is the owner's XDG configuration directory.
+	configHomePath string
+
+	enableLinger bool
+}
+
+// unitDirPath returns the owner's systemd user unit directory.
+func (o *systemdUserScope) unitDirPath() string {
+	return path.Join(o.configHomePath, "systemd", "user")
+}
+
+// runtimeDirPath returns the owner's XDG runtime directory, which
+// contains the socket of the owner's D-Bus session bus.
+func (o *systemdUserScope) runtimeDirPath() string {
+	return path.Join("/run/user", o.owner.Uid)
+}
+
+// command returns an exec.Cmd that runs as the owner, and can connect to
+// the owner's instance of systemd. systemctl finds the instance using the
+// XDG_RUNTIME_DIR and DBUS_SESSION_BUS_ADDRESS environment variables,
+// which are not set (or are set for the wrong user) when the current
+// process is run via sudo or su.
+func (o *systemdUserScope) command(exePath string, args ...string) (*exec.Cmd, error) {
+	cmd := exec.Command(exePath, args...)
+	runtimeDirPath := o.runtimeDirPath()
+	busAddress := "unix:path=" + path.Join(runtimeDirPath, "bus")
+
+	if !o.isOtherUser {
+		cmd.Env = os.Environ()
+		if _, ok := os.LookupEnv("XDG_RUNTIME_DIR"); !ok {
+			cmd.Env = append(cmd.Env, "XDG_RUNTIME_DIR="+runtimeDirPath)
+		}
+		if _, ok := os.LookupEnv("DBUS_SESSION_BUS_ADDRESS"); !ok {
+			cmd.Env = append(cmd.Env, "DBUS_SESSION_BUS_ADDRESS="+busAddress)
+		}
+		return cmd, nil
+	}
+
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + o.owner.HomeDir,
+		"USER=" + o.owner.Username,
+		"LOGNAME=" + o.owner.Username,
+		"XDG_RUNTIME_DIR=" + runtimeDirPath,
+		"DBUS_SESSION_BUS_ADDRESS=" + busAddress,
+	}
+	cmd.Dir = o.owner.HomeDir
+
+	credential, err := osutil.UserCredential(o.owner)
+	if err != nil {
+		return nil, err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: credential,
+	}
+
+	return cmd, nil
+}
+
+// prepareUnitDir creates the unit directory. Directories that are created
+// are owned by the owner.
+func (o *systemdUserScope) prepareUnitDir(dirPath string) error {
+	var created []string
+	for current := dirPath; current != "/" && current != "."; current = filepath.Dir(current) {
+		if _, err := os.Stat(current); err == nil {
+			break
+		}
+		created = append(created, current)
+	}
+
+	err := os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create systemd user unit directory - %s", err.Error())
+	}
+
+	for _, createdPath := range created {
+		err := o.chown(createdPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chown changes the owner of a file to the owner if the current process
+// is not run by the owner.
+func (o *systemdUserScope) chown(filePath string) error {
+	if !o.isOtherUser {
+		return nil
+	}
+
+	uid, err := strconv.Atoi(o.owner.Uid)
+	if err != nil {
+		return fmt.Errorf("failed to parse uid of user '%s' - %s", o.owner.Username, err.Error())
+	}
+
+	gid, err := strconv.Atoi(o.owner.Gid)
+	if err != nil {
+		return fmt.Errorf("failed to parse gid of user '%s' - %s", o.owner.Username, err.Error())
+	}
+
+	err = os.Chown(filePath, uid, gid)
+	if err != nil {
+		return fmt.Errorf("failed to change owner of '%s' to '%s' - %s",
+			filePath, o.owner.Username, err.Error())
+	}
+
+	return nil
+}
+
+// lingerAndWait enables lingering for the owner, and waits for the owner's
+// instance of systemd to start.
+func (o *systemdUserScope) lingerAndWait() error {
+	loginctlPath, err := osutil.LoginctlPath()
+	if err != nil {
+		return err
+	}
+
+	_, _, err = osutil.RunDaemonCli(loginctlPath, "enable-linger", o.owner.Username)
+	if err != nil {
+		return err
+	}
+
+	busPath := path.Join(o.runtimeDirPath(), "bus")
+	deadline := time.Now().Add(lingerTimeout)
+
+	for {
+		if _, err := os.Stat(busPath); err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("systemd user instance for '%s' did not start after enabling lingering ('%s' does not exist)",
+				o.owner.Username, busPath)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// newSystemdUserScope returns a systemdUserScope for the specified owner.
+// If the owner is not specified, the current user is the owner - unless
+// the current process was run by root via sudo, in which case the user
+// that invoked sudo is the owner.
+func newSystemdUserScope(ownerName string) (*systemdUserScope, error) {
+	current, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current user - %s", err.Error())
+	}
+
+	if len(ownerName) == 0 {
+		ownerName = current.Username
+		if sudoUser := os.Getenv("SUDO_USER"); os.Geteuid() == 0 && len(sudoUser) > 0 {
+			ownerName = sudoUser
+		}
+	}
+
+	owner, err := user.Lookup(ownerName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup user '%s' - %s", ownerName, err.Error())
+	}
+
+	isOtherUser := owner.Uid != current.Uid
+	if isOtherUser && os.Geteuid() != 0 {
+		return nil, fmt.Errorf("super user privileges are required to manage a user daemon owned by '%s'",
+			owner.Username)
+	}
+
+	// The XDG_CONFIG_HOME environment variable is only
+	// meaningful if it belongs to the owner.
+	configHomePath := path.Join(owner.HomeDir, ".config")
+	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); !isOtherUser && path.IsAbs(xdgConfigHome) {
+		configHomePath = xdgConfigHome
+	}
+
+	return &systemdUserScope{
+		owner:          owner,
+		isOtherUser:    isOtherUser,
+		configHomePath: configHomePath,
+	}, nil
+}
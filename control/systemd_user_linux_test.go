@@ -0,0 +1,176 @@
+package control
+
+import (
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRunSettingsUnitFilePath(t *testing.T) {
+	defer restoreEnv("XDG_CONFIG_HOME")()
+	defer restoreEnv("SUDO_USER")()
+	os.Unsetenv("SUDO_USER")
+
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		scope         Scope
+		xdgConfigHome string
+		expected      string
+	}{
+		{SystemScope, "/xdg", "/etc/systemd/system/cyberdaemon-test.service"},
+		{"", "/xdg", "/etc/systemd/system/cyberdaemon-test.service"},
+		{UserScope, "/xdg", "/xdg/systemd/user/cyberdaemon-test.service"},
+		{UserScope, "", path.Join(current.HomeDir, ".config/systemd/user/cyberdaemon-test.service")},
+		{UserScope, "relative", path.Join(current.HomeDir, ".config/systemd/user/cyberdaemon-test.service")},
+	}
+
+	for _, test := range tests {
+		os.Setenv("XDG_CONFIG_HOME", test.xdgConfigHome)
+
+		userScope, unitFilePath, err := runSettings(ControllerConfig{
+			DaemonID: "cyberdaemon-test",
+			ExePath:  "/usr/bin/app",
+			Scope:    test.scope,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if unitFilePath != test.expected {
+			t.Fatalf("expected unit file path '%s' for scope '%s' and XDG_CONFIG_HOME '%s' - got '%s'",
+				test.expected, test.scope, test.xdgConfigHome, unitFilePath)
+		}
+
+		if (test.scope == UserScope) != (userScope != nil) {
+			t.Fatalf("expected a user scope for scope '%s': %t", test.scope, test.scope == UserScope)
+		}
+		if userScope != nil && (userScope.owner.Uid != current.Uid || userScope.isOtherUser) {
+			t.Fatalf("expected the current user to own the daemon - got '%s'", userScope.owner.Username)
+		}
+	}
+}
+
+func TestNewSystemdUserScopeSudo(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("sudo is only considered when running as root")
+	}
+
+	owner, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("the 'nobody' user does not exist")
+	}
+
+	defer restoreEnv("XDG_CONFIG_HOME")()
+	defer restoreEnv("SUDO_USER")()
+	os.Setenv("XDG_CONFIG_HOME", "/root/.config")
+	os.Setenv("SUDO_USER", owner.Username)
+
+	userScope, err := newSystemdUserScope("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userScope.owner.Username != owner.Username || !userScope.isOtherUser {
+		t.Fatalf("expected the sudo user to own the daemon - got '%s'", userScope.owner.Username)
+	}
+
+	// The XDG_CONFIG_HOME variable belongs to root, not the owner.
+	expected := path.Join(owner.HomeDir, ".config", "systemd", "user")
+	if userScope.unitDirPath() != expected {
+		t.Fatalf("expected unit directory '%s' - got '%s'", expected, userScope.unitDirPath())
+	}
+
+	cmd, err := userScope.command("/bin/systemctl", "--user", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtimeDirPath := path.Join("/run/user", owner.Uid)
+	for _, expected := range []string{
+		"XDG_RUNTIME_DIR=" + runtimeDirPath,
+		"DBUS_SESSION_BUS_ADDRESS=unix:path=" + runtimeDirPath + "/bus",
+		"HOME=" + owner.HomeDir,
+	} {
+		if !containsString(cmd.Env, expected) {
+			t.Fatalf("expected command environment to contain '%s' - got %q", expected, cmd.Env)
+		}
+	}
+
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Credential == nil {
+		t.Fatal("expected command to run as the owner")
+	}
+	if uid := strconv.FormatUint(uint64(cmd.SysProcAttr.Credential.Uid), 10); uid != owner.Uid {
+		t.Fatalf("expected command to run as uid %s - got %s", owner.Uid, uid)
+	}
+}
+
+func TestSystemdUserUnit(t *testing.T) {
+	defer restoreEnv("SUDO_USER")()
+	os.Unsetenv("SUDO_USER")
+
+	config := ControllerConfig{
+		DaemonID: "cyberdaemon-test",
+		ExePath:  "/usr/bin/app",
+		Scope:    UserScope,
+	}
+
+	controller, err := newSystemdController(config, "/bin/systemctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unit := string(controller.unitContents)
+	if !strings.Contains(unit, "\nWantedBy=default.target\n") {
+		t.Fatalf("expected user unit to be wanted by 'default.target' - unit:\n%s", unit)
+	}
+	if strings.Contains(unit, "\nUser=") {
+		t.Fatalf("expected user unit to not set 'User' - unit:\n%s", unit)
+	}
+
+	for _, invalid := range []ControllerConfig{
+		{Group: "adm"},
+		{Capabilities: []string{"CAP_NET_BIND_SERVICE"}},
+		{CreateUser: true},
+		{Directories: Directories{State: []string{"app"}}},
+	} {
+		invalid.DaemonID = config.DaemonID
+		invalid.ExePath = config.ExePath
+		invalid.Scope = UserScope
+
+		_, err := newSystemdController(invalid, "/bin/systemctl")
+		if err == nil {
+			t.Fatalf("expected an error for user unit %+v", invalid)
+		}
+	}
+}
+
+// restoreEnv returns a function that restores the environment variable
+// to its current value.
+func restoreEnv(name string) func() {
+	value, ok := os.LookupEnv(name)
+
+	return func() {
+		if ok {
+			os.Setenv(name, value)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for i := range values {
+		if values[i] == value {
+			return true
+		}
+	}
+
+	return false
+}
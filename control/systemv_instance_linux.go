@@ -0,0 +1,190 @@
+package control
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// systemvTemplateController controls a multi-instance daemon on System V.
+// There is no init.d script for the daemon itself. Each instance is
+// installed as a separate init.d script (e.g., '/etc/init.d/myapp@tenant1')
+// that is generated from the daemon's configuration.
+type systemvTemplateController struct {
+	config      ControllerConfig
+	servicePath string
+	isRedHat    bool
+	initDirPath string
+	install     installSettings
+}
+
+func (o *systemvTemplateController) Status() (Status, error) {
+	names, err := o.Instances()
+	if err != nil {
+		return Unknown, err
+	}
+
+	if len(names) == 0 {
+		return NotInstalled, nil
+	}
+
+	for _, name := range names {
+		instance, err := o.instance(name)
+		if err != nil {
+			return Unknown, err
+		}
+
+		status, err := instance.Status()
+		if err != nil {
+			return Unknown, err
+		}
+
+		if status == Running {
+			return Running, nil
+		}
+	}
+
+	return Stopped, nil
+}
+
+func (o *systemvTemplateController) Install() error {
+	return o.install.beforeInstall()
+}
+
+func (o *systemvTemplateController) Uninstall() error {
+	names, err := o.Instances()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err := o.UninstallInstance(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return o.install.afterUninstall()
+}
+
+func (o *systemvTemplateController) Start() error {
+	return o.eachInstance(Controller.Start)
+}
+
+func (o *systemvTemplateController) Stop() error {
+	return o.eachInstance(Controller.Stop)
+}
+
+func (o *systemvTemplateController) Debug() error {
+	return fmt.Errorf("a multi-instance daemon cannot be debugged - debug one of its instances instead")
+}
+
+func (o *systemvTemplateController) InstallInstance(name string) error {
+	instance, err := o.instance(name)
+	if err != nil {
+		return err
+	}
+
+	return instance.Install()
+}
+
+func (o *systemvTemplateController) Instances() ([]string, error) {
+	filePaths, err := filepath.Glob(path.Join(o.initDirPath, o.config.DaemonID+"*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for instances - %s", err.Error())
+	}
+
+	var names []string
+
+	for _, filePath := range filePaths {
+		name := strings.TrimPrefix(path.Base(filePath), o.config.DaemonID)
+		if validateInstanceName(name) != nil {
+			continue
+		}
+
+		if _, isManaged := systemvMarker(filePath); !isManaged {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (o *systemvTemplateController) UninstallInstance(name string) error {
+	instance, err := o.instance(name)
+	if err != nil {
+		return err
+	}
+
+	return instance.Uninstall()
+}
+
+func (o *systemvTemplateController) Instance(name string) (Controller, error) {
+	return o.instance(name)
+}
+
+func (o *systemvTemplateController) instance(name string) (*systemvController, error) {
+	err := validateInstanceName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	config := o.config
+	config.DaemonID = config.DaemonID + name
+
+	instance, err := newSystemvController(config, o.servicePath, o.isRedHat)
+	if err != nil {
+		return nil, err
+	}
+
+	// The directories are shared by the instances. They are removed
+	// when the multi-instance daemon is uninstalled.
+	instance.install.removeDirPaths = nil
+
+	return instance, nil
+}
+
+// eachInstance calls the function for each of the installed instances.
+func (o *systemvTemplateController) eachInstance(fn func(Controller) error) error {
+	names, err := o.Instances()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		instance, err := o.instance(name)
+		if err != nil {
+			return err
+		}
+
+		err = fn(instance)
+		if err != nil {
+			return fmt.Errorf("instance '%s' - %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func newSystemvTemplateController(config ControllerConfig, serviceExePath string, isRedHat bool) (*systemvTemplateController, error) {
+	// Creating a controller for the daemon validates the configuration
+	// and renders its init.d script, which is not installed.
+	controller, err := newSystemvController(config, serviceExePath, isRedHat)
+	if err != nil {
+		return nil, err
+	}
+
+	return &systemvTemplateController{
+		config:      config,
+		servicePath: serviceExePath,
+		isRedHat:    isRedHat,
+		initDirPath: path.Dir(controller.initFilePath),
+		install:     controller.install,
+	}, nil
+}
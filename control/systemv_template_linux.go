@@ -0,0 +1,480 @@
+package control
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/stephen-fox/cyberdaemon"
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+const (
+	// systemvCoreBlockSize is the size of the blocks that bash's
+	// 'ulimit' builtin uses for core sizes.
+	systemvCoreBlockSize = 1024
+
+	// posixShellCoreBlockSize is the size of the blocks that the
+	// 'ulimit' builtin of POSIX shells (such as dash and BusyBox's
+	// ash) uses for core sizes.
+	posixShellCoreBlockSize = 512
+)
+
+var (
+	daemonIDRegex        = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.@-]*$`)
+	userNameRegex        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*\$?$`)
+	octalModeRegex       = regexp.MustCompile(`^[0-7]{1,4}$`)
+	shellVariableRegex   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	ulimitArgumentsRegex = regexp.MustCompile(`^-[A-Za-z]+( +([0-9]+|unlimited|soft|hard))?$`)
+)
+
+// SystemVTemplateData is the data model used to render a System V init.d
+// script template. The built-in template is DefaultSystemVTemplate.
+//
+// The following functions are available to templates:
+// 	- join: strings.Join (e.g., '{{join .RequiredStart " "}}')
+// 	- shellQuote: Quotes a string so that the shell interprets it as a
+// 	  single literal word (e.g., 'FOO={{shellQuote .ExePath}}')
+// 	- shellQuoteAll: Quotes each string in a list and joins them with
+// 	  spaces (e.g., 'ARGS=({{shellQuoteAll .Arguments}})')
+//
+// Values that cannot be safely represented in an init.d script (such as
+// an argument containing a newline) are rejected by Validate before the
+// template is rendered.
+type SystemVTemplateData struct {
+	// Name is the daemon's ID. It is also the name of the init.d
+	// script, and the value of the 'Provides' LSB header.
+	Name string
+
+	// ShortDescription is the value of the 'Short-Description'
+	// LSB header.
+	ShortDescription string
+
+	// Description is the value of the 'Description' LSB header.
+	Description string
+
+	// ExePath is the path to the daemon's executable.
+	ExePath string
+
+	// Arguments are the command line arguments to pass to the
+	// daemon's executable on startup.
+	Arguments []string
+
+	// Environment is a list of 'NAME=value' environment variable
+	// assignments for the daemon's process, sorted by name.
+	Environment []string
+
+	// EnvironmentFiles is a list of paths to files that are sourced
+	// before the daemon starts. Paths prefixed with '-' are ignored
+	// if the file does not exist.
+	EnvironmentFiles []string
+
+	// WorkDirPath is the daemon's working directory. An empty string
+	// means the working directory is not changed.
+	WorkDirPath string
+
+	// Umask is the daemon's file mode creation mask as an octal
+	// string. An empty string means the umask is not changed.
+	Umask string
+
+	// Group is the daemon's primary group. An empty string means the
+	// RunAs user's primary group is used.
+	Group string
+
+	// SupplementaryGroups are groups that the daemon's process is
+	// added to in addition to the RunAs user's groups. Changing the
+	// groups requires the 'setpriv' utility.
+	SupplementaryGroups []string
+
+	// Capabilities are the Linux capabilities that the daemon's
+	// process is granted (e.g., 'CAP_NET_BIND_SERVICE'). All other
+	// capabilities are removed from its bounding set. Changing the
+	// capabilities requires the 'setpriv' utility.
+	Capabilities []string
+
+	// Directories are absolute paths of directories that are created
+	// each time the daemon starts. They are owned by the RunAs user and
+	// the Group (or the RunAs user's primary group).
+	Directories []string
+
+	// DirectoryMode is the file mode of the Directories as an octal
+	// string.
+	DirectoryMode string
+
+	// InstanceName is the name of the instance of a multi-instance
+	// daemon (e.g., 'tenant1' for 'myapp@tenant1'). It is exported in
+	// the 'CYBERDAEMON_INSTANCE' environment variable. An empty string
+	// means the daemon is not an instance.
+	InstanceName string
+
+	// RestartPolicy is the daemon's restart policy in the format
+	// produced by cyberdaemon.RestartPolicy.String. It is exported in
+	// the 'CYBERDAEMON_RESTART_POLICY' environment variable, which tells
+	// the daemon to supervise itself. An empty string means the daemon
+	// is not restarted.
+	RestartPolicy string
+
+	// RunAs is the user to run the daemon as. An empty string means
+	// the daemon runs as root.
+	RunAs string
+
+	// LogFilePath is the file that the daemon's stderr is redirected
+	// to. An empty string means the output is discarded.
+	LogFilePath string
+
+	// PIDFilePathVar is the name of the shell variable that stores
+	// the PID file path. The daemon parses the init.d script for this
+	// variable to locate its PID file.
+	PIDFilePathVar string
+
+	// PIDFilePath is the path to the daemon's PID file.
+	PIDFilePath string
+
+	// RequiredStart is the list of facilities for the 'Required-Start'
+	// LSB header (e.g., '$network').
+	RequiredStart []string
+
+	// RequiredStop is the list of facilities for the 'Required-Stop'
+	// LSB header.
+	RequiredStop []string
+
+	// ShouldStart is the list of facilities for the 'Should-Start'
+	// LSB header.
+	ShouldStart []string
+
+	// ShouldStop is the list of facilities for the 'Should-Stop'
+	// LSB header.
+	ShouldStop []string
+
+	// DefaultStart is the list of run levels for the 'Default-Start'
+	// LSB header.
+	DefaultStart []string
+
+	// DefaultStop is the list of run levels for the 'Default-Stop'
+	// LSB header.
+	DefaultStop []string
+
+	// StartBefore is the list of facilities for the 'X-Start-Before'
+	// LSB header. The header is omitted if the list is empty.
+	StartBefore []string
+
+	// StopAfter is the list of facilities for the 'X-Stop-After'
+	// LSB header. The header is omitted if the list is empty.
+	StopAfter []string
+
+	// PreStart is a list of shell commands that are run as root before
+	// the daemon starts.
+	PreStart []string
+
+	// Ulimits is a list of arguments for the 'ulimit' shell builtin
+	// that are applied before the daemon starts (e.g., '-n 4096').
+	Ulimits []string
+
+	// Nice is the niceness adjustment for the daemon's process.
+	// Zero means the niceness is not adjusted.
+	Nice int
+
+	// IOSchedulingClass is the I/O scheduling class number that is
+	// passed to 'ionice -c' when the daemon starts (1 for realtime,
+	// 2 for best-effort, and 3 for idle). Zero means the class is
+	// not changed.
+	IOSchedulingClass int
+
+	// LibraryVersion is the version of this library. It is stored in
+	// the 'X-Cyberdaemon-Version' LSB header, which List uses to
+	// identify scripts generated by a Controller.
+	LibraryVersion string
+
+	// ConfigHash is the hash of the ControllerConfig that the script
+	// was generated from. It is stored in the 'X-Cyberdaemon-Config-Hash'
+	// LSB header.
+	ConfigHash string
+}
+
+// Validate returns a non-nil error if the data contains values that
+// cannot be safely represented in an init.d script.
+func (o SystemVTemplateData) Validate() error {
+	if !daemonIDRegex.MatchString(o.Name) {
+		return fmt.Errorf("name '%s' must start with a letter or number and may only contain letters, numbers, '_', '.', '@', and '-'",
+			o.Name)
+	}
+
+	// Lines in the LSB header cannot be continued, and the init.d script
+	// parsers used by this library are line based. Bash cannot represent
+	// null characters in strings.
+	singleLines := map[string]string{
+		"short description": o.ShortDescription,
+		"description":       o.Description,
+		"executable path":   o.ExePath,
+		"log file path":     o.LogFilePath,
+		"pid file path":     o.PIDFilePath,
+		"working directory": o.WorkDirPath,
+		"library version":   o.LibraryVersion,
+		"config hash":       o.ConfigHash,
+	}
+	for i, argument := range o.Arguments {
+		singleLines[fmt.Sprintf("argument %d", i)] = argument
+	}
+	for i, filePath := range o.EnvironmentFiles {
+		singleLines[fmt.Sprintf("environment file %d", i)] = filePath
+	}
+	for field, value := range singleLines {
+		if strings.ContainsAny(value, "\x00\r\n") {
+			return fmt.Errorf("%s '%s' may not contain newlines or null characters", field, value)
+		}
+	}
+
+	if len(o.ExePath) == 0 {
+		return fmt.Errorf("executable path cannot be empty")
+	}
+
+	for _, assignment := range o.Environment {
+		i := strings.Index(assignment, "=")
+		if i < 1 || !shellVariableRegex.MatchString(assignment[:i]) {
+			return fmt.Errorf("environment variable assignment '%s' must be in the format 'NAME=value'", assignment)
+		}
+		if strings.ContainsAny(assignment, "\x00\r\n") {
+			return fmt.Errorf("environment variable '%s' may not contain newlines or null characters", assignment[:i])
+		}
+	}
+
+	if len(o.InstanceName) > 0 {
+		err := validateInstanceName(o.InstanceName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(o.RestartPolicy) > 0 {
+		_, err := cyberdaemon.ParseRestartPolicy(o.RestartPolicy)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(o.Umask) > 0 && !octalModeRegex.MatchString(o.Umask) {
+		return fmt.Errorf("umask '%s' must be an octal number", o.Umask)
+	}
+
+	for _, group := range append([]string{o.Group}, o.SupplementaryGroups...) {
+		if len(group) > 0 && !userNameRegex.MatchString(group) {
+			return fmt.Errorf("group name '%s' is invalid", group)
+		}
+	}
+
+	for _, dirPath := range o.Directories {
+		if !strings.HasPrefix(dirPath, "/") || strings.ContainsAny(dirPath, "\r\n\x00") {
+			return fmt.Errorf("directory path '%s' must be absolute, and may not contain new lines", dirPath)
+		}
+	}
+
+	if len(o.DirectoryMode) > 0 && !octalModeRegex.MatchString(o.DirectoryMode) {
+		return fmt.Errorf("directory mode '%s' must be an octal number", o.DirectoryMode)
+	}
+
+	err := validateCapabilities(o.Capabilities)
+	if err != nil {
+		return err
+	}
+
+	if len(o.RunAs) > 0 && !userNameRegex.MatchString(o.RunAs) {
+		return fmt.Errorf("user name '%s' is invalid", o.RunAs)
+	}
+
+	if !shellVariableRegex.MatchString(o.PIDFilePathVar) {
+		return fmt.Errorf("pid file path variable name '%s' is not a valid shell variable name", o.PIDFilePathVar)
+	}
+
+	headerLists := map[string][]string{
+		"Required-Start": o.RequiredStart,
+		"Required-Stop":  o.RequiredStop,
+		"Should-Start":   o.ShouldStart,
+		"Should-Stop":    o.ShouldStop,
+		"Default-Start":  o.DefaultStart,
+		"Default-Stop":   o.DefaultStop,
+		"X-Start-Before": o.StartBefore,
+		"X-Stop-After":   o.StopAfter,
+	}
+	for header, values := range headerLists {
+		for _, value := range values {
+			if len(value) == 0 || strings.ContainsAny(value, " \t\x00\r\n") {
+				return fmt.Errorf("'%s' value '%s' must be non-empty and may not contain whitespace",
+					header, value)
+			}
+		}
+	}
+
+	for _, command := range o.PreStart {
+		if strings.ContainsAny(command, "\x00") {
+			return fmt.Errorf("pre-start command '%s' may not contain null characters", command)
+		}
+	}
+
+	for _, ulimit := range o.Ulimits {
+		if !ulimitArgumentsRegex.MatchString(ulimit) {
+			return fmt.Errorf("ulimit arguments '%s' must be an option followed by an optional number or 'unlimited'",
+				ulimit)
+		}
+	}
+
+	if o.IOSchedulingClass < 0 || o.IOSchedulingClass > 3 {
+		return fmt.Errorf("I/O scheduling class %d must be between 0 and 3", o.IOSchedulingClass)
+	}
+
+	return nil
+}
+
+// newSystemvTemplateData returns the default template data for the
+// provided configuration.
+func newSystemvTemplateData(config ControllerConfig, logFilePath string) *SystemVTemplateData {
+	return &SystemVTemplateData{
+		Name:                config.DaemonID,
+		ShortDescription:    fmt.Sprintf("%s daemon.", config.DaemonID),
+		Description:         config.Description,
+		ExePath:             config.ExePath,
+		Arguments:           config.Arguments,
+		Environment:         environmentAssignments(config.Environment),
+		EnvironmentFiles:    config.EnvironmentFiles,
+		WorkDirPath:         config.WorkDirPath,
+		Umask:               config.Umask,
+		Group:               config.Group,
+		SupplementaryGroups: config.SupplementaryGroups,
+		Capabilities:        config.Capabilities,
+		Directories:         config.Directories.paths(),
+		DirectoryMode:       fmt.Sprintf("%04o", uint32(config.Directories.mode())),
+		InstanceName:        instanceName(config.DaemonID),
+		RestartPolicy:       restartPolicyEnvValue(config.RestartPolicy),
+		RunAs:               config.RunAs,
+		Ulimits:             ulimitArguments(config.ResourceLimits, systemvCoreBlockSize),
+		Nice:                config.ResourceLimits.Nice,
+		IOSchedulingClass:   config.ResourceLimits.IOSchedulingClass.ioniceClass(),
+		LogFilePath:         logFilePath,
+		PIDFilePathVar:      cyberdaemon.PIDFilePathVar,
+		PIDFilePath:         defaultPidFilePath(config.DaemonID),
+		RequiredStart:       append([]string{"$local_fs", "$syslog"}, config.Dependencies.Requires...),
+		RequiredStop:        append([]string{"$local_fs", "$syslog"}, config.Dependencies.Requires...),
+		ShouldStart:         append([]string{"$syslog"}, config.Dependencies.explicitAfter()...),
+		ShouldStop:          append([]string{"$syslog"}, config.Dependencies.explicitAfter()...),
+		DefaultStart:        []string{"2", "3", "4", "5"},
+		DefaultStop:         []string{"0", "1", "6"},
+		StartBefore:         config.Dependencies.Before,
+		StopAfter:           config.Dependencies.Before,
+		LibraryVersion:      cyberdaemon.Version,
+		ConfigHash:          config.hash(),
+	}
+}
+
+// ulimitArguments returns the 'ulimit' arguments that apply the resource
+// limits. The shell expects core sizes in blocks of the specified size
+// (1024 bytes for bash), so they are rounded up.
+func ulimitArguments(limits ResourceLimits, coreBlockSize uint64) []string {
+	var ulimits []string
+
+	if limits.OpenFiles > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("-n %d", limits.OpenFiles))
+	}
+
+	if limits.Processes > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("-u %d", limits.Processes))
+	}
+
+	if len(limits.CoreSize) > 0 {
+		coreSize, _ := parseSizeLimit(limits.CoreSize, true)
+		if coreSize != unlimitedSize {
+			size, _ := strconv.ParseUint(coreSize, 10, 64)
+			coreSize = strconv.FormatUint(size/coreBlockSize+(size%coreBlockSize+coreBlockSize-1)/coreBlockSize, 10)
+		}
+		ulimits = append(ulimits, "-c "+coreSize)
+	}
+
+	return ulimits
+}
+
+// renderSystemvScript renders the init.d script for the provided
+// configuration, honoring any template related options.
+func renderSystemvScript(config ControllerConfig, logFilePath string) (string, error) {
+	data := newSystemvTemplateData(config, logFilePath)
+
+	if v, ok := config.SystemSpecificOptions[SystemVTemplateDataOption]; ok {
+		editFn, ok := v.(EditSystemVTemplateData)
+		if !ok {
+			return "", fmt.Errorf("the '%s' option must be an EditSystemVTemplateData function (type assertion failure)",
+				SystemVTemplateDataOption)
+		}
+
+		err := editFn(data)
+		if err != nil {
+			return "", fmt.Errorf("failed to edit init.d script template data - %s", err.Error())
+		}
+	}
+
+	err := data.Validate()
+	if err != nil {
+		return "", fmt.Errorf("init.d script template data is invalid - %s", err.Error())
+	}
+
+	tmpl, err := template.New("systemv").
+		Funcs(template.FuncMap{
+			"join":          strings.Join,
+			"shellQuote":    osutil.ShellQuote,
+			"shellQuoteAll": shellQuoteAll,
+		}).
+		Option("missingkey=error").
+		Parse(DefaultSystemVTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse built-in init.d script template - %s", err.Error())
+	}
+
+	if v, ok := config.SystemSpecificOptions[SystemVTemplateOption]; ok {
+		custom, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("the '%s' option must be a string (type assertion failure)",
+				SystemVTemplateOption)
+		}
+
+		tmpl, err = tmpl.Parse(custom)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse custom init.d script template - %s", err.Error())
+		}
+	}
+
+	buff := bytes.NewBuffer(nil)
+	err = tmpl.Execute(buff, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render init.d script template - %s", err.Error())
+	}
+
+	return buff.String(), nil
+}
+
+// shellQuoteAll quotes each string using osutil.ShellQuote and joins the
+// results with spaces.
+func shellQuoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i := range values {
+		quoted[i] = osutil.ShellQuote(values[i])
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// environmentAssignments converts a map of environment variables into a
+// list of 'NAME=value' assignments sorted by name.
+func environmentAssignments(environment map[string]string) []string {
+	var names []string
+	for name := range environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var assignments []string
+	for _, name := range names {
+		assignments = append(assignments, name+"="+environment[name])
+	}
+
+	return assignments
+}
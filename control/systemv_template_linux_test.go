@@ -0,0 +1,135 @@
+package control
+
+import (
+	"bytes"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderSystemvScript(t *testing.T) {
+	tests := []struct {
+		exePath     string
+		argument1   string
+		argument2   string
+		envName     string
+		envValue    string
+		logFilePath string
+		workDirPath string
+	}{
+		{"/usr/bin/app", "a b", "$(id)", "FOO", "bar baz", "/var/log/app/app.log", "/srv/app"},
+		{"/opt/my app/app", "c;d", "`id`", "QUOTES", `'single' "double"`, "/var/log/it's/app.log", ""},
+		{"/usr/bin/app", "back\\slash", "%s %q", "_X1", "a=b", "", "/tmp/$HOME"},
+		{"/usr/bin/app", "tab\tseparated", "", "NEWLINE", "a\nb", "/var/log/app.log", "/"},
+		{"", "a", "b", "1INVALID", "value", "/var/log/app.log", "/"},
+		{"/usr/bin/app\r", "null\x00", "-", "A=B", "c", "/var/log/\napp.log", "/srv"},
+		{"/usr/bin/app", "glob*", "> redirect", "A", "$(touch injected)", "/var/log/app.log", "/srv/my app"},
+	}
+
+	bashPath, _ := exec.LookPath("bash")
+
+	for _, test := range tests {
+		config := ControllerConfig{
+			DaemonID:    "cyberdaemon-test",
+			ExePath:     test.exePath,
+			Arguments:   []string{test.argument1, test.argument2},
+			Environment: map[string]string{test.envName: test.envValue},
+			WorkDirPath: test.workDirPath,
+			RunAs:       "nobody",
+			Umask:       "0027",
+		}
+
+		script, err := renderSystemvScript(config, test.logFilePath)
+
+		assignment := test.envName + "=" + test.envValue
+		values := test.exePath + test.argument1 + test.argument2 + assignment + test.logFilePath + test.workDirPath
+		representable := len(test.exePath) > 0 &&
+			!strings.ContainsAny(values, "\x00\r\n") &&
+			shellVariableRegex.MatchString(assignment[:strings.Index(assignment, "=")])
+		if !representable {
+			if err == nil {
+				t.Fatalf("expected an error for a value that cannot be represented - script:\n%s", script)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to render representable values - %s", err.Error())
+		}
+
+		variables := map[string]string{
+			"PROGRAM_PATH":  test.exePath,
+			"WORK_DIR_PATH": test.workDirPath,
+		}
+		for name, expected := range variables {
+			actual, _, err := shellVariableValue(script, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != expected {
+				t.Fatalf("expected %s to be %q - got %q", name, expected, actual)
+			}
+		}
+
+		arrays := map[string][]string{
+			"ARGUMENTS":   {test.argument1, test.argument2},
+			"ENVIRONMENT": {assignment},
+		}
+		for name, expected := range arrays {
+			actual, _, err := shellArrayValue(script, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Fatalf("expected %s to be %q - got %q", name, expected, actual)
+			}
+		}
+
+		if len(bashPath) == 0 {
+			continue
+		}
+
+		output, err := exec.Command(bashPath, "-n", "-c", script).CombinedOutput()
+		if err != nil {
+			t.Fatalf("script is not valid bash - %s - output: %s\nscript:\n%s", err.Error(), output, script)
+		}
+
+		words, err := suCommandWords(bashPath, script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{test.exePath, test.argument1, test.argument2}
+		if !reflect.DeepEqual(words, expected) {
+			t.Fatalf("expected su command words %q - got %q", expected, words)
+		}
+	}
+}
+
+// suCommandWords runs the 'su_command' function of a rendered init.d
+// script, and returns the words that the resulting command executes.
+// 'exec' is replaced by a function that prints its arguments.
+func suCommandWords(bashPath string, script string) ([]string, error) {
+	suCommand, err := shellFunction(script, "su_command")
+	if err != nil {
+		return nil, err
+	}
+
+	harness := bytes.NewBuffer(nil)
+	for _, line := range strings.Split(script, "\n") {
+		for _, name := range []string{"PROGRAM_PATH=", "ARGUMENTS=", "UMASK="} {
+			if strings.HasPrefix(line, name) {
+				harness.WriteString(line + "\n")
+			}
+		}
+	}
+	harness.WriteString(suCommand + "\n")
+	harness.WriteString("su_command /dev/null\n")
+	harness.WriteString("exec bash -c \"exec() { printf '%s\\0' \\\"\\$@\\\"; }; ${command}\"\n")
+
+	output, err := exec.Command(bashPath, "-c", harness.String()).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00"), nil
+}
@@ -0,0 +1,105 @@
+package cyberdaemon
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/stephen-fox/cyberdaemon/internal/supervisor"
+	"github.com/stephen-fox/cyberdaemon/pidfile"
+)
+
+// builtinDaemonizer runs the daemon as the built-in supervisor, which is
+// used by the control package when the operating system does not provide
+// a daemon manager. The supervisor is started by a Controller, which sets
+// the supervisor.DirEnv environment variable, detaches the process, and
+// redirects its output.
+//
+// The supervisor holds a lock on the PID file in the runtime directory
+// while it runs, and listens on the runtime directory's control socket
+// once the Application has started. The Controller waits for the socket
+// to accept commands before reporting that the daemon started. When the
+// daemon has a restart policy, the supervisorDaemonizer runs the real
+// Application in a child process.
+type builtinDaemonizer struct {
+	logConfig LogConfig
+}
+
+func (o *builtinDaemonizer) RunUntilExit(application Application) error {
+	dirPath := os.Getenv(supervisor.DirEnv)
+	os.Unsetenv(supervisor.DirEnv)
+
+	if o.logConfig.UseNativeLogger {
+		log.SetOutput(os.Stderr)
+
+		if o.logConfig.NativeLogFlags > 0 {
+			originalLogFlags := log.Flags()
+			log.SetFlags(o.logConfig.NativeLogFlags)
+			defer log.SetFlags(originalLogFlags)
+		}
+	}
+
+	pidFile, err := pidfile.Acquire(supervisor.PIDFilePath(dirPath))
+	if err != nil {
+		return fmt.Errorf("failed to acquire pid file - %s", err.Error())
+	}
+	defer pidFile.Release()
+
+	// The socket is left behind if the supervisor is killed. The PID
+	// file guarantees that no other supervisor is using it.
+	socketPath := supervisor.SocketPath(dirPath)
+	err = os.Remove(socketPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale control socket - %s", err.Error())
+	}
+
+	// Signals are handled before the Application starts so that
+	// a restart policy that gives up immediately stops the daemon
+	// cleanly.
+	interruptsAndTerms := make(chan os.Signal, 1)
+	signal.Notify(interruptsAndTerms, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interruptsAndTerms)
+
+	err = application.Start()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		application.Stop()
+		return fmt.Errorf("failed to listen on control socket - %s", err.Error())
+	}
+
+	go supervisor.Serve(listener, func(command string) (string, func()) {
+		switch command {
+		case supervisor.StatusCommand:
+			return supervisor.RunningReply, nil
+		case supervisor.StopCommand:
+			return supervisor.StoppingReply, func() {
+				select {
+				case interruptsAndTerms <- syscall.SIGTERM:
+				default:
+				}
+			}
+		}
+
+		return supervisor.UnknownCommandReply, nil
+	})
+
+	<-interruptsAndTerms
+
+	// Closing the listener removes the socket.
+	listener.Close()
+
+	return application.Stop()
+}
+
+func newBuiltinDaemonizer(config DaemonizerConfig) Daemonizer {
+	return &builtinDaemonizer{
+		logConfig: config.LogConfig,
+	}
+}
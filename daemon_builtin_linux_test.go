@@ -0,0 +1,162 @@
+package cyberdaemon
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon/internal/supervisor"
+	"github.com/stephen-fox/cyberdaemon/pidfile"
+)
+
+type testApplication struct {
+	started chan struct{}
+	stopped chan struct{}
+}
+
+func (o *testApplication) Start() error {
+	close(o.started)
+	return nil
+}
+
+func (o *testApplication) Stop() error {
+	close(o.stopped)
+	return nil
+}
+
+func TestBuiltinDaemonizer(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	defer os.Unsetenv(supervisor.DirEnv)
+	os.Setenv(supervisor.DirEnv, dirPath)
+
+	application := &testApplication{
+		started: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- newBuiltinDaemonizer(DaemonizerConfig{}).RunUntilExit(application)
+	}()
+
+	select {
+	case <-application.started:
+	case err := <-exited:
+		t.Fatalf("supervisor exited before the application started - %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the application was not started")
+	}
+
+	if _, ok := os.LookupEnv(supervisor.DirEnv); ok {
+		t.Fatalf("expected %s to be removed from the environment", supervisor.DirEnv)
+	}
+
+	// The socket is created after the application starts.
+	var reply string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		reply, err = supervisor.Send(dirPath, supervisor.StatusCommand, time.Second)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != supervisor.RunningReply {
+		t.Fatalf("expected reply '%s' - got '%s'", supervisor.RunningReply, reply)
+	}
+
+	pid, isRunning, err := pidfile.IsRunning(supervisor.PIDFilePath(dirPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isRunning || pid != os.Getpid() {
+		t.Fatalf("expected the supervisor's pid %d to be running - got pid %d, running: %t",
+			os.Getpid(), pid, isRunning)
+	}
+
+	reply, err = supervisor.Send(dirPath, "restart", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != supervisor.UnknownCommandReply {
+		t.Fatalf("expected reply '%s' - got '%s'", supervisor.UnknownCommandReply, reply)
+	}
+
+	reply, err = supervisor.Send(dirPath, supervisor.StopCommand, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != supervisor.StoppingReply {
+		t.Fatalf("expected reply '%s' - got '%s'", supervisor.StoppingReply, reply)
+	}
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the supervisor did not exit after the stop command")
+	}
+
+	select {
+	case <-application.stopped:
+	default:
+		t.Fatal("expected the application to be stopped")
+	}
+
+	_, err = os.Stat(supervisor.SocketPath(dirPath))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected the control socket to be removed - stat returned: %v", err)
+	}
+
+	_, isRunning, err = pidfile.IsRunning(supervisor.PIDFilePath(dirPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isRunning {
+		t.Fatal("expected the pid file to be released")
+	}
+}
+
+func TestBuiltinDaemonizerAlreadyRunning(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	pidFile, err := pidfile.Acquire(supervisor.PIDFilePath(dirPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pidFile.Release()
+
+	defer os.Unsetenv(supervisor.DirEnv)
+	os.Setenv(supervisor.DirEnv, dirPath)
+
+	application := &testApplication{
+		started: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	err = newBuiltinDaemonizer(DaemonizerConfig{}).RunUntilExit(application)
+	if err == nil {
+		t.Fatal("expected an error when another supervisor holds the pid file")
+	}
+
+	select {
+	case <-application.started:
+		t.Fatal("expected the application to not be started")
+	default:
+	}
+}
@@ -30,7 +30,7 @@ func (o *darwinDaemonizer) RunUntilExit(application Application) error {
 		return err
 	}
 
-	interruptsAndTerms := make(chan os.Signal)
+	interruptsAndTerms := make(chan os.Signal, 1)
 	signal.Notify(interruptsAndTerms, os.Interrupt, syscall.SIGTERM)
 	<-interruptsAndTerms
 	signal.Stop(interruptsAndTerms)
@@ -39,7 +39,32 @@ func (o *darwinDaemonizer) RunUntilExit(application Application) error {
 }
 
 func NewDaemonizer(logConfig LogConfig) Daemonizer {
-	return &darwinDaemonizer{
-		logConfig: logConfig,
+	return NewDaemonizerWithConfig(DaemonizerConfig{
+		LogConfig: logConfig,
+	})
+}
+
+// NewDaemonizerWithConfig returns a Daemonizer for the current system
+// using the provided configuration.
+func NewDaemonizerWithConfig(config DaemonizerConfig) Daemonizer {
+	err := config.Validate()
+	if err != nil {
+		return &errDaemonizer{
+			reason: err.Error(),
+		}
+	}
+
+	var daemonizer Daemonizer = &darwinDaemonizer{
+		logConfig: config.LogConfig,
 	}
+
+	if config.DetachConfig.Detach {
+		daemonizer = newDetachDaemonizer(config, daemonizer)
+	}
+
+	if len(config.PrivilegeDropConfig.User) > 0 {
+		return newPrivilegeDropDaemonizer(config, daemonizer)
+	}
+
+	return daemonizer
 }
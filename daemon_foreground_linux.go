@@ -0,0 +1,61 @@
+package cyberdaemon
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const (
+	// supervisordEnabledEnv is the environment variable that supervisord
+	// sets for the processes it starts.
+	supervisordEnabledEnv = "SUPERVISOR_ENABLED"
+)
+
+// foregroundDaemonizer runs a daemon that is started by a supervisor
+// that expects the daemon to run in the foreground, so the process does
+// not fork. It is used on OpenRC (by 'supervise-daemon', and by
+// 'start-stop-daemon' when it is told to background the daemon), on
+// runit and s6, and by supervisord. The daemon's stderr is redirected
+// to its log file (or logger) by the supervisor.
+type foregroundDaemonizer struct {
+	logConfig LogConfig
+}
+
+func (o *foregroundDaemonizer) RunUntilExit(application Application) error {
+	// Only do native log things when running non-interactively.
+	// The 'PS1' environment variable will be empty / not set when
+	// this is run non-interactively.
+	if o.logConfig.UseNativeLogger && len(os.Getenv("PS1")) == 0 {
+		log.SetOutput(os.Stderr)
+
+		if o.logConfig.NativeLogFlags > 0 {
+			originalLogFlags := log.Flags()
+			log.SetFlags(o.logConfig.NativeLogFlags)
+			defer log.SetFlags(originalLogFlags)
+		}
+	}
+
+	// Signals are handled before the application starts so that
+	// a restart policy that gives up immediately (by signaling this
+	// process) stops the daemon cleanly.
+	interruptsAndTerms := make(chan os.Signal, 1)
+	signal.Notify(interruptsAndTerms, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interruptsAndTerms)
+
+	err := application.Start()
+	if err != nil {
+		return err
+	}
+
+	<-interruptsAndTerms
+
+	return application.Stop()
+}
+
+func newForegroundDaemonizer(logConfig LogConfig) Daemonizer {
+	return &foregroundDaemonizer{
+		logConfig: logConfig,
+	}
+}
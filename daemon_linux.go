@@ -1,30 +1,62 @@
 package cyberdaemon
 
 import (
-	"fmt"
+	"os"
 
 	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+	"github.com/stephen-fox/cyberdaemon/internal/supervisor"
 )
 
-type errDaemonizer struct {
-	reason string
+func NewDaemonizer(logConfig LogConfig) Daemonizer {
+	return NewDaemonizerWithConfig(DaemonizerConfig{
+		LogConfig: logConfig,
+	})
 }
 
-func (o *errDaemonizer) RunUntilExit(_ Application) error {
-	return fmt.Errorf("no suitable daemonization logic available for this system - %s", o.reason)
-}
+// NewDaemonizerWithConfig returns a Daemonizer for the current system
+// using the provided configuration.
+func NewDaemonizerWithConfig(config DaemonizerConfig) Daemonizer {
+	err := config.Validate()
+	if err != nil {
+		return &errDaemonizer{
+			reason: err.Error(),
+		}
+	}
 
-func NewDaemonizer(logConfig LogConfig) Daemonizer {
-	if _, isSystemd := osutil.IsSystemd(); isSystemd {
-		return newSystemdDaemonizer(logConfig)
+	var daemonizer Daemonizer
+	if _, isBuiltin := os.LookupEnv(supervisor.DirEnv); isBuiltin {
+		daemonizer = newBuiltinDaemonizer(config)
+	} else if _, isSupervisord := os.LookupEnv(supervisordEnabledEnv); isSupervisord {
+		daemonizer = newForegroundDaemonizer(config.LogConfig)
+	} else if _, isSystemd := osutil.IsSystemd(); isSystemd {
+		daemonizer = newSystemdDaemonizer(config.LogConfig)
+	} else if _, isOpenRC := osutil.IsOpenRC(); isOpenRC {
+		daemonizer = newForegroundDaemonizer(config.LogConfig)
+	} else if _, _, isRunit := osutil.IsRunit(); isRunit {
+		daemonizer = newForegroundDaemonizer(config.LogConfig)
+	} else if _, _, isS6 := osutil.IsS6(); isS6 {
+		daemonizer = newForegroundDaemonizer(config.LogConfig)
+	} else if _, _, notVReason, isSystemv := osutil.IsSystemv(); isSystemv {
+		daemonizer = newSystemvDaemonizer(config)
+	} else {
+		daemonizer = &errDaemonizer{
+			reason: notVReason,
+		}
 	}
 
-	_, _, notVReason, isSystemv := osutil.IsSystemv()
-	if isSystemv {
-		return newSystemvDaemonizer(logConfig)
+	if config.DetachConfig.Detach {
+		daemonizer = newDetachDaemonizer(config, daemonizer)
 	}
 
-	return &errDaemonizer{
-		reason: notVReason,
+	// The supervisor is only used when the restart policy environment
+	// variable is set (which systemd units, OpenRC scripts, runit and s6
+	// service directories, and supervisord programs do not do - they
+	// restart daemons themselves), and in the processes that it starts.
+	daemonizer = newSupervisorDaemonizer(config, daemonizer)
+
+	if len(config.PrivilegeDropConfig.User) > 0 {
+		return newPrivilegeDropDaemonizer(config, daemonizer)
 	}
+
+	return daemonizer
 }
@@ -34,7 +34,7 @@ func (o *systemdDaemonizer) RunUntilExit(application Application) error {
 		return err
 	}
 
-	interruptsAndTerms := make(chan os.Signal)
+	interruptsAndTerms := make(chan os.Signal, 1)
 	signal.Notify(interruptsAndTerms, os.Interrupt, syscall.SIGTERM)
 	<-interruptsAndTerms
 	signal.Stop(interruptsAndTerms)
@@ -4,27 +4,37 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path"
-	"strconv"
 	"strings"
 	"syscall"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+	"github.com/stephen-fox/cyberdaemon/pidfile"
 )
 
 const (
-	pidFilePerm    = 0644
 	PIDFilePathVar = "PID_FILE_PATH"
+
+	// pidFilePathEnv is the environment variable used to pass the PID
+	// file path to the daemon process. Its presence also tells the
+	// process that it is the daemon (and not the init.d-started process).
+	pidFilePathEnv = "CYBERDAEMON_PID_FILE_PATH"
 )
 
 type systemvDaemonizer struct {
-	logConfig LogConfig
+	logConfig    LogConfig
+	initdConfig  InitdConfig
+	startTimeout time.Duration
 }
 
 func (o *systemvDaemonizer) RunUntilExit(application Application) error {
+	notifier := &readinessNotifier{}
+
 	// The 'PS1' environment variable will be empty / not set when
 	// this is run non-interactively.
 	if len(os.Getenv("PS1")) == 0 {
@@ -39,175 +49,103 @@ func (o *systemvDaemonizer) RunUntilExit(application Application) error {
 			}
 		}
 
-		// Check if init.d started us. If it did, then we need to
-		// forkexec (AKA, start a new process and exit this one).
-		// We do this because init.d expects the process to fork and
-		// not block.
-		//
-		// Golang cannot fork because forking only provides the new
-		// process with a single thread. The runtime needs more than
-		// one thread to run - so that is not an option.
-		if initdScriptPath, startedByInitd, err := isInitdOurParent(); startedByInitd {
-			exePath, err := os.Executable()
-			if err != nil {
-				return fmt.Errorf("failed to get executable path when exec'ing daemon - %s", err.Error())
-			}
-
-			// TODO: Just use 'os.Args[0]' as the path?
-			daemon := exec.Command(exePath, os.Args[1:]...)
-			if o.logConfig.UseNativeLogger {
-				// Set stderr of new process to the current
-				// stderr so that input redirection will
-				// be honored.
-				daemon.Stderr = os.Stderr
-			}
-
-			// Either get the PID file from the init.d script,
-			// or try a sane default.
-			pidFilePath, findErr := pidFilePathFromInitdScript(initdScriptPath)
-			if findErr != nil {
-				pidFilePath = DefaultPidFilePath(path.Base(initdScriptPath))
-			}
+		// The daemon process started by the init.d-started process
+		// is identified by the PID file path environment variable.
+		if pidFilePath, isDaemonProcess := os.LookupEnv(pidFilePathEnv); isDaemonProcess {
+			os.Unsetenv(pidFilePathEnv)
 
-			pidFile, err := os.OpenFile(pidFilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, pidFilePerm)
+			var err error
+			notifier, err = newReadinessNotifier()
 			if err != nil {
-				return fmt.Errorf("failed to open pid file - %s", err.Error())
+				return err
 			}
 
-			// Pass the PID file to the process as file
-			// descriptor number 3 (per the 'ExtraFiles'
-			// documentation).
-			daemon.ExtraFiles = []*os.File{pidFile}
-
-			err = daemon.Start()
+			pidFile, err := pidfile.Acquire(pidFilePath)
 			if err != nil {
-				return fmt.Errorf("failed to exec daemon process - %s", err.Error())
+				err = fmt.Errorf("failed to acquire pid file - %s", err.Error())
+				notifier.notify(err)
+				return err
 			}
-
-			_, err = pidFile.WriteString(fmt.Sprintf("%d\n", daemon.Process.Pid))
+			defer pidFile.Release()
+		} else if diagnosis, err := newInitdResolver(o.initdConfig).resolve(); diagnosis.StartedByInitd {
+			// Check if init.d started us. If it did, then we need to
+			// forkexec (AKA, start a new process and exit this one).
+			// We do this because init.d expects the process to fork
+			// and not block.
+			//
+			// Golang cannot fork because forking only provides the
+			// new process with a single thread. The runtime needs
+			// more than one thread to run - so that is not an option.
+			err := startDaemonProcess(diagnosis.ScriptPath, o.logConfig, o.startTimeout)
 			if err != nil {
-				return fmt.Errorf("failed to write daemon pid to pid file - %s", err.Error())
+				return err
 			}
 
-			// Exit.
-			// TODO: Should we just os.Exit() here? Can we trust
-			//  the implementer to properly structure their code?
-			return nil
+			// Exit rather than returning to the application's code.
+			// Returning would be indistinguishable from the daemon
+			// stopping, and any clean up code that the application
+			// runs afterwards could interfere with the daemon.
+			os.Exit(0)
 		} else if err != nil {
 			return fmt.Errorf("failed to determine if init.d started the process - %s", err.Error())
 		}
-
-		// Now we are running as a daemon. File descriptor 3 will be
-		// the PID file.
-		pidFile := os.NewFile(3, "")
-		if pidFile == nil {
-			return fmt.Errorf("failed to open pid file passed to daemon - file descriptor might of been crushed?")
-		}
-		defer func() {
-			// Debian does not remove the PID file for us
-			// (perhaps other OSes might not either).
-			// Delete the contents of the file so the OS
-			// knows the process stopped cleanly.
-			pidFile.Seek(0, 0)
-			pidFile.Truncate(0)
-			pidFile.Close()
-		}()
 	}
 
+	// Signals are handled before the application starts so that
+	// a restart policy that gives up immediately (by signaling this
+	// process) stops the daemon cleanly.
+	interruptsAndTerms := make(chan os.Signal, 1)
+	signal.Notify(interruptsAndTerms, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interruptsAndTerms)
+
+	// Let the init.d-started process know whether the application
+	// started so that it can exit with the appropriate status.
 	err := application.Start()
+	notifier.notify(err)
 	if err != nil {
 		return err
 	}
 
-	interruptsAndTerms := make(chan os.Signal)
-	signal.Notify(interruptsAndTerms, os.Interrupt, syscall.SIGTERM)
 	<-interruptsAndTerms
-	signal.Stop(interruptsAndTerms)
 
 	return application.Stop()
 }
 
-func isInitdOurParent() (scriptPath string, isInitd bool, err error) {
-	pid := os.Getppid()
-	for i := 0; i < 5; i++ {
-		if i != 0 {
-			pid, err = manualParentPid(pid)
-			if err != nil {
-				return "", false, err
-			}
-			if pid == 0 {
-				return "", false, nil
-			}
-		}
-
-		scriptPath, isInitd, err = isPidInitd(pid)
-		if err != nil {
-			return "", false, err
-		}
-
-		if isInitd {
-			return scriptPath, true, nil
-		}
-	}
-
-	return "", false, nil
-}
-
-func manualParentPid(pid int) (int, error) {
-	contents, err := tinyRead(fmt.Sprintf("/proc/%d/stat", pid))
+// startDaemonProcess starts a new instance of the current executable
+// as the daemon process, and waits for it to report whether the
+// application started.
+func startDaemonProcess(initdScriptPath string, logConfig LogConfig, startTimeout time.Duration) error {
+	exePath, err := os.Executable()
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("failed to get executable path when exec'ing daemon - %s", err.Error())
 	}
 
-	split := strings.Split(contents, " ")
-	if len(split) < 4 {
-		return 0, fmt.Errorf("stat file for pid %d has too few elements to get parent pid", pid)
+	// Either get the PID file from the init.d script,
+	// or try a sane default.
+	pidFilePath, findErr := pidFilePathFromInitdScript(initdScriptPath)
+	if findErr != nil {
+		pidFilePath = DefaultPidFilePath(path.Base(initdScriptPath))
 	}
 
-	parentPid, err := strconv.ParseUint(split[3], 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("failed to convert parent pid for pid %d - %s", pid, err.Error())
+	if pid, isRunning, _ := pidfile.IsRunning(pidFilePath); isRunning {
+		return fmt.Errorf("daemon is already running with pid %d", pid)
 	}
 
-	return int(parentPid), nil
-}
-
-func isPidInitd(pid int) (scriptPath string, isInitd bool, err error) {
-	// The 'cmdline' file contains the command line arguments of the
-	// process separated by null. We can derive whether the process
-	// is init.d by looking at its cmdline.
-	cmdlineContents, err := tinyRead(fmt.Sprintf("/proc/%d/cmdline", pid))
-	if err != nil {
-		return "", false, fmt.Errorf("failed to read cmdline for process %d - %s", pid, err.Error())
+	daemon := exec.Command(exePath, os.Args[1:]...)
+	daemon.Env = append(os.Environ(), pidFilePathEnv+"="+pidFilePath)
+	if logConfig.UseNativeLogger {
+		// Set stderr of new process to the current
+		// stderr so that input redirection will
+		// be honored.
+		daemon.Stderr = os.Stderr
 	}
-
-	// File contents are null terminated.
-	splitContents := strings.Split(string(cmdlineContents), "\x00")
-	for i := range splitContents {
-		switch i {
-		case 0, 1, 2, 3:
-			if strings.HasPrefix(splitContents[i], "/etc/init.d/") {
-				return splitContents[i], true, nil
-			}
-		}
+	// Detach the daemon from the terminal (if any) that
+	// the init.d script was run from.
+	daemon.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
 	}
 
-	return "", false, nil
-}
-
-func tinyRead(filePath string) (string, error) {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	contents, err := ioutil.ReadAll(io.LimitReader(f, 100000))
-	if err != nil {
-		return "", err
-	}
-
-	return string(contents), nil
+	return startAndWaitForReady(daemon, startTimeout)
 }
 
 func pidFilePathFromInitdScript(scriptPath string) (string, error) {
@@ -223,7 +161,14 @@ func pidFilePathFromInitdScript(scriptPath string) (string, error) {
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(line, prefix) {
-			return strings.Trim(strings.TrimPrefix(line, prefix), "\"'"), nil
+			words, err := osutil.ShellWords(strings.TrimPrefix(line, prefix))
+			if err != nil {
+				return "", fmt.Errorf("failed to parse pid file path in init.d script - %s", err.Error())
+			}
+			if len(words) == 0 {
+				return "", fmt.Errorf("pid file path ('%s') in init.d script is empty", prefix)
+			}
+			return words[0], nil
 		}
 	}
 
@@ -235,9 +180,11 @@ func pidFilePathFromInitdScript(scriptPath string) (string, error) {
 	return "", fmt.Errorf("failed to find pid file path ('%s') in init.d script", prefix)
 }
 
-func newSystemvDaemonizer(logConfig LogConfig) Daemonizer {
+func newSystemvDaemonizer(config DaemonizerConfig) Daemonizer {
 	return &systemvDaemonizer{
-		logConfig: logConfig,
+		logConfig:    config.LogConfig,
+		initdConfig:  config.InitdConfig,
+		startTimeout: config.DetachConfig.startTimeout(),
 	}
 }
 
@@ -25,7 +25,7 @@ func (o *windowsDaemonizer) RunUntilExit(application Application) error {
 			return err
 		}
 
-		interrupts := make(chan os.Signal)
+		interrupts := make(chan os.Signal, 1)
 		signal.Notify(interrupts, os.Interrupt)
 		<-interrupts
 		signal.Stop(interrupts)
@@ -171,8 +171,41 @@ func (o *serviceWrapper) startStopErr() error {
 	return o.lastErr
 }
 
+// InheritedFiles always returns nil on Windows because dropping privileges
+// is not supported.
+func InheritedFiles() []*os.File {
+	return nil
+}
+
 func NewDaemonizer(logConfig LogConfig) Daemonizer {
+	return NewDaemonizerWithConfig(DaemonizerConfig{
+		LogConfig: logConfig,
+	})
+}
+
+// NewDaemonizerWithConfig returns a Daemonizer for the current system
+// using the provided configuration.
+func NewDaemonizerWithConfig(config DaemonizerConfig) Daemonizer {
+	err := config.Validate()
+	if err != nil {
+		return &errDaemonizer{
+			reason: err.Error(),
+		}
+	}
+
+	if config.DetachConfig.Detach {
+		return &errDaemonizer{
+			reason: "detaching is not supported on Windows",
+		}
+	}
+
+	if len(config.PrivilegeDropConfig.User) > 0 {
+		return &errDaemonizer{
+			reason: "dropping privileges is not supported on Windows",
+		}
+	}
+
 	return &windowsDaemonizer{
-		logConfig: logConfig,
+		logConfig: config.LogConfig,
 	}
 }
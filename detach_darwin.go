@@ -0,0 +1,12 @@
+package cyberdaemon
+
+import (
+	"os"
+)
+
+// shouldDetach returns true if the daemon should detach from the process
+// that started it. launchd (which is always process 1) expects the
+// processes it starts to run in the foreground.
+func shouldDetach(_ DaemonizerConfig) bool {
+	return os.Getppid() != 1
+}
@@ -0,0 +1,52 @@
+package cyberdaemon
+
+import (
+	"os"
+
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+	"github.com/stephen-fox/cyberdaemon/internal/supervisor"
+)
+
+// shouldDetach returns true if the daemon should detach from the process
+// that started it. systemd sets the 'INVOCATION_ID' environment variable
+// for the processes it starts, and expects them to run in the foreground.
+// OpenRC's supervisors preserve the 'RC_SVCNAME' environment variable
+// (and expect the same), as do runit's 'runsv' and s6's 's6-supervise',
+// which are the parent of the daemon's process. supervisord sets the
+// 'SUPERVISOR_ENABLED' environment variable. Daemons started by an
+// init.d script are handled by the System V Daemonizer (including the
+// daemon process it starts), and the built-in supervisor is detached
+// by the Controller that starts it.
+func shouldDetach(config DaemonizerConfig) bool {
+	if len(os.Getenv("INVOCATION_ID")) > 0 {
+		return false
+	}
+
+	if len(os.Getenv("RC_SVCNAME")) > 0 {
+		return false
+	}
+
+	if _, isSupervisord := os.LookupEnv(supervisordEnabledEnv); isSupervisord {
+		return false
+	}
+
+	if parent, err := osutil.ReadProcessStatus(os.Getppid()); err == nil {
+		if parent.Name == "runsv" || parent.Name == "s6-supervise" {
+			return false
+		}
+	}
+
+	if _, isSystemvDaemon := os.LookupEnv(pidFilePathEnv); isSystemvDaemon {
+		return false
+	}
+
+	if _, isBuiltinSupervisor := os.LookupEnv(supervisor.DirEnv); isBuiltinSupervisor {
+		return false
+	}
+
+	if diagnosis, _ := newInitdResolver(config.InitdConfig).resolve(); diagnosis.StartedByInitd {
+		return false
+	}
+
+	return true
+}
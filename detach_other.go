@@ -0,0 +1,10 @@
+// +build !linux,!darwin,!windows
+
+package cyberdaemon
+
+// shouldDetach returns true if the daemon should detach from the process
+// that started it. No service managers that expect daemons to run in the
+// foreground are recognized on this system.
+func shouldDetach(_ DaemonizerConfig) bool {
+	return true
+}
@@ -0,0 +1,366 @@
+// +build !windows
+
+package cyberdaemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+	"github.com/stephen-fox/cyberdaemon/pidfile"
+)
+
+const (
+	// detachedEnv is the environment variable that identifies the
+	// detached daemon process.
+	detachedEnv = "CYBERDAEMON_DETACHED"
+
+	// readyFdEnv is the environment variable used to tell the daemon
+	// process which file descriptor it should report readiness on.
+	readyFdEnv = "CYBERDAEMON_READY_FD"
+
+	readyMessage       = "ready"
+	startFailedMessage = "failed:"
+)
+
+type detachDaemonizer struct {
+	config   DaemonizerConfig
+	fallback Daemonizer
+}
+
+func (o *detachDaemonizer) RunUntilExit(application Application) error {
+	if _, isDetached := os.LookupEnv(detachedEnv); isDetached {
+		os.Unsetenv(detachedEnv)
+		return o.runDetached(application)
+	}
+
+	if !shouldDetach(o.config) {
+		return o.fallback.RunUntilExit(application)
+	}
+
+	err := o.detach()
+	if err != nil {
+		return err
+	}
+
+	// Exit rather than returning to the application's code.
+	// Returning would be indistinguishable from the daemon
+	// stopping, and any clean up code that the application
+	// runs afterwards could interfere with the daemon.
+	os.Exit(0)
+
+	return nil
+}
+
+// detach starts the detached daemon process and waits for it to report
+// that the application started.
+func (o *detachDaemonizer) detach() error {
+	if len(o.config.DetachConfig.PIDFilePath) > 0 {
+		pid, isRunning, _ := pidfile.IsRunning(o.config.DetachConfig.PIDFilePath)
+		if isRunning {
+			return fmt.Errorf("daemon is already running with pid %d", pid)
+		}
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path when exec'ing daemon - %s", err.Error())
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open null device - %s", err.Error())
+	}
+	defer devNull.Close()
+
+	output := devNull
+	if len(o.config.DetachConfig.LogFilePath) > 0 {
+		err := os.MkdirAll(path.Dir(o.config.DetachConfig.LogFilePath), 0700)
+		if err != nil {
+			return fmt.Errorf("failed to create log file directory - %s", err.Error())
+		}
+
+		output, err = os.OpenFile(o.config.DetachConfig.LogFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file - %s", err.Error())
+		}
+		defer output.Close()
+	}
+
+	daemon := exec.Command(exePath, os.Args[1:]...)
+	daemon.Env = append(os.Environ(), detachedEnv+"=")
+	daemon.Dir = o.config.DetachConfig.workDirPath()
+	daemon.Stdin = devNull
+	daemon.Stdout = output
+	daemon.Stderr = output
+	daemon.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+
+	daemon.SysProcAttr.Credential, err = o.groupCredential()
+	if err != nil {
+		return err
+	}
+
+	return startAndWaitForReady(daemon, o.config.DetachConfig.startTimeout())
+}
+
+// runDetached runs the application in the detached daemon process.
+func (o *detachDaemonizer) runDetached(application Application) error {
+	var pidFile *pidfile.PIDFile
+	defer func() {
+		if pidFile != nil {
+			pidFile.Release()
+		}
+	}()
+
+	return runAndReportReadiness(application, o.config.LogConfig, func() error {
+		err := o.prepareDetached()
+		if err != nil {
+			return err
+		}
+
+		if len(o.config.DetachConfig.PIDFilePath) > 0 {
+			pidFile, err = pidfile.Acquire(o.config.DetachConfig.PIDFilePath)
+			if err != nil {
+				return fmt.Errorf("failed to acquire pid file - %s", err.Error())
+			}
+		}
+
+		return nil
+	})
+}
+
+// prepareDetached applies process settings in the detached daemon process.
+func (o *detachDaemonizer) prepareDetached() error {
+	if len(o.config.DetachConfig.Umask) > 0 {
+		mask, err := osutil.ParseUmask(o.config.DetachConfig.Umask)
+		if err != nil {
+			return err
+		}
+		syscall.Umask(mask)
+	}
+
+	return nil
+}
+
+// groupCredential returns the credential for the detached daemon process
+// if its groups were configured, or nil otherwise.
+func (o *detachDaemonizer) groupCredential() (*syscall.Credential, error) {
+	config := o.config.DetachConfig
+	if len(config.Group) == 0 && len(config.SupplementaryGroups) == 0 {
+		return nil, nil
+	}
+
+	credential := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+
+	if len(config.Group) > 0 {
+		gid, err := osutil.LookupGroupID(config.Group)
+		if err != nil {
+			return nil, err
+		}
+		credential.Gid = gid
+	}
+
+	groups, err := os.Getgroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get supplementary groups - %s", err.Error())
+	}
+	for _, group := range groups {
+		credential.Groups = append(credential.Groups, uint32(group))
+	}
+
+	for _, groupName := range config.SupplementaryGroups {
+		gid, err := osutil.LookupGroupID(groupName)
+		if err != nil {
+			return nil, err
+		}
+		credential.Groups = append(credential.Groups, gid)
+	}
+
+	return credential, nil
+}
+
+func newDetachDaemonizer(config DaemonizerConfig, fallback Daemonizer) Daemonizer {
+	return &detachDaemonizer{
+		config:   config,
+		fallback: fallback,
+	}
+}
+
+// startAndWaitForReady starts the daemon process and waits for it to
+// report whether the application started. The daemon process reports
+// this over a pipe whose file descriptor number is stored in the
+// readyFdEnv environment variable. A non-nil error is returned if the
+// application failed to start, if the daemon process exited without
+// reporting its status, or if the timeout was exceeded. The daemon
+// process is killed in the latter case. The daemon process is released
+// once it reports that the application started.
+func startAndWaitForReady(daemon *exec.Cmd, timeout time.Duration) error {
+	err := startAndAwaitReady(daemon, timeout)
+	if err != nil {
+		return err
+	}
+
+	return daemon.Process.Release()
+}
+
+// startAndAwaitReady is the same as startAndWaitForReady, except that the
+// daemon process is not released. The caller must wait for the process.
+func startAndAwaitReady(daemon *exec.Cmd, timeout time.Duration) error {
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create readiness pipe - %s", err.Error())
+	}
+	defer readyReader.Close()
+
+	// Extra files start at file descriptor number 3.
+	fd := 3 + len(daemon.ExtraFiles)
+	daemon.ExtraFiles = append(daemon.ExtraFiles, readyWriter)
+	if daemon.Env == nil {
+		daemon.Env = os.Environ()
+	}
+	daemon.Env = append(daemon.Env, fmt.Sprintf("%s=%d", readyFdEnv, fd))
+
+	err = daemon.Start()
+	readyWriter.Close()
+	if err != nil {
+		return fmt.Errorf("failed to exec daemon process - %s", err.Error())
+	}
+
+	// The read completes when the daemon process closes the pipe,
+	// which also happens if it exits.
+	messages := make(chan string, 1)
+	go func() {
+		message, _ := ioutil.ReadAll(readyReader)
+		messages <- strings.TrimSpace(string(message))
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case message := <-messages:
+		switch {
+		case message == readyMessage:
+			return nil
+		case strings.HasPrefix(message, startFailedMessage):
+			daemon.Wait()
+			return fmt.Errorf("daemon failed to start - %s",
+				strings.TrimSpace(strings.TrimPrefix(message, startFailedMessage)))
+		}
+
+		waitErr := daemon.Wait()
+		if waitErr != nil {
+			return fmt.Errorf("daemon process exited before it started - %s", waitErr.Error())
+		}
+		return fmt.Errorf("daemon process exited before it started")
+	case <-timer.C:
+		daemon.Process.Kill()
+		daemon.Wait()
+		return fmt.Errorf("daemon did not report that it started after %s", timeout.String())
+	}
+}
+
+// readinessNotifier reports whether the application started to the
+// process that started the daemon.
+type readinessNotifier struct {
+	pipe *os.File
+}
+
+// notify reports the result of starting the application. It is safe to
+// call this method more than once - only the first call has an effect.
+func (o *readinessNotifier) notify(startErr error) {
+	if o.pipe == nil {
+		return
+	}
+
+	if startErr == nil {
+		o.pipe.WriteString(readyMessage + "\n")
+	} else {
+		o.pipe.WriteString(startFailedMessage + " " + startErr.Error() + "\n")
+	}
+
+	o.pipe.Close()
+	o.pipe = nil
+}
+
+// newReadinessNotifier returns a readinessNotifier for the readiness pipe
+// passed by the process that started the daemon. The returned notifier
+// does nothing if no pipe was passed.
+func newReadinessNotifier() (*readinessNotifier, error) {
+	fdString, ok := os.LookupEnv(readyFdEnv)
+	if !ok {
+		return &readinessNotifier{}, nil
+	}
+	os.Unsetenv(readyFdEnv)
+
+	fd, err := strconv.Atoi(fdString)
+	if err != nil || fd < 3 {
+		return nil, fmt.Errorf("readiness file descriptor '%s' is invalid", fdString)
+	}
+
+	// Do not leak the pipe to processes started by the application.
+	syscall.CloseOnExec(fd)
+
+	return &readinessNotifier{
+		pipe: os.NewFile(uintptr(fd), "readiness-pipe"),
+	}, nil
+}
+
+// runAndReportReadiness runs the application in a process that was started
+// using startAndAwaitReady until an interrupt or termination signal is
+// received. The result of the application's Start method is reported to
+// the parent process. The prepare function is called before the
+// application is started. The application is not started if it returns
+// a non-nil error.
+func runAndReportReadiness(application Application, logConfig LogConfig, prepare func() error) error {
+	notifier, err := newReadinessNotifier()
+	if err != nil {
+		return err
+	}
+
+	err = prepare()
+	if err != nil {
+		notifier.notify(err)
+		return err
+	}
+
+	if logConfig.UseNativeLogger {
+		log.SetOutput(os.Stderr)
+
+		if logConfig.NativeLogFlags > 0 {
+			originalLogFlags := log.Flags()
+			log.SetFlags(logConfig.NativeLogFlags)
+			defer log.SetFlags(originalLogFlags)
+		}
+	}
+
+	// Signals are handled before the application starts so that
+	// a signal received while it is starting stops it cleanly.
+	interruptsAndTerms := make(chan os.Signal, 1)
+	signal.Notify(interruptsAndTerms, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interruptsAndTerms)
+
+	err = application.Start()
+	notifier.notify(err)
+	if err != nil {
+		return err
+	}
+
+	<-interruptsAndTerms
+
+	return application.Stop()
+}
@@ -5,7 +5,13 @@
 //
 // 	- Linux
 // 		- systemd
-// 		- System V (init.d) (if systemd is unavailable)
+// 		- OpenRC (if systemd is unavailable)
+// 		- runit or s6 (if systemd and OpenRC are unavailable, and
+// 		  'runsvdir' or 's6-svscan' is running)
+// 		- supervisord (if selected using the control package's
+// 		  SupervisordOption, or if 'supervisord' is running as PID 1)
+// 		- System V (init.d) (if none of the above are available)
+// 		- Built-in supervisor (if none of the above are available)
 // 	- macOS
 // 		- launchd
 // 	- Windows
@@ -25,6 +31,21 @@
 // leveraging the Daemonizer. Please review the 'Gotchas' documentation in the
 // Daemonizer interface if you choose to use your own management tooling.
 //
+// A Daemonizer can also be created using a DaemonizerConfig, which provides
+// additional customization options. For example, the DetachConfig allows
+// the daemon to detach itself from a shell or a supervisor that expects
+// daemons to background themselves, and the PrivilegeDropConfig allows a
+// daemon started as root to run the Application as an unprivileged user.
+// An Application that is run as an instance of a multi-instance daemon can
+// retrieve the instance's name using InstanceName.
+// On System V, a daemon that was installed with a RestartPolicy by the
+// 'control' subpackage is supervised by the Daemonizer, which restarts
+// the Application's process when it exits. On Linux systems that provide
+// no daemon manager (i.e., systemd, OpenRC, runit, s6, or System V), the
+// 'control' subpackage starts the daemon with a built-in supervisor, which
+// the Daemonizer implements. The daemon is controlled using a PID file
+// and a control socket.
+//
 // The Application interface is used by the Daemonizer to run your application
 // code as a daemon. Implement this interface in your application and use the
 // Daemonizer to run your program.
@@ -50,6 +50,11 @@ or:
 The daemon can be uninstalled by running:
 	'` + example + ` uninstall'
 
+On Linux, an installed daemon can be run in the foreground (using the same
+arguments, user, and environment that the operating system would use) by
+running:
+	'` + example + ` debug'
+
 [USAGE]`
 )
 
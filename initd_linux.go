@@ -0,0 +1,243 @@
+package cyberdaemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+var (
+	defaultInitdScriptDirPaths = []string{
+		"/etc/init.d",
+		"/etc/rc.d/init.d",
+	}
+)
+
+// InitdDiagnosis describes how the System V Daemonizer determined whether
+// the current process was started by an init.d script.
+type InitdDiagnosis struct {
+	// StartedByInitd is true if an ancestor process is an init.d script.
+	StartedByInitd bool
+
+	// ScriptPath is the canonical path to the init.d script that
+	// started the process. It is empty if StartedByInitd is false.
+	ScriptPath string
+
+	// ScriptDirPaths are the canonical init.d script directory paths
+	// that were searched.
+	ScriptDirPaths []string
+
+	// Steps are human readable messages that describe each step
+	// of the detection process.
+	Steps []string
+}
+
+// String returns the diagnosis as a multi-line string.
+func (o InitdDiagnosis) String() string {
+	return strings.Join(o.Steps, "\n")
+}
+
+// DiagnoseInitd determines whether the current process was started by an
+// init.d script using the same logic as the System V Daemonizer. The
+// resulting diagnosis explains the reasoning behind the determination.
+func DiagnoseInitd(config InitdConfig) (InitdDiagnosis, error) {
+	err := config.Validate()
+	if err != nil {
+		return InitdDiagnosis{}, err
+	}
+
+	return newInitdResolver(config).resolve()
+}
+
+// initdResolver walks the ancestors of the current process looking for
+// an init.d script.
+type initdResolver struct {
+	processes      processTree
+	scriptDirPaths []string
+	maxAncestors   int
+	diagnosis      InitdDiagnosis
+}
+
+func (o *initdResolver) resolve() (InitdDiagnosis, error) {
+	pid := o.processes.parent()
+
+	for i := 0; i < o.maxAncestors; i++ {
+		if pid <= 1 {
+			o.stepf("reached process %d - stopping search", pid)
+			return o.diagnosis, nil
+		}
+
+		scriptPath, isInitd, err := o.isPidInitd(pid)
+		if err != nil {
+			return o.diagnosis, err
+		}
+
+		if isInitd {
+			o.diagnosis.StartedByInitd = true
+			o.diagnosis.ScriptPath = scriptPath
+			return o.diagnosis, nil
+		}
+
+		parentPID, err := o.processes.parentOf(pid)
+		if err != nil {
+			return o.diagnosis, fmt.Errorf("failed to get parent of process %d - %s", pid, err.Error())
+		}
+		pid = parentPID
+	}
+
+	o.stepf("inspected the maximum number of ancestor processes (%d) - stopping search", o.maxAncestors)
+
+	return o.diagnosis, nil
+}
+
+// isPidInitd determines whether the process is an init.d script by
+// checking if its executable, or the script that its interpreter runs,
+// refers to a file in an init.d script directory.
+func (o *initdResolver) isPidInitd(pid int) (string, bool, error) {
+	args, err := o.processes.arguments(pid)
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, arg := range scriptArguments(args) {
+		argPath := arg
+		if !filepath.IsAbs(argPath) {
+			if !strings.Contains(argPath, "/") {
+				// Bare words (such as 'sh') are not
+				// interpreted as paths.
+				continue
+			}
+
+			cwd, err := o.processes.workDir(pid)
+			if err != nil {
+				continue
+			}
+			argPath = filepath.Join(cwd, argPath)
+		}
+
+		canonicalPath, err := filepath.EvalSymlinks(argPath)
+		if err != nil {
+			continue
+		}
+
+		info, err := os.Stat(canonicalPath)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		for _, dirPath := range o.scriptDirPaths {
+			if filepath.Dir(canonicalPath) == dirPath {
+				o.stepf("process %d argument '%s' resolves to '%s', which is in init.d directory '%s'",
+					pid, arg, canonicalPath, dirPath)
+				return canonicalPath, true, nil
+			}
+		}
+	}
+
+	o.stepf("process %d (%s) is not an init.d script", pid, strings.Join(args, " "))
+
+	return "", false, nil
+}
+
+// scriptArguments returns the arguments of a process that may refer to
+// an init.d script. A script that is executed directly appears as the
+// process' name (or, when the kernel runs the script's interpreter, as
+// the first argument that is not an option). Arguments that are passed
+// to the script (such as 'start') are not returned.
+func scriptArguments(args []string) []string {
+	if len(args) == 0 || len(args[0]) == 0 {
+		return nil
+	}
+
+	candidates := []string{args[0]}
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if len(arg) > 0 {
+			candidates = append(candidates, arg)
+		}
+		break
+	}
+
+	return candidates
+}
+
+func (o *initdResolver) stepf(format string, a ...interface{}) {
+	o.diagnosis.Steps = append(o.diagnosis.Steps, fmt.Sprintf(format, a...))
+}
+
+func newInitdResolver(config InitdConfig) *initdResolver {
+	resolver := &initdResolver{
+		processes:    procProcessTree{},
+		maxAncestors: config.maxAncestors(),
+	}
+
+	// Directories may be symbolic links (for example, '/etc/init.d'
+	// is a link to '/etc/rc.d/init.d' on Red Hat systems), so
+	// canonicalize them and remove duplicates.
+	seen := make(map[string]bool)
+	for _, dirPath := range append(defaultInitdScriptDirPaths, config.ScriptDirPaths...) {
+		canonicalPath, err := filepath.EvalSymlinks(dirPath)
+		if err != nil {
+			resolver.stepf("skipping init.d directory '%s' - %s", dirPath, err.Error())
+			continue
+		}
+
+		if seen[canonicalPath] {
+			continue
+		}
+		seen[canonicalPath] = true
+
+		resolver.scriptDirPaths = append(resolver.scriptDirPaths, canonicalPath)
+	}
+
+	resolver.diagnosis.ScriptDirPaths = resolver.scriptDirPaths
+
+	return resolver
+}
+
+// processTree provides information about the ancestors of the current
+// process.
+type processTree interface {
+	// parent returns the pid of the current process' parent.
+	parent() int
+
+	// parentOf returns the pid of the specified process' parent.
+	parentOf(pid int) (int, error)
+
+	// arguments returns the command line arguments of the specified
+	// process (including its name).
+	arguments(pid int) ([]string, error)
+
+	// workDir returns the working directory of the specified process.
+	workDir(pid int) (string, error)
+}
+
+// procProcessTree is a processTree that reads the '/proc' file system.
+type procProcessTree struct{}
+
+func (o procProcessTree) parent() int {
+	return os.Getppid()
+}
+
+func (o procProcessTree) parentOf(pid int) (int, error) {
+	status, err := osutil.ReadProcessStatus(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	return status.ParentPID, nil
+}
+
+func (o procProcessTree) arguments(pid int) ([]string, error) {
+	return osutil.ReadProcessArguments(pid)
+}
+
+func (o procProcessTree) workDir(pid int) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+}
@@ -0,0 +1,184 @@
+package cyberdaemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitdResolver(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "cyberdaemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	tempDirPath, err = filepath.EvalSymlinks(tempDirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initdDirPath := path.Join(tempDirPath, "init.d")
+	rcDirPath := path.Join(tempDirPath, "rc3.d")
+	for _, dirPath := range []string{initdDirPath, rcDirPath} {
+		err = os.Mkdir(dirPath, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scriptPath := path.Join(initdDirPath, "app")
+	err = ioutil.WriteFile(scriptPath, []byte("#!/bin/sh\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	linkPath := path.Join(rcDirPath, "S20app")
+	err = os.Symlink("../init.d/app", linkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		processes    map[int]fakeProcess
+		maxAncestors int
+		isInitd      bool
+	}{
+		{
+			name: "interpreter runs script",
+			processes: map[int]fakeProcess{
+				100: {parent: 1, args: []string{"/bin/sh", scriptPath, "start"}},
+			},
+			isInitd: true,
+		},
+		{
+			name: "interpreter with options runs script",
+			processes: map[int]fakeProcess{
+				100: {parent: 1, args: []string{"/bin/bash", "-e", "-x", scriptPath, "start"}},
+			},
+			isInitd: true,
+		},
+		{
+			name: "script executed through a symbolic link",
+			processes: map[int]fakeProcess{
+				100: {parent: 1, args: []string{linkPath, "start"}},
+			},
+			isInitd: true,
+		},
+		{
+			name: "relative script path",
+			processes: map[int]fakeProcess{
+				100: {parent: 1, args: []string{"sh", "./app", "start"}, workDir: initdDirPath},
+			},
+			isInitd: true,
+		},
+		{
+			name: "script is an ancestor",
+			processes: map[int]fakeProcess{
+				100: {parent: 200, args: []string{"/usr/bin/app", "-v"}},
+				200: {parent: 1, args: []string{"/bin/sh", scriptPath, "start"}},
+			},
+			isInitd: true,
+		},
+		{
+			name: "script is an argument of a command",
+			processes: map[int]fakeProcess{
+				100: {parent: 1, args: []string{"/usr/bin/editor", "notes.txt", scriptPath}},
+			},
+		},
+		{
+			name: "script is an option value",
+			processes: map[int]fakeProcess{
+				100: {parent: 1, args: []string{"/usr/bin/app", "--config=" + scriptPath}},
+			},
+		},
+		{
+			name: "script is beyond the maximum number of ancestors",
+			processes: map[int]fakeProcess{
+				100: {parent: 200, args: []string{"/usr/bin/app"}},
+				200: {parent: 300, args: []string{"/usr/bin/app"}},
+				300: {parent: 1, args: []string{"/bin/sh", scriptPath, "start"}},
+			},
+			maxAncestors: 2,
+		},
+		{
+			name: "no script",
+			processes: map[int]fakeProcess{
+				100: {parent: 1, args: []string{"/bin/sh", "-c", "/usr/bin/app"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		resolver := newInitdResolver(InitdConfig{
+			ScriptDirPaths: []string{initdDirPath},
+			MaxAncestors:   test.maxAncestors,
+		})
+		resolver.processes = fakeProcessTree{
+			parentPID: 100,
+			processes: test.processes,
+		}
+
+		diagnosis, err := resolver.resolve()
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+
+		if diagnosis.StartedByInitd != test.isInitd {
+			t.Fatalf("%s: expected started by init.d: %t - got %t\n%s",
+				test.name, test.isInitd, diagnosis.StartedByInitd, diagnosis.String())
+		}
+
+		if test.isInitd && diagnosis.ScriptPath != scriptPath {
+			t.Fatalf("%s: expected script path '%s' - got '%s'", test.name, scriptPath, diagnosis.ScriptPath)
+		}
+	}
+}
+
+type fakeProcess struct {
+	parent  int
+	args    []string
+	workDir string
+}
+
+// fakeProcessTree is a processTree that is backed by a map of pids to
+// processes.
+type fakeProcessTree struct {
+	parentPID int
+	processes map[int]fakeProcess
+}
+
+func (o fakeProcessTree) parent() int {
+	return o.parentPID
+}
+
+func (o fakeProcessTree) parentOf(pid int) (int, error) {
+	process, ok := o.processes[pid]
+	if !ok {
+		return 0, fmt.Errorf("process %d does not exist", pid)
+	}
+
+	return process.parent, nil
+}
+
+func (o fakeProcessTree) arguments(pid int) ([]string, error) {
+	process, ok := o.processes[pid]
+	if !ok {
+		return nil, fmt.Errorf("process %d does not exist", pid)
+	}
+
+	return process.args, nil
+}
+
+func (o fakeProcessTree) workDir(pid int) (string, error) {
+	process, ok := o.processes[pid]
+	if !ok || len(process.workDir) == 0 {
+		return "", fmt.Errorf("working directory of process %d is unknown", pid)
+	}
+
+	return process.workDir, nil
+}
@@ -0,0 +1,159 @@
+package osutil
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// userHz is the number of clock ticks per second used by the
+	// kernel when reporting times in '/proc'. This value is fixed
+	// at 100 for all user space facing interfaces.
+	userHz = 100
+)
+
+// ProcessStatus contains information about a process that was parsed
+// from its '/proc/<pid>/stat' file.
+type ProcessStatus struct {
+	PID       int
+	Name      string
+	State     byte
+	ParentPID int
+	StartTime time.Time
+}
+
+// IsZombie returns true if the process has exited but has not been
+// reaped by its parent.
+func (o ProcessStatus) IsZombie() bool {
+	return o.State == 'Z' || o.State == 'X'
+}
+
+// ReadProcessStatus parses the '/proc/<pid>/stat' file of a process.
+// The returned error satisfies os.IsNotExist if the process does
+// not exist.
+func ReadProcessStatus(pid int) (ProcessStatus, error) {
+	contents, err := TinyRead(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return ProcessStatus{}, err
+	}
+
+	// The process name is wrapped in parentheses and may contain
+	// spaces (and parentheses). Everything after the last closing
+	// parenthesis is space separated.
+	nameStart := strings.Index(contents, "(")
+	nameEnd := strings.LastIndex(contents, ")")
+	if nameStart < 0 || nameEnd < nameStart {
+		return ProcessStatus{}, fmt.Errorf("stat file for pid %d is malformed", pid)
+	}
+
+	fields := strings.Fields(contents[nameEnd+1:])
+	// Fields after the name start at index 3 ('state') in proc(5).
+	// The start time is field 22.
+	if len(fields) < 20 {
+		return ProcessStatus{}, fmt.Errorf("stat file for pid %d has too few fields", pid)
+	}
+
+	parentPid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return ProcessStatus{}, fmt.Errorf("failed to parse parent pid for pid %d - %s", pid, err.Error())
+	}
+
+	startTicks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return ProcessStatus{}, fmt.Errorf("failed to parse start time for pid %d - %s", pid, err.Error())
+	}
+
+	bootTime, err := BootTime()
+	if err != nil {
+		return ProcessStatus{}, err
+	}
+
+	return ProcessStatus{
+		PID:       pid,
+		Name:      contents[nameStart+1 : nameEnd],
+		State:     fields[0][0],
+		ParentPID: parentPid,
+		StartTime: bootTime.Add(time.Duration(startTicks) * time.Second / userHz),
+	}, nil
+}
+
+// ReadProcessArguments returns the command line arguments of a process
+// (including its name) by parsing its '/proc/<pid>/cmdline' file.
+func ReadProcessArguments(pid int) ([]string, error) {
+	contents, err := TinyRead(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command line of pid %d - %s", pid, err.Error())
+	}
+
+	return strings.Split(strings.TrimSuffix(contents, "\x00"), "\x00"), nil
+}
+
+// FindProcess returns the pid of a running process with the specified
+// name. If several processes have the name, the pid of any of them may
+// be returned.
+func FindProcess(name string) (int, bool) {
+	infos, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, false
+	}
+
+	for _, info := range infos {
+		pid, err := strconv.Atoi(info.Name())
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		// The 'comm' file contains the process name (which is
+		// truncated to 15 characters), followed by a newline.
+		comm, err := TinyRead(fmt.Sprintf("/proc/%d/comm", pid))
+		if err == nil && strings.TrimSpace(comm) == name {
+			return pid, true
+		}
+	}
+
+	return 0, false
+}
+
+// BootTime returns the time at which the system booted.
+func BootTime() (time.Time, error) {
+	contents, err := TinyRead("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, line := range strings.Split(contents, "\n") {
+		if !strings.HasPrefix(line, "btime ") {
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "btime ")), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse boot time - %s", err.Error())
+		}
+
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("failed to find boot time in /proc/stat")
+}
+
+// TinyRead reads up to 100 KB from the specified file.
+func TinyRead(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	contents, err := ioutil.ReadAll(io.LimitReader(f, 100000))
+	if err != nil {
+		return "", err
+	}
+
+	return string(contents), nil
+}
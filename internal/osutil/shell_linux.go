@@ -0,0 +1,71 @@
+package osutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ShellQuote quotes a string for use as a single word in a POSIX shell
+// command line. The string is wrapped in single quotes, and any single
+// quotes it contains are escaped.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// ShellWords splits a string into words using a subset of the POSIX shell
+// quoting rules (single quotes, double quotes, and backslash escapes).
+// Expansions (such as variables and globs) are not performed.
+func ShellWords(s string) ([]string, error) {
+	var words []string
+	var current strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case ' ', '\t', '\n':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		case '\'':
+			inWord = true
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in '%s'", s)
+			}
+			current.WriteString(s[i+1 : i+1+end])
+			i = i + 1 + end
+		case '"':
+			inWord = true
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				// Within double quotes, a backslash only escapes
+				// characters that are otherwise special.
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+				}
+				current.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated double quote in '%s'", s)
+			}
+		case '\\':
+			inWord = true
+			if i+1 < len(s) {
+				i++
+				current.WriteByte(s[i])
+			}
+		default:
+			inWord = true
+			current.WriteByte(c)
+		}
+	}
+
+	if inWord {
+		words = append(words, current.String())
+	}
+
+	return words, nil
+}
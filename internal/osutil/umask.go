@@ -0,0 +1,16 @@
+package osutil
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ParseUmask parses an octal umask string (e.g., "0022").
+func ParseUmask(umask string) (int, error) {
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || mask > 0777 {
+		return 0, fmt.Errorf("umask '%s' is not a valid octal file mode mask", umask)
+	}
+
+	return int(mask), nil
+}
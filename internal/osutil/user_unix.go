@@ -0,0 +1,68 @@
+// +build !windows
+
+package osutil
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// UserCredential returns a credential that can be used to start a process
+// as the specified user. The credential includes the user's supplementary
+// groups. A non-nil error is returned if the current process lacks the
+// privileges needed to switch to the user.
+func UserCredential(u *user.User) (*syscall.Credential, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse uid of user '%s' - %s", u.Username, err.Error())
+	}
+
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse gid of user '%s' - %s", u.Username, err.Error())
+	}
+
+	if os.Geteuid() != 0 && uint32(uid) != uint32(os.Geteuid()) {
+		return nil, fmt.Errorf("super user privileges are required to run a process as user '%s'",
+			u.Username)
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups of user '%s' - %s", u.Username, err.Error())
+	}
+
+	var groups []uint32
+	for i := range groupIDs {
+		groupID, err := strconv.ParseUint(groupIDs[i], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse group id '%s' of user '%s' - %s",
+				groupIDs[i], u.Username, err.Error())
+		}
+		groups = append(groups, uint32(groupID))
+	}
+
+	return &syscall.Credential{
+		Uid:    uint32(uid),
+		Gid:    uint32(gid),
+		Groups: groups,
+	}, nil
+}
+
+// LookupGroupID returns the ID of the specified group.
+func LookupGroupID(groupName string) (uint32, error) {
+	group, err := user.LookupGroup(groupName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup group '%s' - %s", groupName, err.Error())
+	}
+
+	gid, err := strconv.ParseUint(group.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse gid of group '%s' - %s", groupName, err.Error())
+	}
+
+	return uint32(gid), nil
+}
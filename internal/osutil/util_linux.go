@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +14,17 @@ const (
 	serviceExeName   = "service"
 	chkconfigExeName = "chkconfig"
 	updatercdExeName = "update-rc.d"
+	rcServiceExeName = "rc-service"
+	rcUpdateExeName  = "rc-update"
+	svExeName        = "sv"
+	s6SvcExeName     = "s6-svc"
+	s6SvstatExeName  = "s6-svstat"
+	s6SvscanctlName  = "s6-svscanctl"
+	supervisorctlExe = "supervisorctl"
+	loginctlExeName  = "loginctl"
+	useraddExeName   = "useradd"
+	groupaddExeName  = "groupadd"
+	nologinExeName   = "nologin"
 )
 
 var (
@@ -21,6 +33,36 @@ var (
 		"/sbin",
 		"/usr/sbin",
 	}
+	openrcExeDirPaths = []string{
+		"/sbin",
+		"/usr/sbin",
+		"/bin",
+		"/usr/bin",
+	}
+	// supervisionExeDirPaths are the directories that contain the
+	// tools of runit and s6. s6-overlay installs its tools in
+	// '/command'.
+	supervisionExeDirPaths = []string{
+		"/usr/bin",
+		"/bin",
+		"/usr/sbin",
+		"/sbin",
+		"/usr/local/bin",
+		"/command",
+	}
+	supervisorctlExeDirPaths = []string{
+		"/usr/bin",
+		"/usr/local/bin",
+		"/bin",
+	}
+	loginctlExeDirPaths = []string{
+		"/bin",
+		"/usr/bin",
+	}
+	accountExeDirPaths = []string{
+		"/usr/sbin",
+		"/sbin",
+	}
 )
 
 func IsSystemd() (systemctlPath string, ok bool) {
@@ -34,6 +76,121 @@ func IsSystemd() (systemctlPath string, ok bool) {
 	return "", false
 }
 
+// IsOpenRC returns true if the system was booted using OpenRC. OpenRC
+// may be installed on systems that it did not boot (for example, in a
+// container), in which case its tools cannot control daemons.
+func IsOpenRC() (rcServicePath string, ok bool) {
+	rcServicePath, err := searchForExeInPaths(rcServiceExeName, openrcExeDirPaths)
+	if err != nil {
+		return "", false
+	}
+
+	// OpenRC creates this directory when it boots the system.
+	info, err := os.Stat("/run/openrc")
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+
+	return rcServicePath, true
+}
+
+// IsRunit returns true if runit's 'runsvdir' is running. The returned
+// scan directory path is the directory that runsvdir supervises the
+// services of.
+func IsRunit() (svPath string, scanDirPath string, ok bool) {
+	pid, isRunning := FindProcess("runsvdir")
+	if !isRunning {
+		return "", "", false
+	}
+
+	svPath, err := searchForExeInPaths(svExeName, supervisionExeDirPaths)
+	if err != nil {
+		return "", "", false
+	}
+
+	scanDirPath, err = processScanDirPath(pid)
+	if err != nil {
+		return "", "", false
+	}
+
+	return svPath, scanDirPath, true
+}
+
+// IsS6 returns true if s6's 's6-svscan' is running. The returned scan
+// directory path is the directory that s6-svscan supervises the
+// services of.
+func IsS6() (s6SvcPath string, scanDirPath string, ok bool) {
+	pid, isRunning := FindProcess("s6-svscan")
+	if !isRunning {
+		return "", "", false
+	}
+
+	s6SvcPath, err := searchForExeInPaths(s6SvcExeName, supervisionExeDirPaths)
+	if err != nil {
+		return "", "", false
+	}
+
+	scanDirPath, err = processScanDirPath(pid)
+	if err != nil {
+		return "", "", false
+	}
+
+	return s6SvcPath, scanDirPath, true
+}
+
+// processScanDirPath returns the path to the scan directory of a running
+// 'runsvdir' or 's6-svscan' process. The scan directory is the first
+// argument that is a directory. s6-svscan uses its working directory
+// if it is not given one.
+func processScanDirPath(pid int) (string, error) {
+	workDirPath, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+	if err != nil {
+		return "", fmt.Errorf("failed to read working directory of pid %d - %s", pid, err.Error())
+	}
+
+	args, err := ReadProcessArguments(pid)
+	if err != nil {
+		return "", err
+	}
+
+	for i := 1; i < len(args); i++ {
+		if strings.HasPrefix(args[i], "-") {
+			continue
+		}
+
+		dirPath := args[i]
+		if !path.IsAbs(dirPath) {
+			dirPath = path.Join(workDirPath, dirPath)
+		}
+
+		info, err := os.Stat(dirPath)
+		if err == nil && info.IsDir() {
+			return dirPath, nil
+		}
+	}
+
+	return workDirPath, nil
+}
+
+// IsSupervisord returns true if supervisorctl can connect to supervisord.
+// The returned pid is supervisord's pid.
+func IsSupervisord() (supervisorctlPath string, pid int, ok bool) {
+	supervisorctlPath, err := searchForExeInPaths(supervisorctlExe, supervisorctlExeDirPaths)
+	if err != nil {
+		return "", 0, false
+	}
+
+	// Older versions of supervisorctl exit with a status of zero
+	// when they fail to connect, so the output is checked instead.
+	output, _, _ := RunDaemonCli(supervisorctlPath, "pid")
+	pid, err = strconv.Atoi(output)
+	if err != nil || pid < 1 {
+		return "", 0, false
+	}
+
+	return supervisorctlPath, pid, true
+}
+
 func IsSystemv() (servicePath string, isRedHat bool, whyNotSysV string, ok bool) {
 	servicePath, err := searchForExeInPaths(serviceExeName, serviceExeDirPaths)
 	if err != nil {
@@ -62,6 +219,36 @@ func UpdatercdPath() (string, error) {
 	return searchForExeInPaths(updatercdExeName, serviceExeDirPaths)
 }
 
+func RcUpdatePath() (string, error) {
+	return searchForExeInPaths(rcUpdateExeName, openrcExeDirPaths)
+}
+
+func S6SvstatPath() (string, error) {
+	return searchForExeInPaths(s6SvstatExeName, supervisionExeDirPaths)
+}
+
+func S6SvscanctlPath() (string, error) {
+	return searchForExeInPaths(s6SvscanctlName, supervisionExeDirPaths)
+}
+
+func LoginctlPath() (string, error) {
+	return searchForExeInPaths(loginctlExeName, loginctlExeDirPaths)
+}
+
+func UseraddPath() (string, error) {
+	return searchForExeInPaths(useraddExeName, accountExeDirPaths)
+}
+
+func GroupaddPath() (string, error) {
+	return searchForExeInPaths(groupaddExeName, accountExeDirPaths)
+}
+
+// NologinPath returns the path to the 'nologin' shell, which is used as
+// the login shell of system accounts.
+func NologinPath() (string, error) {
+	return searchForExeInPaths(nologinExeName, accountExeDirPaths)
+}
+
 func searchForExeInPaths(exeName string, dirSearchPaths []string) (string, error) {
 	for i := range dirSearchPaths {
 		filePath := path.Join(dirSearchPaths[i], exeName)
@@ -76,13 +263,22 @@ func searchForExeInPaths(exeName string, dirSearchPaths []string) (string, error
 }
 
 func RunDaemonCli(exePath string, args ...string) (string, int, error) {
-	s := exec.Command(exePath, args...)
+	return RunDaemonCliCmd(exec.Command(exePath, args...))
+}
+
+// RunDaemonCliCmd is the same as RunDaemonCli, but runs a command that
+// the caller has configured (for example, to run it as another user).
+func RunDaemonCliCmd(s *exec.Cmd) (string, int, error) {
 	output, err := s.CombinedOutput()
 	trimmedOutput := strings.TrimSpace(string(output))
 	if err != nil {
-		return trimmedOutput, s.ProcessState.ExitCode(),
+		exitCode := -1
+		if s.ProcessState != nil {
+			exitCode = s.ProcessState.ExitCode()
+		}
+		return trimmedOutput, exitCode,
 			fmt.Errorf("failed to execute '%s %s' - %s - output: %s",
-				exePath, args, err.Error(), trimmedOutput)
+				s.Path, s.Args[1:], err.Error(), trimmedOutput)
 	}
 
 	return trimmedOutput, s.ProcessState.ExitCode(), nil
@@ -0,0 +1,109 @@
+// Package supervisor implements the protocol that is used to control a
+// daemon that is run by the built-in supervisor. The supervisor is used
+// when the operating system does not provide a daemon manager (such as
+// in a minimal container). It is the daemon's own executable, run in a
+// special mode by a Controller.
+//
+// Each daemon has a runtime directory that contains the supervisor's PID
+// file and control socket. The control socket accepts a single command
+// per connection. A command is a line of text, and so is its reply.
+package supervisor
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// DirEnv is the environment variable that stores the path to the
+	// daemon's runtime directory. Its presence tells the daemon's
+	// executable to run as the supervisor.
+	DirEnv = "CYBERDAEMON_SUPERVISOR_DIR"
+
+	// StatusCommand asks the supervisor for its status. The supervisor
+	// replies with RunningReply.
+	StatusCommand = "status"
+
+	// StopCommand asks the supervisor to stop the daemon. The supervisor
+	// replies with StoppingReply, and then stops.
+	StopCommand = "stop"
+
+	RunningReply        = "running"
+	StoppingReply       = "stopping"
+	UnknownCommandReply = "unknown command"
+
+	pidFileName    = "supervisor.pid"
+	socketFileName = "supervisor.sock"
+)
+
+// PIDFilePath returns the path to the supervisor's PID file.
+func PIDFilePath(dirPath string) string {
+	return path.Join(dirPath, pidFileName)
+}
+
+// SocketPath returns the path to the supervisor's control socket.
+func SocketPath(dirPath string) string {
+	return path.Join(dirPath, socketFileName)
+}
+
+// Send sends a command to the supervisor that owns the runtime directory,
+// and returns its reply.
+func Send(dirPath string, command string, timeout time.Duration) (string, error) {
+	conn, err := net.DialTimeout("unix", SocketPath(dirPath), timeout)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to supervisor - %s", err.Error())
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	_, err = fmt.Fprintf(conn, "%s\n", command)
+	if err != nil {
+		return "", fmt.Errorf("failed to send '%s' command to supervisor - %s", command, err.Error())
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read reply to '%s' command from supervisor - %s", command, err.Error())
+	}
+
+	return strings.TrimSpace(reply), nil
+}
+
+// Serve accepts connections on the listener until it is closed. The
+// handler is called for each command, and returns the reply and an
+// optional function that is called after the reply is sent.
+func Serve(listener net.Listener, handler func(command string) (reply string, afterReply func())) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go serveConn(conn, handler)
+	}
+}
+
+func serveConn(conn net.Conn, handler func(command string) (string, func())) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	command, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	reply, afterReply := handler(strings.TrimSpace(command))
+
+	fmt.Fprintf(conn, "%s\n", reply)
+
+	if afterReply != nil {
+		conn.Close()
+		afterReply()
+	}
+}
@@ -0,0 +1,104 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSendServe(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "supervisor-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	listener, err := net.Listen("unix", SocketPath(dirPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	stopped := make(chan struct{})
+
+	go Serve(listener, func(command string) (string, func()) {
+		switch command {
+		case StatusCommand:
+			return RunningReply, nil
+		case StopCommand:
+			return StoppingReply, func() {
+				close(stopped)
+			}
+		}
+
+		return UnknownCommandReply, nil
+	})
+
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{StatusCommand, RunningReply},
+		{"  " + StatusCommand + "\r", RunningReply},
+		{"restart", UnknownCommandReply},
+		{"", UnknownCommandReply},
+		{StopCommand, StoppingReply},
+	}
+
+	for _, test := range tests {
+		reply, err := Send(dirPath, test.command, time.Second)
+		if err != nil {
+			t.Fatalf("%q: %s", test.command, err.Error())
+		}
+		if reply != test.expected {
+			t.Fatalf("%q: expected reply '%s' - got '%s'", test.command, test.expected, reply)
+		}
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected the stop command's function to be called after the reply was sent")
+	}
+}
+
+func TestSendNotListening(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "supervisor-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	_, err = Send(dirPath, StatusCommand, time.Second)
+	if err == nil {
+		t.Fatal("expected an error when the socket does not exist")
+	}
+
+	// A supervisor that accepts the connection but never replies
+	// must not block the sender forever.
+	listener, err := net.Listen("unix", SocketPath(dirPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(2 * time.Second)
+	}()
+
+	start := time.Now()
+	_, err = Send(dirPath, StatusCommand, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected an error when the supervisor does not reply")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the command to time out - it took %s", elapsed.String())
+	}
+}
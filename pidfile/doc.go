@@ -0,0 +1,19 @@
+// Package pidfile provides functionality for safely managing a PID file.
+//
+// A PID file stores the process ID of a running daemon so that other
+// programs (such as an init.d script) can determine whether the daemon
+// is running, and signal it. This package protects a PID file from
+// concurrent use by holding an exclusive advisory lock (flock, or a
+// POSIX record lock on systems without flock) on it for the lifetime
+// of the daemon. The lock is released by the operating system when the
+// process exits, which makes it possible to reliably detect PID files
+// left behind by a process that crashed.
+//
+// PID files that are not locked are considered stale if the process they
+// refer to no longer exists, or if the process was started after the PID
+// file was written (meaning the PID was reused by an unrelated process).
+//
+// The PID file contains only the PID followed by a newline, which is the
+// format expected by common tools such as 'start-stop-daemon' and the
+// Red Hat 'killproc' and 'status' shell functions.
+package pidfile
@@ -0,0 +1,43 @@
+// +build solaris
+
+package pidfile
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// tryLock acquires an exclusive or shared lock on the file without
+// blocking. It returns false if another process holds a conflicting lock.
+//
+// flock is not available on this system, so a POSIX record lock on the
+// whole file is used instead. Unlike flock, a record lock is released
+// when the process closes any file descriptor for the file.
+func tryLock(f *os.File, exclusive bool) (bool, error) {
+	lock := syscall.Flock_t{
+		Type:   syscall.F_RDLCK,
+		Whence: io.SeekStart,
+	}
+	if exclusive {
+		lock.Type = syscall.F_WRLCK
+	}
+
+	err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lock)
+	if err == syscall.EAGAIN || err == syscall.EACCES {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// unlock releases the lock acquired by tryLock.
+func unlock(f *os.File) {
+	lock := syscall.Flock_t{
+		Type:   syscall.F_UNLCK,
+		Whence: io.SeekStart,
+	}
+	syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lock)
+}
@@ -0,0 +1,31 @@
+// +build !windows,!solaris
+
+package pidfile
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock acquires an exclusive or shared lock on the file without
+// blocking. It returns false if another process holds a conflicting lock.
+func tryLock(f *os.File, exclusive bool) (bool, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// unlock releases the lock acquired by tryLock.
+func unlock(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
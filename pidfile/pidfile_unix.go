@@ -0,0 +1,323 @@
+// +build !windows
+
+package pidfile
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Perm is the file permission used when creating a PID file.
+	Perm = 0644
+
+	maxLockAttempts = 5
+)
+
+// AlreadyRunningError is returned when a PID file refers to a process
+// that is still running.
+type AlreadyRunningError struct {
+	FilePath string
+	PID      int
+}
+
+func (o *AlreadyRunningError) Error() string {
+	if o.PID > 0 {
+		return fmt.Sprintf("pid file '%s' is in use by process %d", o.FilePath, o.PID)
+	}
+
+	return fmt.Sprintf("pid file '%s' is locked by another process", o.FilePath)
+}
+
+// PIDFile represents a locked PID file that is owned by the current
+// process.
+type PIDFile struct {
+	filePath string
+	file     *os.File
+}
+
+// FilePath returns the path to the PID file.
+func (o *PIDFile) FilePath() string {
+	return o.filePath
+}
+
+// Release removes the PID file and releases its lock. If the PID file
+// cannot be removed (e.g., because the directory containing it is not
+// writable by the current user), its contents are truncated instead so
+// that other programs know the process stopped cleanly.
+func (o *PIDFile) Release() error {
+	if o.file == nil {
+		return nil
+	}
+	defer func() {
+		o.file.Close()
+		o.file = nil
+	}()
+
+	// Only remove the file if it is still the file we locked.
+	isCurrent, err := isCurrentFile(o.file, o.filePath)
+	if err != nil || !isCurrent {
+		return err
+	}
+
+	err = os.Remove(o.filePath)
+	if err == nil {
+		return nil
+	}
+
+	err = o.file.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("failed to remove or truncate pid file - %s", err.Error())
+	}
+
+	return nil
+}
+
+// Acquire creates (or takes over a stale) PID file at the specified path,
+// locks it, and writes the current process's PID to it. The PID file
+// remains locked until Release is called, or the process exits.
+//
+// An *AlreadyRunningError is returned if another process holds the lock
+// or if the existing PID file refers to a running process.
+func Acquire(filePath string) (*PIDFile, error) {
+	f, err := openAndLock(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	existingPid, _ := readPid(f)
+	if existingPid > 0 && existingPid != os.Getpid() {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to stat pid file - %s", err.Error())
+		}
+
+		// The lock is not held by anyone, but the PID file might
+		// have been written by a program that does not lock it.
+		if !isStale(existingPid, info.ModTime()) {
+			f.Close()
+			return nil, &AlreadyRunningError{
+				FilePath: filePath,
+				PID:      existingPid,
+			}
+		}
+	}
+
+	pidFile := &PIDFile{
+		filePath: filePath,
+		file:     f,
+	}
+
+	err = pidFile.write(os.Getpid())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return pidFile, nil
+}
+
+// write atomically replaces the contents of the PID file. The new contents
+// are written to a temporary file that is locked before it is renamed over
+// the existing PID file. If a temporary file cannot be created (e.g.,
+// because the directory is not writable by the current user), the PID
+// file is overwritten in place.
+func (o *PIDFile) write(pid int) error {
+	contents := []byte(fmt.Sprintf("%d\n", pid))
+
+	temp, err := ioutil.TempFile(path.Dir(o.filePath), "."+path.Base(o.filePath)+".")
+	if err != nil {
+		return o.writeInPlace(contents)
+	}
+
+	err = writeLockedTemp(temp, contents)
+	if err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+
+	err = os.Rename(temp.Name(), o.filePath)
+	if err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to rename temporary pid file - %s", err.Error())
+	}
+
+	// Closing the original file releases its lock. Processes waiting
+	// on the old file will notice that it was replaced.
+	o.file.Close()
+	o.file = temp
+
+	return nil
+}
+
+func (o *PIDFile) writeInPlace(contents []byte) error {
+	err := o.file.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("failed to truncate pid file - %s", err.Error())
+	}
+
+	_, err = o.file.WriteAt(contents, 0)
+	if err != nil {
+		return fmt.Errorf("failed to write pid file - %s", err.Error())
+	}
+
+	return o.file.Sync()
+}
+
+func writeLockedTemp(temp *os.File, contents []byte) error {
+	isLocked, err := tryLock(temp, true)
+	if err != nil {
+		return fmt.Errorf("failed to lock temporary pid file - %s", err.Error())
+	}
+	if !isLocked {
+		return fmt.Errorf("failed to lock temporary pid file - it is locked by another process")
+	}
+
+	err = temp.Chmod(Perm)
+	if err != nil {
+		return fmt.Errorf("failed to set temporary pid file permissions - %s", err.Error())
+	}
+
+	_, err = temp.Write(contents)
+	if err != nil {
+		return fmt.Errorf("failed to write temporary pid file - %s", err.Error())
+	}
+
+	return temp.Sync()
+}
+
+// Read returns the PID stored in the specified PID file. It does not
+// check whether the process is running.
+func Read(filePath string) (int, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return readPid(f)
+}
+
+// IsRunning returns the PID stored in the specified PID file and whether
+// the process it refers to is running. A process is considered to be
+// running if it holds the PID file's lock, or if the PID file is not
+// stale.
+func IsRunning(filePath string) (int, bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	defer f.Close()
+
+	pid, _ := readPid(f)
+
+	isLocked, err := tryLock(f, false)
+	if err != nil {
+		return pid, false, fmt.Errorf("failed to check pid file lock - %s", err.Error())
+	}
+	if !isLocked {
+		return pid, true, nil
+	}
+	unlock(f)
+
+	if pid <= 0 {
+		return 0, false, nil
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return pid, false, err
+	}
+
+	return pid, !isStale(pid, info.ModTime()), nil
+}
+
+// openAndLock opens (or creates) the PID file and acquires an exclusive
+// lock on it. Because the PID file may be replaced by its owner while
+// we wait, the lock is only considered valid if the locked file is still
+// the file found at the path.
+func openAndLock(filePath string) (*os.File, error) {
+	for i := 0; i < maxLockAttempts; i++ {
+		f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, Perm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open pid file - %s", err.Error())
+		}
+
+		isLocked, err := tryLock(f, true)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock pid file - %s", err.Error())
+		}
+		if !isLocked {
+			pid, _ := readPid(f)
+			f.Close()
+			return nil, &AlreadyRunningError{
+				FilePath: filePath,
+				PID:      pid,
+			}
+		}
+
+		isCurrent, err := isCurrentFile(f, filePath)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		if isCurrent {
+			return f, nil
+		}
+
+		f.Close()
+	}
+
+	return nil, fmt.Errorf("failed to lock pid file after %d attempts - it is being replaced repeatedly",
+		maxLockAttempts)
+}
+
+// isCurrentFile returns true if the open file is the file found at
+// the specified path.
+func isCurrentFile(f *os.File, filePath string) (bool, error) {
+	openInfo, err := f.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat open pid file - %s", err.Error())
+	}
+
+	pathInfo, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to stat pid file - %s", err.Error())
+	}
+
+	return os.SameFile(openInfo, pathInfo), nil
+}
+
+func readPid(f *os.File) (int, error) {
+	contents, err := ioutil.ReadAll(io.NewSectionReader(f, 0, 64))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pid file - %s", err.Error())
+	}
+
+	trimmed := strings.TrimSpace(string(contents))
+	if len(trimmed) == 0 {
+		return 0, nil
+	}
+
+	pid, err := strconv.Atoi(strings.Fields(trimmed)[0])
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse pid file contents - %s", err.Error())
+	}
+
+	return pid, nil
+}
@@ -0,0 +1,164 @@
+// +build !windows,!solaris
+
+package pidfile
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"testing"
+)
+
+// The tests acquire the same PID file more than once from the current
+// process. This relies on flock locks belonging to an open file rather
+// than a process, which is not the case for POSIX record locks.
+
+func TestAcquireAlreadyRunning(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "pidfile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	filePath := path.Join(tempDirPath, "test.pid")
+
+	pidFile, err := Acquire(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pidFile.Release()
+
+	_, err = Acquire(filePath)
+	if err == nil {
+		t.Fatal("expected the second acquire to fail")
+	}
+
+	alreadyRunning, ok := err.(*AlreadyRunningError)
+	if !ok {
+		t.Fatalf("expected an *AlreadyRunningError - got %T: %s", err, err.Error())
+	}
+	if alreadyRunning.PID != os.Getpid() {
+		t.Fatalf("expected pid %d - got %d", os.Getpid(), alreadyRunning.PID)
+	}
+}
+
+func TestAcquireStale(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "pidfile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	// The PID of a process that has exited and been reaped.
+	cmd := exec.Command("true")
+	err = cmd.Run()
+	if err != nil {
+		t.Skipf("failed to run 'true' - %s", err.Error())
+	}
+	deadPid := cmd.ProcessState.Pid()
+
+	filePath := path.Join(tempDirPath, "test.pid")
+	err = ioutil.WriteFile(filePath, []byte(strconv.Itoa(deadPid)+"\n"), Perm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, isRunning, err := IsRunning(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isRunning {
+		t.Fatalf("expected pid %d to not be running", deadPid)
+	}
+
+	pidFile, err := Acquire(filePath)
+	if err != nil {
+		t.Fatalf("failed to take over stale pid file - %s", err.Error())
+	}
+	defer pidFile.Release()
+
+	pid, err := Read(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != os.Getpid() {
+		t.Fatalf("expected pid %d - got %d", os.Getpid(), pid)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "pidfile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	filePath := path.Join(tempDirPath, "test.pid")
+
+	pidFile, err := Acquire(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pidFile.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(filePath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected pid file to be removed - stat returned: %v", err)
+	}
+
+	pidFile, err = Acquire(filePath)
+	if err != nil {
+		t.Fatalf("failed to acquire released pid file - %s", err.Error())
+	}
+	pidFile.Release()
+}
+
+func TestIsRunning(t *testing.T) {
+	tempDirPath, err := ioutil.TempDir("", "pidfile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	filePath := path.Join(tempDirPath, "test.pid")
+
+	_, isRunning, err := IsRunning(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isRunning {
+		t.Fatal("expected a pid file that does not exist to not be running")
+	}
+
+	pidFile, err := Acquire(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, isRunning, err := IsRunning(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isRunning || pid != os.Getpid() {
+		t.Fatalf("expected pid %d to be running - got pid %d, running: %t", os.Getpid(), pid, isRunning)
+	}
+
+	err = pidFile.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, isRunning, err = IsRunning(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isRunning {
+		t.Fatal("expected a released pid file to not be running")
+	}
+}
@@ -0,0 +1,28 @@
+package pidfile
+
+import (
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+const (
+	// startTimeTolerance accounts for the coarse resolution of process
+	// start times and file modification times.
+	startTimeTolerance = time.Second
+)
+
+// isStale returns true if the process no longer exists, or if it was
+// started after the PID file was written (meaning the PID was reused).
+func isStale(pid int, writtenAt time.Time) bool {
+	status, err := osutil.ReadProcessStatus(pid)
+	if err != nil {
+		return true
+	}
+
+	if status.IsZombie() {
+		return true
+	}
+
+	return status.StartTime.After(writtenAt.Add(startTimeTolerance))
+}
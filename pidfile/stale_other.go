@@ -0,0 +1,15 @@
+// +build !linux,!windows
+
+package pidfile
+
+import (
+	"syscall"
+	"time"
+)
+
+// isStale returns true if the process no longer exists. Process start
+// times are not available on this system, so PID reuse cannot be
+// detected.
+func isStale(pid int, _ time.Time) bool {
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
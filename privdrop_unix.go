@@ -0,0 +1,222 @@
+// +build !windows
+
+package cyberdaemon
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/stephen-fox/cyberdaemon/internal/osutil"
+)
+
+const (
+	// droppedEnv is the environment variable that identifies the
+	// unprivileged daemon process.
+	droppedEnv = "CYBERDAEMON_PRIVILEGES_DROPPED"
+
+	// inheritedFilesEnv is the environment variable used to tell the
+	// unprivileged process how many files were passed to it by the
+	// PreDrop hook.
+	inheritedFilesEnv = "CYBERDAEMON_INHERITED_FILES"
+)
+
+var (
+	inheritedFiles []*os.File
+)
+
+// InheritedFiles returns the files that were returned by the PreDrop hook
+// of a PrivilegeDropConfig, in the same order. It returns nil if the
+// current process is not the unprivileged daemon process, or if the hook
+// did not return any files.
+func InheritedFiles() []*os.File {
+	return inheritedFiles
+}
+
+type privilegeDropDaemonizer struct {
+	config DaemonizerConfig
+	inner  Daemonizer
+}
+
+func (o *privilegeDropDaemonizer) RunUntilExit(application Application) error {
+	if _, isDropped := os.LookupEnv(droppedEnv); isDropped {
+		os.Unsetenv(droppedEnv)
+		return o.runDropped(application)
+	}
+
+	return o.inner.RunUntilExit(&privilegedApplication{
+		config:       o.config.PrivilegeDropConfig,
+		startTimeout: o.config.DetachConfig.startTimeout(),
+	})
+}
+
+// runDropped runs the application in the unprivileged daemon process.
+func (o *privilegeDropDaemonizer) runDropped(application Application) error {
+	return runAndReportReadiness(application, o.config.LogConfig, func() error {
+		var err error
+		inheritedFiles, err = filesFromEnv()
+		return err
+	})
+}
+
+// filesFromEnv returns the files passed by the privileged process.
+// They start at file descriptor number 3.
+func filesFromEnv() ([]*os.File, error) {
+	countString, ok := os.LookupEnv(inheritedFilesEnv)
+	if !ok {
+		return nil, nil
+	}
+	os.Unsetenv(inheritedFilesEnv)
+
+	count, err := strconv.Atoi(countString)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("number of inherited files '%s' is invalid", countString)
+	}
+
+	var files []*os.File
+	for fd := 3; fd < 3+count; fd++ {
+		// Do not leak the files to processes started
+		// by the application.
+		syscall.CloseOnExec(fd)
+		files = append(files, os.NewFile(uintptr(fd), fmt.Sprintf("inherited-file-%d", fd)))
+	}
+
+	return files, nil
+}
+
+// privilegedApplication is the Application that is run by the privileged
+// process in place of the real Application. It starts the unprivileged
+// process (which runs the real Application), and stops it when the daemon
+// is stopped.
+type privilegedApplication struct {
+	config       PrivilegeDropConfig
+	startTimeout time.Duration
+	mutex        sync.Mutex
+	isStopping   bool
+	daemon       *exec.Cmd
+	exited       chan error
+}
+
+func (o *privilegedApplication) Start() error {
+	credential, env, err := o.credentialAndEnv()
+	if err != nil {
+		return err
+	}
+
+	var files []*os.File
+	if o.config.PreDrop != nil {
+		files, err = o.config.PreDrop()
+		if err != nil {
+			return fmt.Errorf("pre-drop function failed - %s", err.Error())
+		}
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path when exec'ing unprivileged daemon - %s", err.Error())
+	}
+
+	o.daemon = exec.Command(exePath, os.Args[1:]...)
+	o.daemon.Env = append(env, droppedEnv+"=", fmt.Sprintf("%s=%d", inheritedFilesEnv, len(files)))
+	o.daemon.Stdout = os.Stdout
+	o.daemon.Stderr = os.Stderr
+	o.daemon.ExtraFiles = files
+	o.daemon.SysProcAttr = &syscall.SysProcAttr{
+		Credential: credential,
+	}
+
+	err = startAndAwaitReady(o.daemon, o.startTimeout)
+	if err != nil {
+		return err
+	}
+
+	o.exited = make(chan error, 1)
+	go func() {
+		err := o.daemon.Wait()
+
+		o.mutex.Lock()
+		isStopping := o.isStopping
+		o.mutex.Unlock()
+
+		// Stop the daemon if the unprivileged process
+		// exits on its own.
+		if !isStopping {
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		}
+
+		o.exited <- err
+	}()
+
+	return nil
+}
+
+func (o *privilegedApplication) Stop() error {
+	o.mutex.Lock()
+	o.isStopping = true
+	o.mutex.Unlock()
+
+	o.daemon.Process.Signal(syscall.SIGTERM)
+
+	err := <-o.exited
+	if err != nil {
+		return fmt.Errorf("unprivileged daemon process exited abnormally - %s", err.Error())
+	}
+
+	return nil
+}
+
+// credentialAndEnv returns the unprivileged process' credential and
+// environment variables.
+func (o *privilegedApplication) credentialAndEnv() (*syscall.Credential, []string, error) {
+	u, err := user.Lookup(o.config.User)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lookup user '%s' - %s", o.config.User, err.Error())
+	}
+
+	credential, err := osutil.UserCredential(u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(o.config.Group) > 0 {
+		credential.Gid, err = osutil.LookupGroupID(o.config.Group)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	env := []string{
+		"HOME=" + u.HomeDir,
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+	}
+	for _, assignment := range os.Environ() {
+		switch {
+		case strings.HasPrefix(assignment, "HOME="),
+			strings.HasPrefix(assignment, "USER="),
+			strings.HasPrefix(assignment, "LOGNAME="):
+			continue
+		}
+		env = append(env, assignment)
+	}
+
+	return credential, env, nil
+}
+
+func newPrivilegeDropDaemonizer(config DaemonizerConfig, inner Daemonizer) Daemonizer {
+	return &privilegeDropDaemonizer{
+		config: config,
+		inner:  inner,
+	}
+}
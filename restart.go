@@ -0,0 +1,275 @@
+package cyberdaemon
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// RestartNever means the daemon is never restarted.
+	RestartNever RestartMode = "never"
+
+	// RestartOnFailure means the daemon is restarted when it exits
+	// with a non-zero exit status, or is killed by a signal.
+	RestartOnFailure RestartMode = "on-failure"
+
+	// RestartAlways means the daemon is restarted whenever it exits,
+	// unless it was stopped by its daemon manager.
+	RestartAlways RestartMode = "always"
+
+	// RestartPolicyEnv is the environment variable that stores a
+	// daemon's RestartPolicy (see RestartPolicy.String). It is set by
+	// the init.d scripts and the built-in supervisor that are used by
+	// the control package, and tells the Daemonizer to supervise the
+	// daemon.
+	RestartPolicyEnv = "CYBERDAEMON_RESTART_POLICY"
+
+	defaultRestartDelay  = 100 * time.Millisecond
+	defaultBurstLimit    = 5
+	defaultBurstInterval = 10 * time.Second
+	maxRestartDelay      = 5 * time.Minute
+)
+
+// RestartMode specifies when a daemon is restarted after it exits.
+type RestartMode string
+
+func (o RestartMode) string() string {
+	return string(o)
+}
+
+// RestartPolicy configures when, and how quickly, a daemon is restarted
+// after it exits. It is modeled after systemd's settings, and uses the
+// same defaults.
+//
+// On systemd, the policy is mapped to the 'Restart', 'RestartSec',
+// 'StartLimitBurst', and 'StartLimitIntervalSec' unit settings. If the
+// policy is left unset, the daemon is restarted on failure.
+//
+// On OpenRC, the daemon is supervised by 'supervise-daemon', which
+// restarts the daemon whenever it exits (meaning RestartOnFailure behaves
+// like RestartAlways). Delay, BurstLimit, and BurstInterval are mapped to
+// the 'respawn_delay', 'respawn_max', and 'respawn_period' variables, and
+// must be whole numbers of seconds. If the Mode is RestartNever, the daemon
+// is started by 'start-stop-daemon' instead, and is not supervised.
+//
+// On runit and s6, the supervisor restarts the daemon one second after
+// it exits (which is also the behavior when the policy is left unset).
+// The service directory's 'finish' script stops the supervisor from
+// restarting the daemon according to the Mode, and waits for the Delay,
+// which must be a whole number of seconds. On s6, the service directory's
+// 'timeout-finish' file is set so that 's6-supervise' does not kill the
+// script while it waits (by default, it is killed after five seconds).
+// BurstLimit and BurstInterval are not supported.
+//
+// On supervisord, the Mode is mapped to the program's 'autorestart'
+// setting (RestartNever maps to 'false', RestartOnFailure maps to
+// 'unexpected', and RestartAlways maps to 'true'). If the policy is left
+// unset, supervisord's default ('unexpected') is used. Delay, BurstLimit,
+// and BurstInterval are not supported.
+//
+// On System V (and when the daemon is run by the built-in supervisor
+// that the control package uses on systems without a daemon manager),
+// the Daemonizer runs the Application in a child process and restarts
+// the child according to the policy. The delay between
+// restarts doubles each time the child exits within BurstInterval of
+// starting (up to a maximum of 5 minutes, or Delay if it is greater).
+// The daemon stops when it is no longer restarted. If the policy is
+// left unset, the daemon is not supervised, and is not restarted.
+//
+// Restart policies are not supported on macOS or Windows.
+type RestartPolicy struct {
+	// Mode specifies when the daemon is restarted. If left unset
+	// (and any other field is set), RestartOnFailure is used.
+	Mode RestartMode
+
+	// Delay is the amount of time to wait before restarting the
+	// daemon. It must be a whole number of milliseconds. If left
+	// unset, a delay of 100 milliseconds is used.
+	Delay time.Duration
+
+	// BurstLimit is the maximum number of times the daemon may be
+	// started within BurstInterval. The daemon is not restarted again
+	// once the limit is reached. If left unset, a limit of 5 is used.
+	BurstLimit int
+
+	// BurstInterval is the interval that BurstLimit applies to. If
+	// left unset, an interval of 10 seconds is used.
+	BurstInterval time.Duration
+}
+
+// Validate returns a non-nil error if the policy is invalid.
+func (o RestartPolicy) Validate() error {
+	switch o.Mode {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("unknown restart mode '%s'", o.Mode)
+	}
+
+	if o.Delay < 0 || o.Delay%time.Millisecond != 0 {
+		return fmt.Errorf("restart delay '%s' cannot be negative, and must be a whole number of milliseconds", o.Delay.String())
+	}
+
+	if o.BurstLimit < 0 {
+		return fmt.Errorf("restart burst limit cannot be negative")
+	}
+
+	if o.BurstInterval < 0 || o.BurstInterval%time.Millisecond != 0 {
+		return fmt.Errorf("restart burst interval '%s' cannot be negative, and must be a whole number of milliseconds",
+			o.BurstInterval.String())
+	}
+
+	return nil
+}
+
+// IsSet returns true if any field of the policy is set.
+func (o RestartPolicy) IsSet() bool {
+	return o != RestartPolicy{}
+}
+
+// EffectiveMode returns the policy's Mode, or RestartOnFailure if the
+// Mode is unset.
+func (o RestartPolicy) EffectiveMode() RestartMode {
+	if len(o.Mode) == 0 {
+		return RestartOnFailure
+	}
+
+	return o.Mode
+}
+
+// String returns the policy in the format used by the RestartPolicyEnv
+// environment variable. The format is a comma separated list of
+// 'key=value' pairs. The mode is always included. The other fields
+// are only included if they are set. For example:
+// 	mode=on-failure,delay=1s,burst=3,interval=1m0s
+// The string can be parsed using ParseRestartPolicy.
+func (o RestartPolicy) String() string {
+	pairs := []string{"mode=" + o.EffectiveMode().string()}
+
+	if o.Delay > 0 {
+		pairs = append(pairs, "delay="+o.Delay.String())
+	}
+
+	if o.BurstLimit > 0 {
+		pairs = append(pairs, "burst="+strconv.Itoa(o.BurstLimit))
+	}
+
+	if o.BurstInterval > 0 {
+		pairs = append(pairs, "interval="+o.BurstInterval.String())
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (o RestartPolicy) delay() time.Duration {
+	if o.Delay == 0 {
+		return defaultRestartDelay
+	}
+
+	return o.Delay
+}
+
+func (o RestartPolicy) burstLimit() int {
+	if o.BurstLimit == 0 {
+		return defaultBurstLimit
+	}
+
+	return o.BurstLimit
+}
+
+func (o RestartPolicy) burstInterval() time.Duration {
+	if o.BurstInterval == 0 {
+		return defaultBurstInterval
+	}
+
+	return o.BurstInterval
+}
+
+// backoff returns the amount of time to wait before restarting the
+// daemon after it was restarted the specified number of times in quick
+// succession.
+func (o RestartPolicy) backoff(quickRestarts int) time.Duration {
+	delay := o.delay()
+	limit := maxRestartDelay
+	if delay > limit {
+		limit = delay
+	}
+
+	for i := 0; i < quickRestarts && delay < limit; i++ {
+		delay = delay * 2
+	}
+
+	if delay > limit {
+		return limit
+	}
+
+	return delay
+}
+
+// restarts returns true if the daemon should be restarted after it
+// exited.
+func (o RestartPolicy) restarts(failed bool) bool {
+	switch o.EffectiveMode() {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return failed
+	}
+
+	return false
+}
+
+// ParseRestartPolicy parses a RestartPolicy from the format produced by
+// RestartPolicy.String.
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	var policy RestartPolicy
+
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return RestartPolicy{}, fmt.Errorf("restart policy setting '%s' must be in the format 'key=value'", pair)
+		}
+
+		var err error
+		switch parts[0] {
+		case "mode":
+			policy.Mode = RestartMode(parts[1])
+		case "delay":
+			policy.Delay, err = time.ParseDuration(parts[1])
+		case "burst":
+			policy.BurstLimit, err = strconv.Atoi(parts[1])
+		case "interval":
+			policy.BurstInterval, err = time.ParseDuration(parts[1])
+		default:
+			return RestartPolicy{}, fmt.Errorf("unknown restart policy setting '%s'", parts[0])
+		}
+		if err != nil {
+			return RestartPolicy{}, fmt.Errorf("failed to parse restart policy setting '%s' - %s", pair, err.Error())
+		}
+	}
+
+	err := policy.Validate()
+	if err != nil {
+		return RestartPolicy{}, err
+	}
+
+	return policy, nil
+}
+
+// restartPolicyFromEnv returns the policy stored in the RestartPolicyEnv
+// environment variable. It returns false if the variable is not set.
+func restartPolicyFromEnv() (RestartPolicy, bool, error) {
+	value, ok := os.LookupEnv(RestartPolicyEnv)
+	if !ok || len(value) == 0 {
+		return RestartPolicy{}, false, nil
+	}
+
+	policy, err := ParseRestartPolicy(value)
+	if err != nil {
+		return RestartPolicy{}, false, fmt.Errorf("failed to parse %s - %s", RestartPolicyEnv, err.Error())
+	}
+
+	return policy, true, nil
+}
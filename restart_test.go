@@ -0,0 +1,113 @@
+package cyberdaemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		policy        RestartPolicy
+		quickRestarts int
+		expected      time.Duration
+	}{
+		{RestartPolicy{}, 0, 100 * time.Millisecond},
+		{RestartPolicy{}, 1, 200 * time.Millisecond},
+		{RestartPolicy{}, 3, 800 * time.Millisecond},
+		{RestartPolicy{}, 100, 5 * time.Minute},
+		{RestartPolicy{Delay: time.Second}, 0, time.Second},
+		{RestartPolicy{Delay: time.Second}, 2, 4 * time.Second},
+		{RestartPolicy{Delay: 3 * time.Minute}, 1, 5 * time.Minute},
+		{RestartPolicy{Delay: 10 * time.Minute}, 0, 10 * time.Minute},
+		{RestartPolicy{Delay: 10 * time.Minute}, 5, 10 * time.Minute},
+	}
+
+	for _, test := range tests {
+		actual := test.policy.backoff(test.quickRestarts)
+		if actual != test.expected {
+			t.Fatalf("expected backoff of %s for %+v after %d quick restarts - got %s",
+				test.expected, test.policy, test.quickRestarts, actual)
+		}
+	}
+}
+
+func TestRestartPolicyRestarts(t *testing.T) {
+	tests := []struct {
+		mode     RestartMode
+		failed   bool
+		expected bool
+	}{
+		{"", false, false},
+		{"", true, true},
+		{RestartNever, false, false},
+		{RestartNever, true, false},
+		{RestartOnFailure, false, false},
+		{RestartOnFailure, true, true},
+		{RestartAlways, false, true},
+		{RestartAlways, true, true},
+	}
+
+	for _, test := range tests {
+		actual := RestartPolicy{Mode: test.mode}.restarts(test.failed)
+		if actual != test.expected {
+			t.Fatalf("expected mode '%s' with failed %t to restart: %t - got %t",
+				test.mode, test.failed, test.expected, actual)
+		}
+	}
+}
+
+func TestRestartPolicyString(t *testing.T) {
+	tests := []struct {
+		policy   RestartPolicy
+		expected string
+	}{
+		{RestartPolicy{}, "mode=on-failure"},
+		{RestartPolicy{Mode: RestartNever}, "mode=never"},
+		{RestartPolicy{Mode: RestartAlways, Delay: 1500 * time.Millisecond}, "mode=always,delay=1.5s"},
+		{RestartPolicy{BurstLimit: 3, BurstInterval: time.Minute}, "mode=on-failure,burst=3,interval=1m0s"},
+		{
+			RestartPolicy{Mode: RestartAlways, Delay: time.Second, BurstLimit: 1, BurstInterval: time.Hour},
+			"mode=always,delay=1s,burst=1,interval=1h0m0s",
+		},
+	}
+
+	for _, test := range tests {
+		actual := test.policy.String()
+		if actual != test.expected {
+			t.Fatalf("expected '%s' - got '%s'", test.expected, actual)
+		}
+
+		parsed, err := ParseRestartPolicy(actual)
+		if err != nil {
+			t.Fatalf("failed to parse '%s' - %s", actual, err.Error())
+		}
+
+		expected := test.policy
+		expected.Mode = expected.EffectiveMode()
+		if parsed != expected {
+			t.Fatalf("expected '%s' to parse as %+v - got %+v", actual, expected, parsed)
+		}
+	}
+}
+
+func TestParseRestartPolicyErrors(t *testing.T) {
+	invalid := []string{
+		"",
+		"mode",
+		"mode=sometimes",
+		"mode=always,delay=1",
+		"mode=always,delay=-1s",
+		"mode=always,delay=1us",
+		"mode=always,burst=a",
+		"mode=always,burst=-1",
+		"mode=always,interval=-1s",
+		"mode=always,unknown=1",
+	}
+
+	for _, s := range invalid {
+		_, err := ParseRestartPolicy(s)
+		if err == nil {
+			t.Fatalf("expected an error for '%s'", s)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package cyberdaemon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// supervisedEnv is the environment variable that identifies the
+	// child process that is started by the supervisor.
+	supervisedEnv = "CYBERDAEMON_SUPERVISED"
+)
+
+// supervisorDaemonizer restarts a System V daemon (or a daemon run by the
+// built-in supervisor) according to the RestartPolicy stored in the
+// RestartPolicyEnv environment variable.
+// The daemon process (the supervisor) runs a new instance of the current
+// executable (with the same command line arguments), which runs the real
+// Application. The supervisor restarts the child process when it exits,
+// and stops the child when the daemon is stopped.
+//
+// The daemon is not supervised if the environment variable is not set,
+// or if the policy's mode is RestartNever.
+type supervisorDaemonizer struct {
+	config DaemonizerConfig
+	inner  Daemonizer
+}
+
+func (o *supervisorDaemonizer) RunUntilExit(application Application) error {
+	if _, isSupervised := os.LookupEnv(supervisedEnv); isSupervised {
+		os.Unsetenv(supervisedEnv)
+		os.Unsetenv(RestartPolicyEnv)
+		return runAndReportReadiness(application, o.config.LogConfig, func() error {
+			return nil
+		})
+	}
+
+	policy, isSet, err := restartPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+
+	if !isSet || policy.EffectiveMode() == RestartNever {
+		return o.inner.RunUntilExit(application)
+	}
+
+	return o.inner.RunUntilExit(&supervisorApplication{
+		policy:       policy,
+		startTimeout: o.config.DetachConfig.startTimeout(),
+		stopping:     make(chan struct{}),
+		exited:       make(chan error, 1),
+	})
+}
+
+// supervisorApplication is the Application that is run by the supervisor
+// in place of the real Application. It starts the child process (which
+// runs the real Application), and restarts it according to the policy.
+type supervisorApplication struct {
+	policy       RestartPolicy
+	startTimeout time.Duration
+	mutex        sync.Mutex
+	isStopping   bool
+	child        *exec.Cmd
+	stopping     chan struct{}
+	exited       chan error
+}
+
+func (o *supervisorApplication) Start() error {
+	err := o.startChild()
+	if err != nil {
+		return err
+	}
+
+	go o.supervise()
+
+	return nil
+}
+
+func (o *supervisorApplication) Stop() error {
+	o.mutex.Lock()
+	o.isStopping = true
+	close(o.stopping)
+	o.child.Process.Signal(syscall.SIGTERM)
+	o.mutex.Unlock()
+
+	return <-o.exited
+}
+
+// startChild starts the child process and waits for it to report that
+// the Application started.
+func (o *supervisorApplication) startChild() error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path when exec'ing supervised daemon - %s", err.Error())
+	}
+
+	child := exec.Command(exePath, os.Args[1:]...)
+	child.Env = append(os.Environ(), supervisedEnv+"=")
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	err = startAndAwaitReady(child, o.startTimeout)
+	if err != nil {
+		return err
+	}
+
+	o.mutex.Lock()
+	o.child = child
+	// The daemon may have been stopped while the child was starting.
+	if o.isStopping {
+		child.Process.Signal(syscall.SIGTERM)
+	}
+	o.mutex.Unlock()
+
+	return nil
+}
+
+// supervise waits for the child process to exit, and restarts it
+// according to the policy. The daemon is stopped when the child is
+// no longer restarted.
+func (o *supervisorApplication) supervise() {
+	bursts := newBurstTracker(o.policy, time.Now())
+
+	for {
+		o.mutex.Lock()
+		child := o.child
+		o.mutex.Unlock()
+
+		err := child.Wait()
+
+		o.mutex.Lock()
+		isStopping := o.isStopping
+		o.mutex.Unlock()
+
+		if isStopping {
+			if err != nil {
+				err = fmt.Errorf("supervised daemon process exited abnormally - %s", err.Error())
+			}
+			o.exited <- err
+			return
+		}
+
+		if !o.policy.restarts(err != nil) {
+			if err != nil {
+				err = fmt.Errorf("supervised daemon process exited abnormally and was not restarted - %s", err.Error())
+			}
+			o.giveUp(err)
+			return
+		}
+
+		if err != nil {
+			log.Printf("supervised daemon process exited abnormally - %s", err.Error())
+		}
+
+		bursts.exited(time.Now())
+
+		for {
+			delay, ok := bursts.next(time.Now())
+			if !ok {
+				o.giveUp(fmt.Errorf("supervised daemon was started %d times within %s and will not be restarted",
+					len(bursts.startTimes), o.policy.burstInterval().String()))
+				return
+			}
+
+			select {
+			case <-time.After(delay):
+			case <-o.stopping:
+				o.exited <- nil
+				return
+			}
+
+			bursts.started(time.Now())
+
+			err := o.startChild()
+			if err == nil {
+				break
+			}
+
+			log.Printf("failed to restart supervised daemon - %s", err.Error())
+		}
+	}
+}
+
+// giveUp stops the daemon after the child process exited, and was not
+// restarted. The error is returned by Stop.
+func (o *supervisorApplication) giveUp(err error) {
+	o.exited <- err
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+}
+
+// burstTracker records when the child process was started, and decides
+// how long to wait before restarting it.
+type burstTracker struct {
+	policy        RestartPolicy
+	startTimes    []time.Time
+	quickRestarts int
+}
+
+// exited records that the child process exited. The backoff is reset if
+// the child ran for at least the policy's burst interval.
+func (o *burstTracker) exited(now time.Time) {
+	if now.Sub(o.startTimes[len(o.startTimes)-1]) >= o.policy.burstInterval() {
+		o.quickRestarts = 0
+	}
+}
+
+// next returns the amount of time to wait before restarting the child
+// process. It returns false if the child was started too many times
+// within the policy's burst interval, meaning it must not be restarted.
+func (o *burstTracker) next(now time.Time) (time.Duration, bool) {
+	o.startTimes = recentTimes(o.startTimes, now, o.policy.burstInterval())
+	if len(o.startTimes) >= o.policy.burstLimit() {
+		return 0, false
+	}
+
+	return o.policy.backoff(o.quickRestarts), true
+}
+
+// started records that the child process was restarted.
+func (o *burstTracker) started(now time.Time) {
+	o.quickRestarts++
+	o.startTimes = append(o.startTimes, now)
+}
+
+func newBurstTracker(policy RestartPolicy, firstStart time.Time) *burstTracker {
+	return &burstTracker{
+		policy:     policy,
+		startTimes: []time.Time{firstStart},
+	}
+}
+
+// recentTimes returns the times that occurred within the interval
+// before now.
+func recentTimes(times []time.Time, now time.Time, interval time.Duration) []time.Time {
+	var recent []time.Time
+	for _, t := range times {
+		if now.Sub(t) < interval {
+			recent = append(recent, t)
+		}
+	}
+
+	return recent
+}
+
+func newSupervisorDaemonizer(config DaemonizerConfig, inner Daemonizer) Daemonizer {
+	return &supervisorDaemonizer{
+		config: config,
+		inner:  inner,
+	}
+}
@@ -0,0 +1,99 @@
+package cyberdaemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBurstTracker(t *testing.T) {
+	type restart struct {
+		// exitAfter is the amount of time the child runs for
+		// before it exits.
+		exitAfter time.Duration
+
+		// delay is the expected delay before the child is
+		// restarted, or -1 if it must not be restarted.
+		delay time.Duration
+	}
+
+	tests := []struct {
+		name     string
+		policy   RestartPolicy
+		restarts []restart
+	}{
+		{
+			name:   "backoff doubles for quick restarts",
+			policy: RestartPolicy{Delay: time.Second, BurstLimit: 10, BurstInterval: time.Minute},
+			restarts: []restart{
+				{time.Second, time.Second},
+				{time.Second, 2 * time.Second},
+				{time.Second, 4 * time.Second},
+			},
+		},
+		{
+			name:   "backoff resets after running for the interval",
+			policy: RestartPolicy{Delay: time.Second, BurstLimit: 10, BurstInterval: time.Minute},
+			restarts: []restart{
+				{time.Second, time.Second},
+				{time.Second, 2 * time.Second},
+				{time.Minute, time.Second},
+			},
+		},
+		{
+			name:   "burst limit stops restarts",
+			policy: RestartPolicy{Delay: time.Second, BurstLimit: 3, BurstInterval: time.Minute},
+			restarts: []restart{
+				{time.Second, time.Second},
+				{time.Second, 2 * time.Second},
+				{time.Second, -1},
+			},
+		},
+		{
+			name:   "old starts do not count towards the burst limit",
+			policy: RestartPolicy{Delay: time.Second, BurstLimit: 2, BurstInterval: 10 * time.Second},
+			restarts: []restart{
+				{time.Second, time.Second},
+				{10 * time.Second, time.Second},
+				{10 * time.Second, time.Second},
+			},
+		},
+		{
+			name:   "default burst limit",
+			policy: RestartPolicy{Mode: RestartAlways},
+			restarts: []restart{
+				{0, 100 * time.Millisecond},
+				{0, 200 * time.Millisecond},
+				{0, 400 * time.Millisecond},
+				{0, 800 * time.Millisecond},
+				{0, -1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		now := time.Unix(1000000, 0)
+		bursts := newBurstTracker(test.policy, now)
+
+		for i, r := range test.restarts {
+			now = now.Add(r.exitAfter)
+			bursts.exited(now)
+
+			delay, ok := bursts.next(now)
+			if r.delay < 0 {
+				if ok {
+					t.Fatalf("%s: expected restart %d to be refused - got delay %s", test.name, i, delay)
+				}
+				break
+			}
+			if !ok {
+				t.Fatalf("%s: expected restart %d to be allowed", test.name, i)
+			}
+			if delay != r.delay {
+				t.Fatalf("%s: expected restart %d to be delayed by %s - got %s", test.name, i, r.delay, delay)
+			}
+
+			now = now.Add(delay)
+			bursts.started(now)
+		}
+	}
+}